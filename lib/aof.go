@@ -4,14 +4,25 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// fsync policies of the AOF, see the appendfsync parameter.
+const (
+	FsyncAlways   = "always"   // after every write, the safest and slowest.
+	FsyncEverySec = "everysec" // once a second in the background.
+	FsyncNo       = "no"       // never, the operating system decides.
+)
+
 type AppendOnlyFile struct {
-	file *os.File
-	rd   *bufio.Reader
-	mu   sync.RWMutex
+	file  *os.File
+	rd    *bufio.Reader
+	mu    sync.RWMutex
+	fsync string
+	done  chan struct{} // closed by Close to stop the background fsync.
+	db    int           // the database the last command written ran in, -1 before the first one.
 }
 
 func NewAppendOnlyFile(path string) (*AppendOnlyFile, error) {
@@ -21,8 +32,11 @@ func NewAppendOnlyFile(path string) (*AppendOnlyFile, error) {
 	}
 
 	aof := AppendOnlyFile{
-		file: f,
-		rd:   bufio.NewReader(f),
+		file:  f,
+		rd:    bufio.NewReader(f),
+		fsync: FsyncEverySec,
+		done:  make(chan struct{}),
+		db:    -1,
 	}
 
 	go syncFileEverySecond(&aof)
@@ -31,36 +45,94 @@ func NewAppendOnlyFile(path string) (*AppendOnlyFile, error) {
 }
 
 func syncFileEverySecond(a *AppendOnlyFile) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
 	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
 		a.mu.Lock()
 		// fmt.Println("Lock acquired on file")
 
-		a.file.Sync()
+		if a.fsync == FsyncEverySec {
+			a.file.Sync()
+		}
 		// fmt.Println("File synchronization starts")
 
 		a.mu.Unlock()
 		// fmt.Println("File synchronization ends & Lock released")
-
-		time.Sleep(time.Second)
 	}
 }
 
-func (a *AppendOnlyFile) Close() {
+// SetFsyncPolicy changes how often the writes are flushed to the disk.
+func (a *AppendOnlyFile) SetFsyncPolicy(policy string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsync = policy
+}
+
+// Sync flushes every write to the disk whatever the fsync policy is.
+func (a *AppendOnlyFile) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Sync()
+}
+
+// Close flushes the writes to the disk and closes the file,
+// the AOF cannot be used afterwards.
+func (a *AppendOnlyFile) Close() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	a.file.Close()
+	close(a.done)
+
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+
+	return a.file.Close()
 }
 
 func (a *AppendOnlyFile) Write(v Value) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	return a.write(v)
+}
+
+// WriteIn writes a command that ran in the database db, preceded by a
+// SELECT when the previous one ran in another database so replaying the
+// AOF lands every write in its database.
+func (a *AppendOnlyFile) WriteIn(db int, v Value) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if db != a.db {
+		if err := a.write(command("select", strconv.Itoa(db))); err != nil {
+			return err
+		}
+		a.db = db
+	}
+	return a.write(v)
+}
+
+// the lock must be held.
+func (a *AppendOnlyFile) write(v Value) error {
 	_, err := a.file.Write(v.Marshal())
 	if err != nil {
 		return err
 	}
 
+	if a.fsync == FsyncAlways {
+		return a.file.Sync()
+	}
+
 	return nil
 }
 
@@ -0,0 +1,615 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// the overflow behaviours of BITFIELD.
+const (
+	bitfieldWrap = iota
+	bitfieldSat
+	bitfieldFail
+)
+
+var (
+	errBitOffset        = errors.New("ERR bit offset is not an integer or out of range")
+	errBitValue         = errors.New("ERR bit is not an integer or out of range")
+	errBitfieldType     = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	errBitfieldReadOnly = errors.New("ERR BITFIELD_RO only supports the GET subcommand")
+)
+
+// parses the offset of a bit, or of an integer of the given width for
+// BITFIELD where "#n" is the offset of the nth integer of that width.
+// Offsets are limited so the string fits in proto-max-bulk-len.
+func parseBitOffset(ctx context.Context, arg string, hash bool, width int) (int64, error) {
+	multiplier := int64(1)
+	if hash && strings.HasPrefix(arg, "#") {
+		arg = arg[1:]
+		multiplier = int64(width)
+	}
+
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > math.MaxInt64/multiplier {
+		return 0, errBitOffset
+	}
+
+	offset *= multiplier
+	if offset>>3 >= maxStringLength(ctx) {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+// the bit at offset, the bits of a byte are numbered from the most
+// significant one and the bits past the end of the string are 0.
+func getBit(buf []byte, offset int64) byte {
+	i := offset >> 3
+	if i >= int64(len(buf)) {
+		return 0
+	}
+	return buf[i] >> (7 - offset&7) & 1
+}
+
+// sets the bit at offset, buf must be long enough to hold it.
+func setBit(buf []byte, offset int64, bit byte) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		buf[offset>>3] |= mask
+	} else {
+		buf[offset>>3] &^= mask
+	}
+}
+
+// the string stored at key for commands changing its bits, grown with
+// zero bytes so the bit at offset exists. The caller stores it back. The
+// store must be locked for writing.
+func (s *SimpleStore) writableBits(key string, offset int64) ([]byte, error) {
+	s.expireIfNeeded(key)
+
+	buf, _, err := s.lookupBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	return growString(buf, int(offset>>3)+1), nil
+}
+
+// doc: https://redis.io/docs/latest/commands/setbit/
+func setbit(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("setbit")
+	}
+
+	offset, err := parseBitOffset(ctx, args[1].Bulk, false, 0)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	if args[2].Bulk != "0" && args[2].Bulk != "1" {
+		return Value{Typ: "error", Str: errBitValue.Error()}
+	}
+	bit := args[2].Bulk[0] - '0'
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	buf, err := db.writableBits(key, offset)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	old := getBit(buf, offset)
+	setBit(buf, offset, bit)
+	db.setString(key, stringValue{buf: buf})
+
+	return Value{Typ: "integer", Num: int(old)}
+}
+
+// doc: https://redis.io/docs/latest/commands/getbit/
+func getbit(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("getbit")
+	}
+
+	offset, err := parseBitOffset(ctx, args[1].Bulk, false, 0)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	buf, _, err := db.lookupBytes(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	return Value{Typ: "integer", Num: int(getBit(buf, offset))}
+}
+
+// parses the "start end [BYTE|BIT]" range of BITCOUNT and BITPOS into a
+// range of bits. Negative indexes count from the end of the string, in
+// bytes or in bits, and the range is clamped to the string. ok is false
+// when the range is empty.
+func parseBitRange(args []Value, length int64) (start, end int64, ok bool, err error) {
+	start, err = strconv.ParseInt(args[0].Bulk, 10, 64)
+	if err != nil {
+		return 0, 0, false, errNotInteger
+	}
+
+	end = length - 1
+	unit := int64(8)
+	if len(args) >= 2 {
+		if end, err = strconv.ParseInt(args[1].Bulk, 10, 64); err != nil {
+			return 0, 0, false, errNotInteger
+		}
+	}
+
+	if len(args) == 3 {
+		switch strings.ToLower(args[2].Bulk) {
+		case "byte":
+		case "bit":
+			unit = 1
+		default:
+			return 0, 0, false, errSyntax
+		}
+	}
+
+	// port of bitcountCommand: https://github.com/redis/redis/blob/unstable/src/bitops.c
+	total := length * 8 / unit
+	if start < 0 {
+		start = max(start+total, 0)
+	}
+	if end < 0 {
+		end = max(end+total, 0)
+	}
+	end = min(end, total-1)
+
+	if start > end {
+		return 0, 0, false, nil
+	}
+
+	if unit == 8 {
+		return start * 8, end*8 + 7, true, nil
+	}
+	return start, end, true, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/bitcount/
+func bitcount(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("bitcount")
+	}
+
+	// the range needs both its start and its end.
+	if len(args) == 2 || len(args) > 4 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	buf, _, err := db.lookupBytes(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	start, end := int64(0), int64(len(buf))*8-1
+	if len(args) > 1 {
+		var ok bool
+		var err error
+		if start, end, ok, err = parseBitRange(args[1:], int64(len(buf))); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		} else if !ok {
+			return Value{Typ: "integer", Num: 0}
+		}
+	}
+
+	if end < start {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	// whole bytes are counted at once, the bits of the first and the last
+	// byte out of the range are taken off.
+	first, last := start>>3, end>>3
+	count := 0
+	for _, b := range buf[first : last+1] {
+		count += bits.OnesCount8(b)
+	}
+	count -= bits.OnesCount8(buf[first] &^ (0xff >> (start & 7)))
+	count -= bits.OnesCount8(buf[last] & (0xff >> (end&7 + 1)))
+
+	return Value{Typ: "integer", Num: count}
+}
+
+// doc: https://redis.io/docs/latest/commands/bitpos/
+func bitpos(ctx context.Context, args []Value) Value {
+	if len(args) < 2 || len(args) > 5 {
+		return wrongNumberOfArgs("bitpos")
+	}
+
+	if args[1].Bulk != "0" && args[1].Bulk != "1" {
+		return Value{Typ: "error", Str: "ERR The bit argument must be 1 or 0."}
+	}
+	bit := args[1].Bulk[0] - '0'
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	buf, exists, err := db.lookupBytes(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// a missing key is an empty string, its bits are all clear.
+	if !exists {
+		if bit == 1 {
+			return Value{Typ: "integer", Num: -1}
+		}
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	start, end := int64(0), int64(len(buf))*8-1
+	if len(args) > 2 {
+		var ok bool
+		var err error
+		if start, end, ok, err = parseBitRange(args[2:], int64(len(buf))); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		} else if !ok {
+			return Value{Typ: "integer", Num: -1}
+		}
+	}
+
+	for i := start; i <= end; {
+		// bytes without the bit are skipped at once.
+		if i&7 == 0 && i+7 <= end && (bit == 1 && buf[i>>3] == 0 || bit == 0 && buf[i>>3] == 0xff) {
+			i += 8
+			continue
+		}
+
+		if getBit(buf, i) == bit {
+			return Value{Typ: "integer", Num: int(i)}
+		}
+		i++
+	}
+
+	// without an end, the string is seen as padded with clear bits on the
+	// right so the first clear bit of a string of set bits is past its end.
+	if bit == 0 && len(args) <= 3 {
+		return Value{Typ: "integer", Num: len(buf) * 8}
+	}
+	return Value{Typ: "integer", Num: -1}
+}
+
+// doc: https://redis.io/docs/latest/commands/bitop/
+func bitop(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("bitop")
+	}
+
+	op := strings.ToLower(args[0].Bulk)
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return Value{Typ: "error", Str: "ERR BITOP NOT must be called with a single source key."}
+		}
+	default:
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, keysOf(args[1:])...)
+	defer unlock()
+
+	dest := args[1].Bulk
+
+	// missing keys are empty strings, and strings shorter than the
+	// longest one are padded with zero bytes.
+	sources := [][]byte{}
+	length := 0
+	for _, key := range args[2:] {
+		buf, _, err := db.lookupBytes(key.Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		sources = append(sources, buf)
+		length = max(length, len(buf))
+	}
+
+	result := make([]byte, length)
+	for i := range result {
+		b := byteAt(sources[0], i)
+		if op == "not" {
+			b = ^b
+		}
+
+		for _, source := range sources[1:] {
+			switch op {
+			case "and":
+				b &= byteAt(source, i)
+			case "or":
+				b |= byteAt(source, i)
+			case "xor":
+				b ^= byteAt(source, i)
+			}
+		}
+		result[i] = b
+	}
+
+	db.deleteKey(dest)
+	if length > 0 {
+		db.setString(dest, stringValue{buf: result})
+	}
+	return Value{Typ: "integer", Num: length}
+}
+
+func byteAt(buf []byte, i int) byte {
+	if i < len(buf) {
+		return buf[i]
+	}
+	return 0
+}
+
+// an operation of BITFIELD.
+type bitfieldOp struct {
+	name     string // get, set or incrby.
+	offset   int64
+	width    int
+	signed   bool
+	value    int64 // the value of SET, the increment of INCRBY.
+	overflow int
+}
+
+// parses a type like i16 or u8, signed integers are up to 64 bits
+// wide and unsigned ones up to 63 so they fit in an integer reply.
+func parseBitfieldType(arg string) (width int, signed bool, err error) {
+	if len(arg) < 2 {
+		return 0, false, errBitfieldType
+	}
+
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return 0, false, errBitfieldType
+	}
+
+	width, err = strconv.Atoi(arg[1:])
+	if err != nil || width < 1 || signed && width > 64 || !signed && width > 63 {
+		return 0, false, errBitfieldType
+	}
+	return width, signed, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/bitfield/
+func bitfield(ctx context.Context, args []Value) Value {
+	return bitfieldGeneric(ctx, "bitfield", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/bitfield_ro/
+func bitfieldRO(ctx context.Context, args []Value) Value {
+	return bitfieldGeneric(ctx, "bitfield_ro", args, true)
+}
+
+func bitfieldGeneric(ctx context.Context, name string, args []Value, readOnly bool) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs(name)
+	}
+
+	// every operation is parsed before any is run so a syntax
+	// error does not leave the string half changed.
+	ops := []bitfieldOp{}
+	overflow := bitfieldWrap
+	highest := int64(-1)
+
+	for i := 1; i < len(args); i++ {
+		subcommand := strings.ToLower(args[i].Bulk)
+		remaining := len(args) - i - 1
+
+		switch {
+		case subcommand == "overflow" && remaining >= 1:
+			switch strings.ToLower(args[i+1].Bulk) {
+			case "wrap":
+				overflow = bitfieldWrap
+			case "sat":
+				overflow = bitfieldSat
+			case "fail":
+				overflow = bitfieldFail
+			default:
+				return Value{Typ: "error", Str: "ERR Invalid OVERFLOW type specified"}
+			}
+			i++
+			continue
+
+		case subcommand == "get" && remaining >= 2,
+			(subcommand == "set" || subcommand == "incrby") && remaining >= 3:
+
+		default:
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+
+		if readOnly && subcommand != "get" {
+			return Value{Typ: "error", Str: errBitfieldReadOnly.Error()}
+		}
+
+		width, signed, err := parseBitfieldType(args[i+1].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		offset, err := parseBitOffset(ctx, args[i+2].Bulk, true, width)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		op := bitfieldOp{name: subcommand, offset: offset, width: width, signed: signed, overflow: overflow}
+		i += 2
+
+		if subcommand != "get" {
+			if op.value, err = strconv.ParseInt(args[i+1].Bulk, 10, 64); err != nil {
+				return Value{Typ: "error", Str: errNotInteger.Error()}
+			}
+			highest = max(highest, offset+int64(width)-1)
+			i++
+		}
+
+		ops = append(ops, op)
+	}
+
+	db := clientDB(ctx)
+	if highest < 0 {
+		unlock := db.rlock(ctx, args[0].Bulk)
+		defer unlock()
+
+		// nothing changes so nothing is written in the AOF.
+		propagateAs(ctx)
+
+		buf, _, err := db.lookupBytes(args[0].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		return bitfieldReply(buf, ops)
+	}
+
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	buf, err := db.writableBits(key, highest)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	reply := bitfieldReply(buf, ops)
+	db.setString(key, stringValue{buf: buf})
+	return reply
+}
+
+// runs the operations on buf and replies with their results: the value for
+// GET, the previous value for SET and the new value for INCRBY, or null
+// when SET or INCRBY overflow with FAIL.
+func bitfieldReply(buf []byte, ops []bitfieldOp) Value {
+	reply := Value{Typ: "array", Array: []Value{}}
+
+	for _, op := range ops {
+		old := getBitfield(buf, op.offset, op.width, op.signed)
+		if op.name == "get" {
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: int(old)})
+			continue
+		}
+
+		value, incr := op.value, int64(0)
+		if op.name == "incrby" {
+			value, incr = old, op.value
+		}
+
+		result, overflowed := bitfieldOverflow(value, incr, op.width, op.signed, op.overflow)
+		if overflowed && op.overflow == bitfieldFail {
+			reply.Array = append(reply.Array, Value{Typ: "null"})
+			continue
+		}
+
+		setBitfield(buf, op.offset, op.width, uint64(result))
+		if op.name == "set" {
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: int(old)})
+		} else {
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: int(result)})
+		}
+	}
+
+	return reply
+}
+
+// the integer of width bits at offset, sign extended when signed.
+func getBitfield(buf []byte, offset int64, width int, signed bool) int64 {
+	var value uint64
+	for i := int64(0); i < int64(width); i++ {
+		value = value<<1 | uint64(getBit(buf, offset+i))
+	}
+
+	if signed && width < 64 && value&(1<<(width-1)) != 0 {
+		value |= math.MaxUint64 << width
+	}
+	return int64(value)
+}
+
+// writes the lowest width bits of value at offset, buf must be long enough.
+func setBitfield(buf []byte, offset int64, width int, value uint64) {
+	for i := 0; i < width; i++ {
+		setBit(buf, offset+int64(i), byte(value>>(width-1-i)&1))
+	}
+}
+
+// adds incr to value and handles the result not fitting in an integer
+// of width bits: WRAP keeps its lowest bits, SAT the closest bound, FAIL
+// does nothing. overflowed reports whether it did not fit. Port of
+// checkSignedBitfieldOverflow and checkUnsignedBitfieldOverflow:
+// https://github.com/redis/redis/blob/unstable/src/bitops.c
+func bitfieldOverflow(value, incr int64, width int, signed bool, overflow int) (int64, bool) {
+	if !signed {
+		maxValue := uint64(1)<<width - 1
+		u := uint64(value)
+
+		switch {
+		case u > maxValue || incr > 0 && uint64(incr) > maxValue-u:
+			if overflow == bitfieldSat {
+				return int64(maxValue), true
+			}
+			return int64((u + uint64(incr)) & maxValue), true
+		case incr < 0 && uint64(-incr) > u:
+			if overflow == bitfieldSat {
+				return 0, true
+			}
+			return int64((u + uint64(incr)) & maxValue), true
+		}
+		return int64(u + uint64(incr)), false
+	}
+
+	maxValue := int64(math.MaxInt64)
+	if width < 64 {
+		maxValue = int64(1)<<(width-1) - 1
+	}
+	minValue := -maxValue - 1
+
+	var over, under bool
+	if width == 64 {
+		over = incr > 0 && value > maxValue-incr
+		under = incr < 0 && value < minValue-incr
+	} else {
+		// the differences are only taken once value is known to be in range.
+		over = value > maxValue || value >= minValue && incr > maxValue-value
+		under = !over && (value < minValue || incr < minValue-value)
+	}
+
+	if !over && !under {
+		return value + incr, false
+	}
+
+	if overflow == bitfieldSat {
+		if over {
+			return maxValue, true
+		}
+		return minValue, true
+	}
+
+	// keep the lowest bits and extend the sign bit.
+	result := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := uint64(math.MaxUint64) << width
+		if result&(1<<(width-1)) != 0 {
+			result |= mask
+		} else {
+			result &^= mask
+		}
+	}
+	return int64(result), true
+}
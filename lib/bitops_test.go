@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It sets and gets bits", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 0, setbit(ctx, command(key, "7", "1").Array).Num)
+		assert.Equal(t, 1, setbit(ctx, command(key, "7", "0").Array).Num)
+		assert.Equal(t, 0, setbit(ctx, command(key, "17", "1").Array).Num)
+		assert.Equal(t, "\x00\x00\x40", get(ctx, command(key).Array).Bulk)
+
+		assert.Equal(t, 1, getbit(ctx, command(key, "17").Array).Num)
+		assert.Equal(t, 0, getbit(ctx, command(key, "1000").Array).Num)
+		assert.Equal(t, 0, getbit(ctx, command("string-missing", "0").Array).Num)
+
+		// integers are changed through their digits.
+		number := newTestKey(t, "SET", "1")
+		setbit(ctx, command(number, "6", "1").Array)
+		assert.Equal(t, "3", get(ctx, command(number).Array).Bulk)
+
+		assert.Equal(t, errBitValue.Error(), setbit(ctx, command(key, "0", "2").Array).Str)
+		assert.Equal(t, errBitOffset.Error(), setbit(ctx, command(key, "-1", "1").Array).Str)
+		assert.Equal(t, errBitOffset.Error(), setbit(ctx, command(key, "4294967296", "1").Array).Str)
+	})
+
+	t.Run("It counts set bits in byte and bit ranges", func(t *testing.T) {
+		key := newTestKey(t, "SET", "foobar")
+
+		assert.Equal(t, 26, bitcount(ctx, command(key).Array).Num)
+		assert.Equal(t, 4, bitcount(ctx, command(key, "0", "0").Array).Num)
+		assert.Equal(t, 6, bitcount(ctx, command(key, "1", "1", "BYTE").Array).Num)
+		assert.Equal(t, 17, bitcount(ctx, command(key, "5", "30", "BIT").Array).Num)
+		assert.Equal(t, 26, bitcount(ctx, command(key, "0", "-1").Array).Num)
+		assert.Equal(t, 0, bitcount(ctx, command(key, "3", "1").Array).Num)
+		assert.Equal(t, 0, bitcount(ctx, command("string-missing").Array).Num)
+
+		assert.Equal(t, errSyntax.Error(), bitcount(ctx, command(key, "0").Array).Str)
+		assert.Equal(t, errSyntax.Error(), bitcount(ctx, command(key, "0", "1", "WORD").Array).Str)
+	})
+
+	t.Run("It finds the first set or clear bit", func(t *testing.T) {
+		key := newTestKey(t, "SET", "\xff\xf0\x00")
+		assert.Equal(t, 12, bitpos(ctx, command(key, "0").Array).Num)
+
+		key = newTestKey(t, "SET", "\x00\xff\xf0")
+		assert.Equal(t, 8, bitpos(ctx, command(key, "1", "0").Array).Num)
+		assert.Equal(t, 16, bitpos(ctx, command(key, "1", "2").Array).Num)
+		assert.Equal(t, 16, bitpos(ctx, command(key, "1", "2", "-1", "BYTE").Array).Num)
+		assert.Equal(t, 8, bitpos(ctx, command(key, "1", "7", "15", "BIT").Array).Num)
+
+		key = newTestKey(t, "SET", "\xff\xff\xff")
+		assert.Equal(t, 24, bitpos(ctx, command(key, "0").Array).Num)
+		assert.Equal(t, -1, bitpos(ctx, command(key, "0", "0", "-1").Array).Num)
+
+		assert.Equal(t, -1, bitpos(ctx, command("string-missing", "1").Array).Num)
+		assert.Equal(t, 0, bitpos(ctx, command("string-missing", "0").Array).Num)
+		assert.Equal(t, "ERR The bit argument must be 1 or 0.", bitpos(ctx, command(key, "2").Array).Str)
+	})
+
+	t.Run("It combines strings bit by bit", func(t *testing.T) {
+		a, b, dest := newTestKey(t, "SET", "foobar"), newTestKey(t, "SET", "abcdef"), newTestKey(t)
+
+		assert.Equal(t, 6, bitop(ctx, command("AND", dest, a, b).Array).Num)
+		assert.Equal(t, "`bc`ab", get(ctx, command(dest).Array).Bulk)
+
+		short := newTestKey(t, "SET", "\x0f")
+		assert.Equal(t, 6, bitop(ctx, command("OR", dest, short, "string-missing", b).Array).Num)
+		assert.Equal(t, "obcdef", get(ctx, command(dest).Array).Bulk)
+		assert.Equal(t, 1, bitop(ctx, command("XOR", dest, short, short).Array).Num)
+		assert.Equal(t, "\x00", get(ctx, command(dest).Array).Bulk)
+		assert.Equal(t, 1, bitop(ctx, command("NOT", dest, short).Array).Num)
+		assert.Equal(t, "\xf0", get(ctx, command(dest).Array).Bulk)
+
+		// an empty result deletes the destination.
+		assert.Equal(t, 0, bitop(ctx, command("AND", dest, "string-missing").Array).Num)
+		assert.Equal(t, -2, ttl(ctx, command(dest).Array).Num)
+
+		assert.Equal(t, "ERR BITOP NOT must be called with a single source key.", bitop(ctx, command("NOT", dest, a, b).Array).Str)
+		assert.Equal(t, errSyntax.Error(), bitop(ctx, command("NAND", dest, a).Array).Str)
+	})
+
+	t.Run("It reads and writes integers of any width", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, []int{1, 0}, integers(bitfield(ctx, command(key, "INCRBY", "i5", "100", "1", "GET", "u4", "0").Array)))
+		assert.Equal(t, []int{0, -100}, integers(bitfield(ctx, command(key, "SET", "i8", "#1", "-100", "GET", "i8", "8").Array)))
+		assert.Equal(t, []int{156}, integers(bitfield(ctx, command(key, "GET", "u8", "#1").Array)))
+		assert.Equal(t, []int{0, 9223372036854775807}, integers(bitfield(ctx, command(key, "SET", "i64", "200", "9223372036854775807", "GET", "i64", "200").Array)))
+
+		assert.Equal(t, errBitfieldType.Error(), bitfield(ctx, command(key, "GET", "u64", "0").Array).Str)
+		assert.Equal(t, errBitfieldType.Error(), bitfield(ctx, command(key, "GET", "x8", "0").Array).Str)
+		assert.Equal(t, "ERR Invalid OVERFLOW type specified", bitfield(ctx, command(key, "OVERFLOW", "CLAMP").Array).Str)
+		assert.Equal(t, errSyntax.Error(), bitfield(ctx, command(key, "GET", "u8").Array).Str)
+	})
+
+	t.Run("It handles overflows by wrapping, saturating or failing", func(t *testing.T) {
+		key := newTestKey(t)
+
+		for _, expected := range [][]int{{1, 1}, {2, 2}, {3, 3}, {0, 3}} {
+			result := bitfield(ctx, command(key, "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1").Array)
+			assert.Equal(t, expected, integers(result))
+		}
+
+		bitfield(ctx, command(key, "SET", "i8", "0", "127").Array)
+		assert.Equal(t, []int{-128}, integers(bitfield(ctx, command(key, "INCRBY", "i8", "0", "1").Array)))
+		assert.Equal(t, []int{-128}, integers(bitfield(ctx, command(key, "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-1").Array)))
+		assert.Equal(t, []int{-128, 127}, integers(bitfield(ctx, command(key, "OVERFLOW", "SAT", "SET", "i8", "0", "1000", "GET", "i8", "0").Array)))
+
+		result := bitfield(ctx, command(key, "OVERFLOW", "FAIL", "INCRBY", "u2", "100", "5", "SET", "i8", "0", "-129").Array)
+		assert.Equal(t, "null", result.Array[0].Typ)
+		assert.Equal(t, "null", result.Array[1].Typ)
+
+		assert.Equal(t, []int{127, -1}, integers(bitfield(ctx, command(key, "SET", "u8", "0", "-1", "GET", "i8", "0").Array)))
+	})
+
+	t.Run("It only reads with BITFIELD_RO", func(t *testing.T) {
+		key := newTestKey(t, "SET", "\x80")
+
+		assert.Equal(t, []int{1}, integers(bitfieldRO(ctx, command(key, "GET", "u1", "0").Array)))
+		assert.Equal(t, errBitfieldReadOnly.Error(), bitfieldRO(ctx, command(key, "SET", "u1", "0", "0").Array).Str)
+
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		server.call(c, command("BITFIELD", key, "GET", "u8", "0"))
+		assert.Empty(t, c.propagated)
+	})
+}
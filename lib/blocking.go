@@ -0,0 +1,344 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// a client blocked by BLPOP, BRPOP, BLMOVE, BLMPOP, XREAD or XREADGROUP
+// until one of its keys can serve it or its timeout elapses.
+type waiter struct {
+	db          *SimpleStore // the database of the keys.
+	keys        []string
+	serve       serveFunc
+	destination string     // the list BLMOVE pushes to, it may unblock other clients.
+	result      chan Value // receives the reply once the client is served.
+}
+
+// serves a blocked client from the key that is ready, ok is false when the
+// key cannot serve it yet. The commands written in the AOF are returned
+// along with the reply. The store is locked for writing.
+type serveFunc func(key string) (reply Value, propagate []Value, ok bool)
+
+// serves the clients blocked on lists of db with the pop once the key holds a list.
+func servePop(db *SimpleStore, pop listPop) serveFunc {
+	return func(key string) (Value, []Value, bool) {
+		l, err := db.writableList(key, false)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}, nil, true
+		}
+		if l == nil {
+			return Value{}, nil, false
+		}
+
+		reply, propagate := pop(db, key, l)
+		return reply, propagate, true
+	}
+}
+
+// a key of one of the databases.
+type dbKey struct {
+	db  int
+	key string
+}
+
+// blockingKeys keeps the clients blocked on every key in the order they
+// blocked so the first one to block is the first one served.
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[dbKey][]*waiter
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{waiters: make(map[dbKey][]*waiter)}
+}
+
+func (b *blockingKeys) add(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range w.keys {
+		k := dbKey{w.db.id, key}
+		b.waiters[k] = append(b.waiters[k], w)
+	}
+}
+
+// unblocks the waiter on every key, it returns false when the
+// waiter was already served. The lock must be held.
+func (b *blockingKeys) removeLocked(w *waiter) bool {
+	removed := false
+
+	for _, key := range w.keys {
+		k := dbKey{w.db.id, key}
+		waiters := b.waiters[k]
+		for i, other := range waiters {
+			if other == w {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				removed = true
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(b.waiters, k)
+		} else {
+			b.waiters[k] = waiters
+		}
+	}
+
+	return removed
+}
+
+func (b *blockingKeys) remove(w *waiter) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.removeLocked(w)
+}
+
+// signalKeyAsReady tells the server a list was pushed to key of db, or an
+// entry added to the stream at key, the clients
+// blocked on it are served once the running command, or the transaction
+// it belongs to, is done.
+func signalKeyAsReady(ctx context.Context, db *SimpleStore, key string) {
+	c := clientFromContext(ctx)
+	if c == nil {
+		return
+	}
+	c.readyKeys = append(c.readyKeys, dbKey{db.id, key})
+}
+
+// serves the clients blocked on the keys the client pushed to, in the
+// order they blocked, for as long as the keys can serve them. Port of
+// handleClientsBlockedOnKeys: https://github.com/redis/redis/blob/unstable/src/blocked.c
+func (s *Server) serveBlockedClients(c *Client) {
+	keys := c.readyKeys
+	c.readyKeys = nil
+
+	for len(keys) > 0 {
+		key := keys[0]
+		keys = keys[1:]
+
+		db := s.databases[key.db]
+
+		// the shards are locked before the blocked clients are, like the
+		// commands lock them: the shard of the key and the ones of the
+		// lists the clients move to.
+		s.blocking.mu.Lock()
+		waiters := slices.Clone(s.blocking.waiters[key])
+		s.blocking.mu.Unlock()
+
+		locked := []string{key.key}
+		for _, w := range waiters {
+			if w.destination != "" {
+				locked = append(locked, w.destination)
+			}
+		}
+		unlock := db.lock(context.Background(), locked...)
+		s.blocking.mu.Lock()
+
+		// serving a waiter removes it from the ones blocked on the key. The
+		// clients that blocked since found the key unable to serve them.
+		for _, w := range waiters {
+			// the client may have given up or been served by another client.
+			if !slices.Contains(s.blocking.waiters[key], w) {
+				continue
+			}
+
+			reply, propagate, ok := w.serve(key.key)
+			if !ok {
+				continue
+			}
+
+			s.blocking.removeLocked(w)
+			w.result <- reply
+
+			// the AOF gets the pop right after the push that allowed it.
+			for _, command := range propagate {
+				s.propagate(db.id, command)
+			}
+
+			// BLMOVE pushes to a list other clients may be blocked on.
+			if w.destination != "" {
+				keys = append(keys, dbKey{db.id, w.destination})
+			}
+		}
+
+		s.blocking.mu.Unlock()
+		unlock()
+	}
+}
+
+// serves the client from the first of the keys able to, or blocks it
+// until one of them is, the timeout elapses, the client disconnects or
+// the server shuts down. A timeout of 0 blocks forever. Clients inside a
+// transaction never block, they get the timeout reply right away like
+// clients that are not connected.
+func blockForKeys(ctx context.Context, w *waiter, timeout time.Duration, timeoutReply Value) Value {
+	keys := w.keys
+	if w.destination != "" {
+		keys = append(slices.Clone(keys), w.destination)
+	}
+	unlock := w.db.lock(ctx, keys...)
+
+	for _, key := range w.keys {
+		reply, propagate, ok := w.serve(key)
+		if !ok {
+			continue
+		}
+		unlock()
+
+		propagateAs(ctx, propagate...)
+		if w.destination != "" {
+			signalKeyAsReady(ctx, w.db, w.destination)
+		}
+		return reply
+	}
+
+	// once blocked, the command is written in the AOF by the client serving it.
+	propagateAs(ctx)
+
+	c := clientFromContext(ctx)
+	if c == nil || c.conn == nil || c.hasFlag(ClientMulti) {
+		unlock()
+		return timeoutReply
+	}
+
+	// a push cannot happen between the keys being unable to serve the
+	// client and the client being registered as blocked, the shards of the
+	// keys are still locked.
+	w.result = make(chan Value, 1)
+	c.srv.blocking.add(w)
+	unlock()
+
+	// nothing is written in the AOF before the client is served, the shards
+	// call holds for it are unlocked while it waits.
+	c.releaseShards()
+
+	// the replies of the commands pipelined before this one are sent
+	// first, the client would not get them before it is served otherwise.
+	c.writer.Flush()
+
+	stop := c.watchDisconnect()
+	defer stop()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case reply := <-w.result:
+		return reply
+	case <-expired:
+	case <-ctx.Done():
+	}
+
+	// the client may have been served while it was giving up.
+	if !c.srv.blocking.remove(w) {
+		return <-w.result
+	}
+	return timeoutReply
+}
+
+// parses the timeout of the blocking commands, seconds with decimals.
+func parseTimeout(arg string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+
+	if seconds < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, errors.New("ERR timeout is out of range")
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// doc: https://redis.io/docs/latest/commands/blpop/
+func blpop(ctx context.Context, args []Value) Value {
+	return blockingPopGeneric(ctx, "blpop", args, listHead)
+}
+
+// doc: https://redis.io/docs/latest/commands/brpop/
+func brpop(ctx context.Context, args []Value) Value {
+	return blockingPopGeneric(ctx, "brpop", args, listTail)
+}
+
+func blockingPopGeneric(ctx context.Context, name string, args []Value, where int) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	timeout, err := parseTimeout(args[len(args)-1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	w := &waiter{db: db, serve: servePop(db, popElement(where))}
+	for _, key := range args[:len(args)-1] {
+		w.keys = append(w.keys, key.Bulk)
+	}
+
+	return blockForKeys(ctx, w, timeout, Value{Typ: "nullarray"})
+}
+
+// doc: https://redis.io/docs/latest/commands/blmove/
+func blmove(ctx context.Context, args []Value) Value {
+	if len(args) != 5 {
+		return wrongNumberOfArgs("blmove")
+	}
+
+	from, ok1 := parseListWhere(args[2].Bulk)
+	to, ok2 := parseListWhere(args[3].Bulk)
+	if !ok1 || !ok2 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	timeout, err := parseTimeout(args[4].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	w := &waiter{
+		db:          db,
+		keys:        []string{args[0].Bulk},
+		serve:       servePop(db, moveElement(args[1].Bulk, from, to)),
+		destination: args[1].Bulk,
+	}
+
+	return blockForKeys(ctx, w, timeout, Value{Typ: "null"})
+}
+
+// doc: https://redis.io/docs/latest/commands/blmpop/
+func blmpop(ctx context.Context, args []Value) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("blmpop")
+	}
+
+	timeout, err := parseTimeout(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	keys, where, count, err := parseMultiPopArgs(args[1:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	w := &waiter{db: db, keys: keys, serve: servePop(db, popElements(where, count))}
+	return blockForKeys(ctx, w, timeout, Value{Typ: "nullarray"})
+}
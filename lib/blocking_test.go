@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waits until n clients are blocked on the key of the first database.
+func waitBlocked(t *testing.T, server *Server, key string, n int) {
+	waitBlockedIn(t, server, 0, key, n)
+}
+
+// waits until n clients are blocked on the key of the database db.
+func waitBlockedIn(t *testing.T, server *Server, db int, key string, n int) {
+	assert.Eventually(t, func() bool {
+		server.blocking.mu.Lock()
+		defer server.blocking.mu.Unlock()
+		return len(server.blocking.waiters[dbKey{db, key}]) == n
+	}, time.Second, time.Millisecond)
+}
+
+// runs the command in the background, it returns the reply once the command is done.
+func runBlocking(server *Server, c *Client, value Value) chan Value {
+	result := make(chan Value, 1)
+	go func() { result <- server.handleCommandExecution(c, value) }()
+	return result
+}
+
+func receive(t *testing.T, result chan Value) Value {
+	select {
+	case value := <-result:
+		return value
+	case <-time.After(time.Second):
+		t.Fatal("the blocked client was not served")
+		return Value{}
+	}
+}
+
+func TestBlockingPops(t *testing.T) {
+	t.Run("It blocks the client until an element is pushed", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		result := runBlocking(server, testClient(t, server), command("BLPOP", "blocking-other", key, "0"))
+		waitBlocked(t, server, key, 1)
+
+		server.handleCommandExecution(testClient(t, server), command("RPUSH", key, "a", "b"))
+
+		reply := receive(t, result)
+		assert.Equal(t, []string{key, "a"}, bulks(reply))
+		assert.Equal(t, 1, llen(context.Background(), command(key).Array).Num)
+	})
+
+	t.Run("It serves the clients blocked on the same key in the order they blocked", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		first := runBlocking(server, testClient(t, server), command("BRPOP", key, "0"))
+		waitBlocked(t, server, key, 1)
+		second := runBlocking(server, testClient(t, server), command("BLPOP", key, "0"))
+		waitBlocked(t, server, key, 2)
+
+		pusher := testClient(t, server)
+		server.handleCommandExecution(pusher, command("RPUSH", key, "a"))
+		assert.Equal(t, []string{key, "a"}, bulks(receive(t, first)))
+
+		server.handleCommandExecution(pusher, command("RPUSH", key, "b"))
+		assert.Equal(t, []string{key, "b"}, bulks(receive(t, second)))
+	})
+
+	t.Run("It only serves blocked clients once the transaction is executed", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		result := runBlocking(server, testClient(t, server), command("BLPOP", key, "0.2"))
+		waitBlocked(t, server, key, 1)
+
+		// the element is gone when the transaction is done.
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("MULTI"))
+		server.handleCommandExecution(c, command("RPUSH", key, "a"))
+		server.handleCommandExecution(c, command("LPOP", key))
+		exec := server.handleCommandExecution(c, command("EXEC"))
+		assert.Equal(t, "a", exec.Array[1].Bulk)
+
+		assert.Equal(t, "nullarray", receive(t, result).Typ)
+	})
+
+	t.Run("It replies with a null once the timeout elapses", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		start := time.Now()
+		reply := server.handleCommandExecution(testClient(t, server), command("BLMPOP", "0.05", "1", key, "LEFT"))
+		assert.Equal(t, "nullarray", reply.Typ)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		reply = server.handleCommandExecution(testClient(t, server), command("BLMOVE", key, "other", "LEFT", "LEFT", "0.01"))
+		assert.Equal(t, "null", reply.Typ)
+
+		waitBlocked(t, server, key, 0)
+	})
+
+	t.Run("It stops waiting when the client disconnects", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		conn, peer := net.Pipe()
+		defer conn.Close()
+		c := server.newClient(conn)
+
+		result := runBlocking(server, c, command("BLPOP", key, "0"))
+		waitBlocked(t, server, key, 1)
+
+		peer.Close()
+
+		assert.Equal(t, "nullarray", receive(t, result).Typ)
+		waitBlocked(t, server, key, 0)
+	})
+
+	t.Run("It sends the replies of the commands pipelined before it blocks", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		client, conn := net.Pipe()
+		defer client.Close()
+		go server.readConn(conn)
+
+		pipeline := "SET blocking-pipelined 1\r\nBLPOP " + key + " 0\r\n"
+		_, err := client.Write([]byte(pipeline))
+		assert.Nil(t, err)
+
+		reader := bufio.NewReader(client)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "+OK\r\n", line)
+
+		waitBlocked(t, server, key, 1)
+		server.handleCommandExecution(testClient(t, server), command("RPUSH", key, "a"))
+
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		line, err = reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "*2\r\n", line)
+	})
+
+	t.Run("It stops waiting when the server shuts down", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "blocking-" + strconv.Itoa(rand.Int())
+
+		result := runBlocking(server, testClient(t, server), command("BLPOP", key, "0"))
+		waitBlocked(t, server, key, 1)
+
+		server.Shutdown()
+
+		assert.Equal(t, "nullarray", receive(t, result).Typ)
+	})
+
+	t.Run("It does not block inside a transaction", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("MULTI"))
+		server.handleCommandExecution(c, command("BLPOP", "blocking-missing", "0"))
+		exec := server.handleCommandExecution(c, command("EXEC"))
+
+		assert.Equal(t, "nullarray", exec.Array[0].Typ)
+	})
+
+	t.Run("It hands the elements moved by BLMOVE to the clients blocked on the destination", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		source := "blocking-" + strconv.Itoa(rand.Int())
+		destination := "blocking-" + strconv.Itoa(rand.Int())
+
+		move := runBlocking(server, testClient(t, server), command("BLMOVE", source, destination, "LEFT", "RIGHT", "0"))
+		waitBlocked(t, server, source, 1)
+		pop := runBlocking(server, testClient(t, server), command("BLPOP", destination, "0"))
+		waitBlocked(t, server, destination, 1)
+
+		server.handleCommandExecution(testClient(t, server), command("LPUSH", source, "a"))
+
+		assert.Equal(t, "a", receive(t, move).Bulk)
+		assert.Equal(t, []string{destination, "a"}, bulks(receive(t, pop)))
+	})
+
+	t.Run("It refuses invalid timeouts", func(t *testing.T) {
+		result := blpop(context.Background(), command("blocking-key", "-1").Array)
+		assert.Equal(t, "ERR timeout is negative", result.Str)
+
+		result = blpop(context.Background(), command("blocking-key", "soon").Array)
+		assert.Equal(t, "ERR timeout is not a float or out of range", result.Str)
+	})
+}
@@ -0,0 +1,274 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flags describing the state of a client.
+const (
+	ClientMulti     = 1 << iota // the client is inside MULTI, its commands are queued.
+	ClientDirtyExec             // a command was refused while queuing, EXEC must fail.
+)
+
+// Client is the session of a single connection, everything that belongs
+// to one client rather than to the whole server lives here so clients
+// never see each other's transactions or replies.
+type Client struct {
+	id     int64
+	srv    *Server
+	conn   net.Conn
+	resp   *Resp   // parser of the commands sent by the client.
+	writer *Writer // buffered replies of the client.
+	queue  []Value // commands queued between MULTI and EXEC.
+	db     int     // the database the client operates on, see SELECT.
+	name   string  // set with CLIENT SETNAME or HELLO SETNAME.
+	flags  int
+
+	propagated []Value // what the running command writes in the AOF, see propagateAs.
+	rewritten  bool    // whether propagated replaces the running command.
+	readyKeys  []dbKey // keys pushed to by the running command, see signalKeyAsReady.
+
+	// the shards the running command locked for writing are held until it
+	// is in the AOF, see lockShards.
+	holdShards       bool
+	heldShards       []func() // unlocks them.
+	holdsQueueShards bool     // EXEC holds the shards of its queue while it runs.
+
+	// cancelled when the client disconnects or the server shuts down,
+	// handlers receive it so blocked clients stop waiting.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type clientKey struct{}
+
+func (s *Server) newClient(conn net.Conn) *Client {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	c := &Client{
+		id:     s.nextClientID.Add(1),
+		srv:    s,
+		conn:   conn,
+		resp:   NewResp(conn),
+		writer: s.spawnWriter(conn),
+		queue:  make([]Value, 0),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	return c
+}
+
+// a client without a connection, used to replay the AOF.
+func (s *Server) newFakeClient() *Client {
+	ctx, cancel := context.WithCancel(s.ctx)
+	return &Client{id: -1, srv: s, queue: make([]Value, 0), ctx: ctx, cancel: cancel}
+}
+
+// the context handed to command handlers carries the client running the command.
+func contextWithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// returns the client running the command or nil when a handler
+// is called outside of a connection e.g. in tests.
+func clientFromContext(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
+
+// the protocol negotiated by the client running the command, RESP2
+// when there is none.
+func clientProto(ctx context.Context) int {
+	c := clientFromContext(ctx)
+	if c == nil || c.writer == nil {
+		return RESP2
+	}
+	return c.writer.Proto()
+}
+
+// the database the client running the command operates on, the first
+// one when there is no client.
+func clientDB(ctx context.Context) *SimpleStore {
+	c := clientFromContext(ctx)
+	if c == nil {
+		return KvStore
+	}
+	return c.srv.databases[c.db]
+}
+
+// the databases of the server the client running the command is connected
+// to, only the first one when there is no client.
+func clientDatabases(ctx context.Context) []*SimpleStore {
+	c := clientFromContext(ctx)
+	if c == nil {
+		return []*SimpleStore{KvStore}
+	}
+	return c.srv.databases
+}
+
+// watches the connection while the client is blocked and nobody reads
+// from it, the context of the client is cancelled if it disconnects.
+// The returned function stops watching.
+func (c *Client) watchDisconnect() (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		// commands sent while blocked are left for the next read.
+		if err := c.resp.Peek(); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			c.cancel()
+		}
+	}()
+
+	return func() {
+		// wakes the watcher up, the deadline is removed once it is gone
+		// unless the server set one to disconnect the client on shutdown.
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		if !c.srv.shuttingDown.Load() {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+func (c *Client) hasFlag(flag int) bool {
+	return c.flags&flag != 0
+}
+
+func (c *Client) addFlag(flag int) {
+	c.flags |= flag
+}
+
+func (c *Client) clearFlag(flag int) {
+	c.flags &^= flag
+}
+
+// leaves the transaction state, whether it was executed or discarded.
+func (c *Client) discardTransaction() {
+	c.queue = c.queue[:0]
+	c.clearFlag(ClientMulti | ClientDirtyExec)
+}
+
+// client names are shown in logs so they cannot contain spaces or newlines.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// doc: https://redis.io/docs/latest/commands/hello/
+func hello(ctx context.Context, args []Value) Value {
+	c := clientFromContext(ctx)
+	if c == nil || c.writer == nil {
+		return Value{Typ: "error", Str: "ERR HELLO requires a connection"}
+	}
+
+	proto := c.writer.Proto()
+	name := c.name
+
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: "ERR Protocol version is not an integer or out of range"}
+		}
+
+		if version != RESP2 && version != RESP3 {
+			return Value{Typ: "error", Str: "NOPROTO unsupported protocol version"}
+		}
+		proto = version
+
+		for i := 1; i < len(args); i++ {
+			option := strings.ToLower(args[i].Bulk)
+			more := len(args) - i - 1
+
+			if option == "auth" && more >= 2 {
+				// there are no users besides the default one and it has no password.
+				if args[i+1].Bulk != "default" {
+					return Value{Typ: "error", Str: "WRONGPASS invalid username-password pair or user is disabled."}
+				}
+				i += 2
+				continue
+			}
+
+			if option == "setname" && more >= 1 {
+				if !validClientName(args[i+1].Bulk) {
+					return Value{Typ: "error", Str: "ERR Client names cannot contain spaces, newlines or special characters."}
+				}
+				name = args[i+1].Bulk
+				i++
+				continue
+			}
+
+			return Value{Typ: "error", Str: fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i].Bulk)}
+		}
+	}
+
+	// nothing changes unless every option was valid.
+	c.name = name
+
+	// the reply is already sent with the negotiated protocol.
+	c.writer.SetProto(proto)
+
+	return Value{Typ: "map", Array: []Value{
+		{Typ: "bulk", Bulk: "server"}, {Typ: "bulk", Bulk: "minired"},
+		{Typ: "bulk", Bulk: "version"}, {Typ: "bulk", Bulk: Version},
+		{Typ: "bulk", Bulk: "proto"}, {Typ: "integer", Num: proto},
+		{Typ: "bulk", Bulk: "id"}, {Typ: "integer", Num: int(c.id)},
+		{Typ: "bulk", Bulk: "mode"}, {Typ: "bulk", Bulk: "standalone"},
+		{Typ: "bulk", Bulk: "role"}, {Typ: "bulk", Bulk: "master"},
+		{Typ: "bulk", Bulk: "modules"}, {Typ: "array", Array: []Value{}},
+	}}
+}
+
+// doc: https://redis.io/docs/latest/commands/client/
+func client(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("client")
+	}
+
+	c := clientFromContext(ctx)
+	if c == nil {
+		return Value{Typ: "error", Str: "ERR CLIENT requires a connection"}
+	}
+
+	subcommand := strings.ToLower(args[0].Bulk)
+
+	switch {
+	case subcommand == "id" && len(args) == 1:
+		return Value{Typ: "integer", Num: int(c.id)}
+
+	case subcommand == "getname" && len(args) == 1:
+		if c.name == "" {
+			return Value{Typ: "null"}
+		}
+		return Value{Typ: "bulk", Bulk: c.name}
+
+	case subcommand == "setname" && len(args) == 2:
+		if !validClientName(args[1].Bulk) {
+			return Value{Typ: "error", Str: "ERR Client names cannot contain spaces, newlines or special characters."}
+		}
+		c.name = args[1].Bulk
+		return Value{Typ: "string", Str: "OK"}
+
+	default:
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", args[0].Bulk)}
+	}
+}
+
+// unlocks the shards the running command holds, see lockShards.
+func (c *Client) releaseShards() {
+	for i := len(c.heldShards) - 1; i >= 0; i-- {
+		c.heldShards[i]()
+	}
+	c.heldShards = nil
+}
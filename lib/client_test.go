@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelloCommand(t *testing.T) {
+	t.Run("It switches the connection to RESP3 when version 3 is requested", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		result := server.handleCommandExecution(c, command("HELLO", "3"))
+
+		assert.Equal(t, "map", result.Typ)
+		assert.Equal(t, RESP3, c.writer.Proto())
+	})
+
+	t.Run("It keeps the current protocol when no version is sent", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		result := server.handleCommandExecution(c, command("HELLO"))
+
+		assert.Equal(t, "map", result.Typ)
+		assert.Equal(t, RESP2, c.writer.Proto())
+	})
+
+	t.Run("It returns a NOPROTO error for unsupported versions", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		result := server.handleCommandExecution(c, command("HELLO", "4"))
+
+		assert.Equal(t, "error", result.Typ)
+		assert.Contains(t, result.Str, "NOPROTO")
+		assert.Equal(t, RESP2, c.writer.Proto())
+	})
+
+	t.Run("It accepts AUTH for the default user and SETNAME", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		result := server.handleCommandExecution(c, command("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "worker"))
+
+		assert.Equal(t, "map", result.Typ)
+		assert.Equal(t, RESP3, c.writer.Proto())
+		assert.Equal(t, "worker", c.name)
+	})
+
+	t.Run("It only negotiates the protocol of the connection that sent it", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		first := testClient(t, server)
+		second := testClient(t, server)
+
+		server.handleCommandExecution(first, command("HELLO", "3"))
+
+		assert.Equal(t, RESP3, first.writer.Proto())
+		assert.Equal(t, RESP2, second.writer.Proto())
+	})
+}
+
+func TestClientCommand(t *testing.T) {
+	t.Run("It returns a different id for every client", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		first := testClient(t, server)
+		second := testClient(t, server)
+
+		firstID := client(contextWithClient(context.Background(), first), command("ID").Array)
+		secondID := client(contextWithClient(context.Background(), second), command("ID").Array)
+
+		assert.Equal(t, "integer", firstID.Typ)
+		assert.NotEqual(t, firstID.Num, secondID.Num)
+	})
+
+	t.Run("It sets and gets the name of the client", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		assert.Equal(t, "null", client(ctx, command("GETNAME").Array).Typ)
+		assert.Equal(t, "OK", client(ctx, command("SETNAME", "api").Array).Str)
+		assert.Equal(t, "api", client(ctx, command("GETNAME").Array).Bulk)
+	})
+
+	t.Run("It refuses names with spaces", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		result := client(ctx, command("SETNAME", "my api").Array)
+
+		assert.Equal(t, "error", result.Typ)
+	})
+}
@@ -2,152 +2,681 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync"
+	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
 // the string is the command while the func is the handler
 var CommandHandlers = map[string]func(ctx context.Context, val []Value) Value{
-	"ping":    ping,
-	"set":     set,
-	"get":     get,
-	"hset":    hset,
-	"hget":    hget,
-	"hgetall": hgetall,
+	"ping":     ping,
+	"set":      set,
+	"get":      get,
+	"hset":     hset,
+	"hget":     hget,
+	"hgetall":  hgetall,
+	"hello":    hello,
+	"client":   client,
+	"config":   config,
+	"shutdown": shutdown,
+
+	"expire":      expire,
+	"pexpire":     pexpire,
+	"expireat":    expireat,
+	"pexpireat":   pexpireat,
+	"ttl":         ttl,
+	"pttl":        pttl,
+	"expiretime":  expiretime,
+	"pexpiretime": pexpiretime,
+	"persist":     persist,
+
+	"object":    objectCommand,
+	"del":       del,
+	"unlink":    unlink,
+	"exists":    exists,
+	"touch":     touch,
+	"type":      typeCommand,
+	"rename":    rename,
+	"renamenx":  renamenx,
+	"copy":      copyCommand,
+	"randomkey": randomkey,
+	"dbsize":    dbsize,
+	"flushdb":   flushdb,
+	"flushall":  flushall,
+	"keys":      keys,
+	"scan":      scan,
+	"select":    selectCommand,
+	"move":      move,
+	"swapdb":    swapdb,
+
+	"setnx":  setnx,
+	"getset": getset,
+	"getdel": getdel,
+	"getex":  getex,
+
+	"incr":        incr,
+	"decr":        decr,
+	"incrby":      incrby,
+	"decrby":      decrby,
+	"incrbyfloat": incrbyfloat,
+	"append":      appendCommand,
+	"strlen":      strlen,
+	"getrange":    getrange,
+	"setrange":    setrange,
+	"mget":        mget,
+	"mset":        mset,
+	"msetnx":      msetnx,
+	"lcs":         lcs,
+
+	"setbit":      setbit,
+	"getbit":      getbit,
+	"bitcount":    bitcount,
+	"bitpos":      bitpos,
+	"bitop":       bitop,
+	"bitfield":    bitfield,
+	"bitfield_ro": bitfieldRO,
+
+	"pfadd":   pfadd,
+	"pfcount": pfcount,
+	"pfmerge": pfmerge,
+
+	"geoadd":         geoadd,
+	"geopos":         geopos,
+	"geohash":        geohash,
+	"geodist":        geodist,
+	"geosearch":      geosearch,
+	"geosearchstore": geosearchstore,
+
+	"lpush":   lpush,
+	"rpush":   rpush,
+	"lpushx":  lpushx,
+	"rpushx":  rpushx,
+	"lpop":    lpop,
+	"rpop":    rpop,
+	"llen":    llen,
+	"lrange":  lrange,
+	"lindex":  lindex,
+	"lset":    lset,
+	"linsert": linsert,
+	"lrem":    lrem,
+	"ltrim":   ltrim,
+	"lpos":    lpos,
+	"lmove":   lmove,
+	"lmpop":   lmpop,
+	"blpop":   blpop,
+	"brpop":   brpop,
+	"blmove":  blmove,
+	"blmpop":  blmpop,
+
+	"sadd":        sadd,
+	"srem":        srem,
+	"smembers":    smembers,
+	"sismember":   sismember,
+	"smismember":  smismember,
+	"scard":       scard,
+	"spop":        spop,
+	"srandmember": srandmember,
+	"smove":       smove,
+	"sinter":      sinter,
+	"sunion":      sunion,
+	"sdiff":       sdiff,
+	"sinterstore": sinterstore,
+	"sunionstore": sunionstore,
+	"sdiffstore":  sdiffstore,
+	"sintercard":  sintercard,
+
+	"zadd":             zadd,
+	"zincrby":          zincrby,
+	"zrem":             zrem,
+	"zscore":           zscore,
+	"zmscore":          zmscore,
+	"zcard":            zcard,
+	"zcount":           zcount,
+	"zrank":            zrank,
+	"zrevrank":         zrevrank,
+	"zrange":           zrange,
+	"zrangestore":      zrangestore,
+	"zpopmin":          zpopmin,
+	"zpopmax":          zpopmax,
+	"zremrangebyrank":  zremrangebyrank,
+	"zremrangebyscore": zremrangebyscore,
+	"zremrangebylex":   zremrangebylex,
+	"zunionstore":      zunionstore,
+	"zinterstore":      zinterstore,
+	"zdiffstore":       zdiffstore,
+
+	"xadd":       xadd,
+	"xrange":     xrange,
+	"xrevrange":  xrevrange,
+	"xlen":       xlen,
+	"xdel":       xdel,
+	"xtrim":      xtrim,
+	"xread":      xread,
+	"xgroup":     xgroup,
+	"xreadgroup": xreadgroup,
+	"xack":       xack,
+	"xpending":   xpending,
+	"xclaim":     xclaim,
+	"xautoclaim": xautoclaim,
+	"xinfo":      xinfo,
+
+	"hsetnx":       hsetnx,
+	"hmget":        hmget,
+	"hkeys":        hkeys,
+	"hvals":        hvals,
+	"hdel":         hdel,
+	"hexists":      hexists,
+	"hlen":         hlen,
+	"hstrlen":      hstrlen,
+	"hincrby":      hincrby,
+	"hincrbyfloat": hincrbyfloat,
+	"hrandfield":   hrandfield,
+	"hscan":        hscan,
+
+	"hexpire":    hexpire,
+	"hpexpire":   hpexpire,
+	"hexpireat":  hexpireat,
+	"hpexpireat": hpexpireat,
+	"httl":       httl,
+	"hpttl":      hpttl,
+	"hpersist":   hpersist,
+	"hgetex":     hgetex,
+	"hsetex":     hsetex,
 }
 
-type SimpleStore struct {
-	mu        sync.RWMutex
-	kvStore   map[string]string
-	hashStore map[string]map[string]string
+// commands changing the dataset, they are recorded in the AOF unless
+// they fail. See propagateAs for the ones not written as they are sent.
+var writeCommands = map[string]bool{
+	"set":              true,
+	"hset":             true,
+	"expire":           true,
+	"pexpire":          true,
+	"expireat":         true,
+	"pexpireat":        true,
+	"persist":          true,
+	"del":              true,
+	"unlink":           true,
+	"rename":           true,
+	"renamenx":         true,
+	"copy":             true,
+	"flushdb":          true,
+	"flushall":         true,
+	"move":             true,
+	"swapdb":           true,
+	"setnx":            true,
+	"getset":           true,
+	"getdel":           true,
+	"getex":            true,
+	"incr":             true,
+	"decr":             true,
+	"incrby":           true,
+	"decrby":           true,
+	"incrbyfloat":      true,
+	"append":           true,
+	"setrange":         true,
+	"mset":             true,
+	"msetnx":           true,
+	"setbit":           true,
+	"bitop":            true,
+	"bitfield":         true,
+	"pfadd":            true,
+	"pfmerge":          true,
+	"lpush":            true,
+	"rpush":            true,
+	"lpushx":           true,
+	"rpushx":           true,
+	"lpop":             true,
+	"rpop":             true,
+	"lset":             true,
+	"linsert":          true,
+	"lrem":             true,
+	"ltrim":            true,
+	"lmove":            true,
+	"lmpop":            true,
+	"blpop":            true,
+	"brpop":            true,
+	"blmove":           true,
+	"blmpop":           true,
+	"sadd":             true,
+	"srem":             true,
+	"spop":             true,
+	"smove":            true,
+	"sinterstore":      true,
+	"sunionstore":      true,
+	"sdiffstore":       true,
+	"zadd":             true,
+	"zincrby":          true,
+	"zrem":             true,
+	"zrangestore":      true,
+	"zpopmin":          true,
+	"zpopmax":          true,
+	"zremrangebyrank":  true,
+	"zremrangebyscore": true,
+	"zremrangebylex":   true,
+	"zunionstore":      true,
+	"zinterstore":      true,
+	"zdiffstore":       true,
+	"geoadd":           true,
+	"geosearchstore":   true,
+	"xadd":             true,
+	"xdel":             true,
+	"xtrim":            true,
+	"xgroup":           true,
+	"xreadgroup":       true,
+	"xack":             true,
+	"xclaim":           true,
+	"xautoclaim":       true,
+	"hsetnx":           true,
+	"hdel":             true,
+	"hincrby":          true,
+	"hincrbyfloat":     true,
+	"hexpire":          true,
+	"hpexpire":         true,
+	"hexpireat":        true,
+	"hpexpireat":       true,
+	"hpersist":         true,
+	"hgetex":           true,
+	"hsetex":           true,
 }
 
-// for testing purposes.
-var KvStore SimpleStore = SimpleStore{
-	kvStore:   map[string]string{},
-	hashStore: map[string]map[string]string{},
-	mu:        sync.RWMutex{},
+// where the keys of the commands are in their arguments, EXEC locks the
+// shards of the keys of its queue and no other. The commands missing work
+// on the whole keyspace or on another database, EXEC locks every shard
+// for them.
+var commandKeys = map[string]func(args []Value) []string{
+	"ping":             noKeys,
+	"set":              keyAt(0),
+	"get":              keyAt(0),
+	"hset":             keyAt(0),
+	"hget":             keyAt(0),
+	"hgetall":          keyAt(0),
+	"hello":            noKeys,
+	"client":           noKeys,
+	"config":           noKeys,
+	"expire":           keyAt(0),
+	"pexpire":          keyAt(0),
+	"expireat":         keyAt(0),
+	"pexpireat":        keyAt(0),
+	"ttl":              keyAt(0),
+	"pttl":             keyAt(0),
+	"expiretime":       keyAt(0),
+	"pexpiretime":      keyAt(0),
+	"persist":          keyAt(0),
+	"object":           keyAt(1),
+	"del":              keysFrom(0, -1, 1),
+	"unlink":           keysFrom(0, -1, 1),
+	"exists":           keysFrom(0, -1, 1),
+	"touch":            keysFrom(0, -1, 1),
+	"type":             keyAt(0),
+	"rename":           keysFrom(0, 1, 1),
+	"renamenx":         keysFrom(0, 1, 1),
+	"select":           noKeys,
+	"setnx":            keyAt(0),
+	"getset":           keyAt(0),
+	"getdel":           keyAt(0),
+	"getex":            keyAt(0),
+	"incr":             keyAt(0),
+	"decr":             keyAt(0),
+	"incrby":           keyAt(0),
+	"decrby":           keyAt(0),
+	"incrbyfloat":      keyAt(0),
+	"append":           keyAt(0),
+	"strlen":           keyAt(0),
+	"getrange":         keyAt(0),
+	"setrange":         keyAt(0),
+	"mget":             keysFrom(0, -1, 1),
+	"mset":             keysFrom(0, -1, 2),
+	"msetnx":           keysFrom(0, -1, 2),
+	"lcs":              keysFrom(0, 1, 1),
+	"setbit":           keyAt(0),
+	"getbit":           keyAt(0),
+	"bitcount":         keyAt(0),
+	"bitpos":           keyAt(0),
+	"bitop":            keysFrom(1, -1, 1),
+	"bitfield":         keyAt(0),
+	"pfadd":            keyAt(0),
+	"pfcount":          keysFrom(0, -1, 1),
+	"pfmerge":          keysFrom(0, -1, 1),
+	"geoadd":           keyAt(0),
+	"geopos":           keyAt(0),
+	"geohash":          keyAt(0),
+	"geodist":          keyAt(0),
+	"geosearch":        keyAt(0),
+	"geosearchstore":   keysFrom(0, 1, 1),
+	"lpush":            keyAt(0),
+	"rpush":            keyAt(0),
+	"lpushx":           keyAt(0),
+	"rpushx":           keyAt(0),
+	"lpop":             keyAt(0),
+	"rpop":             keyAt(0),
+	"llen":             keyAt(0),
+	"lrange":           keyAt(0),
+	"lindex":           keyAt(0),
+	"lset":             keyAt(0),
+	"linsert":          keyAt(0),
+	"lrem":             keyAt(0),
+	"ltrim":            keyAt(0),
+	"lpos":             keyAt(0),
+	"lmove":            keysFrom(0, 1, 1),
+	"lmpop":            numkeysAt(0),
+	"blpop":            keysFrom(0, -2, 1),
+	"brpop":            keysFrom(0, -2, 1),
+	"blmove":           keysFrom(0, 1, 1),
+	"blmpop":           numkeysAt(1),
+	"sadd":             keyAt(0),
+	"srem":             keyAt(0),
+	"smembers":         keyAt(0),
+	"sismember":        keyAt(0),
+	"smismember":       keyAt(0),
+	"scard":            keyAt(0),
+	"spop":             keyAt(0),
+	"srandmember":      keyAt(0),
+	"smove":            keysFrom(0, 1, 1),
+	"sinter":           keysFrom(0, -1, 1),
+	"sunion":           keysFrom(0, -1, 1),
+	"sdiff":            keysFrom(0, -1, 1),
+	"sinterstore":      keysFrom(0, -1, 1),
+	"sunionstore":      keysFrom(0, -1, 1),
+	"sdiffstore":       keysFrom(0, -1, 1),
+	"sintercard":       numkeysAt(0),
+	"zadd":             keyAt(0),
+	"zincrby":          keyAt(0),
+	"zrem":             keyAt(0),
+	"zscore":           keyAt(0),
+	"zmscore":          keyAt(0),
+	"zcard":            keyAt(0),
+	"zcount":           keyAt(0),
+	"zrank":            keyAt(0),
+	"zrevrank":         keyAt(0),
+	"zrange":           keyAt(0),
+	"zrangestore":      keysFrom(0, 1, 1),
+	"zpopmin":          keyAt(0),
+	"zpopmax":          keyAt(0),
+	"zremrangebyrank":  keyAt(0),
+	"zremrangebyscore": keyAt(0),
+	"zremrangebylex":   keyAt(0),
+	"zunionstore":      destinationAndNumkeys,
+	"zinterstore":      destinationAndNumkeys,
+	"zdiffstore":       destinationAndNumkeys,
+	"xadd":             keyAt(0),
+	"xrange":           keyAt(0),
+	"xrevrange":        keyAt(0),
+	"xlen":             keyAt(0),
+	"xdel":             keyAt(0),
+	"xtrim":            keyAt(0),
+	"xread":            streamKeys,
+	"xgroup":           keyAt(1),
+	"xreadgroup":       streamKeys,
+	"xack":             keyAt(0),
+	"xpending":         keyAt(0),
+	"xclaim":           keyAt(0),
+	"xautoclaim":       keyAt(0),
+	"xinfo":            keyAt(1),
+	"hsetnx":           keyAt(0),
+	"hmget":            keyAt(0),
+	"hkeys":            keyAt(0),
+	"hvals":            keyAt(0),
+	"hdel":             keyAt(0),
+	"hexists":          keyAt(0),
+	"hlen":             keyAt(0),
+	"hstrlen":          keyAt(0),
+	"hincrby":          keyAt(0),
+	"hincrbyfloat":     keyAt(0),
+	"hrandfield":       keyAt(0),
+	"hscan":            keyAt(0),
+	"hexpire":          keyAt(0),
+	"hpexpire":         keyAt(0),
+	"hexpireat":        keyAt(0),
+	"hpexpireat":       keyAt(0),
+	"httl":             keyAt(0),
+	"hpttl":            keyAt(0),
+	"hpersist":         keyAt(0),
+	"hgetex":           keyAt(0),
+	"hsetex":           keyAt(0),
 }
 
-// doc: https://redis.io/docs/latest/commands/ping/
-func ping(_ context.Context, args []Value) Value {
-	if len(args) == 0 {
-		return Value{Typ: "string", Str: "PONG"}
-	}
-
-	// the syntax of the "PING" command with an arg is
-	// PING arguement? (without space)
-	// PING hello world will respond with "hello",
-	// discarding the world, if you want the world use
-	// PING "hello world"
-	return Value{Typ: "bulk", Bulk: args[0].Bulk}
+func noKeys(args []Value) []string {
+	return nil
 }
 
-// doc: https://redis.io/docs/latest/commands/set/
-func set(_ context.Context, args []Value) Value {
-	KvStore.mu.Lock()
-	defer KvStore.mu.Unlock()
+// the key at args[i].
+func keyAt(i int) func(args []Value) []string {
+	return keysFrom(i, i, 1)
+}
 
-	if len(args) != 2 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'set' command"}
+// the keys from args[first] to args[last] every step arguments, last
+// counts from the end when negative: -1 is the last argument.
+func keysFrom(first, last, step int) func(args []Value) []string {
+	return func(args []Value) []string {
+		end := last
+		if end < 0 {
+			end += len(args)
+		}
+
+		var keys []string
+		for i := first; i <= end && i < len(args); i += step {
+			keys = append(keys, args[i].Bulk)
+		}
+		return keys
 	}
-
-	key := args[0].Bulk
-	value := args[1].Bulk
-	KvStore.kvStore[key] = value
-
-	return Value{Typ: "string", Str: "OK"}
 }
 
-func get(_ context.Context, args []Value) Value {
-	KvStore.mu.RLock()
-	defer KvStore.mu.RUnlock()
-
-	if len(args) != 1 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'get' command"}
+// the keys following their number, given at args[i].
+func numkeysAt(i int) func(args []Value) []string {
+	return func(args []Value) []string {
+		if i >= len(args) {
+			return nil
+		}
+
+		n, err := strconv.Atoi(args[i].Bulk)
+		if err != nil || n < 0 || n > len(args)-i-1 {
+			return nil
+		}
+		return keysOf(args[i+1 : i+1+n])
 	}
+}
 
-	key := args[0].Bulk
+// the keys of ZUNIONSTORE and the like, the destination followed by the
+// keys given with their number.
+func destinationAndNumkeys(args []Value) []string {
+	return append(keyAt(0)(args), numkeysAt(1)(args)...)
+}
 
-	value, ok := KvStore.kvStore[key]
-	if !ok {
-		return Value{Typ: "string", Str: "nil"}
+// the keys of XREAD and XREADGROUP, the first half of the arguments
+// following STREAMS. A group or a consumer may be called streams too,
+// the keys following each are taken.
+func streamKeys(args []Value) []string {
+	var keys []string
+	for i, arg := range args {
+		if strings.ToLower(arg.Bulk) == "streams" {
+			streams := args[i+1:]
+			keys = append(keys, keysOf(streams[:len(streams)/2])...)
+		}
 	}
+	return keys
+}
+
+// SimpleStore is one of the databases of the server, the ones clients
+// switch between with SELECT. Its keys are spread over shards by their
+// hash, each with its own lock, so commands working on different keys run
+// in parallel. A command locks the shards of the keys it works on, see lock,
+// and the methods saying the store must be locked mean those shards.
+type SimpleStore struct {
+	id     int // the index clients SELECT the database with.
+	shards []*shard
 
-	return Value{Typ: "string", Str: value}
+	// set while the AOF is replayed, see now.
+	loading atomic.Bool
 }
 
-// doc: https://redis.io/docs/latest/commands/hset/
-func hset(_ context.Context, args []Value) Value {
-	KvStore.mu.Lock()
-	defer KvStore.mu.Unlock()
+// the first database, the one handlers use when they are called outside
+// of a connection e.g. in tests.
+var KvStore = newSimpleStore(0)
 
-	if len(args) < 3 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'hset' command"}
+func newSimpleStore(id int) *SimpleStore {
+	s := &SimpleStore{id: id, shards: make([]*shard, keyspaceShards)}
+	for i := range s.shards {
+		s.shards[i] = newShard(id*keyspaceShards + i)
 	}
+	return s
+}
 
-	hashKey := args[0].Bulk
+// the object stored at key for commands reading it, nil when there is
+// none or it expired. Looking it up counts as an access. The store must be locked.
+func (s *SimpleStore) lookup(key string) *object {
+	o := s.peek(key)
+	if o != nil {
+		o.touch()
+	}
+	return o
+}
 
-	store := make(map[string]string)
-	values := args[1:]
-	values_len := len(values)
+// like lookup without counting as an access, for the commands inspecting
+// keys rather than reading them. A hash whose fields all expired is no value.
+func (s *SimpleStore) peek(key string) *object {
+	now := s.now()
+	o, ok := s.object(key)
+	if !ok || o.expires != 0 && o.expires <= now {
+		return nil
+	}
 
-	// it is possible client sends key without value.
-	if values_len%2 != 0 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'hset' command"}
+	if h, ok := o.value.(*hash); ok && h.empty(now) {
+		return nil
 	}
+	return o
+}
 
-	for i := 0; i < values_len; i++ {
-		store[values[i].Bulk] = values[i+1].Bulk
-		i++
+// the value of type typ stored at key for commands reading it, the zero
+// value when there is none. The store must be locked.
+func lookupValue[V any](s *SimpleStore, key string, typ objectType) (V, error) {
+	var zero V
+	o := s.lookup(key)
+	if o == nil {
+		return zero, nil
+	}
+	if o.typ != typ {
+		return zero, errWrongType
 	}
+	return o.value.(V), nil
+}
 
-	KvStore.hashStore[hashKey] = store
+// the value of type typ stored at key for commands writing it, the one
+// newValue returns is stored when create is true and there is none.
+// The store must be locked for writing.
+func writableValue[V any](s *SimpleStore, key string, typ objectType, create bool, newValue func() V) (V, error) {
+	s.expireIfNeeded(key)
+
+	var zero V
+	o, ok := s.object(key)
+	switch {
+	case ok && o.typ != typ:
+		return zero, errWrongType
+	case ok:
+		o.touch()
+		return o.value.(V), nil
+	case !create:
+		return zero, nil
+	}
 
-	return Value{Typ: "string", Str: fmt.Sprint(len(store))}
+	v := newValue()
+	s.shard(key).keys.set(key, newObject(typ, v))
+	return v, nil
 }
 
-// doc: https://redis.io/docs/latest/commands/hget/
-func hget(_ context.Context, args []Value) Value {
-	KvStore.mu.Lock()
-	defer KvStore.mu.Unlock()
+// reports whether the key holds a value that has not expired,
+// the store must be locked.
+func (s *SimpleStore) keyExists(key string) bool {
+	return s.peek(key) != nil
+}
 
-	if len(args) < 2 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'hget' command"}
-	}
+// stores value at key in place of what it held, its time to live
+// included. The store must be locked for writing.
+func (s *SimpleStore) add(key string, typ objectType, value any) {
+	s.deleteKey(key)
+	s.shard(key).keys.set(key, newObject(typ, value))
+}
 
-	hashKey := args[0].Bulk
-	subKey := args[1].Bulk
+// removes the key whatever its type along with its time to live,
+// the store must be locked for writing.
+func (s *SimpleStore) deleteKey(key string) {
+	sh := s.shard(key)
+	sh.keys.delete(key)
+	delete(sh.expires, key)
+	delete(sh.volatileHashes, key)
+}
 
-	result, ok := KvStore.hashStore[hashKey][subKey]
-	if !ok {
-		return Value{Typ: "string", Str: "nil"}
+// doc: https://redis.io/docs/latest/commands/ping/
+func ping(_ context.Context, args []Value) Value {
+	if len(args) == 0 {
+		return Value{Typ: "string", Str: "PONG"}
 	}
 
-	return Value{Typ: "string", Str: result}
+	// the syntax of the "PING" command with an arg is
+	// PING arguement? (without space)
+	// PING hello world will respond with "hello",
+	// discarding the world, if you want the world use
+	// PING "hello world"
+	return Value{Typ: "bulk", Bulk: args[0].Bulk}
 }
 
-// doc: https://redis.io/docs/latest/commands/hgetall/
-func hgetall(_ context.Context, args []Value) Value {
-	KvStore.mu.RLock()
-	defer KvStore.mu.RUnlock()
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errOutOfRange = errors.New("ERR value is out of range")
+)
+
+// the proto-max-multibulk-len of the server of the client. A negative
+// count of SRANDMEMBER or HRANDFIELD may repeat elements, so it is not
+// bounded by the size of the key: the reply is built whole before it is
+// written and is limited like the arguments of a request.
+func maxRandomCount(ctx context.Context) int64 {
+	if c := clientFromContext(ctx); c != nil {
+		return c.srv.config.GetInt("proto-max-multibulk-len")
+	}
+	return DefaultProtoMaxMultiBulkLen
+}
 
-	if len(args) < 1 {
-		return Value{Typ: "error", Str: "ERR incorrect number of arguements for the 'hgetall' command"}
+func parseRandomCount(ctx context.Context, arg string) (int, error) {
+	count, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errNotInteger
 	}
+	if int64(count) < -maxRandomCount(ctx) {
+		return 0, errOutOfRange
+	}
+	return count, nil
+}
 
-	hashKey := args[0].Bulk
+// builds a command the way clients send it, an array of bulk strings.
+func command(args ...string) Value {
+	value := Value{Typ: "array", Array: []Value{}}
+	for _, arg := range args {
+		value.Array = append(value.Array, Value{Typ: "bulk", Bulk: arg})
+	}
+	return value
+}
 
-	values, ok := KvStore.hashStore[hashKey]
+// the reply of commands returning a value that may not exist.
+func bulkOrNull(value string, ok bool) Value {
 	if !ok {
-		return Value{Typ: "string", Str: "nil"}
+		return Value{Typ: "null"}
 	}
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// the error redis replies with when a command receives too many or too few arguements.
+func wrongNumberOfArgs(command string) Value {
+	return Value{Typ: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", command)}
+}
 
-	results := []Value{}
-	for k, v := range values {
-		results = append(results, Value{Typ: "string", Str: k})
-		results = append(results, Value{Typ: "string", Str: v})
+// the error redis replies with for commands it does not know.
+func unknownCommand(command string, args []Value) Value {
+	var sb strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "'%s' ", arg.Bulk)
 	}
 
-	return Value{Typ: "array", Array: results}
+	return Value{Typ: "error", Str: fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", command, sb.String())}
 }
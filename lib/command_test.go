@@ -4,11 +4,42 @@ import (
 	"context"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// the value stored at key even when it expired, the zero value when there
+// is none or it has another type.
+func storedValue[V any](key string) V {
+	var v V
+	if o, ok := KvStore.object(key); ok {
+		v, _ = o.value.(V)
+	}
+	return v
+}
+
+// a key no other test uses, holding what the command given with args
+// stores at it. The key is the first argument of the command. The test
+// fails when the command replies with an error. Without args the key
+// holds nothing.
+func newTestKey(t testing.TB, args ...string) string {
+	t.Helper()
+
+	key := "key-" + strconv.Itoa(rand.Int())
+	if len(args) == 0 {
+		return key
+	}
+
+	handler := CommandHandlers[strings.ToLower(args[0])]
+	reply := handler(context.Background(), command(append([]string{key}, args[1:]...)...).Array)
+	if reply.Typ == "error" {
+		t.Fatalf("%s %s: %s", args[0], key, reply.Str)
+	}
+	return key
+}
+
 func TestPingCommand(t *testing.T) {
 	tests := []struct {
 		args    string
@@ -56,7 +87,7 @@ func TestSetCommand(t *testing.T) {
 
 		result := set(context.Background(), args.Array)
 
-		if KvStore.kvStore[key] != val {
+		if storedValue[stringValue](key).String() != val {
 			t.Fatalf(key, "was not assigned value ", val)
 		}
 
@@ -102,8 +133,8 @@ func TestSetCommand(t *testing.T) {
 			set(context.Background(), v.Array)
 		}
 
-		assert.NotEqual(t, KvStore.kvStore[key], val1)
-		assert.Equal(t, KvStore.kvStore[key], val2)
+		assert.NotEqual(t, storedValue[stringValue](key).String(), val1)
+		assert.Equal(t, storedValue[stringValue](key).String(), val2)
 	})
 }
 
@@ -121,7 +152,7 @@ func TestGetCommand(t *testing.T) {
 	})
 
 	t.Run("It returns nil if a value has not been set for the provided key", func(t *testing.T) {
-		key := strconv.Itoa(rand.Intn(50)) //random key
+		key := "missing-" + strconv.Itoa(rand.Int()) //random key nothing else uses
 
 		args := &Value{
 			Array: []Value{
@@ -131,8 +162,8 @@ func TestGetCommand(t *testing.T) {
 
 		result := get(context.Background(), args.Array)
 
-		assert.Contains(t, result.Str, "nil")
-		assert.Equal(t, result.Typ, "string")
+		assert.Empty(t, result.Bulk)
+		assert.Equal(t, result.Typ, "null")
 	})
 
 	t.Run("It retrieves the value of the key set", func(t *testing.T) {
@@ -154,8 +185,8 @@ func TestGetCommand(t *testing.T) {
 		}
 		result := get(context.Background(), args.Array)
 
-		assert.Equal(t, result.Str, val)
-		assert.Equal(t, result.Typ, "string")
+		assert.Equal(t, result.Bulk, val)
+		assert.Equal(t, result.Typ, "bulk")
 	})
 }
 
@@ -208,20 +239,21 @@ func TestHSetCommand(t *testing.T) {
 
 		result := hset(context.Background(), args.Array)
 
-		if KvStore.hashStore[hashKey] == nil {
-			t.Fatal("values was not stored in the hash store", KvStore.hashStore)
+		if storedValue[*hash](hashKey) == nil {
+			t.Fatal("values was not stored in the hash store")
 		}
 
-		if KvStore.hashStore[hashKey][role] != person {
+		if value, _ := storedValue[*hash](hashKey).get(role, mstime()); value != person {
 			t.Fatalf(role, "was not assigned value ", person)
 		}
 
-		assert.Len(t, KvStore.hashStore[hashKey], 1)
-		assert.Equal(t, result.Str, "1")
+		assert.Equal(t, 1, storedValue[*hash](hashKey).len(mstime()))
+		assert.Equal(t, result.Num, 1)
+		assert.Equal(t, result.Typ, "integer")
 	})
 
 	t.Run("It updates the key's value on every call", func(t *testing.T) {
-		hashKey := "admin"
+		hashKey := "admins"
 		field := "status"
 		value := "monarch"
 		final_value := "king"
@@ -247,8 +279,9 @@ func TestHSetCommand(t *testing.T) {
 			hset(context.Background(), v.Array)
 		}
 
-		assert.NotEqual(t, KvStore.hashStore[hashKey][field], value)
-		assert.Equal(t, KvStore.hashStore[hashKey][field], final_value)
+		stored, _ := storedValue[*hash](hashKey).get(field, mstime())
+		assert.NotEqual(t, stored, value)
+		assert.Equal(t, stored, final_value)
 	})
 }
 
@@ -265,8 +298,8 @@ func TestHGetCommand(t *testing.T) {
 	})
 
 	t.Run("It returns nil if a value has not been set for the provided key", func(t *testing.T) {
-		hashKey := strconv.Itoa(rand.Intn(50)) //random key
-		key := strconv.Itoa(rand.Intn(50))     //random key
+		hashKey := "missing-" + strconv.Itoa(rand.Int()) //random key nothing else uses
+		key := strconv.Itoa(rand.Intn(50))               //random key
 
 		args := &Value{
 			Array: []Value{
@@ -277,8 +310,8 @@ func TestHGetCommand(t *testing.T) {
 
 		result := hget(context.Background(), args.Array)
 
-		assert.Contains(t, result.Str, "nil")
-		assert.Equal(t, result.Typ, "string")
+		assert.Empty(t, result.Bulk)
+		assert.Equal(t, result.Typ, "null")
 	})
 
 	t.Run("It retrieves the value of the key set", func(t *testing.T) {
@@ -303,8 +336,8 @@ func TestHGetCommand(t *testing.T) {
 		}
 		result := hget(context.Background(), args.Array)
 
-		assert.Equal(t, result.Str, val)
-		assert.Equal(t, result.Typ, "string")
+		assert.Equal(t, result.Bulk, val)
+		assert.Equal(t, result.Typ, "bulk")
 	})
 }
 
@@ -320,8 +353,8 @@ func TestHGetAllCommand(t *testing.T) {
 		assert.Equal(t, result.Typ, "error")
 	})
 
-	t.Run("It returns nil if a value has not been set for the provided key", func(t *testing.T) {
-		hashKey := strconv.Itoa(rand.Intn(50)) //random key
+	t.Run("It returns an empty map if a value has not been set for the provided key", func(t *testing.T) {
+		hashKey := "missing-" + strconv.Itoa(rand.Int()) //random key nothing else uses
 
 		args := &Value{
 			Array: []Value{
@@ -331,8 +364,8 @@ func TestHGetAllCommand(t *testing.T) {
 
 		result := hgetall(context.Background(), args.Array)
 
-		assert.Contains(t, result.Str, "nil")
-		assert.Equal(t, result.Typ, "string")
+		assert.Empty(t, result.Array)
+		assert.Equal(t, result.Typ, "map")
 	})
 
 	t.Run("It returns all the key value pairs when the hash key exists", func(t *testing.T) {
@@ -358,6 +391,112 @@ func TestHGetAllCommand(t *testing.T) {
 		result := hgetall(context.Background(), args.Array)
 
 		assert.Len(t, result.Array, 2)
-		assert.Equal(t, result.Typ, "array")
+		assert.Equal(t, result.Typ, "map")
+	})
+}
+
+func TestSetCommand_WhenConditionsAreGiven(t *testing.T) {
+	t.Run("It only sets a missing key with NX and an existing one with XX", func(t *testing.T) {
+		key := "set-nx-" + strconv.Itoa(rand.Int())
+
+		result := set(context.Background(), command(key, "first", "XX").Array)
+		assert.Equal(t, "null", result.Typ)
+
+		result = set(context.Background(), command(key, "first", "NX", "PX", "100000").Array)
+		assert.Equal(t, "OK", result.Str)
+
+		result = set(context.Background(), command(key, "second", "NX").Array)
+		assert.Equal(t, "null", result.Typ)
+		assert.Equal(t, "first", get(context.Background(), command(key).Array).Bulk)
+
+		result = set(context.Background(), command(key, "second", "XX").Array)
+		assert.Equal(t, "OK", result.Str)
+		assert.Equal(t, "second", get(context.Background(), command(key).Array).Bulk)
+	})
+
+	t.Run("It replies with the previous value with GET", func(t *testing.T) {
+		key := "set-get-" + strconv.Itoa(rand.Int())
+
+		result := set(context.Background(), command(key, "first", "GET").Array)
+		assert.Equal(t, "null", result.Typ)
+
+		result = set(context.Background(), command(key, "second", "GET").Array)
+		assert.Equal(t, "bulk", result.Typ)
+		assert.Equal(t, "first", result.Bulk)
+
+		// the key is not set but the value is still returned.
+		result = set(context.Background(), command(key, "third", "NX", "GET").Array)
+		assert.Equal(t, "second", result.Bulk)
+		assert.Equal(t, "second", get(context.Background(), command(key).Array).Bulk)
+	})
+
+	t.Run("It refuses NX and XX together", func(t *testing.T) {
+		result := set(context.Background(), command("set-nx-xx", "value", "NX", "XX").Array)
+		assert.Equal(t, "ERR syntax error", result.Str)
+	})
+}
+
+func TestSetNXCommand(t *testing.T) {
+	t.Run("It returns 1 when the key is set and 0 when it exists", func(t *testing.T) {
+		key := "setnx-" + strconv.Itoa(rand.Int())
+
+		result := setnx(context.Background(), command(key, "first").Array)
+		assert.Equal(t, "integer", result.Typ)
+		assert.Equal(t, 1, result.Num)
+
+		result = setnx(context.Background(), command(key, "second").Array)
+		assert.Equal(t, 0, result.Num)
+		assert.Equal(t, "first", get(context.Background(), command(key).Array).Bulk)
+	})
+}
+
+func TestGetSetCommand(t *testing.T) {
+	t.Run("It sets the key and returns its previous value", func(t *testing.T) {
+		key := "getset-" + strconv.Itoa(rand.Int())
+
+		result := getset(context.Background(), command(key, "first").Array)
+		assert.Equal(t, "null", result.Typ)
+
+		expire(context.Background(), command(key, "100").Array)
+
+		result = getset(context.Background(), command(key, "second").Array)
+		assert.Equal(t, "first", result.Bulk)
+		assert.Equal(t, -1, ttl(context.Background(), command(key).Array).Num)
+	})
+}
+
+func TestGetDelCommand(t *testing.T) {
+	t.Run("It returns the value and deletes the key", func(t *testing.T) {
+		key := "getdel-" + strconv.Itoa(rand.Int())
+		set(context.Background(), command(key, "value").Array)
+
+		result := getdel(context.Background(), command(key).Array)
+		assert.Equal(t, "value", result.Bulk)
+
+		result = getdel(context.Background(), command(key).Array)
+		assert.Equal(t, "null", result.Typ)
+	})
+}
+
+func TestGetExCommand(t *testing.T) {
+	t.Run("It returns the value and changes its time to live", func(t *testing.T) {
+		key := "getex-" + strconv.Itoa(rand.Int())
+		set(context.Background(), command(key, "value").Array)
+
+		result := getex(context.Background(), command(key, "EX", "100").Array)
+		assert.Equal(t, "value", result.Bulk)
+		assert.Equal(t, 100, ttl(context.Background(), command(key).Array).Num)
+
+		result = getex(context.Background(), command(key, "PERSIST").Array)
+		assert.Equal(t, "value", result.Bulk)
+		assert.Equal(t, -1, ttl(context.Background(), command(key).Array).Num)
+	})
+
+	t.Run("It refuses options of SET", func(t *testing.T) {
+		result := getex(context.Background(), command("getex-options", "KEEPTTL").Array)
+		assert.Equal(t, "ERR syntax error", result.Str)
+
+		result = getex(context.Background(), command("getex-options", "EX", "-1").Array)
+		assert.Equal(t, "ERR invalid expire time in 'getex' command", result.Str)
 	})
 }
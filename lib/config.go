@@ -0,0 +1,519 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// a parameter of the configuration, values are kept as the
+// normalized strings CONFIG GET replies with.
+type configParam struct {
+	def     string
+	mutable bool // whether CONFIG SET can change it while the server runs.
+	parse   func(args []string) (string, error)
+}
+
+// every parameter understood in the config file, on the command line and by CONFIG.
+// doc: https://redis.io/docs/latest/operate/oss_and_stack/management/config-file/
+var configParams = map[string]configParam{
+	"bind":                    {def: "*", parse: parseString},
+	"port":                    {def: "6379", parse: parseIntRange(0, 65535)},
+	"dir":                     {def: ".", parse: parseString},
+	"appendonly":              {def: "yes", parse: parseBool},
+	"appendfilename":          {def: "minired.aof", parse: parseFilename},
+	"appendfsync":             {def: "everysec", mutable: true, parse: parseEnum("always", "everysec", "no")},
+	"maxclients":              {def: "10000", mutable: true, parse: parseIntRange(1, math.MaxInt32)},
+	"proto-max-bulk-len":      {def: strconv.Itoa(DefaultProtoMaxBulkLen), mutable: true, parse: parseMemory(1024*1024, math.MaxInt64)},
+	"proto-max-multibulk-len": {def: strconv.Itoa(DefaultProtoMaxMultiBulkLen), mutable: true, parse: parseIntRange(1, math.MaxInt32)},
+	"databases":               {def: "16", parse: parseIntRange(1, math.MaxInt32)},
+	"hz":                      {def: "10", mutable: true, parse: parseIntRange(1, 500)},
+	"hll-sparse-max-bytes":    {def: strconv.Itoa(defaultHLLSparseMaxBytes), mutable: true, parse: parseMemory(0, math.MaxInt64)},
+}
+
+// Config holds the parameters of the server, they come from a redis.conf
+// style file, command-line overrides and CONFIG SET in that order.
+type Config struct {
+	mu     sync.RWMutex
+	file   string // the config file the server started with, rewritten by CONFIG REWRITE.
+	values map[string]string
+}
+
+// NewConfig returns the default configuration.
+func NewConfig() *Config {
+	values := make(map[string]string, len(configParams))
+	for name, param := range configParams {
+		values[name] = param.def
+	}
+
+	return &Config{values: values}
+}
+
+// LoadConfig builds the configuration from the command-line arguments the
+// way redis-server does: an optional config file followed by overrides
+// written as "--directive value ...", e.g.
+//
+//	minired /etc/minired.conf --port 7000 --dir /var/lib/minired
+func LoadConfig(args []string) (*Config, error) {
+	config := NewConfig()
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		file, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := config.parse(string(content)); err != nil {
+			return nil, fmt.Errorf("%s: %w", args[0], err)
+		}
+
+		config.file = file
+		args = args[1:]
+	}
+
+	// every "--directive" starts a new line of config, the values
+	// following it are its arguments.
+	var overrides strings.Builder
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			if i > 0 {
+				overrides.WriteString("\n")
+			}
+			overrides.WriteString(arg[2:])
+			continue
+		}
+
+		if i == 0 {
+			return nil, fmt.Errorf("invalid argument '%s', directives start with --", arg)
+		}
+		overrides.WriteString(" " + quoteConfigArg(arg))
+	}
+
+	if err := config.parse(overrides.String()); err != nil {
+		return nil, fmt.Errorf("command line: %w", err)
+	}
+
+	return config, nil
+}
+
+// applies the directives of a config file, one per line, later lines win.
+func (c *Config) parse(content string) error {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		args, err := splitArgs(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		name := strings.ToLower(args[0])
+		param, ok := configParams[name]
+		if !ok {
+			return fmt.Errorf("line %d: bad directive '%s'", line, args[0])
+		}
+
+		value, err := param.parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("line %d: '%s' %w", line, name, err)
+		}
+
+		c.values[name] = value
+	}
+
+	return scanner.Err()
+}
+
+// Get returns the value of a parameter as CONFIG GET shows it.
+func (c *Config) Get(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[name]
+}
+
+func (c *Config) GetInt(name string) int64 {
+	n, _ := strconv.ParseInt(c.Get(name), 10, 64)
+	return n
+}
+
+func (c *Config) GetBool(name string) bool {
+	return c.Get(name) == "yes"
+}
+
+// Addr is the address the server listens on.
+func (c *Config) Addr() string {
+	host := c.Get("bind")
+	if host == "*" {
+		host = ""
+	}
+
+	return net.JoinHostPort(host, c.Get("port"))
+}
+
+// AppendOnlyPath is the location of the AOF inside the data directory.
+func (c *Config) AppendOnlyPath() string {
+	return filepath.Join(c.Get("dir"), c.Get("appendfilename"))
+}
+
+// Set changes several parameters at once, either every one of them is
+// changed or none is. The error names the parameter that was refused.
+func (c *Config) Set(pairs [][2]string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		name := strings.ToLower(pair[0])
+
+		param, ok := configParams[name]
+		if !ok {
+			return pair[0], errUnknownConfig
+		}
+
+		if _, seen := values[name]; seen {
+			return pair[0], errors.New("duplicate parameter")
+		}
+
+		if !param.mutable {
+			return pair[0], errors.New("can't set immutable config")
+		}
+
+		value, err := param.parse([]string{pair[1]})
+		if err != nil {
+			return pair[0], err
+		}
+		values[name] = value
+	}
+
+	for name, value := range values {
+		c.values[name] = value
+	}
+
+	return "", nil
+}
+
+// Rewrite updates the config file the server started with so it holds the
+// current configuration. Comments and the order of the directives are kept,
+// parameters missing from the file are appended when they are not the default.
+func (c *Config) Rewrite() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.file == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	content, err := os.ReadFile(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	written := map[string]bool{}
+	lines := []string{}
+
+	existing := []string{}
+	if len(content) > 0 {
+		existing = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	for _, line := range existing {
+		args, err := splitArgs(line)
+		trimmed := strings.TrimSpace(line)
+
+		if err != nil || len(args) == 0 || strings.HasPrefix(trimmed, "#") {
+			lines = append(lines, line)
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		if _, ok := configParams[name]; !ok {
+			lines = append(lines, line)
+			continue
+		}
+
+		// only the first occurrence survives, it holds the current value.
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		lines = append(lines, name+" "+quoteConfigArg(c.values[name]))
+	}
+
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header := false
+	for _, name := range names {
+		if written[name] || c.values[name] == configParams[name].def {
+			continue
+		}
+
+		if !header {
+			lines = append(lines, "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		lines = append(lines, name+" "+quoteConfigArg(c.values[name]))
+	}
+
+	// write to a temporary file first, a crash never leaves a half written config.
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.file)
+}
+
+var errUnknownConfig = errors.New("unknown parameter")
+
+// quotes a value so splitArgs reads it back as a single argument.
+func quoteConfigArg(arg string) string {
+	safe := arg != ""
+	for i := 0; i < len(arg); i++ {
+		if isSpace(arg[i]) || arg[i] == '"' || arg[i] == '\'' || arg[i] == '\\' || arg[i] < ' ' || arg[i] > '~' {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch b := arg[i]; {
+		case b == '\\' || b == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b == '\n':
+			sb.WriteString(`\n`)
+		case b == '\r':
+			sb.WriteString(`\r`)
+		case b == '\t':
+			sb.WriteString(`\t`)
+		case b < ' ' || b > '~':
+			fmt.Fprintf(&sb, `\x%02x`, b)
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
+
+func parseString(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("wrong number of arguments")
+	}
+	return args[0], nil
+}
+
+func parseFilename(args []string) (string, error) {
+	name, err := parseString(args)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" || filepath.Base(name) != name {
+		return "", errors.New("must be a file name, not a path")
+	}
+	return name, nil
+}
+
+func parseBool(args []string) (string, error) {
+	value, err := parseString(args)
+	if err != nil {
+		return "", err
+	}
+
+	value = strings.ToLower(value)
+	if value != "yes" && value != "no" {
+		return "", errors.New("argument must be 'yes' or 'no'")
+	}
+	return value, nil
+}
+
+func parseEnum(options ...string) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		value, err := parseString(args)
+		if err != nil {
+			return "", err
+		}
+
+		value = strings.ToLower(value)
+		for _, option := range options {
+			if value == option {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(options, ", "))
+	}
+}
+
+func parseIntRange(lower, upper int64) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		value, err := parseString(args)
+		if err != nil {
+			return "", err
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", errors.New("argument couldn't be parsed into an integer")
+		}
+
+		if n < lower || n > upper {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", lower, upper)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+// memory values accept units: 1k = 1000 bytes, 1kb = 1024 bytes and so on.
+func parseMemory(lower, upper int64) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		value, err := parseString(args)
+		if err != nil {
+			return "", err
+		}
+
+		n, err := memToInt(value)
+		if err != nil {
+			return "", errors.New("argument must be a memory value")
+		}
+
+		if n < lower || n > upper {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", lower, upper)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+func memToInt(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	value = strings.ToLower(value)
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			mul = unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > math.MaxInt64/mul || n < math.MinInt64/mul {
+		return 0, strconv.ErrRange
+	}
+	return n * mul, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/config/
+func config(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("config")
+	}
+
+	c := clientFromContext(ctx)
+	if c == nil {
+		return Value{Typ: "error", Str: "ERR CONFIG requires a connection"}
+	}
+
+	subcommand := strings.ToLower(args[0].Bulk)
+	args = args[1:]
+
+	switch {
+	case subcommand == "get" && len(args) >= 1:
+		return configGet(c.srv.config, args)
+	case subcommand == "set" && len(args) >= 2 && len(args)%2 == 0:
+		return configSet(c.srv, args)
+	case subcommand == "rewrite" && len(args) == 0:
+		if err := c.srv.config.Rewrite(); err != nil {
+			return Value{Typ: "error", Str: "ERR Rewriting config file: " + err.Error()}
+		}
+		return Value{Typ: "string", Str: "OK"}
+	default:
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", subcommand)}
+	}
+}
+
+// doc: https://redis.io/docs/latest/commands/config-get/
+func configGet(cfg *Config, patterns []Value) Value {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		for _, pattern := range patterns {
+			if stringMatch(pattern.Bulk, name, true) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	results := []Value{}
+	for _, name := range names {
+		results = append(results, Value{Typ: "bulk", Bulk: name})
+		results = append(results, Value{Typ: "bulk", Bulk: cfg.Get(name)})
+	}
+
+	return Value{Typ: "map", Array: results}
+}
+
+// doc: https://redis.io/docs/latest/commands/config-set/
+func configSet(s *Server, args []Value) Value {
+	pairs := make([][2]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, [2]string{args[i].Bulk, args[i+1].Bulk})
+	}
+
+	name, err := s.config.Set(pairs)
+	if err == errUnknownConfig {
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)}
+	}
+	if err != nil {
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err)}
+	}
+
+	s.applyConfig()
+
+	return Value{Typ: "string", Str: "OK"}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "minired.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("It uses the defaults when nothing is passed", func(t *testing.T) {
+		config, err := LoadConfig(nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, ":6379", config.Addr())
+		assert.Equal(t, "minired.aof", config.AppendOnlyPath())
+		assert.Equal(t, int64(DefaultProtoMaxBulkLen), config.GetInt("proto-max-bulk-len"))
+	})
+
+	t.Run("It reads the config file and lets the command line override it", func(t *testing.T) {
+		path := writeConfigFile(t, "# instance for the batch jobs\nport 7000\n\nbind 127.0.0.1\ndir \"/var/lib/minired one\"\nproto-max-bulk-len 2mb\n")
+
+		config, err := LoadConfig([]string{path, "--port", "7001", "--appendfilename", "jobs.aof"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1:7001", config.Addr())
+		assert.Equal(t, "/var/lib/minired one/jobs.aof", config.AppendOnlyPath())
+		assert.Equal(t, int64(2*1024*1024), config.GetInt("proto-max-bulk-len"))
+	})
+
+	t.Run("It reports the line of an invalid directive", func(t *testing.T) {
+		path := writeConfigFile(t, "port 7000\nnot-a-directive yes\n")
+
+		_, err := LoadConfig([]string{path})
+
+		assert.ErrorContains(t, err, "line 2: bad directive 'not-a-directive'")
+	})
+
+	t.Run("It refuses invalid values", func(t *testing.T) {
+		_, err := LoadConfig([]string{"--port", "70000"})
+		assert.ErrorContains(t, err, "argument must be between 0 and 65535")
+
+		_, err = LoadConfig([]string{"--appendfsync", "sometimes"})
+		assert.ErrorContains(t, err, "must be one of the following")
+
+		_, err = LoadConfig([]string{"--appendfilename", "../escape.aof"})
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigCommand(t *testing.T) {
+	t.Run("It returns the parameters matching the patterns", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		result := config(ctx, command("GET", "append*", "PORT").Array)
+
+		assert.Equal(t, "map", result.Typ)
+		assert.Equal(t, []Value{
+			{Typ: "bulk", Bulk: "appendfilename"}, {Typ: "bulk", Bulk: "minired.aof"},
+			{Typ: "bulk", Bulk: "appendfsync"}, {Typ: "bulk", Bulk: "everysec"},
+			{Typ: "bulk", Bulk: "appendonly"}, {Typ: "bulk", Bulk: "yes"},
+			{Typ: "bulk", Bulk: "port"}, {Typ: "bulk", Bulk: "6379"},
+		}, result.Array)
+	})
+
+	t.Run("It changes runtime parameters with CONFIG SET", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		result := config(ctx, command("SET", "maxclients", "10", "appendfsync", "always").Array)
+
+		assert.Equal(t, "OK", result.Str)
+		assert.Equal(t, int64(10), server.config.GetInt("maxclients"))
+		assert.Equal(t, "always", server.config.Get("appendfsync"))
+	})
+
+	t.Run("It changes nothing when one of the parameters is refused", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		result := config(ctx, command("SET", "maxclients", "10", "port", "7000").Array)
+
+		assert.Equal(t, "error", result.Typ)
+		assert.Contains(t, result.Str, "can't set immutable config")
+		assert.Equal(t, int64(10000), server.config.GetInt("maxclients"))
+
+		result = config(ctx, command("SET", "nope", "1").Array)
+		assert.Contains(t, result.Str, "Unknown option")
+	})
+
+	t.Run("It rewrites the config file keeping the comments", func(t *testing.T) {
+		path := writeConfigFile(t, "# the cache\nport 7000\nmaxclients 5\nmaxclients 6\n")
+		cfg, err := LoadConfig([]string{path})
+		assert.Nil(t, err)
+
+		server := NewServer(cfg)
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		config(ctx, command("SET", "maxclients", "20", "appendfsync", "no").Array)
+		result := config(ctx, command("REWRITE").Array)
+		assert.Equal(t, "OK", result.Str)
+
+		content, _ := os.ReadFile(path)
+		assert.Equal(t, "# the cache\nport 7000\nmaxclients 20\n# Generated by CONFIG REWRITE\nappendfsync no\n", string(content))
+
+		reloaded, err := LoadConfig([]string{path})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(20), reloaded.GetInt("maxclients"))
+	})
+
+	t.Run("It cannot rewrite without a config file", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		ctx := contextWithClient(context.Background(), testClient(t, server))
+
+		result := config(ctx, command("REWRITE").Array)
+
+		assert.Equal(t, "error", result.Typ)
+	})
+}
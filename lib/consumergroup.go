@@ -0,0 +1,944 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a pending entry: delivered to a consumer of the group but not acknowledged yet.
+type streamNACK struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  int64 // unix time in milliseconds of the last delivery.
+	deliveryCount int
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // the last time the consumer tried to read or claim.
+	activeTime int64 // the last time the consumer read or claimed something, -1 for never.
+	pending    map[streamID]*streamNACK
+}
+
+// streamGroup delivers every entry of the stream to one of its consumers,
+// the entries stay pending until they are acknowledged with XACK so they
+// can be claimed by another consumer if the first one fails.
+type streamGroup struct {
+	lastID    streamID // the ID of the last entry delivered.
+	pel       map[streamID]*streamNACK
+	consumers map[string]*streamConsumer
+}
+
+func newStreamGroup(lastID streamID) *streamGroup {
+	return &streamGroup{
+		lastID:    lastID,
+		pel:       map[streamID]*streamNACK{},
+		consumers: map[string]*streamConsumer{},
+	}
+}
+
+// a copy of the group, its pending entries belong to the copies of
+// their consumers.
+func (g *streamGroup) clone() *streamGroup {
+	c := newStreamGroup(g.lastID)
+	for name, consumer := range g.consumers {
+		copied := *consumer
+		copied.pending = map[streamID]*streamNACK{}
+		c.consumers[name] = &copied
+	}
+
+	for id, nack := range g.pel {
+		copied := *nack
+		copied.consumer = c.consumers[nack.consumer.name]
+		copied.consumer.pending[id] = &copied
+		c.pel[id] = &copied
+	}
+	return c
+}
+
+// the consumer with the name, it is created when create is true and
+// it does not exist. The bool is true when it was created.
+func (g *streamGroup) consumer(name string, create bool) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok || !create {
+		return c, false
+	}
+
+	c := &streamConsumer{name: name, seenTime: mstime(), activeTime: -1, pending: map[streamID]*streamNACK{}}
+	g.consumers[name] = c
+	return c, true
+}
+
+// removes the consumer along with its pending entries, it returns
+// how many of them were pending.
+func (g *streamGroup) deleteConsumer(c *streamConsumer) int {
+	for id := range c.pending {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, c.name)
+	return len(c.pending)
+}
+
+// delivers the entry to the consumer, it becomes pending unless it already was.
+func (g *streamGroup) deliver(id streamID, c *streamConsumer, now int64) *streamNACK {
+	nack, ok := g.pel[id]
+	if !ok {
+		nack = &streamNACK{id: id}
+		g.pel[id] = nack
+	}
+
+	g.assign(nack, c)
+	nack.deliveryTime = now
+	nack.deliveryCount++
+	return nack
+}
+
+// makes the consumer the owner of the pending entry.
+func (g *streamGroup) assign(nack *streamNACK, c *streamConsumer) {
+	if nack.consumer != nil {
+		delete(nack.consumer.pending, nack.id)
+	}
+	nack.consumer = c
+	c.pending[nack.id] = nack
+}
+
+func (g *streamGroup) ack(id streamID) bool {
+	nack, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+
+	if nack.consumer != nil {
+		delete(nack.consumer.pending, id)
+	}
+	delete(g.pel, id)
+	return true
+}
+
+// the IDs of the pending entries in order.
+func sortedPendingIDs(pel map[streamID]*streamNACK) []streamID {
+	ids := make([]streamID, 0, len(pel))
+	for id := range pel {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// parses "$" as the last ID of the stream or an ID.
+func parseGroupID(arg string, st *stream) (streamID, error) {
+	if arg == "$" {
+		return st.lastID, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+func noGroupError(key, group string) Value {
+	return Value{Typ: "error", Str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group)}
+}
+
+// the group of the stream at key, the error reply when there is none.
+// The store must be locked for writing.
+func (s *SimpleStore) writableGroup(key, group string) (*stream, *streamGroup, *Value) {
+	st, err := s.writableStream(key, false)
+	if err != nil {
+		reply := Value{Typ: "error", Str: err.Error()}
+		return nil, nil, &reply
+	}
+	if st == nil || st.groups[group] == nil {
+		reply := noGroupError(key, group)
+		return nil, nil, &reply
+	}
+	return st, st.groups[group], nil
+}
+
+// doc: https://redis.io/docs/latest/commands/xgroup/
+func xgroup(ctx context.Context, args []Value) Value {
+	if len(args) == 0 {
+		return wrongNumberOfArgs("xgroup")
+	}
+
+	subcommand := strings.ToLower(args[0].Bulk)
+	unknown := Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try XGROUP HELP.", args[0].Bulk)}
+
+	switch {
+	case subcommand == "create" && (len(args) == 4 || len(args) == 5):
+	case subcommand == "setid" && len(args) == 4:
+	case subcommand == "destroy" && len(args) == 3:
+	case (subcommand == "createconsumer" || subcommand == "delconsumer") && len(args) == 4:
+	default:
+		return unknown
+	}
+
+	key, name := args[1].Bulk, args[2].Bulk
+	mkStream := false
+	if len(args) == 5 {
+		if subcommand != "create" || strings.ToLower(args[4].Bulk) != "mkstream" {
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+		mkStream = true
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	st, err := db.writableStream(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	created := st == nil
+	if created {
+		if !mkStream {
+			return Value{Typ: "error", Str: "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+		}
+		st = newStream()
+	}
+
+	if subcommand == "create" {
+		if _, ok := st.groups[name]; ok {
+			return Value{Typ: "error", Str: "BUSYGROUP Consumer Group name already exists"}
+		}
+
+		id, err := parseGroupID(args[3].Bulk, st)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		st.groups[name] = newStreamGroup(id)
+		if created {
+			db.add(key, typeStream, st)
+		}
+		return Value{Typ: "string", Str: "OK"}
+	}
+
+	g := st.groups[name]
+	if g == nil {
+		return Value{Typ: "error", Str: fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", name, key)}
+	}
+
+	switch subcommand {
+	case "setid":
+		id, err := parseGroupID(args[3].Bulk, st)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		g.lastID = id
+		return Value{Typ: "string", Str: "OK"}
+
+	case "destroy":
+		delete(st.groups, name)
+		return Value{Typ: "integer", Num: 1}
+
+	case "createconsumer":
+		_, created := g.consumer(args[3].Bulk, true)
+		if created {
+			return Value{Typ: "integer", Num: 1}
+		}
+		return Value{Typ: "integer", Num: 0}
+
+	default:
+		c, _ := g.consumer(args[3].Bulk, false)
+		if c == nil {
+			return Value{Typ: "integer", Num: 0}
+		}
+		return Value{Typ: "integer", Num: g.deleteConsumer(c)}
+	}
+}
+
+// the command written in the AOF for an entry delivered or claimed, it
+// makes the pending entry the same when the AOF is replayed. See
+// streamPropagateXCLAIM: https://github.com/redis/redis/blob/unstable/src/t_stream.c
+func xclaimCommand(key, group string, nack *streamNACK, lastID streamID) Value {
+	return command("xclaim", key, group, nack.consumer.name, "0", nack.id.String(),
+		"time", strconv.FormatInt(nack.deliveryTime, 10),
+		"retrycount", strconv.Itoa(nack.deliveryCount),
+		"force", "justid", "lastid", lastID.String())
+}
+
+// doc: https://redis.io/docs/latest/commands/xreadgroup/
+func xreadgroup(ctx context.Context, args []Value) Value {
+	if len(args) < 6 {
+		return wrongNumberOfArgs("xreadgroup")
+	}
+
+	if strings.ToLower(args[0].Bulk) != "group" {
+		return Value{Typ: "error", Str: "ERR Missing GROUP option for XREADGROUP"}
+	}
+	group, consumerName := args[1].Bulk, args[2].Bulk
+
+	opts, err := parseStreamReadOptions("xreadgroup", args[3:], true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// ">" reads new entries, other IDs the history of the consumer.
+	history := make([]*streamID, len(opts.ids))
+	for i, arg := range opts.ids {
+		if arg == ">" {
+			continue
+		}
+
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		history[i] = &id
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, opts.keys...)
+	for i, key := range opts.keys {
+		if st, err := db.lookupStream(key); err != nil || st == nil || st.groups[group] == nil {
+			unlock()
+			return Value{Typ: "error", Str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", opts.keys[i], group)}
+		}
+	}
+	unlock()
+
+	count := opts.count
+	if count == 0 {
+		count = -1
+	}
+
+	// the history is always replied, new entries only when there are some.
+	read := func(string) (Value, []Value, bool) {
+		var keys []string
+		var entries []Value
+		var propagate []Value
+		now := mstime()
+
+		for i, key := range opts.keys {
+			st, g, reply := db.writableGroup(key, group)
+			if reply != nil {
+				return *reply, propagate, true
+			}
+
+			c, created := g.consumer(consumerName, true)
+			c.seenTime = now
+			if created {
+				propagate = append(propagate, command("xgroup", "createconsumer", key, group, consumerName))
+			}
+
+			if history[i] != nil {
+				reply, delivered := consumerHistory(st, c, *history[i], count, now)
+				for _, nack := range delivered {
+					propagate = append(propagate, xclaimCommand(key, group, nack, g.lastID))
+				}
+				keys = append(keys, key)
+				entries = append(entries, reply)
+				continue
+			}
+
+			start, ok := g.lastID.incr()
+			if !ok {
+				continue
+			}
+
+			found := st.rangeEntries(start, streamMaxID, count, false)
+			if len(found) == 0 {
+				continue
+			}
+
+			c.activeTime = now
+			for _, entry := range found {
+				g.lastID = entry.id
+				if !opts.noAck {
+					nack := g.deliver(entry.id, c, now)
+					propagate = append(propagate, xclaimCommand(key, group, nack, g.lastID))
+				}
+			}
+
+			if opts.noAck {
+				propagate = append(propagate, command("xgroup", "setid", key, group, g.lastID.String()))
+			}
+
+			keys = append(keys, key)
+			entries = append(entries, streamEntriesReply(found))
+		}
+
+		if len(keys) == 0 {
+			return Value{}, propagate, false
+		}
+		return streamReadReply(ctx, keys, entries), propagate, true
+	}
+
+	blocking := opts.block
+	for _, id := range history {
+		blocking = blocking && id == nil
+	}
+
+	if !blocking {
+		unlock := db.lock(ctx, opts.keys...)
+		defer unlock()
+
+		reply, propagate, ok := read("")
+		propagateAs(ctx, propagate...)
+		if ok {
+			return reply
+		}
+		return Value{Typ: "nullarray"}
+	}
+
+	w := &waiter{db: db, keys: opts.keys, serve: read}
+	return blockForKeys(ctx, w, opts.timeout, Value{Typ: "nullarray"})
+}
+
+// the entries pending for the consumer after the ID, the deleted ones
+// without fields. Like redis, the entries still in the stream are
+// delivered again: the returned ones had their delivery count and time updated.
+func consumerHistory(st *stream, c *streamConsumer, after streamID, count int, now int64) (Value, []*streamNACK) {
+	reply := Value{Typ: "array", Array: []Value{}}
+	var delivered []*streamNACK
+
+	for _, id := range sortedPendingIDs(c.pending) {
+		if count == 0 {
+			break
+		}
+		if !after.less(id) {
+			continue
+		}
+
+		entry, ok := st.get(id)
+		reply.Array = append(reply.Array, streamEntryReply(id, entry.fields, !ok))
+		if ok {
+			nack := c.pending[id]
+			nack.deliveryTime = now
+			nack.deliveryCount++
+			delivered = append(delivered, nack)
+		}
+		count--
+	}
+	return reply, delivered
+}
+
+// doc: https://redis.io/docs/latest/commands/xack/
+func xack(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("xack")
+	}
+
+	ids, err := parseStreamIDs(args[2:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	_, g, reply := db.writableGroup(args[0].Bulk, args[1].Bulk)
+	if reply != nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return Value{Typ: "integer", Num: acked}
+}
+
+// doc: https://redis.io/docs/latest/commands/xpending/
+func xpending(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("xpending")
+	}
+
+	extended := len(args) > 2
+	minIdle := int64(0)
+	rest := args[2:]
+
+	if extended && strings.ToLower(rest[0].Bulk) == "idle" {
+		if len(rest) < 2 {
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+
+		idle, err := strconv.ParseInt(rest[1].Bulk, 10, 64)
+		if err != nil {
+			return Value{Typ: "error", Str: errNotInteger.Error()}
+		}
+		minIdle = idle
+		rest = rest[2:]
+	}
+
+	if extended && len(rest) != 3 && len(rest) != 4 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	var start, end streamID
+	count := 0
+	consumerName := ""
+
+	if extended {
+		var err error
+		if start, err = parseStreamRangeBound(rest[0].Bulk, true); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if end, err = parseStreamRangeBound(rest[1].Bulk, false); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		if count, err = strconv.Atoi(rest[2].Bulk); err != nil {
+			return Value{Typ: "error", Str: errNotInteger.Error()}
+		}
+		count = max(count, 0)
+
+		if len(rest) == 4 {
+			consumerName = rest[3].Bulk
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	_, g, reply := db.writableGroup(args[0].Bulk, args[1].Bulk)
+	if reply != nil {
+		return *reply
+	}
+
+	if !extended {
+		return pendingSummary(g)
+	}
+
+	pel := g.pel
+	if consumerName != "" {
+		c, _ := g.consumer(consumerName, false)
+		if c == nil {
+			return Value{Typ: "array", Array: []Value{}}
+		}
+		pel = c.pending
+	}
+
+	now := mstime()
+	result := Value{Typ: "array", Array: []Value{}}
+
+	for _, id := range sortedPendingIDs(pel) {
+		if len(result.Array) == count || end.less(id) {
+			break
+		}
+		if id.less(start) {
+			continue
+		}
+
+		nack := pel[id]
+		idle := now - nack.deliveryTime
+		if idle < minIdle {
+			continue
+		}
+
+		result.Array = append(result.Array, Value{Typ: "array", Array: []Value{
+			{Typ: "bulk", Bulk: id.String()},
+			{Typ: "bulk", Bulk: nack.consumer.name},
+			{Typ: "integer", Num: int(idle)},
+			{Typ: "integer", Num: nack.deliveryCount},
+		}})
+	}
+	return result
+}
+
+// the reply of XPENDING without a range: the number of pending entries,
+// the smallest and greatest IDs and the number of entries per consumer.
+func pendingSummary(g *streamGroup) Value {
+	if len(g.pel) == 0 {
+		return Value{Typ: "array", Array: []Value{
+			{Typ: "integer", Num: 0}, {Typ: "null"}, {Typ: "null"}, {Typ: "nullarray"},
+		}}
+	}
+
+	ids := sortedPendingIDs(g.pel)
+
+	names := make([]string, 0, len(g.consumers))
+	for name, c := range g.consumers {
+		if len(c.pending) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	consumers := Value{Typ: "array", Array: []Value{}}
+	for _, name := range names {
+		consumers.Array = append(consumers.Array, Value{Typ: "array", Array: []Value{
+			{Typ: "bulk", Bulk: name},
+			{Typ: "bulk", Bulk: strconv.Itoa(len(g.consumers[name].pending))},
+		}})
+	}
+
+	return Value{Typ: "array", Array: []Value{
+		{Typ: "integer", Num: len(ids)},
+		{Typ: "bulk", Bulk: ids[0].String()},
+		{Typ: "bulk", Bulk: ids[len(ids)-1].String()},
+		consumers,
+	}}
+}
+
+// claims the pending entry for the consumer, the delivery count is
+// incremented unless justID. Deleted entries are removed from the PEL,
+// the bool is false then.
+func (g *streamGroup) claim(st *stream, nack *streamNACK, c *streamConsumer, deliveryTime int64, justID bool) (streamEntry, bool) {
+	entry, ok := st.get(nack.id)
+	if !ok {
+		g.ack(nack.id)
+		return entry, false
+	}
+
+	g.assign(nack, c)
+	nack.deliveryTime = deliveryTime
+	if !justID {
+		nack.deliveryCount++
+	}
+	return entry, true
+}
+
+// doc: https://redis.io/docs/latest/commands/xclaim/
+func xclaim(ctx context.Context, args []Value) Value {
+	if len(args) < 5 {
+		return wrongNumberOfArgs("xclaim")
+	}
+
+	key, group, consumerName := args[0].Bulk, args[1].Bulk, args[2].Bulk
+
+	minIdle, err := strconv.ParseInt(args[3].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: "ERR Invalid min-idle-time argument for XCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+
+	// the IDs go on until the first option.
+	i := 4
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i].Bulk, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return Value{Typ: "error", Str: errInvalidStreamID.Error()}
+	}
+
+	now := mstime()
+	deliveryTime := now
+	retryCount := -1
+	force, justID := false, false
+	var lastID *streamID
+
+	for ; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+		hasValue := i+1 < len(args)
+
+		switch {
+		case option == "force":
+			force = true
+		case option == "justid":
+			justID = true
+		case option == "idle" && hasValue:
+			idle, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Typ: "error", Str: "ERR Invalid IDLE option argument for XCLAIM"}
+			}
+			deliveryTime = now - idle
+			i++
+		case option == "time" && hasValue:
+			time, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Typ: "error", Str: "ERR Invalid TIME option argument for XCLAIM"}
+			}
+			deliveryTime = time
+			i++
+		case option == "retrycount" && hasValue:
+			count, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil || count < 0 {
+				return Value{Typ: "error", Str: "ERR Invalid RETRYCOUNT option argument for XCLAIM"}
+			}
+			retryCount = count
+			i++
+		case option == "lastid" && hasValue:
+			id, err := parseStreamID(args[i+1].Bulk, 0)
+			if err != nil {
+				return Value{Typ: "error", Str: err.Error()}
+			}
+			lastID = &id
+			i++
+		default:
+			return Value{Typ: "error", Str: fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i].Bulk)}
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	st, g, reply := db.writableGroup(key, group)
+	if reply != nil {
+		return *reply
+	}
+
+	var propagate []Value
+	if lastID != nil && g.lastID.less(*lastID) {
+		g.lastID = *lastID
+		propagate = append(propagate, command("xgroup", "setid", key, group, g.lastID.String()))
+	}
+
+	c, created := g.consumer(consumerName, true)
+	c.seenTime = now
+	if created {
+		propagate = append(propagate, command("xgroup", "createconsumer", key, group, consumerName))
+	}
+
+	result := Value{Typ: "array", Array: []Value{}}
+	for _, id := range ids {
+		nack := g.pel[id]
+		if nack == nil {
+			// FORCE makes entries of the stream pending even if they were not delivered.
+			if _, ok := st.get(id); !force || !ok {
+				continue
+			}
+			nack = &streamNACK{id: id}
+			g.pel[id] = nack
+		}
+
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		entry, ok := g.claim(st, nack, c, deliveryTime, justID)
+		if !ok {
+			propagate = append(propagate, command("xack", key, group, id.String()))
+			continue
+		}
+
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		}
+		c.activeTime = now
+
+		if justID {
+			result.Array = append(result.Array, Value{Typ: "bulk", Bulk: id.String()})
+		} else {
+			result.Array = append(result.Array, streamEntryReply(id, entry.fields, false))
+		}
+		propagate = append(propagate, xclaimCommand(key, group, nack, g.lastID))
+	}
+
+	propagateAs(ctx, propagate...)
+	return result
+}
+
+// doc: https://redis.io/docs/latest/commands/xautoclaim/
+func xautoclaim(ctx context.Context, args []Value) Value {
+	if len(args) < 5 {
+		return wrongNumberOfArgs("xautoclaim")
+	}
+
+	key, group, consumerName := args[0].Bulk, args[1].Bulk, args[2].Bulk
+
+	minIdle, err := strconv.ParseInt(args[3].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: "ERR Invalid min-idle-time argument for XAUTOCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+
+	start, err := parseStreamRangeBound(args[4].Bulk, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	count := 100
+	justID := false
+	for i := 5; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+
+		switch {
+		case option == "justid":
+			justID = true
+		case option == "count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return Value{Typ: "error", Str: errNotInteger.Error()}
+			}
+			if n < 1 {
+				return Value{Typ: "error", Str: "ERR COUNT must be > 0"}
+			}
+			count = n
+			i++
+		default:
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	st, g, reply := db.writableGroup(key, group)
+	if reply != nil {
+		return *reply
+	}
+
+	now := mstime()
+	var propagate []Value
+
+	c, created := g.consumer(consumerName, true)
+	c.seenTime = now
+	if created {
+		propagate = append(propagate, command("xgroup", "createconsumer", key, group, consumerName))
+	}
+
+	claimed := Value{Typ: "array", Array: []Value{}}
+	deleted := Value{Typ: "array", Array: []Value{}}
+	next := streamID{}
+
+	ids := sortedPendingIDs(g.pel)
+	i := sort.Search(len(ids), func(i int) bool { return !ids[i].less(start) })
+
+	for ; i < len(ids) && count > 0; i++ {
+		nack := g.pel[ids[i]]
+		if now-nack.deliveryTime < minIdle {
+			continue
+		}
+		count--
+
+		entry, ok := g.claim(st, nack, c, now, justID)
+		if !ok {
+			deleted.Array = append(deleted.Array, Value{Typ: "bulk", Bulk: ids[i].String()})
+			propagate = append(propagate, command("xack", key, group, ids[i].String()))
+			continue
+		}
+
+		c.activeTime = now
+		if justID {
+			claimed.Array = append(claimed.Array, Value{Typ: "bulk", Bulk: ids[i].String()})
+		} else {
+			claimed.Array = append(claimed.Array, streamEntryReply(ids[i], entry.fields, false))
+		}
+		propagate = append(propagate, xclaimCommand(key, group, nack, g.lastID))
+	}
+
+	// the scan goes on from the next pending entry, 0-0 once they were all seen.
+	if i < len(ids) {
+		next = ids[i]
+	}
+
+	propagateAs(ctx, propagate...)
+	return Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: next.String()}, claimed, deleted}}
+}
+
+// doc: https://redis.io/docs/latest/commands/xinfo/
+func xinfo(ctx context.Context, args []Value) Value {
+	if len(args) == 0 {
+		return wrongNumberOfArgs("xinfo")
+	}
+
+	subcommand := strings.ToLower(args[0].Bulk)
+	switch {
+	case subcommand == "stream" && len(args) == 2:
+	case subcommand == "groups" && len(args) == 2:
+	case subcommand == "consumers" && len(args) == 3:
+	default:
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try XINFO HELP.", args[0].Bulk)}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[1].Bulk)
+	defer unlock()
+
+	key := args[1].Bulk
+	st, err := db.lookupStream(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil {
+		return Value{Typ: "error", Str: "ERR no such key"}
+	}
+
+	switch subcommand {
+	case "stream":
+		return streamInfo(st)
+	case "groups":
+		return groupsInfo(st)
+	default:
+		g := st.groups[args[2].Bulk]
+		if g == nil {
+			return Value{Typ: "error", Str: fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", args[2].Bulk, key)}
+		}
+		return consumersInfo(g)
+	}
+}
+
+func streamInfo(st *stream) Value {
+	firstID := streamID{}
+	first, last := Value{Typ: "null"}, Value{Typ: "null"}
+	if st.len() > 0 {
+		firstEntry, lastEntry := st.entries[0], st.entries[st.len()-1]
+		firstID = firstEntry.id
+		first = streamEntryReply(firstEntry.id, firstEntry.fields, false)
+		last = streamEntryReply(lastEntry.id, lastEntry.fields, false)
+	}
+
+	return Value{Typ: "map", Array: []Value{
+		{Typ: "bulk", Bulk: "length"}, {Typ: "integer", Num: st.len()},
+		{Typ: "bulk", Bulk: "last-generated-id"}, {Typ: "bulk", Bulk: st.lastID.String()},
+		{Typ: "bulk", Bulk: "max-deleted-entry-id"}, {Typ: "bulk", Bulk: st.maxDeletedID.String()},
+		{Typ: "bulk", Bulk: "entries-added"}, {Typ: "integer", Num: st.entriesAdded},
+		{Typ: "bulk", Bulk: "recorded-first-entry-id"}, {Typ: "bulk", Bulk: firstID.String()},
+		{Typ: "bulk", Bulk: "groups"}, {Typ: "integer", Num: len(st.groups)},
+		{Typ: "bulk", Bulk: "first-entry"}, first,
+		{Typ: "bulk", Bulk: "last-entry"}, last,
+	}}
+}
+
+func groupsInfo(st *stream) Value {
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, name := range names {
+		g := st.groups[name]
+		reply.Array = append(reply.Array, Value{Typ: "map", Array: []Value{
+			{Typ: "bulk", Bulk: "name"}, {Typ: "bulk", Bulk: name},
+			{Typ: "bulk", Bulk: "consumers"}, {Typ: "integer", Num: len(g.consumers)},
+			{Typ: "bulk", Bulk: "pending"}, {Typ: "integer", Num: len(g.pel)},
+			{Typ: "bulk", Bulk: "last-delivered-id"}, {Typ: "bulk", Bulk: g.lastID.String()},
+		}})
+	}
+	return reply
+}
+
+func consumersInfo(g *streamGroup) Value {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := mstime()
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, name := range names {
+		c := g.consumers[name]
+
+		inactive := -1
+		if c.activeTime >= 0 {
+			inactive = int(now - c.activeTime)
+		}
+
+		reply.Array = append(reply.Array, Value{Typ: "map", Array: []Value{
+			{Typ: "bulk", Bulk: "name"}, {Typ: "bulk", Bulk: name},
+			{Typ: "bulk", Bulk: "pending"}, {Typ: "integer", Num: len(c.pending)},
+			{Typ: "bulk", Bulk: "idle"}, {Typ: "integer", Num: int(now - c.seenTime)},
+			{Typ: "bulk", Bulk: "inactive"}, {Typ: "integer", Num: inactive},
+		}})
+	}
+	return reply
+}
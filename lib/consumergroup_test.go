@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a stream with the entries 1-0 to n-0 and the group "g" reading it from the start.
+func newTestGroup(t *testing.T, n int) string {
+	key := newTestKey(t)
+	for i := 1; i <= n; i++ {
+		xadd(context.Background(), command(key, strconv.Itoa(i)+"-0", "n", strconv.Itoa(i)).Array)
+	}
+
+	assert.Equal(t, "OK", xgroup(context.Background(), command("CREATE", key, "g", "0", "MKSTREAM").Array).Str)
+	return key
+}
+
+func TestConsumerGroups(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It creates and manages groups and consumers", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.", xgroup(ctx, command("CREATE", key, "g", "$").Array).Str)
+		assert.Equal(t, "OK", xgroup(ctx, command("CREATE", key, "g", "$", "MKSTREAM").Array).Str)
+		assert.Equal(t, "BUSYGROUP Consumer Group name already exists", xgroup(ctx, command("CREATE", key, "g", "$").Array).Str)
+
+		assert.Equal(t, 1, xgroup(ctx, command("CREATECONSUMER", key, "g", "alice").Array).Num)
+		assert.Equal(t, 0, xgroup(ctx, command("CREATECONSUMER", key, "g", "alice").Array).Num)
+		assert.Equal(t, 0, xgroup(ctx, command("DELCONSUMER", key, "g", "alice").Array).Num)
+		assert.Equal(t, "OK", xgroup(ctx, command("SETID", key, "g", "5-0").Array).Str)
+
+		groups := xinfo(ctx, command("GROUPS", key).Array)
+		assert.Equal(t, []Value{
+			{Typ: "bulk", Bulk: "name"}, {Typ: "bulk", Bulk: "g"},
+			{Typ: "bulk", Bulk: "consumers"}, {Typ: "integer", Num: 0},
+			{Typ: "bulk", Bulk: "pending"}, {Typ: "integer", Num: 0},
+			{Typ: "bulk", Bulk: "last-delivered-id"}, {Typ: "bulk", Bulk: "5-0"},
+		}, groups.Array[0].Array)
+
+		assert.Equal(t, 1, xgroup(ctx, command("DESTROY", key, "g").Array).Num)
+		assert.Equal(t, "NOGROUP No such consumer group 'g' for key name '"+key+"'", xgroup(ctx, command("SETID", key, "g", "0").Array).Str)
+	})
+
+	t.Run("It delivers every entry once and keeps it pending until acknowledged", func(t *testing.T) {
+		key := newTestGroup(t, 3)
+
+		result := xreadgroup(ctx, command("GROUP", "g", "alice", "COUNT", "2", "STREAMS", key, ">").Array)
+		assert.Equal(t, []string{"1-0", "2-0"}, streamIDs(result.Array[0].Array[1]))
+
+		result = xreadgroup(ctx, command("GROUP", "g", "bob", "STREAMS", key, ">").Array)
+		assert.Equal(t, []string{"3-0"}, streamIDs(result.Array[0].Array[1]))
+		assert.Equal(t, "nullarray", xreadgroup(ctx, command("GROUP", "g", "bob", "STREAMS", key, ">").Array).Typ)
+
+		// the history of a consumer is its pending entries.
+		result = xreadgroup(ctx, command("GROUP", "g", "alice", "STREAMS", key, "0").Array)
+		assert.Equal(t, []string{"1-0", "2-0"}, streamIDs(result.Array[0].Array[1]))
+
+		summary := xpending(ctx, command(key, "g").Array)
+		assert.Equal(t, 3, summary.Array[0].Num)
+		assert.Equal(t, "1-0", summary.Array[1].Bulk)
+		assert.Equal(t, "3-0", summary.Array[2].Bulk)
+		assert.Equal(t, []string{"alice", "2"}, bulks(summary.Array[3].Array[0]))
+
+		assert.Equal(t, 1, xack(ctx, command(key, "g", "1-0", "9-0").Array).Num)
+		pending := xpending(ctx, command(key, "g", "-", "+", "10", "alice").Array)
+		assert.Len(t, pending.Array, 1)
+		assert.Equal(t, "2-0", pending.Array[0].Array[0].Bulk)
+		// read once and once more from the history.
+		assert.Equal(t, 2, pending.Array[0].Array[3].Num)
+
+		// deleted entries are in the history without their fields.
+		xdel(ctx, command(key, "2-0").Array)
+		result = xreadgroup(ctx, command("GROUP", "g", "alice", "STREAMS", key, "0").Array)
+		assert.Equal(t, "nullarray", result.Array[0].Array[1].Array[0].Array[1].Typ)
+
+		assert.Equal(t, "NOGROUP No such key 'stream-missing' or consumer group 'g' in XREADGROUP with GROUP option", xreadgroup(ctx, command("GROUP", "g", "alice", "STREAMS", "stream-missing", ">").Array).Str)
+	})
+
+	t.Run("It delivers the entries of the history again", func(t *testing.T) {
+		key := newTestGroup(t, 2)
+		xreadgroup(ctx, command("GROUP", "g", "alice", "STREAMS", key, ">").Array)
+		time.Sleep(20 * time.Millisecond)
+
+		pending := xpending(ctx, command(key, "g", "-", "+", "10").Array)
+		assert.GreaterOrEqual(t, pending.Array[0].Array[2].Num, 20)
+
+		result := xreadgroup(ctx, command("GROUP", "g", "alice", "COUNT", "1", "STREAMS", key, "0").Array)
+		assert.Equal(t, []string{"1-0"}, streamIDs(result.Array[0].Array[1]))
+
+		pending = xpending(ctx, command(key, "g", "-", "+", "10").Array)
+		assert.Less(t, pending.Array[0].Array[2].Num, 20)
+		assert.Equal(t, 2, pending.Array[0].Array[3].Num)
+		assert.GreaterOrEqual(t, pending.Array[1].Array[2].Num, 20)
+		assert.Equal(t, 1, pending.Array[1].Array[3].Num)
+	})
+
+	t.Run("It does not keep entries pending with NOACK", func(t *testing.T) {
+		key := newTestGroup(t, 2)
+
+		result := xreadgroup(ctx, command("GROUP", "g", "alice", "NOACK", "STREAMS", key, ">").Array)
+		assert.Equal(t, []string{"1-0", "2-0"}, streamIDs(result.Array[0].Array[1]))
+		assert.Equal(t, 0, xpending(ctx, command(key, "g").Array).Array[0].Num)
+	})
+
+	t.Run("It claims pending entries for another consumer", func(t *testing.T) {
+		key := newTestGroup(t, 3)
+		xreadgroup(ctx, command("GROUP", "g", "alice", "STREAMS", key, ">").Array)
+
+		assert.Empty(t, xclaim(ctx, command(key, "g", "bob", "3600000", "1-0").Array).Array)
+
+		result := xclaim(ctx, command(key, "g", "bob", "0", "1-0", "2-0").Array)
+		assert.Equal(t, []string{"1-0", "2-0"}, streamIDs(result))
+
+		pending := xpending(ctx, command(key, "g", "-", "+", "10", "bob").Array)
+		assert.Equal(t, 2, pending.Array[0].Array[3].Num)
+
+		result = xclaim(ctx, command(key, "g", "carol", "0", "1-0", "JUSTID", "RETRYCOUNT", "7").Array)
+		assert.Equal(t, []string{"1-0"}, bulks(result))
+		pending = xpending(ctx, command(key, "g", "-", "+", "10", "carol").Array)
+		assert.Equal(t, 7, pending.Array[0].Array[3].Num)
+
+		xdel(ctx, command(key, "3-0").Array)
+		result = xautoclaim(ctx, command(key, "g", "dave", "0", "0", "COUNT", "2").Array)
+		assert.Equal(t, "3-0", result.Array[0].Bulk)
+		assert.Equal(t, []string{"1-0", "2-0"}, streamIDs(result.Array[1]))
+		assert.Empty(t, result.Array[2].Array)
+
+		result = xautoclaim(ctx, command(key, "g", "dave", "0", "3-0").Array)
+		assert.Equal(t, "0-0", result.Array[0].Bulk)
+		assert.Equal(t, []string{"3-0"}, bulks(result.Array[2]))
+		assert.Equal(t, 2, xpending(ctx, command(key, "g").Array).Array[0].Num)
+	})
+
+	t.Run("It blocks XREADGROUP until an entry is added", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := newTestGroup(t, 0)
+
+		result := runBlocking(server, testClient(t, server), command("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", key, ">"))
+		waitBlocked(t, server, key, 1)
+
+		server.handleCommandExecution(testClient(t, server), command("XADD", key, "1-0", "f", "v"))
+
+		reply := receive(t, result)
+		assert.Equal(t, []string{"1-0"}, streamIDs(reply.Array[0].Array[1]))
+		assert.Equal(t, 1, xpending(ctx, command(key, "g").Array).Array[0].Num)
+	})
+
+	t.Run("It keeps the pending entries across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stream.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c := testClient(t, server)
+		key := newTestKey(t)
+
+		server.handleCommandExecution(c, command("XADD", key, "*", "n", "1"))
+		server.handleCommandExecution(c, command("XADD", key, "*", "n", "2"))
+		server.handleCommandExecution(c, command("XADD", key, "*", "n", "3"))
+		server.handleCommandExecution(c, command("XGROUP", "CREATE", key, "g", "0"))
+		server.handleCommandExecution(c, command("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", key, ">"))
+		server.handleCommandExecution(c, command("XREADGROUP", "GROUP", "g", "bob", "NOACK", "STREAMS", key, ">"))
+		ids := streamIDs(xrange(ctx, command(key, "-", "+").Array))
+		server.handleCommandExecution(c, command("XCLAIM", key, "g", "bob", "0", ids[1]))
+		server.handleCommandExecution(c, command("XACK", key, "g", ids[0]))
+		assert.Nil(t, aof.Close())
+
+		before := xpending(ctx, command(key, "g", "-", "+", "10").Array)
+		info := xinfo(ctx, command("GROUPS", key).Array)
+
+		unlock := KvStore.lock(context.Background(), key)
+		KvStore.deleteKey(key)
+		unlock()
+
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+
+		after := xpending(ctx, command(key, "g", "-", "+", "10").Array)
+		assert.Len(t, after.Array, 1)
+		assert.Equal(t, ids[1], after.Array[0].Array[0].Bulk)
+		assert.Equal(t, "bob", after.Array[0].Array[1].Bulk)
+		assert.Equal(t, before.Array[0].Array[3], after.Array[0].Array[3])
+		assert.Equal(t, info, xinfo(ctx, command("GROUPS", key).Array))
+	})
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+// the smallest table of a dict, always a power of two.
+const dictMinSize = 4
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+// dict is a hash table with separate chaining like the one redis uses for
+// its keyspace and its aggregate types. Go maps would do for lookups, but
+// they can neither return a random key in O(1) nor be walked with a cursor
+// that survives resizes, which SRANDMEMBER, SPOP and the SCAN family need.
+// The table doubles when it holds as many entries as buckets and halves
+// when it is less than an eighth full.
+type dict[V any] struct {
+	table []*dictEntry[V]
+	used  int
+	seed  maphash.Seed
+}
+
+func newDict[V any]() *dict[V] {
+	return &dict[V]{
+		table: make([]*dictEntry[V], dictMinSize),
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+func (d *dict[V]) len() int {
+	return d.used
+}
+
+func (d *dict[V]) mask() uint64 {
+	return uint64(len(d.table) - 1)
+}
+
+func (d *dict[V]) bucket(key string) uint64 {
+	return maphash.String(d.seed, key) & d.mask()
+}
+
+func (d *dict[V]) find(key string) *dictEntry[V] {
+	for e := d.table[d.bucket(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *dict[V]) get(key string) (V, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// sets the value of the key, it returns true when the key is new.
+func (d *dict[V]) set(key string, value V) bool {
+	if e := d.find(key); e != nil {
+		e.value = value
+		return false
+	}
+
+	if d.used >= len(d.table) {
+		d.resize(len(d.table) * 2)
+	}
+
+	i := d.bucket(key)
+	d.table[i] = &dictEntry[V]{key: key, value: value, next: d.table[i]}
+	d.used++
+	return true
+}
+
+// deletes the key, it returns false when it was not in the dict.
+func (d *dict[V]) delete(key string) bool {
+	i := d.bucket(key)
+
+	for prev, e := (*dictEntry[V])(nil), d.table[i]; e != nil; prev, e = e, e.next {
+		if e.key != key {
+			continue
+		}
+
+		if prev == nil {
+			d.table[i] = e.next
+		} else {
+			prev.next = e.next
+		}
+		d.used--
+
+		if len(d.table) > dictMinSize && d.used < len(d.table)/8 {
+			d.resize(len(d.table) / 2)
+		}
+		return true
+	}
+
+	return false
+}
+
+func (d *dict[V]) resize(size int) {
+	old := d.table
+	d.table = make([]*dictEntry[V], size)
+
+	for _, e := range old {
+		for e != nil {
+			next := e.next
+			i := d.bucket(e.key)
+			e.next = d.table[i]
+			d.table[i] = e
+			e = next
+		}
+	}
+}
+
+// calls fn for every entry until it returns false, the dict
+// must not be changed by fn.
+func (d *dict[V]) each(fn func(key string, value V) bool) {
+	for _, e := range d.table {
+		for ; e != nil; e = e.next {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// a copy of the dict, the values are copied by assignment.
+func (d *dict[V]) clone() *dict[V] {
+	c := newDict[V]()
+	d.each(func(key string, value V) bool {
+		c.set(key, value)
+		return true
+	})
+	return c
+}
+
+// returns a random entry, the dict must not be empty. Buckets are
+// at least an eighth full so finding a non-empty one is quick, the
+// entries of long chains are a little less likely to be picked.
+func (d *dict[V]) random() (string, V) {
+	for {
+		e := d.table[rand.Intn(len(d.table))]
+		if e == nil {
+			continue
+		}
+
+		n := 0
+		for c := e; c != nil; c = c.next {
+			n++
+		}
+		for i := rand.Intn(n); i > 0; i-- {
+			e = e.next
+		}
+		return e.key, e.value
+	}
+}
+
+// scan calls fn for the entries of the bucket at cursor and returns the
+// cursor of the next call, 0 once every bucket was visited. Entries in the
+// dict for the whole scan are returned at least once, even if it is resized
+// in between, because the cursor is incremented from its highest bit: the
+// buckets already visited in a small table are the ones already visited in
+// a bigger table and the other way around. Port of dictScan:
+// https://github.com/redis/redis/blob/unstable/src/dict.c
+func (d *dict[V]) scan(cursor uint64, fn func(key string, value V)) uint64 {
+	mask := d.mask()
+
+	for e := d.table[cursor&mask]; e != nil; e = e.next {
+		fn(e.key, e.value)
+	}
+
+	// set the unmasked bits so incrementing the reversed cursor
+	// carries into the masked ones.
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	cursor = bits.Reverse64(cursor)
+
+	return cursor
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDict(t *testing.T) {
+	t.Run("It stores, replaces and deletes entries while resizing", func(t *testing.T) {
+		d := newDict[int]()
+
+		for i := 0; i < 1000; i++ {
+			assert.True(t, d.set(strconv.Itoa(i), i))
+		}
+		assert.False(t, d.set("10", -10))
+		assert.Equal(t, 1000, d.len())
+
+		value, ok := d.get("10")
+		assert.True(t, ok)
+		assert.Equal(t, -10, value)
+
+		for i := 0; i < 990; i++ {
+			assert.True(t, d.delete(strconv.Itoa(i)))
+		}
+		assert.False(t, d.delete("0"))
+		assert.Equal(t, 10, d.len())
+		assert.LessOrEqual(t, len(d.table), 128)
+
+		_, ok = d.get("995")
+		assert.True(t, ok)
+	})
+
+	t.Run("It returns every entry with scan even when the dict is resized in between", func(t *testing.T) {
+		d := newDict[struct{}]()
+		for i := 0; i < 100; i++ {
+			d.set(strconv.Itoa(i), struct{}{})
+		}
+
+		seen := map[string]bool{}
+		cursor, calls := uint64(0), 0
+		for {
+			cursor = d.scan(cursor, func(key string, _ struct{}) { seen[key] = true })
+			calls++
+
+			// the table grows and shrinks while the scan goes on.
+			if calls == 10 {
+				for i := 100; i < 1000; i++ {
+					d.set(strconv.Itoa(i), struct{}{})
+				}
+			}
+			if calls == 200 {
+				for i := 100; i < 1000; i++ {
+					d.delete(strconv.Itoa(i))
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+
+		for i := 0; i < 100; i++ {
+			assert.True(t, seen[strconv.Itoa(i)], i)
+		}
+	})
+
+	t.Run("It returns random entries", func(t *testing.T) {
+		d := newDict[int]()
+		for i := 0; i < 10; i++ {
+			d.set(strconv.Itoa(i), i)
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i < 1000; i++ {
+			key, value := d.random()
+			assert.Equal(t, key, strconv.Itoa(value))
+			seen[key] = true
+		}
+		assert.Len(t, seen, 10)
+	})
+}
@@ -0,0 +1,392 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tuning of the active expire cycle, the same values redis uses.
+const (
+	activeExpireCycleKeysPerLoop     = 20 // keys with a time to live sampled at every iteration.
+	activeExpireCycleAcceptableStale = 25 // percentage of expired keys in a sample under which the cycle stops.
+	activeExpireCycleSlowTimePerc    = 25 // percentage of every cron tick the cycle can use.
+)
+
+// the conditions accepted by the EXPIRE family of commands.
+const (
+	expireNX = 1 << iota // only when the key has no time to live.
+	expireXX             // only when the key has a time to live.
+	expireGT             // only when the new time to live is greater.
+	expireLT             // only when the new time to live is less.
+)
+
+var errExpireOverflow = errors.New("expire time overflows")
+
+// the current unix time in milliseconds, the unit expire times are kept in.
+func mstime() int64 {
+	return time.Now().UnixMilli()
+}
+
+// the time the keys of the store expire against. Nothing expires while the
+// AOF is replayed, like redis does when loading: the commands of the log
+// ran on keys that had not expired yet, the times they hold are only
+// checked once it is loaded.
+func (s *SimpleStore) now() int64 {
+	if s.loading.Load() {
+		return math.MinInt64
+	}
+	return mstime()
+}
+
+// converts a time to live in seconds or milliseconds, relative to now
+// or a unix time, into the unix time in milliseconds it ends at.
+func absoluteExpireTime(when int64, unit int64, relative bool) (int64, error) {
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		return 0, errExpireOverflow
+	}
+	when *= unit
+
+	if relative {
+		now := mstime()
+		if when > math.MaxInt64-now {
+			return 0, errExpireOverflow
+		}
+		when += now
+	}
+
+	return when, nil
+}
+
+// the error redis replies with when an expire time overflows.
+func invalidExpireTime(command string) Value {
+	return Value{Typ: "error", Str: fmt.Sprintf("ERR invalid expire time in '%s' command", command)}
+}
+
+// reports whether the time to live of the key passed, the key is still
+// in the store until it is written or the active expire cycle finds it.
+// The store must be locked.
+func (s *SimpleStore) isExpired(key string) bool {
+	o, ok := s.object(key)
+	return ok && o.expires != 0 && o.expires <= s.now()
+}
+
+// the unix time in milliseconds the key expires at, ok is false when it
+// has no time to live. The store must be locked.
+func (s *SimpleStore) expireTime(key string) (when int64, ok bool) {
+	o, ok := s.object(key)
+	if !ok || o.expires == 0 {
+		return 0, false
+	}
+	return o.expires, true
+}
+
+// gives the key a time to live, the store must be locked for writing.
+func (s *SimpleStore) setExpire(key string, when int64) {
+	if o, ok := s.object(key); ok {
+		o.expires = when
+		s.shard(key).expires[key] = o
+	}
+}
+
+// removes the time to live of the key, it returns false when the key had
+// none. The store must be locked for writing.
+func (s *SimpleStore) removeExpire(key string) bool {
+	o, ok := s.object(key)
+	if !ok || o.expires == 0 {
+		return false
+	}
+
+	o.expires = 0
+	delete(s.shard(key).expires, key)
+	return true
+}
+
+// deletes the key when its time to live passed, commands writing
+// a key call it first so they never build on an expired value.
+// The store must be locked for writing.
+func (s *SimpleStore) expireIfNeeded(key string) bool {
+	if !s.isExpired(key) {
+		return false
+	}
+
+	s.deleteKey(key)
+	return true
+}
+
+// deletes the expired keys nobody reads anymore, lazy expiry alone would
+// keep them in memory forever. Like redis it samples keys with a time to
+// live and goes on while more than a quarter of the sample was expired,
+// within the time budget so clients are not kept waiting. The shards are
+// sampled one after the other, each locked on its own, from a random one
+// so the budget running out does not always spare the same shards.
+func (s *SimpleStore) activeExpireCycle(budget time.Duration) {
+	start := time.Now()
+	offset := rand.Intn(len(s.shards))
+
+	for i := range s.shards {
+		sh := s.shards[(offset+i)%len(s.shards)]
+		for {
+			sampled, expired := 0, 0
+
+			sh.mu.Lock()
+			now := mstime()
+			// maps are iterated in a random order, which makes the sample random.
+			for key, o := range sh.expires {
+				if sampled == activeExpireCycleKeysPerLoop {
+					break
+				}
+				sampled++
+
+				if o.expires <= now {
+					s.deleteKey(key)
+					expired++
+				}
+			}
+			sh.mu.Unlock()
+
+			if time.Since(start) > budget {
+				return
+			}
+
+			if sampled == 0 || expired*100 <= sampled*activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+}
+
+// deletes the expired fields of the hashes nobody writes anymore, the
+// same way activeExpireCycle does for keys: it samples hashes with fields
+// that have a time to live and goes on while more than a quarter of the
+// sample had expired fields.
+func (s *SimpleStore) activeExpireHashFields(budget time.Duration) {
+	start := time.Now()
+	offset := rand.Intn(len(s.shards))
+
+	for i := range s.shards {
+		sh := s.shards[(offset+i)%len(s.shards)]
+		for {
+			sampled, expired := 0, 0
+
+			sh.mu.Lock()
+			now := mstime()
+			for key := range sh.volatileHashes {
+				if sampled == activeExpireCycleKeysPerLoop {
+					break
+				}
+				sampled++
+
+				// the key may hold another type once the hash was replaced.
+				var h *hash
+				if o, ok := sh.keys.get(key); ok {
+					h, _ = o.value.(*hash)
+				}
+				if h == nil || len(h.expires) == 0 {
+					delete(sh.volatileHashes, key)
+					continue
+				}
+
+				if h.deleteExpired(now) > 0 {
+					expired++
+					if h.dict.len() == 0 {
+						s.deleteKey(key)
+					}
+				}
+			}
+			sh.mu.Unlock()
+
+			if time.Since(start) > budget {
+				return
+			}
+
+			if sampled == 0 || expired*100 <= sampled*activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+}
+
+// reports whether the condition of an EXPIRE command holds for the
+// time to live current, when is the new one. A key without a time to live
+// lives forever: it is never less than the new time to live and always greater.
+func expireConditionHolds(flags int, current int64, hasTTL bool, when int64) bool {
+	switch {
+	case flags&expireNX != 0 && hasTTL,
+		flags&expireXX != 0 && !hasTTL,
+		flags&expireGT != 0 && (!hasTTL || when <= current),
+		flags&expireLT != 0 && hasTTL && when >= current:
+		return false
+	}
+	return true
+}
+
+// doc: https://redis.io/docs/latest/commands/expire/
+func expire(ctx context.Context, args []Value) Value {
+	return expireGeneric(ctx, "expire", args, 1000, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/pexpire/
+func pexpire(ctx context.Context, args []Value) Value {
+	return expireGeneric(ctx, "pexpire", args, 1, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/expireat/
+func expireat(ctx context.Context, args []Value) Value {
+	return expireGeneric(ctx, "expireat", args, 1000, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/pexpireat/
+func pexpireat(ctx context.Context, args []Value) Value {
+	return expireGeneric(ctx, "pexpireat", args, 1, false)
+}
+
+// sets the time to live of a key, in seconds or milliseconds and
+// relative to now or as a unix time depending on the command.
+func expireGeneric(ctx context.Context, name string, args []Value, unit int64, relative bool) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	key := args[0].Bulk
+
+	when, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	flags := 0
+	for _, arg := range args[2:] {
+		switch strings.ToLower(arg.Bulk) {
+		case "nx":
+			flags |= expireNX
+		case "xx":
+			flags |= expireXX
+		case "gt":
+			flags |= expireGT
+		case "lt":
+			flags |= expireLT
+		default:
+			return Value{Typ: "error", Str: fmt.Sprintf("ERR Unsupported option %s", arg.Bulk)}
+		}
+	}
+
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return Value{Typ: "error", Str: "ERR NX and XX, GT or LT options at the same time are not compatible"}
+	}
+
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return Value{Typ: "error", Str: "ERR GT and LT options at the same time are not compatible"}
+	}
+
+	when, err = absoluteExpireTime(when, unit, relative)
+	if err != nil {
+		return invalidExpireTime(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	// nothing changes so nothing is written in the AOF.
+	propagateAs(ctx)
+
+	db.expireIfNeeded(key)
+	if !db.keyExists(key) {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	current, hasTTL := db.expireTime(key)
+	if !expireConditionHolds(flags, current, hasTTL, when) {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	if when <= db.now() {
+		db.deleteKey(key)
+	} else {
+		db.setExpire(key, when)
+	}
+
+	// the AOF always gets the unix time so replaying it later
+	// does not give the key a new lease of life.
+	propagateAs(ctx, command("pexpireat", key, strconv.FormatInt(when, 10)))
+
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/ttl/
+func ttl(ctx context.Context, args []Value) Value {
+	return ttlGeneric(ctx, "ttl", args, false, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/pttl/
+func pttl(ctx context.Context, args []Value) Value {
+	return ttlGeneric(ctx, "pttl", args, true, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/expiretime/
+func expiretime(ctx context.Context, args []Value) Value {
+	return ttlGeneric(ctx, "expiretime", args, false, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/pexpiretime/
+func pexpiretime(ctx context.Context, args []Value) Value {
+	return ttlGeneric(ctx, "pexpiretime", args, true, true)
+}
+
+// replies with the time to live of a key, or the unix time it expires at,
+// -1 when the key has no time to live and -2 when it does not exist.
+func ttlGeneric(ctx context.Context, name string, args []Value, milliseconds bool, absolute bool) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	if !db.keyExists(key) {
+		return Value{Typ: "integer", Num: -2}
+	}
+
+	when, ok := db.expireTime(key)
+	if !ok {
+		return Value{Typ: "integer", Num: -1}
+	}
+
+	if !absolute {
+		when = max(when-mstime(), 0)
+	}
+
+	if !milliseconds {
+		when = (when + 500) / 1000
+	}
+
+	return Value{Typ: "integer", Num: int(when)}
+}
+
+// doc: https://redis.io/docs/latest/commands/persist/
+func persist(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("persist")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	db.expireIfNeeded(key)
+
+	if !db.removeExpire(key) {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: 1}
+}
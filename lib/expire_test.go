@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCommand_WhenExpireOptionsAreGiven(t *testing.T) {
+	t.Run("It sets the time to live of the key", func(t *testing.T) {
+		result := set(context.Background(), command("set-ex", "value", "EX", "100").Array)
+		assert.Equal(t, "OK", result.Str)
+
+		result = ttl(context.Background(), command("set-ex").Array)
+		assert.Equal(t, 100, result.Num)
+
+		result = set(context.Background(), command("set-px", "value", "PX", "100000").Array)
+		assert.Equal(t, "OK", result.Str)
+
+		result = pttl(context.Background(), command("set-px").Array)
+		assert.InDelta(t, 100000, result.Num, 1000)
+	})
+
+	t.Run("It clears the time to live unless KEEPTTL is given", func(t *testing.T) {
+		set(context.Background(), command("set-keepttl", "value", "EX", "100").Array)
+
+		set(context.Background(), command("set-keepttl", "other", "KEEPTTL").Array)
+		result := ttl(context.Background(), command("set-keepttl").Array)
+		assert.Equal(t, 100, result.Num)
+
+		set(context.Background(), command("set-keepttl", "other").Array)
+		result = ttl(context.Background(), command("set-keepttl").Array)
+		assert.Equal(t, -1, result.Num)
+	})
+
+	t.Run("It refuses invalid expire times and options", func(t *testing.T) {
+		result := set(context.Background(), command("set-invalid", "value", "EX", "0").Array)
+		assert.Equal(t, "ERR invalid expire time in 'set' command", result.Str)
+
+		result = set(context.Background(), command("set-invalid", "value", "PX", "soon").Array)
+		assert.Equal(t, "ERR value is not an integer or out of range", result.Str)
+
+		result = set(context.Background(), command("set-invalid", "value", "EX", "10", "PX", "10").Array)
+		assert.Equal(t, "ERR syntax error", result.Str)
+
+		result = set(context.Background(), command("set-invalid", "value", "EX", "10", "KEEPTTL").Array)
+		assert.Equal(t, "ERR syntax error", result.Str)
+
+		result = set(context.Background(), command("set-invalid", "value", "EX", "9223372036854775807").Array)
+		assert.Equal(t, "ERR invalid expire time in 'set' command", result.Str)
+	})
+}
+
+func TestExpireCommand(t *testing.T) {
+	t.Run("It returns 1 when the time to live is set and 0 for missing keys", func(t *testing.T) {
+		set(context.Background(), command("expire-key", "value").Array)
+
+		result := expire(context.Background(), command("expire-key", "100").Array)
+		assert.Equal(t, "integer", result.Typ)
+		assert.Equal(t, 1, result.Num)
+
+		result = expire(context.Background(), command("expire-missing", "100").Array)
+		assert.Equal(t, 0, result.Num)
+	})
+
+	t.Run("It only sets the time to live when the condition holds", func(t *testing.T) {
+		set(context.Background(), command("expire-conditions", "value").Array)
+
+		tests := []struct {
+			args    []string
+			expects int
+		}{
+			{args: []string{"100", "XX"}, expects: 0},
+			{args: []string{"100", "GT"}, expects: 0},
+			{args: []string{"100", "NX"}, expects: 1},
+			{args: []string{"200", "NX"}, expects: 0},
+			{args: []string{"50", "GT"}, expects: 0},
+			{args: []string{"200", "GT"}, expects: 1},
+			{args: []string{"300", "LT"}, expects: 0},
+			{args: []string{"150", "LT"}, expects: 1},
+			{args: []string{"120", "XX"}, expects: 1},
+		}
+
+		for _, tt := range tests {
+			args := append([]string{"expire-conditions"}, tt.args...)
+			result := expire(context.Background(), command(args...).Array)
+			assert.Equal(t, tt.expects, result.Num, tt.args)
+		}
+
+		result := ttl(context.Background(), command("expire-conditions").Array)
+		assert.Equal(t, 120, result.Num)
+	})
+
+	t.Run("It refuses options that cannot be used together", func(t *testing.T) {
+		result := expire(context.Background(), command("expire-key", "100", "NX", "XX").Array)
+		assert.Equal(t, "ERR NX and XX, GT or LT options at the same time are not compatible", result.Str)
+
+		result = expire(context.Background(), command("expire-key", "100", "GT", "LT").Array)
+		assert.Equal(t, "ERR GT and LT options at the same time are not compatible", result.Str)
+
+		result = expire(context.Background(), command("expire-key", "100", "SOON").Array)
+		assert.Equal(t, "ERR Unsupported option SOON", result.Str)
+	})
+
+	t.Run("It deletes the key when the time is in the past", func(t *testing.T) {
+		set(context.Background(), command("expire-past", "value").Array)
+
+		result := pexpireat(context.Background(), command("expire-past", "1").Array)
+		assert.Equal(t, 1, result.Num)
+
+		result = get(context.Background(), command("expire-past").Array)
+		assert.Equal(t, "null", result.Typ)
+	})
+}
+
+func TestTTLCommands(t *testing.T) {
+	t.Run("It returns -2 for missing keys and -1 for keys without a time to live", func(t *testing.T) {
+		result := ttl(context.Background(), command("ttl-missing").Array)
+		assert.Equal(t, -2, result.Num)
+
+		set(context.Background(), command("ttl-persistent", "value").Array)
+		result = pttl(context.Background(), command("ttl-persistent").Array)
+		assert.Equal(t, -1, result.Num)
+	})
+
+	t.Run("It returns the unix time the key expires at", func(t *testing.T) {
+		at := time.Now().Add(time.Hour).Unix()
+		set(context.Background(), command("ttl-at", "value", "EXAT", strconv.FormatInt(at, 10)).Array)
+
+		result := expiretime(context.Background(), command("ttl-at").Array)
+		assert.Equal(t, int(at), result.Num)
+
+		result = pexpiretime(context.Background(), command("ttl-at").Array)
+		assert.Equal(t, int(at*1000), result.Num)
+	})
+
+	t.Run("It removes the time to live with PERSIST", func(t *testing.T) {
+		set(context.Background(), command("ttl-persist", "value", "EX", "100").Array)
+
+		result := persist(context.Background(), command("ttl-persist").Array)
+		assert.Equal(t, 1, result.Num)
+
+		result = persist(context.Background(), command("ttl-persist").Array)
+		assert.Equal(t, 0, result.Num)
+
+		result = ttl(context.Background(), command("ttl-persist").Array)
+		assert.Equal(t, -1, result.Num)
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	t.Run("It hides expired keys from readers", func(t *testing.T) {
+		set(context.Background(), command("expiry-lazy", "value", "PX", "1").Array)
+		hset(context.Background(), command("expiry-lazy-hash", "field", "value").Array)
+		pexpire(context.Background(), command("expiry-lazy-hash", "1").Array)
+
+		time.Sleep(5 * time.Millisecond)
+
+		assert.Equal(t, "null", get(context.Background(), command("expiry-lazy").Array).Typ)
+		assert.Equal(t, "null", hget(context.Background(), command("expiry-lazy-hash", "field").Array).Typ)
+		assert.Empty(t, hgetall(context.Background(), command("expiry-lazy-hash").Array).Array)
+		assert.Equal(t, -2, ttl(context.Background(), command("expiry-lazy").Array).Num)
+	})
+
+	t.Run("It deletes expired keys in the background", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			set(context.Background(), command("expiry-active-"+strconv.Itoa(i), "value", "PX", "1").Array)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		// every cycle stops once the sample is mostly keys that did not expire.
+		assert.Eventually(t, func() bool {
+			KvStore.activeExpireCycle(time.Second)
+
+			unlock := lockDatabases(context.Background(), KvStore)
+			defer unlock()
+			for i := 0; i < 100; i++ {
+				if _, ok := KvStore.object("expiry-active-" + strconv.Itoa(i)); ok {
+					return false
+				}
+			}
+			return true
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("It writes unix times in the AOF so expired keys stay expired after a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "expire.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("SET", "expiry-aof", "value", "EX", "100"))
+		server.handleCommandExecution(c, command("EXPIRE", "expiry-aof", "200"))
+		server.handleCommandExecution(c, command("EXPIRE", "expiry-aof-missing", "200"))
+		assert.Nil(t, aof.Close())
+
+		content, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Contains(t, string(content), "pxat")
+		assert.Contains(t, string(content), "pexpireat")
+		assert.NotContains(t, string(content), "EX\r\n")
+		assert.NotContains(t, string(content), "expiry-aof-missing")
+	})
+
+	t.Run("It replays the AOF without expiring the keys of the commands it holds", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "expire.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("SELECT", "14"))
+
+		server.handleCommandExecution(c, command("SET", "replayed", "5", "PX", "50"))
+		server.handleCommandExecution(c, command("INCR", "replayed"))
+		assert.Nil(t, aof.Close())
+		time.Sleep(60 * time.Millisecond)
+
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+		c = testClient(t, restarted)
+		restarted.handleCommandExecution(c, command("SELECT", "14"))
+
+		assert.Equal(t, "null", restarted.handleCommandExecution(c, command("GET", "replayed")).Typ)
+		assert.Equal(t, -2, restarted.handleCommandExecution(c, command("TTL", "replayed")).Num)
+	})
+}
@@ -0,0 +1,641 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geo sets are sorted sets whose scores are the 52 bits geohash of the
+// members, see geohash.go. Searches look up the box of the center and its
+// 8 neighbors, boxes being sized after the searched area so they cover it,
+// and keep the members in the area. Port of
+// https://github.com/redis/redis/blob/unstable/src/geo.c
+
+var errGeoUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+// the meters in a unit of distance.
+func parseGeoUnit(arg string) (float64, error) {
+	switch strings.ToLower(arg) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errGeoUnit
+}
+
+// parses a longitude and a latitude, they must be in the ranges geohashes encode.
+func parseLongLat(longArg, latArg string) (float64, float64, error) {
+	longitude, err := parseFloat(longArg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	latitude, err := parseFloat(latArg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if longitude < geoLongMin || longitude > geoLongMax || latitude < geoLatMin || latitude > geoLatMax {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude)
+	}
+	return longitude, latitude, nil
+}
+
+// distances are replied in the unit asked with 4 decimals.
+func geoDistanceReply(distance float64) Value {
+	return Value{Typ: "bulk", Bulk: strconv.FormatFloat(distance, 'f', 4, 64)}
+}
+
+// coordinates are replied with 17 decimals, trailing zeros removed,
+// a bulk string for RESP2 clients.
+func geoCoordinateReply(ctx context.Context, coordinate float64) Value {
+	if clientProto(ctx) >= RESP3 {
+		return Value{Typ: "double", Double: coordinate}
+	}
+
+	s := strconv.FormatFloat(coordinate, 'f', 17, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return Value{Typ: "bulk", Bulk: s}
+}
+
+func geoPositionReply(ctx context.Context, longitude, latitude float64) Value {
+	return Value{Typ: "array", Array: []Value{geoCoordinateReply(ctx, longitude), geoCoordinateReply(ctx, latitude)}}
+}
+
+// doc: https://redis.io/docs/latest/commands/geoadd/
+func geoadd(ctx context.Context, args []Value) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("geoadd")
+	}
+
+	nx, xx := false, false
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i].Bulk) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+		default:
+			break options
+		}
+	}
+
+	triplets := args[i:]
+	if len(triplets) == 0 || len(triplets)%3 != 0 || (nx && xx) {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	// it is a ZADD with the geohashes as scores.
+	zaddArgs := append([]Value{}, args[:i]...)
+	for j := 0; j < len(triplets); j += 3 {
+		longitude, latitude, err := parseLongLat(triplets[j].Bulk, triplets[j+1].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		score, _ := encodeGeoScore(longitude, latitude)
+		zaddArgs = append(zaddArgs, Value{Typ: "bulk", Bulk: formatFloat(score)}, triplets[j+2])
+	}
+
+	return zadd(ctx, zaddArgs)
+}
+
+// doc: https://redis.io/docs/latest/commands/geopos/
+func geopos(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("geopos")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if z != nil {
+			score, ok = z.score(member.Bulk)
+		}
+
+		if !ok {
+			reply.Array = append(reply.Array, Value{Typ: "nullarray"})
+			continue
+		}
+
+		longitude, latitude := decodeGeoScore(score)
+		reply.Array = append(reply.Array, geoPositionReply(ctx, longitude, latitude))
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/geohash/
+func geohash(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("geohash")
+	}
+
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if z != nil {
+			score, ok = z.score(member.Bulk)
+		}
+
+		if !ok {
+			reply.Array = append(reply.Array, Value{Typ: "null"})
+			continue
+		}
+
+		// the standard geohash covers the latitudes from -90 to 90
+		// where scores stop at the ones of the mercator projection.
+		longitude, latitude := decodeGeoScore(score)
+		hash, _ := geohashEncode(wgs84LongRange, geohashRange{-90, 90}, longitude, latitude, geoStepMax)
+
+		// 11 characters although the 52 bits only fill 10 and a half.
+		buf := make([]byte, 11)
+		for i := range buf {
+			idx := 0
+			if i < 10 {
+				idx = int(hash.bits >> (52 - (i+1)*5) & 0x1f)
+			}
+			buf[i] = alphabet[idx]
+		}
+		reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: string(buf)})
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/geodist/
+func geodist(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("geodist")
+	}
+	if len(args) > 4 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	conversion := 1.0
+	if len(args) == 4 {
+		var err error
+		if conversion, err = parseGeoUnit(args[3].Bulk); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "null"}
+	}
+
+	score1, ok1 := z.score(args[1].Bulk)
+	score2, ok2 := z.score(args[2].Bulk)
+	if !ok1 || !ok2 {
+		return Value{Typ: "null"}
+	}
+
+	lon1, lat1 := decodeGeoScore(score1)
+	lon2, lat2 := decodeGeoScore(score2)
+	return geoDistanceReply(geohashDistance(lon1, lat1, lon2, lat2) / conversion)
+}
+
+// the orders of the results of a search.
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+// a search of GEOSEARCH and GEOSEARCHSTORE.
+type geoSearch struct {
+	member                        string
+	fromMember, fromLonLat        bool
+	longitude, latitude           float64
+	byRadius, byBox               bool
+	radius, width, height         float64
+	conversion                    float64 // meters in the unit of the distances.
+	sort                          int
+	count                         int
+	any                           bool
+	withCoord, withDist, withHash bool
+	storeDist                     bool
+}
+
+// a member found by a search.
+type geoPoint struct {
+	member              string
+	score               float64
+	longitude, latitude float64
+	dist                float64 // in meters.
+}
+
+// parses the options of GEOSEARCH, or of GEOSEARCHSTORE when store is set.
+func parseGeoSearch(args []Value, store bool) (geoSearch, error) {
+	search := geoSearch{}
+	name := "GEOSEARCH"
+	if store {
+		name = "GEOSEARCHSTORE"
+	}
+
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+
+		switch strings.ToLower(args[i].Bulk) {
+		case "frommember":
+			if remaining < 1 || search.fromMember || search.fromLonLat {
+				return search, errSyntax
+			}
+			search.member = args[i+1].Bulk
+			search.fromMember = true
+			i++
+		case "fromlonlat":
+			if remaining < 2 || search.fromMember || search.fromLonLat {
+				return search, errSyntax
+			}
+
+			var err error
+			if search.longitude, search.latitude, err = parseLongLat(args[i+1].Bulk, args[i+2].Bulk); err != nil {
+				return search, err
+			}
+			search.fromLonLat = true
+			i += 2
+		case "byradius":
+			if remaining < 2 || search.byRadius || search.byBox {
+				return search, errSyntax
+			}
+
+			var err error
+			if search.radius, err = parseFloat(args[i+1].Bulk); err != nil {
+				return search, errors.New("ERR need numeric radius")
+			}
+			if search.radius < 0 {
+				return search, errors.New("ERR radius cannot be negative")
+			}
+			if search.conversion, err = parseGeoUnit(args[i+2].Bulk); err != nil {
+				return search, err
+			}
+			search.byRadius = true
+			i += 2
+		case "bybox":
+			if remaining < 3 || search.byRadius || search.byBox {
+				return search, errSyntax
+			}
+
+			var err error
+			if search.width, err = parseFloat(args[i+1].Bulk); err != nil {
+				return search, errors.New("ERR need numeric width")
+			}
+			if search.height, err = parseFloat(args[i+2].Bulk); err != nil {
+				return search, errors.New("ERR need numeric height")
+			}
+			if search.width < 0 || search.height < 0 {
+				return search, errors.New("ERR height or width cannot be negative")
+			}
+			if search.conversion, err = parseGeoUnit(args[i+3].Bulk); err != nil {
+				return search, err
+			}
+			search.byBox = true
+			i += 3
+		case "asc":
+			search.sort = geoSortAsc
+		case "desc":
+			search.sort = geoSortDesc
+		case "count":
+			if remaining < 1 {
+				return search, errSyntax
+			}
+
+			count, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return search, errNotInteger
+			}
+			if count <= 0 {
+				return search, errors.New("ERR COUNT must be > 0")
+			}
+			search.count = count
+			i++
+		case "any":
+			search.any = true
+		case "withcoord":
+			search.withCoord = true
+		case "withdist":
+			search.withDist = true
+		case "withhash":
+			search.withHash = true
+		case "storedist":
+			if !store {
+				return search, errSyntax
+			}
+			search.storeDist = true
+		default:
+			return search, errSyntax
+		}
+	}
+
+	if store && (search.withDist || search.withHash || search.withCoord) {
+		return search, errors.New("ERR STORE option in geosearchstore is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	if !search.fromMember && !search.fromLonLat {
+		return search, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name)
+	}
+	if !search.byRadius && !search.byBox {
+		return search, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", name)
+	}
+	if search.any && search.count == 0 {
+		return search, errors.New("ERR the ANY argument requires COUNT argument")
+	}
+
+	// the closest members are the ones counted, unless any of them will do.
+	if search.count != 0 && search.sort == geoSortNone && !search.any {
+		search.sort = geoSortAsc
+	}
+	return search, nil
+}
+
+// the bounding box of the searched area: min longitude, min latitude,
+// max longitude and max latitude.
+func (search geoSearch) boundingBox() [4]float64 {
+	height, width := search.radius, search.radius
+	if search.byBox {
+		height, width = search.height/2, search.width/2
+	}
+	height *= search.conversion
+	width *= search.conversion
+
+	latDelta := radDeg(height / earthRadiusInMeters)
+	longDeltaTop := radDeg(width / earthRadiusInMeters / math.Cos(degRad(search.latitude+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadiusInMeters / math.Cos(degRad(search.latitude-latDelta)))
+
+	// the widest side of the area is the one closest to the equator.
+	longDelta := longDeltaTop
+	if search.latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return [4]float64{search.longitude - longDelta, search.latitude - latDelta, search.longitude + longDelta, search.latitude + latDelta}
+}
+
+// the boxes covering the searched area: the one of the center followed by
+// its neighbors, the ones outside of the area are zero.
+func (search geoSearch) boxes() []geohashBits {
+	bounds := search.boundingBox()
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+
+	// the distance from the center to the corners of a box.
+	radiusMeters := search.radius
+	if search.byBox {
+		radiusMeters = math.Sqrt(search.width/2*search.width/2 + search.height/2*search.height/2)
+	}
+	radiusMeters *= search.conversion
+
+	steps := geohashEstimateStepsByRadius(radiusMeters, search.latitude)
+	hash, _ := geohashEncode(wgs84LongRange, wgs84LatRange, search.longitude, search.latitude, steps)
+	neighbors := hash.neighbors()
+	area := geohashDecode(wgs84LongRange, wgs84LatRange, hash)
+
+	// near the edges of the box of the center, its neighbors may not
+	// reach the ends of the area: larger boxes are needed.
+	north := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.north)
+	south := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.south)
+	east := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.east)
+	west := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.west)
+
+	if steps > 1 && (north.latitude.max < maxLat || south.latitude.min > minLat || east.longitude.max < maxLon || west.longitude.min > minLon) {
+		steps--
+		hash, _ = geohashEncode(wgs84LongRange, wgs84LatRange, search.longitude, search.latitude, steps)
+		neighbors = hash.neighbors()
+		area = geohashDecode(wgs84LongRange, wgs84LatRange, hash)
+	}
+
+	// the neighbors on the sides the box of the center already covers
+	// are not searched.
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			neighbors.south, neighbors.southWest, neighbors.southEast = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.latitude.max > maxLat {
+			neighbors.north, neighbors.northEast, neighbors.northWest = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.longitude.min < minLon {
+			neighbors.west, neighbors.southWest, neighbors.northWest = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.longitude.max > maxLon {
+			neighbors.east, neighbors.southEast, neighbors.northEast = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+	}
+
+	return []geohashBits{
+		hash, neighbors.north, neighbors.south, neighbors.east, neighbors.west,
+		neighbors.northEast, neighbors.northWest, neighbors.southEast, neighbors.southWest,
+	}
+}
+
+// the distance of the point from the center when it is in the area.
+func (search geoSearch) contains(longitude, latitude float64) (float64, bool) {
+	if search.byRadius {
+		distance := geohashDistance(search.longitude, search.latitude, longitude, latitude)
+		return distance, distance <= search.radius*search.conversion
+	}
+
+	// the latitude is cheaper to check first.
+	if geohashLatDistance(latitude, search.latitude) > search.height*search.conversion/2 {
+		return 0, false
+	}
+	if geohashDistance(longitude, latitude, search.longitude, latitude) > search.width*search.conversion/2 {
+		return 0, false
+	}
+	return geohashDistance(search.longitude, search.latitude, longitude, latitude), true
+}
+
+// the members of the sorted set in the searched area, at most count of
+// them with ANY, sorted as asked.
+func (search geoSearch) run(z *zset) []geoPoint {
+	limit := 0
+	if search.any {
+		limit = search.count
+	}
+
+	points := []geoPoint{}
+	var last *geohashBits
+
+	for _, box := range search.boxes() {
+		if box.isZero() {
+			continue
+		}
+
+		// with huge radiuses neighbors can be the same box, it is
+		// searched once.
+		if last != nil && *last == box {
+			continue
+		}
+		if limit != 0 && len(points) >= limit {
+			break
+		}
+
+		// the members of the box have the scores of its hash followed
+		// by any bits.
+		r := zrangeSpec{min: float64(box.align52()), max: float64(geohashBits{bits: box.bits + 1, step: box.step}.align52()), maxex: true}
+		for x := z.zsl.firstInRange(r); x != nil && r.lteMax(x); x = x.level[0].forward {
+			if limit != 0 && len(points) >= limit {
+				break
+			}
+
+			longitude, latitude := decodeGeoScore(x.score)
+			if distance, ok := search.contains(longitude, latitude); ok {
+				points = append(points, geoPoint{member: x.member, score: x.score, longitude: longitude, latitude: latitude, dist: distance})
+			}
+		}
+
+		b := box
+		last = &b
+	}
+
+	switch search.sort {
+	case geoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	case geoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+
+	if search.count != 0 && len(points) > search.count {
+		points = points[:search.count]
+	}
+	return points
+}
+
+// the center of a search from a member of the sorted set.
+func (search *geoSearch) locate(z *zset) error {
+	if search.fromLonLat {
+		return nil
+	}
+
+	score, ok := z.score(search.member)
+	if !ok {
+		return errors.New("ERR could not decode requested zset member")
+	}
+	search.longitude, search.latitude = decodeGeoScore(score)
+	return nil
+}
+
+// doc: https://redis.io/docs/latest/commands/geosearch/
+func geosearch(ctx context.Context, args []Value) Value {
+	if len(args) < 6 {
+		return wrongNumberOfArgs("geosearch")
+	}
+
+	search, err := parseGeoSearch(args[1:], false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "array", Array: []Value{}}
+	}
+	if err := search.locate(z); err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, point := range search.run(z) {
+		member := Value{Typ: "bulk", Bulk: point.member}
+		if !search.withDist && !search.withHash && !search.withCoord {
+			reply.Array = append(reply.Array, member)
+			continue
+		}
+
+		result := Value{Typ: "array", Array: []Value{member}}
+		if search.withDist {
+			result.Array = append(result.Array, geoDistanceReply(point.dist/search.conversion))
+		}
+		if search.withHash {
+			result.Array = append(result.Array, Value{Typ: "integer", Num: int(point.score)})
+		}
+		if search.withCoord {
+			result.Array = append(result.Array, geoPositionReply(ctx, point.longitude, point.latitude))
+		}
+		reply.Array = append(reply.Array, result)
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/geosearchstore/
+func geosearchstore(ctx context.Context, args []Value) Value {
+	if len(args) < 7 {
+		return wrongNumberOfArgs("geosearchstore")
+	}
+
+	search, err := parseGeoSearch(args[2:], true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	var entries []zsetEntry
+	z, err := db.writableZset(args[1].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z != nil {
+		if err := search.locate(z); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		// the members keep their geohash, or are scored by their
+		// distance with STOREDIST.
+		for _, point := range search.run(z) {
+			score := point.score
+			if search.storeDist {
+				score = point.dist / search.conversion
+			}
+			entries = append(entries, zsetEntry{member: point.member, score: score})
+		}
+	}
+
+	return Value{Typ: "integer", Num: db.storeZset(args[0].Bulk, entries)}
+}
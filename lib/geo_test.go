@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the Sicily of the examples of the redis documentation.
+func newTestGeo(t *testing.T) string {
+	return newTestKey(t, "GEOADD",
+		"13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+}
+
+func TestGeohash(t *testing.T) {
+	t.Run("It encodes points in boxes holding them", func(t *testing.T) {
+		hash, ok := geohashEncode(wgs84LongRange, wgs84LatRange, 13.361389, 38.115556, geoStepMax)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(3479099956230698), hash.align52())
+
+		area := geohashDecode(wgs84LongRange, wgs84LatRange, hash)
+		assert.True(t, area.longitude.min <= 13.361389 && 13.361389 <= area.longitude.max)
+		assert.True(t, area.latitude.min <= 38.115556 && 38.115556 <= area.latitude.max)
+
+		_, ok = geohashEncode(wgs84LongRange, wgs84LatRange, 0, 86, geoStepMax)
+		assert.False(t, ok)
+	})
+
+	t.Run("It finds the boxes around a box", func(t *testing.T) {
+		hash, _ := geohashEncode(wgs84LongRange, wgs84LatRange, 10, 10, 10)
+		area := geohashDecode(wgs84LongRange, wgs84LatRange, hash)
+		neighbors := hash.neighbors()
+
+		north := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.north)
+		assert.Equal(t, area.latitude.max, north.latitude.min)
+		assert.Equal(t, area.longitude, north.longitude)
+
+		southWest := geohashDecode(wgs84LongRange, wgs84LatRange, neighbors.southWest)
+		assert.Equal(t, area.latitude.min, southWest.latitude.max)
+		assert.Equal(t, area.longitude.min, southWest.longitude.max)
+	})
+
+	t.Run("It measures distances on the earth", func(t *testing.T) {
+		assert.InDelta(t, 166274.26, geohashDistance(13.361389, 38.115556, 15.087269, 37.502669), 0.01)
+		assert.InDelta(t, 111226.30, geohashDistance(0, 0, 0, 1), 0.01)
+	})
+}
+
+func TestGeoCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It adds members scored by their geohash", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 2, geoadd(ctx, command(key, "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania").Array).Num)
+		assert.Equal(t, "3479099956230698", formatDouble(zscore(ctx, command(key, "Palermo").Array).Double))
+		assert.Equal(t, 0, geoadd(ctx, command(key, "NX", "0", "0", "Palermo").Array).Num)
+		assert.Equal(t, 1, geoadd(ctx, command(key, "XX", "CH", "0", "0", "Palermo", "1", "1", "Rome").Array).Num)
+		assert.Equal(t, 2, zcard(ctx, command(key).Array).Num)
+
+		assert.Equal(t, "ERR invalid longitude,latitude pair 200.000000,10.000000", geoadd(ctx, command(key, "200", "10", "x").Array).Str)
+		assert.Equal(t, errNotFloat.Error(), geoadd(ctx, command(key, "a", "10", "x").Array).Str)
+		assert.Equal(t, errSyntax.Error(), geoadd(ctx, command(key, "NX", "XX", "1", "1", "x").Array).Str)
+		assert.Equal(t, errSyntax.Error(), geoadd(ctx, command(key, "1", "1", "x", "2").Array).Str)
+	})
+
+	t.Run("It replies with positions, geohashes and distances", func(t *testing.T) {
+		key := newTestGeo(t)
+
+		result := geopos(ctx, command(key, "Palermo", "missing").Array)
+		assert.Equal(t, []string{"13.36138933897018433", "38.11555639549629859"}, bulks(result.Array[0]))
+		assert.Equal(t, "nullarray", result.Array[1].Typ)
+
+		assert.Equal(t, []string{"sqc8b49rny0", "sqdtr74hyu0", ""}, bulks(geohash(ctx, command(key, "Palermo", "Catania", "missing").Array)))
+
+		assert.Equal(t, "166274.1516", geodist(ctx, command(key, "Palermo", "Catania").Array).Bulk)
+		assert.Equal(t, "166.2742", geodist(ctx, command(key, "Palermo", "Catania", "KM").Array).Bulk)
+		assert.Equal(t, "103.3182", geodist(ctx, command(key, "Palermo", "Catania", "mi").Array).Bulk)
+		assert.Equal(t, "null", geodist(ctx, command(key, "Palermo", "missing").Array).Typ)
+		assert.Equal(t, errGeoUnit.Error(), geodist(ctx, command(key, "Palermo", "Catania", "yd").Array).Str)
+	})
+
+	t.Run("It searches members in a radius or a box", func(t *testing.T) {
+		key := newTestGeo(t)
+
+		assert.Equal(t, []string{"Catania", "Palermo"}, bulks(geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC").Array)))
+		assert.Equal(t, []string{"Palermo", "Catania"}, bulks(geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC").Array)))
+		assert.Equal(t, []string{"Catania", "Palermo", "edge2", "edge1"}, bulks(geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC").Array)))
+		assert.Equal(t, []string{"Palermo", "edge1", "Catania"}, bulks(geosearch(ctx, command(key, "FROMMEMBER", "Palermo", "BYRADIUS", "170", "km", "ASC").Array)))
+
+		// a box narrower than its height misses the edges.
+		assert.Equal(t, []string{"Catania"}, bulks(geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYBOX", "50", "400", "km").Array)))
+
+		assert.Equal(t, []string{"Catania"}, bulks(geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "1").Array)))
+		assert.Len(t, geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "2", "ANY").Array).Array, 2)
+		assert.Empty(t, geosearch(ctx, command("zset-missing", "FROMMEMBER", "Palermo", "BYRADIUS", "1", "m").Array).Array)
+	})
+
+	t.Run("It replies with the distance, hash and coordinates of members", func(t *testing.T) {
+		key := newTestGeo(t)
+
+		result := geosearch(ctx, command(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHCOORD", "WITHDIST", "WITHHASH").Array)
+		assert.Len(t, result.Array, 2)
+		assert.Equal(t, "Catania", result.Array[0].Array[0].Bulk)
+		assert.Equal(t, "56.4413", result.Array[0].Array[1].Bulk)
+		assert.Equal(t, 3479447370796909, result.Array[0].Array[2].Num)
+		assert.Equal(t, []string{"15.08726745843887329", "37.50266842333162032"}, bulks(result.Array[0].Array[3]))
+		assert.Equal(t, "190.4424", result.Array[1].Array[1].Bulk)
+	})
+
+	t.Run("It stores the members found with their geohash or distance", func(t *testing.T) {
+		key, dest := newTestGeo(t), newTestKey(t)
+
+		assert.Equal(t, 3, geosearchstore(ctx, command(dest, key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "COUNT", "3").Array).Num)
+		assert.Equal(t, "3479447370796909", formatDouble(zscore(ctx, command(dest, "Catania").Array).Double))
+
+		assert.Equal(t, 3, geosearchstore(ctx, command(dest, key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "COUNT", "3", "STOREDIST").Array).Num)
+		assert.Equal(t, []string{"Catania", "Palermo", "edge2"}, bulks(zrange(ctx, command(dest, "0", "-1").Array)))
+		assert.InDelta(t, 56.44125787, zscore(ctx, command(dest, "Catania").Array).Double, 1e-8)
+		assert.InDelta(t, 279.74034178, zscore(ctx, command(dest, "edge2").Array).Double, 1e-8)
+
+		assert.Equal(t, 0, geosearchstore(ctx, command(dest, key, "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km").Array).Num)
+		assert.Equal(t, 0, zcard(ctx, command(dest).Array).Num)
+	})
+
+	t.Run("It checks the search options", func(t *testing.T) {
+		key := newTestGeo(t)
+
+		assert.Equal(t, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH", geosearch(ctx, command(key, "BYRADIUS", "1", "km", "ASC", "COUNT", "1").Array).Str)
+		assert.Equal(t, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH", geosearch(ctx, command(key, "FROMLONLAT", "1", "1", "ASC", "DESC").Array).Str)
+		assert.Equal(t, errSyntax.Error(), geosearch(ctx, command(key, "FROMMEMBER", "a", "FROMLONLAT", "1", "1").Array).Str)
+		assert.Equal(t, "ERR the ANY argument requires COUNT argument", geosearch(ctx, command(key, "FROMLONLAT", "1", "1", "BYRADIUS", "1", "km", "ANY").Array).Str)
+		assert.Equal(t, "ERR COUNT must be > 0", geosearch(ctx, command(key, "FROMLONLAT", "1", "1", "BYRADIUS", "1", "km", "COUNT", "0").Array).Str)
+		assert.Equal(t, "ERR radius cannot be negative", geosearch(ctx, command(key, "FROMLONLAT", "1", "1", "BYRADIUS", "-1", "km").Array).Str)
+		assert.Equal(t, "ERR could not decode requested zset member", geosearch(ctx, command(key, "FROMMEMBER", "missing", "BYRADIUS", "1", "km").Array).Str)
+		assert.Equal(t, errSyntax.Error(), geosearch(ctx, command(key, "FROMLONLAT", "1", "1", "BYRADIUS", "1", "km", "STOREDIST").Array).Str)
+		assert.Equal(t, "ERR STORE option in geosearchstore is not compatible with WITHDIST, WITHHASH and WITHCOORD options",
+			geosearchstore(ctx, command("dest", key, "FROMLONLAT", "1", "1", "BYRADIUS", "1", "km", "WITHDIST").Array).Str)
+	})
+}
@@ -0,0 +1,252 @@
+package lib
+
+import "math"
+
+// geohashes interleave the bits of the latitude, the even ones, with the
+// bits of the longitude, the odd ones: each step halves the area in both
+// directions. At 26 steps the 52 bits fit exactly in the mantissa of the
+// score of a sorted set member. Port of
+// https://github.com/redis/redis/blob/unstable/src/geohash.c and
+// https://github.com/redis/redis/blob/unstable/src/geohash_helper.c
+const (
+	geoStepMax = 26
+
+	// the latitudes of the web mercator projection redis limits points to.
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180
+	geoLongMax = 180
+
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+type geohashRange struct {
+	min, max float64
+}
+
+type geohashBits struct {
+	bits uint64
+	step uint
+}
+
+func (h geohashBits) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// the score of the hash once its bits are aligned to 52.
+func (h geohashBits) align52() uint64 {
+	return h.bits << (52 - h.step*2)
+}
+
+type geohashArea struct {
+	longitude, latitude geohashRange
+}
+
+// the 8 boxes around a geohash box, of the same step.
+type geohashNeighbors struct {
+	north, east, west, south                   geohashBits
+	northEast, southEast, northWest, southWest geohashBits
+}
+
+var (
+	wgs84LongRange = geohashRange{geoLongMin, geoLongMax}
+	wgs84LatRange  = geohashRange{geoLatMin, geoLatMax}
+)
+
+// spreads the 32 bits of x over the even bits and the ones of y over
+// the odd bits of the result.
+func interleave64(x, y uint32) uint64 {
+	spread := func(v uint64) uint64 {
+		v = (v | v<<16) & 0x0000FFFF0000FFFF
+		v = (v | v<<8) & 0x00FF00FF00FF00FF
+		v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v<<2) & 0x3333333333333333
+		v = (v | v<<1) & 0x5555555555555555
+		return v
+	}
+	return spread(uint64(x)) | spread(uint64(y))<<1
+}
+
+// the reverse of interleave64: x in the low 32 bits, y in the high ones.
+func deinterleave64(interleaved uint64) uint64 {
+	squash := func(v uint64) uint64 {
+		v &= 0x5555555555555555
+		v = (v | v>>1) & 0x3333333333333333
+		v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v>>4) & 0x00FF00FF00FF00FF
+		v = (v | v>>8) & 0x0000FFFF0000FFFF
+		v = (v | v>>16) & 0x00000000FFFFFFFF
+		return v
+	}
+	return squash(interleaved) | squash(interleaved>>1)<<32
+}
+
+// the geohash of step bits per coordinate of the point, ok is false when
+// it is out of the ranges.
+func geohashEncode(longRange, latRange geohashRange, longitude, latitude float64, step uint) (geohashBits, bool) {
+	if step > 32 || step == 0 ||
+		latitude < geoLatMin || latitude > geoLatMax || longitude < geoLongMin || longitude > geoLongMax ||
+		latitude < latRange.min || latitude > latRange.max || longitude < longRange.min || longitude > longRange.max {
+		return geohashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+
+	// the offsets are in [0, 1], scaled to step bits.
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geohashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}, true
+}
+
+// the box of the geohash.
+func geohashDecode(longRange, latRange geohashRange, hash geohashBits) geohashArea {
+	separated := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+
+	ilato := uint32(separated)
+	ilono := uint32(separated >> 32)
+	cells := float64(uint64(1) << hash.step)
+
+	return geohashArea{
+		latitude: geohashRange{
+			min: latRange.min + float64(ilato)/cells*latScale,
+			max: latRange.min + (float64(ilato)+1)/cells*latScale,
+		},
+		longitude: geohashRange{
+			min: longRange.min + float64(ilono)/cells*longScale,
+			max: longRange.min + (float64(ilono)+1)/cells*longScale,
+		},
+	}
+}
+
+// the center of the box, kept in the ranges of the coordinates.
+func (a geohashArea) center() (float64, float64) {
+	longitude := min(max((a.longitude.min+a.longitude.max)/2, geoLongMin), geoLongMax)
+	latitude := min(max((a.latitude.min+a.latitude.max)/2, geoLatMin), geoLatMax)
+	return longitude, latitude
+}
+
+// the coordinates of a member of a geo sorted set from its score.
+func decodeGeoScore(score float64) (float64, float64) {
+	hash := geohashBits{bits: uint64(score), step: geoStepMax}
+	return geohashDecode(wgs84LongRange, wgs84LatRange, hash).center()
+}
+
+// the score of a point in a geo sorted set.
+func encodeGeoScore(longitude, latitude float64) (float64, bool) {
+	hash, ok := geohashEncode(wgs84LongRange, wgs84LatRange, longitude, latitude, geoStepMax)
+	return float64(hash.align52()), ok
+}
+
+// moves the box d boxes east, west when d is negative.
+func (h *geohashBits) moveX(d int) {
+	if d == 0 {
+		return
+	}
+
+	x := h.bits & 0xaaaaaaaaaaaaaaaa
+	y := h.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - h.step*2)
+
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - h.step*2)
+	h.bits = x | y
+}
+
+// moves the box d boxes north, south when d is negative.
+func (h *geohashBits) moveY(d int) {
+	if d == 0 {
+		return
+	}
+
+	x := h.bits & 0xaaaaaaaaaaaaaaaa
+	y := h.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.step*2)
+
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+
+	y &= 0x5555555555555555 >> (64 - h.step*2)
+	h.bits = x | y
+}
+
+func (h geohashBits) moved(dx, dy int) geohashBits {
+	h.moveX(dx)
+	h.moveY(dy)
+	return h
+}
+
+func (h geohashBits) neighbors() geohashNeighbors {
+	return geohashNeighbors{
+		east:      h.moved(1, 0),
+		west:      h.moved(-1, 0),
+		south:     h.moved(0, -1),
+		north:     h.moved(0, 1),
+		northWest: h.moved(-1, 1),
+		southWest: h.moved(-1, -1),
+		northEast: h.moved(1, 1),
+		southEast: h.moved(1, -1),
+	}
+}
+
+// the step of the boxes to search so the 9 around the center cover the
+// range, boxes are wider near the poles.
+func geohashEstimateStepsByRadius(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2 // the range is in most of the base cases.
+
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+func degRad(angle float64) float64 {
+	return angle * (math.Pi / 180)
+}
+
+func radDeg(angle float64) float64 {
+	return angle / (math.Pi / 180)
+}
+
+// the distance in meters between two latitudes on a meridian.
+func geohashLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// the distance in meters between two points with the haversine formula.
+func geohashDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((degRad(lon2) - degRad(lon1)) / 2)
+	if v == 0 {
+		return geohashLatDistance(lat1, lat2)
+	}
+
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,666 @@
+package lib
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// a hash, the fields given a time to live have the unix time in
+// milliseconds they expire at in expires. Like keys, expired fields stay
+// in the dict until a command writes the hash or the active expire cycle
+// finds them, reading skips them. The fields with a time to live are also
+// kept ordered by it so counting the expired ones does not walk them all.
+type hash struct {
+	*dict[string]
+	expires  map[string]int64
+	byExpire *zskiplist // the fields of expires scored by the time they expire at.
+}
+
+func newHash() *hash {
+	return &hash{dict: newDict[string]()}
+}
+
+// reports whether the time to live of the field passed at now. A command
+// checks every field against the same now, the one of its store, so the
+// fields it counts are the ones it walks.
+func (h *hash) expired(field string, now int64) bool {
+	when, ok := h.expires[field]
+	return ok && when <= now
+}
+
+func (h *hash) get(field string, now int64) (string, bool) {
+	if h.expired(field, now) {
+		return "", false
+	}
+	return h.dict.get(field)
+}
+
+// sets the value of the field and clears its time to live, it returns
+// true when the field is new. Like update and delete it is used on the
+// hashes of writableHash, which have no expired fields.
+func (h *hash) set(field, value string) bool {
+	h.persist(field)
+	return h.dict.set(field, value)
+}
+
+// sets the value of the field keeping its time to live.
+func (h *hash) update(field, value string) {
+	h.dict.set(field, value)
+}
+
+func (h *hash) delete(field string) bool {
+	h.persist(field)
+	return h.dict.delete(field)
+}
+
+// the number of fields that did not expire at now, O(log n) in the number
+// of fields with a time to live.
+func (h *hash) len(now int64) int {
+	if len(h.expires) == 0 {
+		return h.dict.len()
+	}
+
+	last := h.byExpire.lastInRange(zrangeSpec{min: math.Inf(-1), max: float64(now)})
+	if last == nil {
+		return h.dict.len()
+	}
+	return h.dict.len() - h.byExpire.rank(last.score, last.member)
+}
+
+// reports whether every field expired at now, or there are none, in O(1):
+// a field is left when one has no time to live or the last one to expire did not.
+func (h *hash) empty(now int64) bool {
+	if h.dict.len() > len(h.expires) || len(h.expires) == 0 {
+		return h.dict.len() == 0
+	}
+	return h.byExpire.tail.score <= float64(now)
+}
+
+func (h *hash) each(now int64, fn func(field, value string) bool) {
+	h.dict.each(func(field, value string) bool {
+		return h.expired(field, now) || fn(field, value)
+	})
+}
+
+// returns a random field that did not expire at now, ok is false when
+// none is left.
+func (h *hash) random(now int64) (field, value string, ok bool) {
+	if h.empty(now) {
+		return "", "", false
+	}
+	for {
+		field, value := h.dict.random()
+		if !h.expired(field, now) {
+			return field, value, true
+		}
+	}
+}
+
+// the unix time in milliseconds the field expires at, if it has a time to live.
+func (h *hash) ttl(field string) (int64, bool) {
+	when, ok := h.expires[field]
+	return when, ok
+}
+
+func (h *hash) expire(field string, when int64) {
+	if h.expires == nil {
+		h.expires = map[string]int64{}
+		h.byExpire = newZskiplist()
+	}
+
+	if old, ok := h.expires[field]; ok {
+		h.byExpire.updateScore(float64(old), field, float64(when))
+	} else {
+		h.byExpire.insert(float64(when), field)
+	}
+	h.expires[field] = when
+}
+
+func (h *hash) persist(field string) bool {
+	when, ok := h.expires[field]
+	if ok {
+		delete(h.expires, field)
+		h.byExpire.delete(float64(when), field)
+	}
+	return ok
+}
+
+// deletes the fields whose time to live passed at now and returns how many there were.
+func (h *hash) deleteExpired(now int64) int {
+	if len(h.expires) == 0 {
+		return 0
+	}
+
+	deleted := 0
+	for x := h.byExpire.header.level[0].forward; x != nil && x.score <= float64(now); x = h.byExpire.header.level[0].forward {
+		h.persist(x.member)
+		h.dict.delete(x.member)
+		deleted++
+	}
+	return deleted
+}
+
+// a copy of the hash, the expired fields are copied with their time to live.
+func (h *hash) clone() *hash {
+	c := newHash()
+	h.dict.each(func(field, value string) bool {
+		c.dict.set(field, value)
+		if when, ok := h.expires[field]; ok {
+			c.expire(field, when)
+		}
+		return true
+	})
+	return c
+}
+
+// the hash stored at key for commands reading it, nil when there is none
+// or all its fields expired. The store must be locked.
+func (s *SimpleStore) lookupHash(key string) (*hash, error) {
+	return lookupValue[*hash](s, key, typeHash)
+}
+
+// the hash stored at key for commands writing it, an empty one is
+// stored when create is true and there is none. The expired fields are
+// deleted first, with the hash once none is left. The store must be locked for writing.
+func (s *SimpleStore) writableHash(key string, create bool) (*hash, error) {
+	h, err := writableValue(s, key, typeHash, false, newHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if h != nil && h.deleteExpired(s.now()) > 0 && h.dict.len() == 0 {
+		s.deleteKey(key)
+		h = nil
+	}
+
+	if h == nil && create {
+		h = newHash()
+		s.shard(key).keys.set(key, newObject(typeHash, h))
+	}
+	return h, nil
+}
+
+// the value of the field at now, a missing hash has no fields.
+func hashGet(h *hash, field string, now int64) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	return h.get(field, now)
+}
+
+// doc: https://redis.io/docs/latest/commands/hset/
+func hset(ctx context.Context, args []Value) Value {
+	// it is possible client sends key without value.
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongNumberOfArgs("hset")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.writableHash(args[0].Bulk, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// only the fields that were not in the hash are counted.
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		if h.set(args[i].Bulk, args[i+1].Bulk) {
+			added++
+		}
+	}
+
+	return Value{Typ: "integer", Num: added}
+}
+
+// doc: https://redis.io/docs/latest/commands/hsetnx/
+func hsetnx(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hsetnx")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.writableHash(args[0].Bulk, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if _, ok := h.get(args[1].Bulk, db.now()); ok {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	h.set(args[1].Bulk, args[2].Bulk)
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/hget/
+func hget(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hget")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	return bulkOrNull(hashGet(h, args[1].Bulk, db.now()))
+}
+
+// doc: https://redis.io/docs/latest/commands/hmget/
+func hmget(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hmget")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	now := db.now()
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, field := range args[1:] {
+		reply.Array = append(reply.Array, bulkOrNull(hashGet(h, field.Bulk, now)))
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hgetall/
+func hgetall(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("hgetall")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	// a missing hash is an empty hash.
+	results := []Value{}
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if h != nil {
+		h.each(db.now(), func(field, value string) bool {
+			results = append(results, Value{Typ: "bulk", Bulk: field}, Value{Typ: "bulk", Bulk: value})
+			return true
+		})
+	}
+
+	// RESP2 connections receive the map as a flat array of fields and values.
+	return Value{Typ: "map", Array: results}
+}
+
+// doc: https://redis.io/docs/latest/commands/hkeys/
+func hkeys(ctx context.Context, args []Value) Value {
+	return hashElements(ctx, "hkeys", args, true, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/hvals/
+func hvals(ctx context.Context, args []Value) Value {
+	return hashElements(ctx, "hvals", args, false, true)
+}
+
+func hashElements(ctx context.Context, name string, args []Value, fields, values bool) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if h != nil {
+		h.each(db.now(), func(field, value string) bool {
+			if fields {
+				reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: field})
+			}
+			if values {
+				reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: value})
+			}
+			return true
+		})
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hdel/
+func hdel(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hdel")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	h, err := db.writableHash(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if h == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		if h.delete(field.Bulk) {
+			deleted++
+		}
+	}
+
+	// redis never keeps empty hashes.
+	if h.empty(db.now()) {
+		db.deleteKey(key)
+	}
+	return Value{Typ: "integer", Num: deleted}
+}
+
+// doc: https://redis.io/docs/latest/commands/hexists/
+func hexists(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hexists")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	if _, ok := hashGet(h, args[1].Bulk, db.now()); ok {
+		return Value{Typ: "integer", Num: 1}
+	}
+	return Value{Typ: "integer", Num: 0}
+}
+
+// doc: https://redis.io/docs/latest/commands/hlen/
+func hlen(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("hlen")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if h == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: h.len(db.now())}
+}
+
+// doc: https://redis.io/docs/latest/commands/hstrlen/
+func hstrlen(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hstrlen")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	value, _ := hashGet(h, args[1].Bulk, db.now())
+	return Value{Typ: "integer", Num: len(value)}
+}
+
+// doc: https://redis.io/docs/latest/commands/hincrby/
+func hincrby(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hincrby")
+	}
+
+	incr, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.writableHash(args[0].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	current := int64(0)
+	if value, ok := hashGet(h, args[1].Bulk, db.now()); ok {
+		if current, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Value{Typ: "error", Str: "ERR hash value is not an integer"}
+		}
+	}
+
+	if (incr < 0 && current < math.MinInt64-incr) || (incr > 0 && current > math.MaxInt64-incr) {
+		return Value{Typ: "error", Str: "ERR increment or decrement would overflow"}
+	}
+
+	current += incr
+	if h == nil {
+		h, _ = db.writableHash(args[0].Bulk, true)
+	}
+	h.update(args[1].Bulk, strconv.FormatInt(current, 10))
+	return Value{Typ: "integer", Num: int(current)}
+}
+
+// doc: https://redis.io/docs/latest/commands/hincrbyfloat/
+func hincrbyfloat(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hincrbyfloat")
+	}
+
+	incr, err := parseFloat(args[2].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key, field := args[0].Bulk, args[1].Bulk
+	h, err := db.writableHash(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	current, value := 0.0, "0"
+	if v, ok := hashGet(h, field, db.now()); ok {
+		if current, err = parseFloat(v); err != nil {
+			return Value{Typ: "error", Str: "ERR hash value is not a float"}
+		}
+		value = v
+	}
+
+	current += incr
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return Value{Typ: "error", Str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	value = addLongDoubles(value, args[2].Bulk)
+	if h == nil {
+		h, _ = db.writableHash(key, true)
+	}
+	h.update(field, value)
+
+	// the AOF gets the result so float rounding cannot differ when replaying it.
+	propagateAs(ctx, command("hset", key, field, value))
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// doc: https://redis.io/docs/latest/commands/hrandfield/
+func hrandfield(ctx context.Context, args []Value) Value {
+	if len(args) < 1 || len(args) > 3 {
+		return wrongNumberOfArgs("hrandfield")
+	}
+
+	count := 1
+	if len(args) >= 2 {
+		n, err := parseRandomCount(ctx, args[1].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		count = n
+	}
+
+	withValues := len(args) == 3
+	if withValues && strings.ToLower(args[2].Bulk) != "withvalues" {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// every field is checked against the same time, a field expiring
+	// while the command runs is either counted and walked or neither.
+	now := db.now()
+	if h != nil && h.empty(now) {
+		h = nil
+	}
+
+	if len(args) == 1 {
+		if h == nil {
+			return Value{Typ: "null"}
+		}
+		field, _, _ := h.random(now)
+		return Value{Typ: "bulk", Bulk: field}
+	}
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	if h == nil || count == 0 {
+		return reply
+	}
+
+	type pair struct{ field, value string }
+	var pairs []pair
+
+	switch {
+	// a negative count may return the same field more than once.
+	case count < 0:
+		for i := 0; i < -count; i++ {
+			field, value, _ := h.random(now)
+			pairs = append(pairs, pair{field, value})
+		}
+
+	case count >= h.len(now):
+		h.each(now, func(field, value string) bool {
+			pairs = append(pairs, pair{field, value})
+			return true
+		})
+
+	// when most fields are returned, dropping the others is cheaper
+	// than picking random ones until enough are distinct.
+	case count*3 > h.len(now):
+		h.each(now, func(field, value string) bool {
+			pairs = append(pairs, pair{field, value})
+			return true
+		})
+		rand.Shuffle(len(pairs), func(i, j int) { pairs[i], pairs[j] = pairs[j], pairs[i] })
+		pairs = pairs[:count]
+
+	default:
+		picked := make(map[string]bool, count)
+		for len(picked) < count {
+			field, value, _ := h.random(now)
+			if !picked[field] {
+				picked[field] = true
+				pairs = append(pairs, pair{field, value})
+			}
+		}
+	}
+
+	resp3 := clientProto(ctx) >= RESP3
+	for _, p := range pairs {
+		field := Value{Typ: "bulk", Bulk: p.field}
+		value := Value{Typ: "bulk", Bulk: p.value}
+
+		switch {
+		case !withValues:
+			reply.Array = append(reply.Array, field)
+		case resp3:
+			reply.Array = append(reply.Array, Value{Typ: "array", Array: []Value{field, value}})
+		default:
+			reply.Array = append(reply.Array, field, value)
+		}
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hscan/
+func hscan(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hscan")
+	}
+
+	opts, err := parseScanOptions(args[1:], "hscan")
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if h == nil {
+		return scanReply(0, []Value{})
+	}
+
+	now := db.now()
+	elements := []Value{}
+	cursor := scanDict(h.dict, opts.cursor, opts.count, func(field, value string) {
+		if h.expired(field, now) || !opts.match(field) {
+			return
+		}
+
+		elements = append(elements, Value{Typ: "bulk", Bulk: field})
+		if !opts.noValues {
+			elements = append(elements, Value{Typ: "bulk", Bulk: value})
+		}
+	})
+
+	return scanReply(cursor, elements)
+}
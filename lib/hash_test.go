@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It merges the fields set by HSET and counts the new ones", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "name", "ada", "lang", "en")
+
+		assert.Equal(t, 1, hset(ctx, command(key, "lang", "fr", "city", "paris").Array).Num)
+		assert.Equal(t, 0, hsetnx(ctx, command(key, "name", "grace").Array).Num)
+		assert.Equal(t, 1, hsetnx(ctx, command(key, "age", "36").Array).Num)
+
+		assert.Equal(t, 4, hlen(ctx, command(key).Array).Num)
+		assert.Equal(t, []string{"ada", "fr", ""}, bulks(hmget(ctx, command(key, "name", "lang", "missing").Array)))
+		assert.Equal(t, "null", hmget(ctx, command(key, "missing").Array).Array[0].Typ)
+		assert.ElementsMatch(t, []string{"name", "lang", "city", "age"}, bulks(hkeys(ctx, command(key).Array)))
+		assert.ElementsMatch(t, []string{"ada", "fr", "paris", "36"}, bulks(hvals(ctx, command(key).Array)))
+		assert.Equal(t, 5, hstrlen(ctx, command(key, "city").Array).Num)
+		assert.Equal(t, 1, hexists(ctx, command(key, "age").Array).Num)
+		assert.Equal(t, 0, hexists(ctx, command(key, "missing").Array).Num)
+	})
+
+	t.Run("It deletes fields and the hash once empty", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "a", "1", "b", "2")
+
+		assert.Equal(t, 1, hdel(ctx, command(key, "a", "z").Array).Num)
+		assert.Equal(t, 1, hdel(ctx, command(key, "b").Array).Num)
+		assert.Equal(t, -2, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It increments fields", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "name", "ada")
+
+		assert.Equal(t, 5, hincrby(ctx, command(key, "visits", "5").Array).Num)
+		assert.Equal(t, 3, hincrby(ctx, command(key, "visits", "-2").Array).Num)
+		assert.Equal(t, "ERR hash value is not an integer", hincrby(ctx, command(key, "name", "1").Array).Str)
+		assert.Equal(t, "ERR increment or decrement would overflow", hincrby(ctx, command(key, "visits", "9223372036854775807").Array).Str)
+
+		assert.Equal(t, "10.5", hincrbyfloat(ctx, command(key, "score", "10.5").Array).Bulk)
+		assert.Equal(t, "5.5", hincrbyfloat(ctx, command(key, "score", "-5e0").Array).Bulk)
+		assert.Equal(t, "1.1", hincrbyfloat(ctx, command(key, "rounded", "1.1").Array).Bulk)
+		assert.Equal(t, "3.3", hincrbyfloat(ctx, command(key, "rounded", "2.2").Array).Bulk)
+		assert.Equal(t, "ERR hash value is not a float", hincrbyfloat(ctx, command(key, "name", "1").Array).Str)
+
+		missing := newTestKey(t)
+		assert.Equal(t, "ERR increment would produce NaN or Infinity", hincrbyfloat(ctx, command(missing, "f", "inf").Array).Str)
+		assert.Equal(t, -2, ttl(ctx, command(missing).Array).Num)
+	})
+
+	t.Run("It writes the result of HINCRBYFLOAT in the AOF", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t, "HSET", "f", "1.5")
+
+		server.call(c, command("HINCRBYFLOAT", key, "f", "1"))
+		assert.Equal(t, []string{"hset", key, "f", "2.5"}, bulks(c.propagated[0]))
+	})
+
+	t.Run("It returns random fields", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "a", "1", "b", "2", "c", "3")
+
+		assert.Contains(t, []string{"a", "b", "c"}, hrandfield(ctx, command(key).Array).Bulk)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, bulks(hrandfield(ctx, command(key, "5").Array)))
+		assert.Len(t, hrandfield(ctx, command(key, "2").Array).Array, 2)
+		assert.Len(t, hrandfield(ctx, command(key, "-5").Array).Array, 5)
+		assert.Equal(t, "ERR value is out of range", hrandfield(ctx, command(key, "-2000000000").Array).Str)
+
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("CONFIG", "SET", "proto-max-multibulk-len", "10"))
+		assert.Len(t, server.handleCommandExecution(c, command("HRANDFIELD", key, "-10")).Array, 10)
+		assert.Equal(t, "ERR value is out of range", server.handleCommandExecution(c, command("HRANDFIELD", key, "-11")).Str)
+
+		result := hrandfield(ctx, command(key, "1", "WITHVALUES").Array)
+		assert.Len(t, result.Array, 2)
+		value, _ := storedValue[*hash](key).get(result.Array[0].Bulk, mstime())
+		assert.Equal(t, value, result.Array[1].Bulk)
+
+		assert.Equal(t, "null", hrandfield(ctx, command("hash-missing").Array).Typ)
+	})
+
+	t.Run("It scans every field with a cursor", func(t *testing.T) {
+		key := newTestKey(t)
+		expected := []string{}
+		for i := 0; i < 100; i++ {
+			hset(ctx, command(key, "field:"+strconv.Itoa(i), strconv.Itoa(i)).Array)
+			expected = append(expected, "field:"+strconv.Itoa(i))
+		}
+		hset(ctx, command(key, "other", "x").Array)
+
+		seen := map[string]bool{}
+		cursor := "0"
+		for {
+			result := hscan(ctx, command(key, cursor, "MATCH", "field:*", "COUNT", "7", "NOVALUES").Array)
+			for _, field := range result.Array[1].Array {
+				seen[field.Bulk] = true
+			}
+
+			cursor = result.Array[0].Bulk
+			if cursor == "0" {
+				break
+			}
+		}
+
+		fields := []string{}
+		for field := range seen {
+			fields = append(fields, field)
+		}
+		assert.ElementsMatch(t, expected, fields)
+
+		result := hscan(ctx, command(key, "0", "MATCH", "other", "COUNT", "1000").Array)
+		assert.Equal(t, []string{"other", "x"}, bulks(result.Array[1]))
+		assert.Equal(t, "ERR invalid cursor", hscan(ctx, command(key, "x").Array).Str)
+	})
+}
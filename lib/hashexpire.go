@@ -0,0 +1,429 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errHashFieldsMissing = errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+
+// gives the field of the hash stored at key a time to live, the store
+// must be locked for writing.
+func (s *SimpleStore) expireHashField(key string, h *hash, field string, when int64) {
+	h.expire(field, when)
+	s.shard(key).volatileHashes[key] = true
+}
+
+// parses "FIELDS numfields field ...", each field followed by its value when withValues.
+func parseHashFields(args []Value, withValues bool) ([]Value, error) {
+	if len(args) < 2 || strings.ToLower(args[0].Bulk) != "fields" {
+		return nil, errHashFieldsMissing
+	}
+
+	n, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || n <= 0 {
+		return nil, errors.New("ERR Number of fields must be a positive integer")
+	}
+
+	fields := args[2:]
+	if withValues {
+		n *= 2
+	}
+	if len(fields) != n {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+
+	return fields, nil
+}
+
+// the position of FIELDS after the key and the options of HGETEX and HSETEX.
+func hashFieldsIndex(args []Value) int {
+	for i := 1; i < len(args); i++ {
+		if strings.ToLower(args[i].Bulk) == "fields" {
+			return i
+		}
+	}
+	return len(args)
+}
+
+// builds "name key options... FIELDS numfields fields...", the fields
+// are followed by their values for HSETEX.
+func hashFieldsCommand(name, key string, options []string, numFields int, fields []string) Value {
+	args := append([]string{name, key}, options...)
+	args = append(args, "FIELDS", strconv.Itoa(numFields))
+	return command(append(args, fields...)...)
+}
+
+// the commands writing in the AOF what a command did to the time to live of
+// fields: the ones given the unix time when and the ones deleted or persisted.
+func hashExpireCommands(key string, when int64, expiring, deleted, persisted []string) []Value {
+	var cmds []Value
+	if len(expiring) > 0 {
+		cmds = append(cmds, hashFieldsCommand("hpexpireat", key, []string{strconv.FormatInt(when, 10)}, len(expiring), expiring))
+	}
+	if len(deleted) > 0 {
+		cmds = append(cmds, command(append([]string{"hdel", key}, deleted...)...))
+	}
+	if len(persisted) > 0 {
+		cmds = append(cmds, hashFieldsCommand("hpersist", key, nil, len(persisted), persisted))
+	}
+	return cmds
+}
+
+// doc: https://redis.io/docs/latest/commands/hexpire/
+func hexpire(ctx context.Context, args []Value) Value {
+	return hexpireGeneric(ctx, "hexpire", args, 1000, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/hpexpire/
+func hpexpire(ctx context.Context, args []Value) Value {
+	return hexpireGeneric(ctx, "hpexpire", args, 1, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/hexpireat/
+func hexpireat(ctx context.Context, args []Value) Value {
+	return hexpireGeneric(ctx, "hexpireat", args, 1000, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/hpexpireat/
+func hpexpireat(ctx context.Context, args []Value) Value {
+	return hexpireGeneric(ctx, "hpexpireat", args, 1, false)
+}
+
+// sets the time to live of fields like expireGeneric does for keys. Every
+// field gets a reply: -2 when it does not exist, 0 when the condition does
+// not hold, 1 when the time to live is set and 2 when the field is deleted
+// because the time is in the past.
+func hexpireGeneric(ctx context.Context, name string, args []Value, unit int64, relative bool) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs(name)
+	}
+
+	key := args[0].Bulk
+
+	when, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+	if when < 0 {
+		return invalidExpireTime(name)
+	}
+
+	flags := 0
+	switch strings.ToLower(args[2].Bulk) {
+	case "nx":
+		flags = expireNX
+	case "xx":
+		flags = expireXX
+	case "gt":
+		flags = expireGT
+	case "lt":
+		flags = expireLT
+	}
+
+	rest := args[2:]
+	if flags != 0 {
+		rest = rest[1:]
+	}
+
+	fields, err := parseHashFields(rest, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	when, err = absoluteExpireTime(when, unit, relative)
+	if err != nil {
+		return invalidExpireTime(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	h, err := db.writableHash(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	now := db.now()
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	var expiring, deleted []string
+	for _, field := range fields {
+		result := 1
+
+		if _, ok := hashGet(h, field.Bulk, now); !ok {
+			result = -2
+		} else if current, hasTTL := h.ttl(field.Bulk); !expireConditionHolds(flags, current, hasTTL, when) {
+			result = 0
+		} else if when <= now {
+			h.delete(field.Bulk)
+			deleted = append(deleted, field.Bulk)
+			result = 2
+		} else {
+			db.expireHashField(key, h, field.Bulk, when)
+			expiring = append(expiring, field.Bulk)
+		}
+
+		reply.Array = append(reply.Array, Value{Typ: "integer", Num: result})
+	}
+
+	// redis never keeps empty hashes.
+	if h != nil && h.dict.len() == 0 {
+		db.deleteKey(key)
+	}
+
+	// the AOF always gets the unix time so replaying it later
+	// does not give the fields a new lease of life.
+	propagateAs(ctx, hashExpireCommands(key, when, expiring, deleted, nil)...)
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/httl/
+func httl(ctx context.Context, args []Value) Value {
+	return httlGeneric(ctx, "httl", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/hpttl/
+func hpttl(ctx context.Context, args []Value) Value {
+	return httlGeneric(ctx, "hpttl", args, true)
+}
+
+// replies with the time to live of every field, -1 when it has
+// none and -2 when the field does not exist.
+func httlGeneric(ctx context.Context, name string, args []Value, milliseconds bool) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs(name)
+	}
+
+	fields, err := parseHashFields(args[1:], false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	h, err := db.lookupHash(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	now := db.now()
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, field := range fields {
+		if _, ok := hashGet(h, field.Bulk, now); !ok {
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: -2})
+			continue
+		}
+
+		when, ok := h.ttl(field.Bulk)
+		if !ok {
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: -1})
+			continue
+		}
+
+		when = max(when-mstime(), 0)
+		if !milliseconds {
+			when = (when + 500) / 1000
+		}
+		reply.Array = append(reply.Array, Value{Typ: "integer", Num: int(when)})
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hpersist/
+func hpersist(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("hpersist")
+	}
+
+	fields, err := parseHashFields(args[1:], false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	h, err := db.writableHash(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	now := db.now()
+	reply := Value{Typ: "array", Array: []Value{}}
+	var persisted []string
+	for _, field := range fields {
+		switch _, ok := hashGet(h, field.Bulk, now); {
+		case !ok:
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: -2})
+		case !h.persist(field.Bulk):
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: -1})
+		default:
+			persisted = append(persisted, field.Bulk)
+			reply.Array = append(reply.Array, Value{Typ: "integer", Num: 1})
+		}
+	}
+
+	propagateAs(ctx, hashExpireCommands(key, 0, nil, nil, persisted)...)
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hgetex/
+func hgetex(ctx context.Context, args []Value) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("hgetex")
+	}
+
+	i := hashFieldsIndex(args)
+	flags, expireAt, err := parseStringOptions("hgetex", args[1:i])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	fields, err := parseHashFields(args[i:], false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	h, err := db.writableHash(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	now := db.now()
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	var expiring, deleted, persisted []string
+	for _, field := range fields {
+		value, ok := hashGet(h, field.Bulk, now)
+		reply.Array = append(reply.Array, bulkOrNull(value, ok))
+		if !ok {
+			continue
+		}
+
+		switch {
+		case flags&setExpire != 0 && expireAt <= now:
+			h.delete(field.Bulk)
+			deleted = append(deleted, field.Bulk)
+		case flags&setExpire != 0:
+			db.expireHashField(key, h, field.Bulk, expireAt)
+			expiring = append(expiring, field.Bulk)
+		case flags&setPersist != 0 && h.persist(field.Bulk):
+			persisted = append(persisted, field.Bulk)
+		}
+	}
+
+	if h != nil && h.dict.len() == 0 {
+		db.deleteKey(key)
+	}
+
+	// without options it is a plain HMGET, nothing is written.
+	propagateAs(ctx, hashExpireCommands(key, expireAt, expiring, deleted, persisted)...)
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/hsetex/
+func hsetex(ctx context.Context, args []Value) Value {
+	if len(args) < 5 {
+		return wrongNumberOfArgs("hsetex")
+	}
+
+	i := hashFieldsIndex(args)
+	flags, expireAt, err := parseStringOptions("hsetex", args[1:i])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	pairs, err := parseHashFields(args[i:], true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	now := db.now()
+
+	// with FNX none of the fields may exist, with FXX all of them must.
+	if flags&(setNX|setXX) != 0 {
+		h, err := db.writableHash(key, false)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		for j := 0; j < len(pairs); j += 2 {
+			_, ok := hashGet(h, pairs[j].Bulk, now)
+			if flags&setNX != 0 && ok || flags&setXX != 0 && !ok {
+				propagateAs(ctx)
+				return Value{Typ: "integer", Num: 0}
+			}
+		}
+	}
+
+	fields := []string{}
+	for j := 0; j < len(pairs); j += 2 {
+		fields = append(fields, pairs[j].Bulk)
+	}
+
+	// fields set with a time in the past are deleted right away.
+	if flags&setExpire != 0 && expireAt <= now {
+		deleted := []string{}
+		h, err := db.writableHash(key, false)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if h != nil {
+			for _, field := range fields {
+				if h.delete(field) {
+					deleted = append(deleted, field)
+				}
+			}
+			if h.dict.len() == 0 {
+				db.deleteKey(key)
+			}
+		}
+
+		propagateAs(ctx, hashExpireCommands(key, 0, nil, deleted, nil)...)
+		return Value{Typ: "integer", Num: 1}
+	}
+
+	h, err := db.writableHash(key, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	for j := 0; j < len(pairs); j += 2 {
+		field, value := pairs[j].Bulk, pairs[j+1].Bulk
+
+		switch {
+		case flags&setKeepTTL != 0:
+			h.update(field, value)
+		case flags&setExpire != 0:
+			h.set(field, value)
+			db.expireHashField(key, h, field, expireAt)
+		default:
+			h.set(field, value)
+		}
+	}
+
+	if flags&setExpire != 0 {
+		// restarting later must not make the fields live longer.
+		values := []string{}
+		for _, pair := range pairs {
+			values = append(values, pair.Bulk)
+		}
+		propagateAs(ctx, hashFieldsCommand("hsetex", key, []string{"PXAT", strconv.FormatInt(expireAt, 10)}, len(fields), values))
+	}
+
+	return Value{Typ: "integer", Num: 1}
+}
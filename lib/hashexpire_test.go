@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the integers of an array reply.
+func integers(v Value) []int {
+	result := []int{}
+	for _, element := range v.Array {
+		result = append(result, element.Num)
+	}
+	return result
+}
+
+func TestHashFieldExpiration(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It sets, reads and removes the time to live of fields", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "a", "1", "b", "2", "c", "3")
+
+		assert.Equal(t, []int{1, -2}, integers(hexpire(ctx, command(key, "100", "FIELDS", "2", "a", "missing").Array)))
+		assert.Equal(t, []int{0, 1}, integers(hexpire(ctx, command(key, "200", "NX", "FIELDS", "2", "a", "b").Array)))
+		assert.Equal(t, []int{0}, integers(hexpire(ctx, command(key, "50", "GT", "FIELDS", "1", "a").Array)))
+
+		assert.Equal(t, []int{100, 200, -1, -2}, integers(httl(ctx, command(key, "FIELDS", "4", "a", "b", "c", "missing").Array)))
+		assert.InDelta(t, 100000, hpttl(ctx, command(key, "FIELDS", "1", "a").Array).Array[0].Num, 1000)
+
+		assert.Equal(t, []int{1, -1, -2}, integers(hpersist(ctx, command(key, "FIELDS", "3", "a", "c", "missing").Array)))
+		assert.Equal(t, []int{-1}, integers(httl(ctx, command(key, "FIELDS", "1", "a").Array)))
+
+		// a time in the past deletes the field.
+		assert.Equal(t, []int{2}, integers(hexpireat(ctx, command(key, "1", "FIELDS", "1", "c").Array)))
+		assert.Equal(t, 2, hlen(ctx, command(key).Array).Num)
+
+		// overwriting a field clears its time to live, incrementing it does not.
+		hpexpire(ctx, command(key, "100000", "FIELDS", "1", "a").Array)
+		hset(ctx, command(key, "b", "x").Array)
+		hincrby(ctx, command(key, "a", "1").Array)
+		assert.Equal(t, []int{100, -1}, integers(httl(ctx, command(key, "FIELDS", "2", "a", "b").Array)))
+
+		assert.Equal(t, []int{-2}, integers(httl(ctx, command("hash-missing", "FIELDS", "1", "a").Array)))
+		assert.Equal(t, errHashFieldsMissing.Error(), hexpire(ctx, command(key, "100", "a", "b").Array).Str)
+		assert.Equal(t, "ERR The `numfields` parameter must match the number of arguments", httl(ctx, command(key, "FIELDS", "2", "a").Array).Str)
+		assert.Equal(t, "ERR invalid expire time in 'hexpire' command", hexpire(ctx, command(key, "-1", "FIELDS", "1", "a").Array).Str)
+	})
+
+	t.Run("It skips expired fields and deletes the hash with its last field", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "a", "1", "b", "2")
+		hpexpire(ctx, command(key, "1", "FIELDS", "1", "a").Array)
+
+		time.Sleep(5 * time.Millisecond)
+
+		assert.Equal(t, "null", hget(ctx, command(key, "a").Array).Typ)
+		assert.Equal(t, 1, hlen(ctx, command(key).Array).Num)
+		assert.Equal(t, []string{"b", "2"}, bulks(hgetall(ctx, command(key).Array)))
+		assert.Equal(t, 0, hexists(ctx, command(key, "a").Array).Num)
+
+		// the expired field is new again.
+		assert.Equal(t, 1, hset(ctx, command(key, "a", "3").Array).Num)
+
+		hpexpire(ctx, command(key, "1", "FIELDS", "2", "a", "b").Array)
+		time.Sleep(5 * time.Millisecond)
+
+		assert.Equal(t, -2, ttl(ctx, command(key).Array).Num)
+		assert.Empty(t, hgetall(ctx, command(key).Array).Array)
+	})
+
+	t.Run("It counts the fields left without walking their time to live", func(t *testing.T) {
+		h := newHash()
+		now := mstime()
+		for i := 0; i < 1000; i++ {
+			h.set(strconv.Itoa(i), "v")
+			h.expire(strconv.Itoa(i), now+int64(i%2)*100000-50000)
+		}
+		assert.Equal(t, 500, h.len(now))
+		assert.False(t, h.empty(now))
+
+		// updating the time to live moves the field in the order.
+		h.expire("1", now-1)
+		h.expire("0", now+100000)
+		assert.Equal(t, 500, h.len(now))
+		h.persist("3")
+		h.delete("5")
+		assert.Equal(t, 499, h.len(now))
+
+		assert.Equal(t, 500, h.deleteExpired(now))
+		assert.Equal(t, 499, h.dict.len())
+		assert.Equal(t, 498, len(h.expires))
+
+		h.delete("3")
+		for field := range h.expires {
+			h.expire(field, now-1)
+		}
+		assert.True(t, h.empty(now))
+		assert.Equal(t, 0, h.len(now))
+
+		// a random field is looked for only while one is left.
+		_, _, ok := h.random(now)
+		assert.False(t, ok)
+	})
+
+	t.Run("It deletes expired fields in the background", func(t *testing.T) {
+		for i := 0; i < 50; i++ {
+			key := "hash-active-" + strconv.Itoa(i)
+			hset(ctx, command(key, "a", "1", "b", "2").Array)
+			hpexpire(ctx, command(key, "1", "FIELDS", "1", "a").Array)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		assert.Eventually(t, func() bool {
+			KvStore.activeExpireHashFields(time.Second)
+
+			unlock := lockDatabases(context.Background(), KvStore)
+			defer unlock()
+			for i := 0; i < 50; i++ {
+				if storedValue[*hash]("hash-active-"+strconv.Itoa(i)).dict.len() != 1 {
+					return false
+				}
+			}
+			return true
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("It gets and sets fields along with their time to live", func(t *testing.T) {
+		key := newTestKey(t, "HSET", "a", "1")
+
+		result := hgetex(ctx, command(key, "EX", "100", "FIELDS", "2", "a", "missing").Array)
+		assert.Equal(t, "1", result.Array[0].Bulk)
+		assert.Equal(t, "null", result.Array[1].Typ)
+		assert.Equal(t, []int{100}, integers(httl(ctx, command(key, "FIELDS", "1", "a").Array)))
+
+		hgetex(ctx, command(key, "PERSIST", "FIELDS", "1", "a").Array)
+		assert.Equal(t, []int{-1}, integers(httl(ctx, command(key, "FIELDS", "1", "a").Array)))
+
+		assert.Equal(t, 0, hsetex(ctx, command(key, "FNX", "EX", "50", "FIELDS", "2", "a", "x", "b", "y").Array).Num)
+		assert.Equal(t, 0, hsetex(ctx, command(key, "FXX", "FIELDS", "2", "a", "x", "b", "y").Array).Num)
+		assert.Equal(t, 1, hsetex(ctx, command(key, "FNX", "EX", "50", "FIELDS", "2", "b", "y", "c", "z").Array).Num)
+		assert.Equal(t, []int{-1, 50, 50}, integers(httl(ctx, command(key, "FIELDS", "3", "a", "b", "c").Array)))
+
+		assert.Equal(t, 1, hsetex(ctx, command(key, "KEEPTTL", "FIELDS", "1", "b", "w").Array).Num)
+		assert.Equal(t, 1, hsetex(ctx, command(key, "FIELDS", "1", "c", "v").Array).Num)
+		assert.Equal(t, []int{50, -1}, integers(httl(ctx, command(key, "FIELDS", "2", "b", "c").Array)))
+		assert.Equal(t, []string{"w", "v"}, bulks(hmget(ctx, command(key, "b", "c").Array)))
+
+		assert.Equal(t, errSyntax.Error(), hsetex(ctx, command(key, "NX", "FIELDS", "1", "a", "x").Array).Str)
+		assert.Equal(t, "ERR Number of fields must be a positive integer", hgetex(ctx, command(key, "FIELDS", "0", "a").Array).Str)
+	})
+
+	t.Run("It writes unix times in the AOF so expired fields stay expired after a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hash.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c := testClient(t, server)
+		key := newTestKey(t)
+
+		server.handleCommandExecution(c, command("HSET", key, "a", "1", "b", "2", "c", "3", "d", "4"))
+		server.handleCommandExecution(c, command("HEXPIRE", key, "100", "FIELDS", "1", "a"))
+		server.handleCommandExecution(c, command("HSETEX", key, "PX", "1", "FIELDS", "1", "b", "5"))
+		server.handleCommandExecution(c, command("HGETEX", key, "PX", "200000", "FIELDS", "1", "c"))
+		server.handleCommandExecution(c, command("HPEXPIRE", key, "300000", "FIELDS", "1", "d"))
+		server.handleCommandExecution(c, command("HPERSIST", key, "FIELDS", "1", "d"))
+		assert.Nil(t, aof.Close())
+
+		content, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Contains(t, string(content), "hpexpireat")
+		assert.Contains(t, string(content), "PXAT")
+
+		time.Sleep(5 * time.Millisecond)
+		before := httl(ctx, command(key, "FIELDS", "4", "a", "b", "c", "d").Array)
+
+		unlock := KvStore.lock(context.Background(), key)
+		KvStore.deleteKey(key)
+		unlock()
+
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+
+		assert.Equal(t, []int{100, -2, 200, -1}, integers(before))
+		assert.Equal(t, integers(before), integers(httl(ctx, command(key, "FIELDS", "4", "a", "b", "c", "d").Array)))
+	})
+
+	t.Run("It replays the AOF without expiring the fields of the commands it holds", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hashexpire.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("SELECT", "14"))
+
+		server.handleCommandExecution(c, command("HSET", "replayed-hash", "f", "1", "g", "2"))
+		server.handleCommandExecution(c, command("HPEXPIRE", "replayed-hash", "50", "FIELDS", "1", "f"))
+		server.handleCommandExecution(c, command("HINCRBY", "replayed-hash", "f", "5"))
+		assert.Nil(t, aof.Close())
+		time.Sleep(60 * time.Millisecond)
+
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+		c = testClient(t, restarted)
+		restarted.handleCommandExecution(c, command("SELECT", "14"))
+
+		assert.Equal(t, "null", restarted.handleCommandExecution(c, command("HGET", "replayed-hash", "f")).Typ)
+		assert.Equal(t, []int{-2}, integers(restarted.handleCommandExecution(c, command("HTTL", "replayed-hash", "FIELDS", "1", "f"))))
+		assert.Equal(t, "2", restarted.handleCommandExecution(c, command("HGET", "replayed-hash", "g")).Bulk)
+	})
+}
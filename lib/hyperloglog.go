@@ -0,0 +1,473 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are strings laid out like the ones of redis so they can be
+// moved between both with GET and SET. Port of
+// https://github.com/redis/redis/blob/unstable/src/hyperloglog.c
+//
+// A 16 bytes header: the "HYLL" magic, the encoding, 3 unused bytes and
+// the cached cardinality as a little endian integer, its most significant
+// bit set when the registers changed since it was computed. It is followed
+// by the 2^14 registers of 6 bits, either dense, packed from the least
+// significant bits of the bytes, or sparse as a sequence of opcodes:
+//
+//	ZERO  00xxxxxx          xxxxxx+1 registers set to 0, up to 64.
+//	XZERO 01xxxxxx yyyyyyyy xxxxxxyyyyyyyy+1 registers set to 0, up to 16384.
+//	VAL   1vvvvvxx          xx+1 registers set to vvvvv+1, up to 4 registers up to 32.
+//
+// A new HLL is sparse, it becomes dense when a register exceeds 32 or
+// the string grows past hll-sparse-max-bytes.
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+
+	defaultHLLSparseMaxBytes = 3000 // hll-sparse-max-bytes
+
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var (
+	errHLLWrongType = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errHLLCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// the 64 bits MurmurHash2 of redis, reading the words in little endian
+// whatever the machine.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// the register of element and the length of the run of zeros ending its
+// hash plus one, the value the register is raised to.
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+
+	// the bit past the Q bits left ends the run when they are all 0.
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+func denseRegister(registers []byte, i int) uint8 {
+	n := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+
+	v := uint(registers[n]) >> fb
+	if n+1 < len(registers) {
+		v |= uint(registers[n+1]) << (8 - fb)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+func setDenseRegister(registers []byte, i int, value uint8) {
+	n := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+
+	registers[n] &^= hllRegisterMax << fb
+	registers[n] |= value << fb
+	if n+1 < len(registers) {
+		registers[n+1] &^= hllRegisterMax >> (8 - fb)
+		registers[n+1] |= value >> (8 - fb)
+	}
+}
+
+// whether buf holds a HyperLogLog. Like redis, sparse registers are
+// only checked when they are read.
+func isHLL(buf []byte) bool {
+	if len(buf) < hllHeaderSize || string(buf[:4]) != "HYLL" {
+		return false
+	}
+
+	switch buf[4] {
+	case hllDense:
+		return len(buf) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// decodes the registers of the HLL in buf into one byte each.
+func hllDecode(buf []byte) ([]uint8, error) {
+	registers := make([]uint8, hllRegisters)
+	if buf[4] == hllDense {
+		for i := range registers {
+			registers[i] = denseRegister(buf[hllHeaderSize:], i)
+		}
+		return registers, nil
+	}
+
+	i := 0
+	for p := hllHeaderSize; p < len(buf); p++ {
+		op := buf[p]
+		switch {
+		case op&0xc0 == 0x00:
+			i += int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 == len(buf) {
+				return nil, errHLLCorrupted
+			}
+			p++
+			i += int(op&0x3f)<<8 | int(buf[p]) + 1
+		default:
+			n := int(op&0x3) + 1
+			if i+n > hllRegisters {
+				return nil, errHLLCorrupted
+			}
+			for end := i + n; i < end; i++ {
+				registers[i] = (op>>2)&0x1f + 1
+			}
+		}
+
+		if i > hllRegisters {
+			return nil, errHLLCorrupted
+		}
+	}
+
+	if i != hllRegisters {
+		return nil, errHLLCorrupted
+	}
+	return registers, nil
+}
+
+// encodes registers as a HLL whose cardinality is not computed yet,
+// sparse when asked and they fit in maxSparse bytes, dense otherwise.
+func hllEncode(registers []uint8, sparse bool, maxSparse int64) []byte {
+	buf := make([]byte, hllHeaderSize, hllDenseSize)
+	copy(buf, "HYLL")
+	buf[15] = 0x80
+
+	if sparse {
+		for i := 0; i < hllRegisters && len(buf) <= int(maxSparse); {
+			value := registers[i]
+			if value > hllSparseValMaxValue {
+				break
+			}
+
+			run := 1
+			for i+run < hllRegisters && registers[i+run] == value {
+				run++
+			}
+			i += run
+
+			for run > 0 {
+				switch {
+				case value != 0:
+					n := min(run, hllSparseValMaxLen)
+					buf = append(buf, 0x80|(value-1)<<2|byte(n-1))
+					run -= n
+				case run > hllSparseZeroMaxLen:
+					n := min(run, hllSparseXZeroMaxLen)
+					buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+					run -= n
+				default:
+					buf = append(buf, byte(run-1))
+					run = 0
+				}
+			}
+
+			if i == hllRegisters && len(buf) <= int(maxSparse) {
+				buf[4] = hllSparse
+				return buf
+			}
+		}
+	}
+
+	buf = buf[:hllDenseSize]
+	buf[4] = hllDense
+	for i, value := range registers {
+		setDenseRegister(buf[hllHeaderSize:], i, value)
+	}
+	return buf
+}
+
+// an empty HLL, sparse with its cardinality of 0 computed.
+func newHLL() []byte {
+	buf := hllEncode(make([]uint8, hllRegisters), true, hllDenseSize)
+	buf[15] = 0
+	return buf
+}
+
+// estimates the cardinality of registers with the estimator of Otmar Ertl
+// redis uses, see "New cardinality estimation algorithms for HyperLogLog
+// sketches": https://arxiv.org/abs/1702.01284
+func hllCount(registers []uint8) uint64 {
+	m := float64(hllRegisters)
+
+	var histogram [hllRegisterMax + 1]int
+	for _, value := range registers {
+		histogram[value]++
+	}
+
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+		if z == previous {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if z == previous {
+			return z / 3
+		}
+	}
+}
+
+// the hll-sparse-max-bytes of the server of the client.
+func hllSparseMaxBytes(ctx context.Context) int64 {
+	if c := clientFromContext(ctx); c != nil {
+		return c.srv.config.GetInt("hll-sparse-max-bytes")
+	}
+	return defaultHLLSparseMaxBytes
+}
+
+// the HLL stored at key for commands changing it, nil when the key does
+// not exist. The caller stores it back. The store must be locked for writing.
+func (s *SimpleStore) writableHLL(key string) ([]byte, error) {
+	s.expireIfNeeded(key)
+
+	buf, ok, err := s.lookupBytes(key)
+	if !ok {
+		return nil, err
+	}
+
+	if !isHLL(buf) {
+		return nil, errHLLWrongType
+	}
+	return buf, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/pfadd/
+func pfadd(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfadd")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	buf, err := db.writableHLL(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	created := buf == nil
+	if created {
+		buf = newHLL()
+	}
+
+	updated := false
+	if buf[4] == hllDense {
+		for _, element := range args[1:] {
+			i, count := hllPatLen(element.Bulk)
+			if count > denseRegister(buf[hllHeaderSize:], i) {
+				setDenseRegister(buf[hllHeaderSize:], i, count)
+				updated = true
+			}
+		}
+	} else {
+		// the sparse registers are decoded once for all the elements,
+		// the ones left are encoded again when one changed.
+		registers, err := hllDecode(buf)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		for _, element := range args[1:] {
+			i, count := hllPatLen(element.Bulk)
+			if count > registers[i] {
+				registers[i] = count
+				updated = true
+			}
+		}
+
+		if updated {
+			buf = hllEncode(registers, true, hllSparseMaxBytes(ctx))
+		}
+	}
+
+	if updated {
+		buf[15] |= 0x80
+	}
+
+	if updated || created {
+		db.setString(key, stringValue{buf: buf})
+		return Value{Typ: "integer", Num: 1}
+	}
+	return Value{Typ: "integer", Num: 0}
+}
+
+// doc: https://redis.io/docs/latest/commands/pfcount/
+func pfcount(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfcount")
+	}
+
+	db := clientDB(ctx)
+
+	// the write lock lets a single key keep the cardinality it computes.
+	unlock := db.lock(ctx, keysOf(args)...)
+	defer unlock()
+
+	if len(args) == 1 {
+		buf, err := db.writableHLL(args[0].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if buf == nil {
+			return Value{Typ: "integer", Num: 0}
+		}
+
+		if buf[15]&0x80 == 0 {
+			return Value{Typ: "integer", Num: int(binary.LittleEndian.Uint64(buf[8:]))}
+		}
+
+		registers, err := hllDecode(buf)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		card := hllCount(registers)
+		binary.LittleEndian.PutUint64(buf[8:], card)
+		return Value{Typ: "integer", Num: int(card)}
+	}
+
+	// the cardinality of the union, estimated from the largest of the
+	// registers of every HLL.
+	union, _, err := db.unionHLL(args)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	return Value{Typ: "integer", Num: int(hllCount(union))}
+}
+
+// the registers of the union of the HLLs stored at keys, and whether one
+// of them is dense. Missing keys are empty HLLs. The store must be locked.
+func (s *SimpleStore) unionHLL(keys []Value) ([]uint8, bool, error) {
+	union := make([]uint8, hllRegisters)
+	dense := false
+
+	for _, key := range keys {
+		buf, ok, err := s.lookupBytes(key.Bulk)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			continue
+		}
+		if !isHLL(buf) {
+			return nil, false, errHLLWrongType
+		}
+
+		registers, err := hllDecode(buf)
+		if err != nil {
+			return nil, false, err
+		}
+
+		dense = dense || buf[4] == hllDense
+		for i, value := range registers {
+			union[i] = max(union[i], value)
+		}
+	}
+	return union, dense, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/pfmerge/
+func pfmerge(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfmerge")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, keysOf(args)...)
+	defer unlock()
+
+	// the destination is merged with the sources, it stays sparse
+	// unless one of them is dense.
+	union, dense, err := db.unionHLL(args)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db.expireIfNeeded(args[0].Bulk)
+	db.setString(args[0].Bulk, stringValue{buf: hllEncode(union, !dense, hllSparseMaxBytes(ctx))})
+	return Value{Typ: "string", Str: "OK"}
+}
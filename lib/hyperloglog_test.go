@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the elements "prefix0" to "prefix<n-1>".
+func elements(prefix string, n int) []string {
+	result := []string{}
+	for i := 0; i < n; i++ {
+		result = append(result, prefix+strconv.Itoa(i))
+	}
+	return result
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	t.Run("It lays out empty HLLs like redis", func(t *testing.T) {
+		assert.Equal(t, "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff", string(newHLL()))
+	})
+
+	t.Run("It encodes the same registers sparse and dense", func(t *testing.T) {
+		registers := make([]uint8, hllRegisters)
+		registers[0], registers[1], registers[2] = 3, 3, 1
+		registers[100] = 32
+		registers[hllRegisters-1] = 7
+
+		sparse := hllEncode(registers, true, defaultHLLSparseMaxBytes)
+		assert.Equal(t, byte(hllSparse), sparse[4])
+		assert.Equal(t, []byte{0x89, 0x80, 0x40, 0x60, 0xfc, 0x7f, 0x99, 0x98}, sparse[hllHeaderSize:])
+
+		dense := hllEncode(registers, false, defaultHLLSparseMaxBytes)
+		assert.Equal(t, byte(hllDense), dense[4])
+		assert.Len(t, dense, hllDenseSize)
+
+		for _, buf := range [][]byte{sparse, dense} {
+			decoded, err := hllDecode(buf)
+			assert.Nil(t, err)
+			assert.Equal(t, registers, decoded)
+		}
+
+		// registers past 32 do not fit in sparse HLLs, nor HLLs longer than allowed.
+		registers[5] = 33
+		assert.Equal(t, byte(hllDense), hllEncode(registers, true, defaultHLLSparseMaxBytes)[4])
+		registers[5] = 0
+		assert.Equal(t, byte(hllDense), hllEncode(registers, true, 20)[4])
+	})
+
+	t.Run("It detects corrupted sparse HLLs", func(t *testing.T) {
+		buf := newHLL()
+		_, err := hllDecode(buf[:len(buf)-1])
+		assert.Equal(t, errHLLCorrupted, err)
+
+		_, err = hllDecode(append(newHLL(), 0x00))
+		assert.Equal(t, errHLLCorrupted, err)
+	})
+}
+
+func TestHyperLogLogCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It adds elements and estimates their number", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 1, pfadd(ctx, command(key).Array).Num)
+		assert.Equal(t, 0, pfadd(ctx, command(key).Array).Num)
+		assert.Equal(t, 0, pfcount(ctx, command(key).Array).Num)
+
+		assert.Equal(t, 1, pfadd(ctx, command(key, "a", "b", "c").Array).Num)
+		assert.Equal(t, 0, pfadd(ctx, command(key, "a", "b").Array).Num)
+		assert.Equal(t, 3, pfcount(ctx, command(key).Array).Num)
+		assert.Equal(t, byte(hllSparse), storedValue[stringValue](key).buf[4])
+
+		// the sparse HLL becomes dense as it grows.
+		for _, batch := range [][]string{elements("x", 5000), elements("y", 5000)} {
+			pfadd(ctx, command(append([]string{key}, batch...)...).Array)
+		}
+		assert.Equal(t, byte(hllDense), storedValue[stringValue](key).buf[4])
+		assert.InEpsilon(t, 10003, pfcount(ctx, command(key).Array).Num, 0.02)
+
+		assert.Equal(t, 0, pfcount(ctx, command("string-missing").Array).Num)
+	})
+
+	t.Run("It caches the cardinality until the registers change", func(t *testing.T) {
+		key := newTestKey(t)
+		pfadd(ctx, command(key, "a", "b").Array)
+		assert.Equal(t, byte(0x80), storedValue[stringValue](key).buf[15]&0x80)
+
+		assert.Equal(t, 2, pfcount(ctx, command(key).Array).Num)
+		assert.Equal(t, byte(0), storedValue[stringValue](key).buf[15]&0x80)
+		assert.Equal(t, byte(2), storedValue[stringValue](key).buf[8])
+
+		pfadd(ctx, command(key, "c").Array)
+		assert.Equal(t, 3, pfcount(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It counts and merges unions", func(t *testing.T) {
+		a, b, dest := newTestKey(t), newTestKey(t), newTestKey(t)
+		pfadd(ctx, command(append([]string{a}, elements("a", 300)...)...).Array)
+		pfadd(ctx, command(append([]string{b}, elements("a", 200)...)...).Array)
+		pfadd(ctx, command(append([]string{b}, elements("b", 200)...)...).Array)
+
+		assert.InEpsilon(t, 500, pfcount(ctx, command(a, b, "string-missing").Array).Num, 0.02)
+
+		assert.Equal(t, "OK", pfmerge(ctx, command(dest, a, b).Array).Str)
+		assert.Equal(t, pfcount(ctx, command(a, b).Array).Num, pfcount(ctx, command(dest).Array).Num)
+		assert.Equal(t, byte(hllSparse), storedValue[stringValue](dest).buf[4])
+
+		// the destination is one of the sources.
+		pfadd(ctx, command(append([]string{a}, elements("c", 5000)...)...).Array)
+		pfmerge(ctx, command(dest, a).Array)
+		assert.Equal(t, byte(hllDense), storedValue[stringValue](dest).buf[4])
+		assert.InEpsilon(t, 5500, pfcount(ctx, command(dest).Array).Num, 0.02)
+
+		empty := newTestKey(t)
+		assert.Equal(t, "OK", pfmerge(ctx, command(empty).Array).Str)
+		assert.Equal(t, 0, pfcount(ctx, command(empty).Array).Num)
+	})
+
+	t.Run("It refuses strings that are not HLLs", func(t *testing.T) {
+		key := newTestKey(t, "SET", "hello")
+		wrongType := "WRONGTYPE Key is not a valid HyperLogLog string value."
+
+		assert.Equal(t, wrongType, pfadd(ctx, command(key, "a").Array).Str)
+		assert.Equal(t, wrongType, pfcount(ctx, command(key).Array).Str)
+		assert.Equal(t, wrongType, pfmerge(ctx, command(newTestKey(t), key).Array).Str)
+
+		corrupted := newTestKey(t, "SET", "HYLL\x01\x00\x00\x00\x00\x00\x00\x80\x00\x00\x00\x80\x7f")
+		assert.Equal(t, errHLLCorrupted.Error(), pfcount(ctx, command(corrupted).Array).Str)
+	})
+
+	t.Run("It reads HLLs copied with GET and SET", func(t *testing.T) {
+		key, copied := newTestKey(t), newTestKey(t)
+		pfadd(ctx, command(append([]string{key}, elements("e", 100)...)...).Array)
+
+		set(ctx, command(copied, get(ctx, command(key).Array).Bulk).Array)
+		assert.Equal(t, pfcount(ctx, command(key).Array).Num, pfcount(ctx, command(copied).Array).Num)
+	})
+}
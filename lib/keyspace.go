@@ -0,0 +1,492 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoSuchKey         = errors.New("ERR no such key")
+	errDBIndexOutOfRange = errors.New("ERR DB index is out of range")
+	errSameObject        = errors.New("ERR source and destination objects are the same")
+)
+
+// the database at the index arg among dbs.
+func parseDBIndex(arg string, dbs []*SimpleStore) (*SimpleStore, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if index < 0 || index >= len(dbs) {
+		return nil, errDBIndexOutOfRange
+	}
+	return dbs[index], nil
+}
+
+// the type of the value stored at key, "none" when there is none.
+// The store must be locked.
+func (s *SimpleStore) keyType(key string) string {
+	o := s.peek(key)
+	if o == nil {
+		return "none"
+	}
+	return o.typ.String()
+}
+
+// stores the object at key, which must not exist, along with its time to
+// live. volatile tells whether it is a hash with fields that have a time
+// to live. The store must be locked for writing.
+func (s *SimpleStore) setObject(key string, o *object, volatile bool) {
+	sh := s.shard(key)
+	sh.keys.set(key, o)
+
+	if o.expires != 0 {
+		sh.expires[key] = o
+	}
+	if volatile {
+		sh.volatileHashes[key] = true
+	}
+}
+
+// moves the object of src along with its time to live to dst, replacing
+// what dst held. The store must be locked for writing.
+func (s *SimpleStore) renameKey(src, dst string) {
+	o, _ := s.object(src)
+	volatile := s.shard(src).volatileHashes[src]
+
+	s.deleteKey(src)
+	s.deleteKey(dst)
+	s.setObject(dst, o, volatile)
+}
+
+// copies the value of src along with its time to live to dst of the
+// database to, replacing what dst held. The copy shares nothing with the
+// value of src. Both databases must be locked for writing.
+func (s *SimpleStore) copyKey(src string, to *SimpleStore, dst string) {
+	o, _ := s.object(src)
+	value := o.copyValue()
+
+	to.add(dst, o.typ, value)
+	if o.expires != 0 {
+		to.setExpire(dst, o.expires)
+	}
+	if h, ok := value.(*hash); ok && len(h.expires) > 0 {
+		to.shard(dst).volatileHashes[dst] = true
+	}
+}
+
+// moves the object of key along with its time to live to the database to,
+// where the key must not exist. Both databases must be locked for writing.
+func (s *SimpleStore) moveKey(key string, to *SimpleStore) {
+	o, _ := s.object(key)
+	volatile := s.shard(key).volatileHashes[key]
+
+	s.deleteKey(key)
+	to.setObject(key, o, volatile)
+}
+
+// exchanges the keys of the databases, the clients of one see the keys of
+// the other right away. A key belongs to the shard at the same index in
+// every database so the shards swap their keys one by one, they stay
+// where commands that looked them up before locking expect them. Both
+// databases must be locked for writing.
+func (s *SimpleStore) swap(other *SimpleStore) {
+	for i, sh := range s.shards {
+		o := other.shards[i]
+		sh.keys, o.keys = o.keys, sh.keys
+		sh.expires, o.expires = o.expires, sh.expires
+		sh.volatileHashes, o.volatileHashes = o.volatileHashes, sh.volatileHashes
+	}
+}
+
+// removes every key. The store must be locked for writing.
+func (s *SimpleStore) flush() {
+	for _, sh := range s.shards {
+		sh.flush()
+	}
+}
+
+// doc: https://redis.io/docs/latest/commands/del/
+func del(ctx context.Context, args []Value) Value {
+	return delGeneric(ctx, "del", args)
+}
+
+// like DEL, values are small enough to be freed right away.
+// doc: https://redis.io/docs/latest/commands/unlink/
+func unlink(ctx context.Context, args []Value) Value {
+	return delGeneric(ctx, "unlink", args)
+}
+
+func delGeneric(ctx context.Context, name string, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, keysOf(args)...)
+	defer unlock()
+
+	deleted := 0
+	for _, key := range args {
+		if db.keyExists(key.Bulk) {
+			deleted++
+		}
+		db.deleteKey(key.Bulk)
+	}
+	return Value{Typ: "integer", Num: deleted}
+}
+
+// doc: https://redis.io/docs/latest/commands/exists/
+func exists(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("exists")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, keysOf(args)...)
+	defer unlock()
+
+	// keys given more than once are counted more than once.
+	count := 0
+	for _, key := range args {
+		if db.keyExists(key.Bulk) {
+			count++
+		}
+	}
+	return Value{Typ: "integer", Num: count}
+}
+
+// doc: https://redis.io/docs/latest/commands/touch/
+func touch(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("touch")
+	}
+	return exists(ctx, args)
+}
+
+// doc: https://redis.io/docs/latest/commands/type/
+func typeCommand(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("type")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	return Value{Typ: "string", Str: db.keyType(args[0].Bulk)}
+}
+
+// doc: https://redis.io/docs/latest/commands/rename/
+func rename(ctx context.Context, args []Value) Value {
+	return renameGeneric(ctx, "rename", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/renamenx/
+func renamenx(ctx context.Context, args []Value) Value {
+	return renameGeneric(ctx, "renamenx", args, true)
+}
+
+func renameGeneric(ctx context.Context, name string, args []Value, nx bool) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	src, dst := args[0].Bulk, args[1].Bulk
+	if !db.keyExists(src) {
+		return Value{Typ: "error", Str: errNoSuchKey.Error()}
+	}
+
+	switch {
+	case src == dst && nx:
+		return Value{Typ: "integer", Num: 0}
+	case src == dst:
+		return Value{Typ: "string", Str: "OK"}
+	case nx && db.keyExists(dst):
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	db.renameKey(src, dst)
+	signalKeyAsReady(ctx, db, dst)
+
+	if nx {
+		return Value{Typ: "integer", Num: 1}
+	}
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/copy/
+func copyCommand(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("copy")
+	}
+
+	db := clientDB(ctx)
+	to := db
+
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(args[i].Bulk); {
+		case option == "replace":
+			replace = true
+		case option == "db" && i+1 < len(args):
+			var err error
+			if to, err = parseDBIndex(args[i+1].Bulk, clientDatabases(ctx)); err != nil {
+				return Value{Typ: "error", Str: err.Error()}
+			}
+			i++
+		default:
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+	}
+
+	src, dst := args[0].Bulk, args[1].Bulk
+	if src == dst && db == to {
+		return Value{Typ: "error", Str: errSameObject.Error()}
+	}
+
+	unlock := lockShards(ctx, true, db.shard(src), to.shard(dst))
+	defer unlock()
+
+	if !db.keyExists(src) || (!replace && to.keyExists(dst)) {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	db.copyKey(src, to, dst)
+	signalKeyAsReady(ctx, to, dst)
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/randomkey/
+func randomkey(ctx context.Context, args []Value) Value {
+	if len(args) != 0 {
+		return wrongNumberOfArgs("randomkey")
+	}
+
+	db := clientDB(ctx)
+	unlock := lockDatabases(ctx, db)
+	defer unlock()
+
+	// the expired keys picked are deleted until one that is not comes up.
+	for {
+		key, ok := db.randomKey()
+		if !ok {
+			return Value{Typ: "null"}
+		}
+		if db.keyExists(key) {
+			return Value{Typ: "bulk", Bulk: key}
+		}
+		db.deleteKey(key)
+	}
+}
+
+// doc: https://redis.io/docs/latest/commands/dbsize/
+func dbsize(ctx context.Context, args []Value) Value {
+	if len(args) != 0 {
+		return wrongNumberOfArgs("dbsize")
+	}
+
+	// the shards are counted one at a time, the size is not a snapshot
+	// of the whole database.
+	size := 0
+	for _, sh := range clientDB(ctx).shards {
+		unlock := lockShards(ctx, false, sh)
+		size += sh.keys.len()
+		unlock()
+	}
+	return Value{Typ: "integer", Num: size}
+}
+
+// doc: https://redis.io/docs/latest/commands/flushdb/
+func flushdb(ctx context.Context, args []Value) Value {
+	return flushGeneric(ctx, "flushdb", args, clientDB(ctx))
+}
+
+// doc: https://redis.io/docs/latest/commands/flushall/
+func flushall(ctx context.Context, args []Value) Value {
+	return flushGeneric(ctx, "flushall", args, clientDatabases(ctx)...)
+}
+
+// the keys of dbs are dropped right away, ASYNC is accepted and does the same as SYNC.
+func flushGeneric(ctx context.Context, name string, args []Value, dbs ...*SimpleStore) Value {
+	if len(args) > 1 {
+		return wrongNumberOfArgs(name)
+	}
+	if len(args) == 1 && !strings.EqualFold(args[0].Bulk, "sync") && !strings.EqualFold(args[0].Bulk, "async") {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	unlock := lockDatabases(ctx, dbs...)
+	defer unlock()
+
+	for _, db := range dbs {
+		db.flush()
+	}
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/select/
+func selectCommand(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("select")
+	}
+
+	c := clientFromContext(ctx)
+	if c == nil {
+		return Value{Typ: "error", Str: "ERR SELECT requires a connection"}
+	}
+
+	db, err := parseDBIndex(args[0].Bulk, c.srv.databases)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	c.db = db.id
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/move/
+func move(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("move")
+	}
+
+	db := clientDB(ctx)
+	to, err := parseDBIndex(args[1].Bulk, clientDatabases(ctx))
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if to == db {
+		return Value{Typ: "error", Str: errSameObject.Error()}
+	}
+
+	key := args[0].Bulk
+	unlock := lockShards(ctx, true, db.shard(key), to.shard(key))
+	defer unlock()
+
+	if !db.keyExists(key) || to.keyExists(key) {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	db.moveKey(key, to)
+	signalKeyAsReady(ctx, to, key)
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/swapdb/
+func swapdb(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("swapdb")
+	}
+
+	dbs := clientDatabases(ctx)
+	first, err := parseDBIndex(args[0].Bulk, dbs)
+	if err == errNotInteger {
+		return Value{Typ: "error", Str: "ERR invalid first DB index"}
+	}
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	second, err := parseDBIndex(args[1].Bulk, dbs)
+	if err == errNotInteger {
+		return Value{Typ: "error", Str: "ERR invalid second DB index"}
+	}
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	unlock := lockDatabases(ctx, first, second)
+	defer unlock()
+
+	first.swap(second)
+
+	// the clients blocked in either database may be served by the keys it got.
+	if c := clientFromContext(ctx); c != nil {
+		c.srv.blocking.mu.Lock()
+		for k := range c.srv.blocking.waiters {
+			if (k.db == first.id || k.db == second.id) && dbs[k.db].keyExists(k.key) {
+				signalKeyAsReady(ctx, dbs[k.db], k.key)
+			}
+		}
+		c.srv.blocking.mu.Unlock()
+	}
+
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/keys/
+func keys(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("keys")
+	}
+
+	db := clientDB(ctx)
+	pattern := args[0].Bulk
+	reply := Value{Typ: "array", Array: []Value{}}
+
+	// the shards are walked one at a time, the commands writing to the
+	// others are not held up for the whole walk.
+	for _, sh := range db.shards {
+		unlock := lockShards(ctx, false, sh)
+		sh.keys.each(func(key string, _ *object) bool {
+			if (pattern == "*" || stringMatch(pattern, key, false)) && db.keyExists(key) {
+				reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: key})
+			}
+			return true
+		})
+		unlock()
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/scan/
+func scan(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("scan")
+	}
+
+	opts, err := parseScanOptions(args, "scan")
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+
+	// the low bits of the cursor are the shard the scan is at and the
+	// others the cursor of its dict, the shards are scanned in turn.
+	i, cursor := int(opts.cursor%keyspaceShards), opts.cursor/keyspaceShards
+	elements := []Value{}
+	visited := 0
+	for i < keyspaceShards && visited < opts.count {
+		sh := db.shards[i]
+		unlock := lockShards(ctx, false, sh)
+		cursor = scanDict(sh.keys, cursor, opts.count-visited, func(key string, _ *object) {
+			visited++
+			if !opts.match(key) || !db.keyExists(key) {
+				return
+			}
+			if opts.typ != "" && db.keyType(key) != opts.typ {
+				return
+			}
+			elements = append(elements, Value{Typ: "bulk", Bulk: key})
+		})
+		unlock()
+
+		if cursor != 0 {
+			break
+		}
+		i++
+	}
+
+	if i == keyspaceShards {
+		return scanReply(0, elements)
+	}
+	return scanReply(cursor*keyspaceShards+uint64(i), elements)
+}
@@ -0,0 +1,325 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It deletes keys of any type", func(t *testing.T) {
+		str, h, l := newTestKey(t, "SET", "v"), newTestKey(t, "HSET", "f", "v"), newTestKey(t, "RPUSH", "a")
+
+		assert.Equal(t, 3, exists(ctx, command(str, h, l).Array).Num)
+		assert.Equal(t, 2, exists(ctx, command(str, str, "missing").Array).Num)
+		assert.Equal(t, 2, del(ctx, command(str, h, "missing").Array).Num)
+		assert.Equal(t, 1, unlink(ctx, command(l, l).Array).Num)
+		assert.Equal(t, 0, exists(ctx, command(str, h, l).Array).Num)
+		assert.Equal(t, "null", get(ctx, command(str).Array).Typ)
+
+		assert.Equal(t, wrongNumberOfArgs("del"), del(ctx, command().Array))
+	})
+
+	t.Run("It replies with the type of keys", func(t *testing.T) {
+		stream := newTestKey(t)
+		xadd(ctx, command(stream, "*", "f", "v").Array)
+
+		keys := map[string]string{
+			newTestKey(t, "SET", "v"):             "string",
+			newTestKey(t, "HSET", "f", "v"):       "hash",
+			newTestKey(t, "RPUSH", "a"):           "list",
+			newTestKey(t, "SADD", "a"):            "set",
+			newTestKey(t, "ZADD", "1", "a"):       "zset",
+			stream:                                "stream",
+			"missing-" + strconv.Itoa(rand.Int()): "none",
+		}
+		for key, typ := range keys {
+			assert.Equal(t, typ, typeCommand(ctx, command(key).Array).Str)
+		}
+
+		expired := newTestKey(t, "SET", "v")
+		pexpire(ctx, command(expired, "1").Array)
+		time.Sleep(2 * time.Millisecond)
+		assert.Equal(t, "none", typeCommand(ctx, command(expired).Array).Str)
+	})
+
+	t.Run("It renames keys with their time to live", func(t *testing.T) {
+		src, dst := newTestKey(t, "HSET", "f", "v"), newTestKey(t, "SET", "old")
+		expire(ctx, command(src, "100").Array)
+
+		assert.Equal(t, "OK", rename(ctx, command(src, dst).Array).Str)
+		assert.Equal(t, "v", hget(ctx, command(dst, "f").Array).Bulk)
+		assert.Equal(t, 100, ttl(ctx, command(dst).Array).Num)
+		assert.Equal(t, 0, exists(ctx, command(src).Array).Num)
+		assert.Equal(t, "hash", typeCommand(ctx, command(dst).Array).Str)
+
+		assert.Equal(t, "OK", rename(ctx, command(dst, dst).Array).Str)
+		assert.Equal(t, errNoSuchKey.Error(), rename(ctx, command(src, dst).Array).Str)
+
+		other := newTestKey(t, "SET", "v")
+		assert.Equal(t, 0, renamenx(ctx, command(dst, other).Array).Num)
+		assert.Equal(t, 1, renamenx(ctx, command(dst, src).Array).Num)
+		assert.Equal(t, "v", hget(ctx, command(src, "f").Array).Bulk)
+	})
+
+	t.Run("It copies keys without sharing their values", func(t *testing.T) {
+		src, dst := newTestKey(t, "RPUSH", "a", "b"), newTestKey(t)
+		expire(ctx, command(src, "100").Array)
+
+		assert.Equal(t, 1, copyCommand(ctx, command(src, dst).Array).Num)
+		lpush(ctx, command(src, "c").Array)
+		assert.Equal(t, []string{"a", "b"}, bulks(lrange(ctx, command(dst, "0", "-1").Array)))
+		assert.Equal(t, 100, ttl(ctx, command(dst).Array).Num)
+
+		assert.Equal(t, 0, copyCommand(ctx, command(src, dst).Array).Num)
+		assert.Equal(t, 1, copyCommand(ctx, command(src, dst, "REPLACE", "DB", "0").Array).Num)
+		assert.Equal(t, []string{"c", "a", "b"}, bulks(lrange(ctx, command(dst, "0", "-1").Array)))
+		assert.Equal(t, 0, copyCommand(ctx, command("missing", dst, "REPLACE").Array).Num)
+
+		assert.Equal(t, "ERR source and destination objects are the same", copyCommand(ctx, command(src, src).Array).Str)
+		assert.Equal(t, "ERR DB index is out of range", copyCommand(ctx, command(src, dst, "DB", "1").Array).Str)
+		assert.Equal(t, errSyntax.Error(), copyCommand(ctx, command(src, dst, "DB").Array).Str)
+	})
+
+	t.Run("It matches keys against glob patterns", func(t *testing.T) {
+		prefix := "keys-" + strconv.Itoa(rand.Int())
+		for _, name := range []string{"one", "two", "three"} {
+			set(ctx, command(prefix+":"+name, "v").Array)
+		}
+
+		result := bulks(keys(ctx, command(prefix+":t*").Array))
+		sort.Strings(result)
+		assert.Equal(t, []string{prefix + ":three", prefix + ":two"}, result)
+		assert.Equal(t, []string{prefix + ":one"}, bulks(keys(ctx, command(prefix+":?ne").Array)))
+		assert.Empty(t, keys(ctx, command(prefix+":four").Array).Array)
+	})
+
+	t.Run("It scans every key present during the whole scan while keys are added", func(t *testing.T) {
+		prefix := "scan-" + strconv.Itoa(rand.Int())
+		for i := 0; i < 200; i++ {
+			set(ctx, command(prefix+":"+strconv.Itoa(i), "v").Array)
+		}
+
+		seen := map[string]bool{}
+		cursor, added := "0", 0
+		for {
+			result := scan(ctx, command(cursor, "MATCH", prefix+":*", "COUNT", "10").Array)
+			for _, key := range bulks(result.Array[1]) {
+				seen[key] = true
+			}
+
+			// the dict grows while it is scanned.
+			for i := 0; i < 50; i++ {
+				set(ctx, command(prefix+":new-"+strconv.Itoa(added), "v").Array)
+				added++
+			}
+
+			cursor = result.Array[0].Bulk
+			if cursor == "0" {
+				break
+			}
+		}
+
+		for i := 0; i < 200; i++ {
+			assert.True(t, seen[prefix+":"+strconv.Itoa(i)])
+		}
+	})
+
+	t.Run("It filters the keys scanned by type", func(t *testing.T) {
+		prefix := "scantype-" + strconv.Itoa(rand.Int())
+		set(ctx, command(prefix+":string", "v").Array)
+		sadd(ctx, command(prefix+":set", "a").Array)
+
+		var found []string
+		cursor := "0"
+		for {
+			result := scan(ctx, command(cursor, "MATCH", prefix+":*", "TYPE", "SET").Array)
+			found = append(found, bulks(result.Array[1])...)
+			if cursor = result.Array[0].Bulk; cursor == "0" {
+				break
+			}
+		}
+		assert.Equal(t, []string{prefix + ":set"}, found)
+
+		assert.Equal(t, "ERR unknown type name 'tree'", scan(ctx, command("0", "TYPE", "tree").Array).Str)
+		assert.Equal(t, errSyntax.Error(), scan(ctx, command("0", "NOVALUES").Array).Str)
+		assert.Equal(t, errSyntax.Error(), hscan(ctx, command("h", "0", "TYPE", "hash").Array).Str)
+	})
+
+	t.Run("It counts, picks and flushes keys", func(t *testing.T) {
+		key := newTestKey(t, "SET", "v")
+		assert.Equal(t, 1, touch(ctx, command(key, "missing").Array).Num)
+		assert.Greater(t, dbsize(ctx, command().Array).Num, 0)
+		assert.Equal(t, "bulk", randomkey(ctx, command().Array).Typ)
+
+		assert.Equal(t, errSyntax.Error(), flushdb(ctx, command("LATER").Array).Str)
+		assert.Equal(t, "OK", flushall(ctx, command("ASYNC").Array).Str)
+		assert.Equal(t, 0, dbsize(ctx, command().Array).Num)
+		assert.Equal(t, "null", randomkey(ctx, command().Array).Typ)
+		assert.Equal(t, "null", get(ctx, command(key).Array).Typ)
+
+		key = newTestKey(t, "SET", "v")
+		assert.Equal(t, []string{key}, bulks(keys(ctx, command("*").Array)))
+		assert.Equal(t, "OK", flushdb(ctx, command().Array).Str)
+	})
+}
+
+func TestDatabases(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It keeps the keys of every database apart", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := "db-" + strconv.Itoa(rand.Int())
+
+		assert.Equal(t, "OK", server.handleCommandExecution(c, command("SELECT", "1")).Str)
+		server.handleCommandExecution(c, command("SET", key, "one"))
+		assert.Equal(t, 1, server.handleCommandExecution(c, command("DBSIZE")).Num)
+
+		other := testClient(t, server)
+		assert.Equal(t, "null", server.handleCommandExecution(other, command("GET", key)).Typ)
+		assert.Equal(t, "one", server.handleCommandExecution(c, command("GET", key)).Bulk)
+
+		assert.Equal(t, "OK", server.handleCommandExecution(c, command("FLUSHDB")).Str)
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("DBSIZE")).Num)
+
+		assert.Equal(t, errDBIndexOutOfRange.Error(), server.handleCommandExecution(c, command("SELECT", "16")).Str)
+		assert.Equal(t, errNotInteger.Error(), server.handleCommandExecution(c, command("SELECT", "one")).Str)
+		assert.Equal(t, "ERR SELECT requires a connection", selectCommand(ctx, command("1").Array).Str)
+		assert.Equal(t, 1, c.db)
+	})
+
+	t.Run("It creates the number of databases configured", func(t *testing.T) {
+		config, err := LoadConfig([]string{"--databases", "2"})
+		assert.Nil(t, err)
+
+		server := NewServer(config)
+		c := testClient(t, server)
+		assert.Equal(t, "OK", server.handleCommandExecution(c, command("SELECT", "1")).Str)
+		assert.Equal(t, errDBIndexOutOfRange.Error(), server.handleCommandExecution(c, command("SELECT", "2")).Str)
+	})
+
+	t.Run("It moves and copies keys between databases", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t, "RPUSH", "a", "b")
+		expire(ctx, command(key, "100").Array)
+
+		assert.Equal(t, 1, server.handleCommandExecution(c, command("COPY", key, key, "DB", "2")).Num)
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("MOVE", key, "2")).Num)
+		assert.Equal(t, 1, server.handleCommandExecution(c, command("DEL", key)).Num)
+
+		server.handleCommandExecution(c, command("SELECT", "2"))
+		assert.Equal(t, 100, server.handleCommandExecution(c, command("TTL", key)).Num)
+		assert.Equal(t, 1, server.handleCommandExecution(c, command("MOVE", key, "0")).Num)
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("EXISTS", key)).Num)
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("MOVE", key, "0")).Num)
+
+		assert.Equal(t, []string{"a", "b"}, bulks(lrange(ctx, command(key, "0", "-1").Array)))
+		assert.Equal(t, 100, ttl(ctx, command(key).Array).Num)
+
+		assert.Equal(t, errSameObject.Error(), server.handleCommandExecution(c, command("MOVE", key, "2")).Str)
+		assert.Equal(t, errSameObject.Error(), server.handleCommandExecution(c, command("COPY", key, key)).Str)
+		assert.Equal(t, errDBIndexOutOfRange.Error(), server.handleCommandExecution(c, command("MOVE", key, "-1")).Str)
+	})
+
+	t.Run("It swaps databases under the feet of their clients", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := "swap-" + strconv.Itoa(rand.Int())
+
+		server.handleCommandExecution(c, command("SELECT", "3"))
+		server.handleCommandExecution(c, command("SET", key, "three"))
+
+		assert.Equal(t, "OK", server.handleCommandExecution(c, command("SWAPDB", "3", "4")).Str)
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("EXISTS", key)).Num)
+		server.handleCommandExecution(c, command("SELECT", "4"))
+		assert.Equal(t, "three", server.handleCommandExecution(c, command("GET", key)).Bulk)
+
+		assert.Equal(t, "ERR invalid first DB index", server.handleCommandExecution(c, command("SWAPDB", "a", "4")).Str)
+		assert.Equal(t, "ERR invalid second DB index", server.handleCommandExecution(c, command("SWAPDB", "4", "b")).Str)
+		assert.Equal(t, errDBIndexOutOfRange.Error(), server.handleCommandExecution(c, command("SWAPDB", "4", "16")).Str)
+	})
+
+	t.Run("It serves the clients blocked in the database a list moved to", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := "db-blocking-" + strconv.Itoa(rand.Int())
+
+		blocked := testClient(t, server)
+		server.handleCommandExecution(blocked, command("SELECT", "5"))
+		result := runBlocking(server, blocked, command("BLPOP", key, "0"))
+		waitBlockedIn(t, server, 5, key, 1)
+
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("SELECT", "6"))
+		server.handleCommandExecution(c, command("RPUSH", key, "a", "b"))
+		server.handleCommandExecution(c, command("SWAPDB", "5", "6"))
+		assert.Equal(t, []string{key, "a"}, bulks(receive(t, result)))
+
+		reply := server.handleCommandExecution(blocked, command("BLPOP", key, "0"))
+		assert.Equal(t, []string{key, "b"}, bulks(reply))
+
+		result = runBlocking(server, blocked, command("BLPOP", key, "0"))
+		waitBlockedIn(t, server, 5, key, 1)
+		server.handleCommandExecution(c, command("RPUSH", key, "c"))
+		server.handleCommandExecution(c, command("MOVE", key, "5"))
+		assert.Equal(t, []string{key, "c"}, bulks(receive(t, result)))
+	})
+
+	t.Run("It records the database of the writes in the AOF", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "databases.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		c, other := testClient(t, server), testClient(t, server)
+		key := "db-aof-" + strconv.Itoa(rand.Int())
+
+		server.handleCommandExecution(c, command("SELECT", "7"))
+		server.handleCommandExecution(c, command("SET", key, "seven"))
+		server.handleCommandExecution(c, command("APPEND", key, "!"))
+		server.handleCommandExecution(other, command("SET", key, "zero"))
+		server.handleCommandExecution(c, command("MULTI"))
+		server.handleCommandExecution(c, command("SELECT", "8"))
+		server.handleCommandExecution(c, command("SET", key, "eight"))
+		server.handleCommandExecution(c, command("EXEC"))
+		assert.Nil(t, aof.Close())
+
+		content, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, strings.Count(string(content), "select"))
+
+		del(ctx, command(key).Array)
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+
+		values := map[int]string{0: "zero", 7: "seven!", 8: "eight"}
+		for db, value := range values {
+			unlock := restarted.databases[db].rlock(ctx, key)
+			buf, _, _ := restarted.databases[db].lookupString(key)
+			unlock()
+			assert.Equal(t, value, buf)
+		}
+	})
+
+	t.Run("It flushes every database with FLUSHALL", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("SELECT", "9"))
+		server.handleCommandExecution(c, command("SET", "k", "v"))
+		server.handleCommandExecution(c, command("FLUSHALL"))
+		assert.Equal(t, 0, server.handleCommandExecution(c, command("DBSIZE")).Num)
+	})
+}
@@ -0,0 +1,829 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// the smallest ring buffer of a list, always a power of two.
+const listMinCapacity = 8
+
+// the ends of a list, see LPUSH/RPUSH, LMOVE and LMPOP.
+const (
+	listHead = iota
+	listTail
+)
+
+// list is a double-ended queue stored in a ring buffer: pushing and popping
+// at both ends is O(1) and reading an element by its index does not walk
+// nodes like a linked list would. The buffer doubles when it is full and
+// halves when it is a quarter full so queues that drain give memory back.
+type list struct {
+	buf  []string
+	head int // position of the first element in buf.
+	size int
+}
+
+func newList() *list {
+	return &list{buf: make([]string, listMinCapacity)}
+}
+
+func (l *list) clone() *list {
+	return &list{buf: append([]string(nil), l.buf...), head: l.head, size: l.size}
+}
+
+func (l *list) len() int {
+	return l.size
+}
+
+// position in buf of the i-th element, the capacity is a power
+// of two so wrapping around is a mask.
+func (l *list) pos(i int) int {
+	return (l.head + i) & (len(l.buf) - 1)
+}
+
+// the i-th element from the head, i must be in range.
+func (l *list) index(i int) string {
+	return l.buf[l.pos(i)]
+}
+
+func (l *list) set(i int, value string) {
+	l.buf[l.pos(i)] = value
+}
+
+func (l *list) pushFront(value string) {
+	l.grow()
+	l.head = (l.head - 1) & (len(l.buf) - 1)
+	l.buf[l.head] = value
+	l.size++
+}
+
+func (l *list) pushBack(value string) {
+	l.grow()
+	l.buf[l.pos(l.size)] = value
+	l.size++
+}
+
+func (l *list) popFront() string {
+	value := l.buf[l.head]
+	l.buf[l.head] = "" // let the string be collected.
+	l.head = l.pos(1)
+	l.size--
+	l.shrink()
+	return value
+}
+
+func (l *list) popBack() string {
+	i := l.pos(l.size - 1)
+	value := l.buf[i]
+	l.buf[i] = ""
+	l.size--
+	l.shrink()
+	return value
+}
+
+func (l *list) push(where int, value string) {
+	if where == listHead {
+		l.pushFront(value)
+	} else {
+		l.pushBack(value)
+	}
+}
+
+func (l *list) pop(where int) string {
+	if where == listHead {
+		return l.popFront()
+	}
+	return l.popBack()
+}
+
+// inserts the value so it becomes the i-th element, the elements
+// on the shorter side of i are moved to make room.
+func (l *list) insert(i int, value string) {
+	if i < l.size/2 {
+		l.pushFront(value)
+		for j := 0; j < i; j++ {
+			l.set(j, l.index(j+1))
+		}
+	} else {
+		l.pushBack(value)
+		for j := l.size - 1; j > i; j-- {
+			l.set(j, l.index(j-1))
+		}
+	}
+	l.set(i, value)
+}
+
+// the elements from start to end, both included and in range.
+func (l *list) slice(start, end int) []string {
+	values := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		values = append(values, l.index(i))
+	}
+	return values
+}
+
+// keeps the elements for which keep returns true, in order.
+func (l *list) filter(keep func(i int, value string) bool) {
+	values := make([]string, 0, l.size)
+	for i := 0; i < l.size; i++ {
+		if value := l.index(i); keep(i, value) {
+			values = append(values, value)
+		}
+	}
+	l.reset(values)
+}
+
+// replaces the content of the list by the values.
+func (l *list) reset(values []string) {
+	capacity := listMinCapacity
+	for capacity < len(values) {
+		capacity *= 2
+	}
+
+	l.buf = make([]string, capacity)
+	copy(l.buf, values)
+	l.head = 0
+	l.size = len(values)
+}
+
+func (l *list) grow() {
+	if l.size == len(l.buf) {
+		l.resize(len(l.buf) * 2)
+	}
+}
+
+func (l *list) shrink() {
+	if len(l.buf) > listMinCapacity && l.size <= len(l.buf)/4 {
+		l.resize(len(l.buf) / 2)
+	}
+}
+
+func (l *list) resize(capacity int) {
+	buf := make([]string, capacity)
+	for i := 0; i < l.size; i++ {
+		buf[i] = l.index(i)
+	}
+	l.buf = buf
+	l.head = 0
+}
+
+// the list stored at key for commands reading it, nil when there is none.
+// The store must be locked.
+func (s *SimpleStore) lookupList(key string) (*list, error) {
+	return lookupValue[*list](s, key, typeList)
+}
+
+// the list stored at key for commands writing it, an empty one is stored
+// when create is true and there is none. The store must be locked for writing.
+func (s *SimpleStore) writableList(key string, create bool) (*list, error) {
+	return writableValue(s, key, typeList, create, newList)
+}
+
+// pops up to count elements from an end of the list stored at key,
+// redis never keeps empty lists so the key is deleted once drained.
+// The store must be locked for writing.
+func (s *SimpleStore) popList(key string, l *list, where int, count int) []Value {
+	values := make([]Value, 0, min(count, l.len()))
+	for len(values) < count && l.len() > 0 {
+		values = append(values, Value{Typ: "bulk", Bulk: l.pop(where)})
+	}
+
+	if l.len() == 0 {
+		s.deleteKey(key)
+	}
+	return values
+}
+
+// a pop made by a list command once the list at key holds elements, the
+// blocking commands share them with their non-blocking versions. It returns
+// the reply of the client and the commands written in the AOF for it.
+// The store must be locked for writing.
+type listPop func(db *SimpleStore, key string, l *list) (reply Value, propagate []Value)
+
+// the pop of BLPOP and BRPOP, the reply tells which key it came from.
+func popElement(where int) listPop {
+	return func(db *SimpleStore, key string, l *list) (Value, []Value) {
+		value := db.popList(key, l, where, 1)[0]
+		reply := Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: key}, value}}
+		return reply, []Value{command(listPopCommand(where), key)}
+	}
+}
+
+// the pop of LMPOP and BLMPOP.
+func popElements(where int, count int) listPop {
+	return func(db *SimpleStore, key string, l *list) (Value, []Value) {
+		values := db.popList(key, l, where, count)
+		reply := Value{Typ: "array", Array: []Value{
+			{Typ: "bulk", Bulk: key},
+			{Typ: "array", Array: values},
+		}}
+		return reply, []Value{command(listPopCommand(where), key, strconv.Itoa(len(values)))}
+	}
+}
+
+// the pop of LMOVE and BLMOVE, the source is emptied after
+// the push so rotating a list of one element keeps the list. Nothing
+// moves when the destination holds another type.
+func moveElement(destination string, from int, to int) listPop {
+	return func(db *SimpleStore, key string, l *list) (Value, []Value) {
+		dst, err := db.writableList(destination, true)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}, nil
+		}
+
+		value := l.pop(from)
+		dst.push(to, value)
+		if l.len() == 0 {
+			db.deleteKey(key)
+		}
+
+		propagate := command("lmove", key, destination, listWhereName(from), listWhereName(to))
+		return Value{Typ: "bulk", Bulk: value}, []Value{propagate}
+	}
+}
+
+func listPopCommand(where int) string {
+	if where == listHead {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+func listWhereName(where int) string {
+	if where == listHead {
+		return "left"
+	}
+	return "right"
+}
+
+// converts a list index, negative ones count from the tail, to a position
+// from the head. The position is out of range when the index is.
+func listIndex(index, length int) int {
+	if index < 0 {
+		return length + index
+	}
+	return index
+}
+
+// parses LEFT or RIGHT.
+func parseListWhere(arg string) (int, bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return listHead, true
+	case "right":
+		return listTail, true
+	default:
+		return 0, false
+	}
+}
+
+// doc: https://redis.io/docs/latest/commands/lpush/
+func lpush(ctx context.Context, args []Value) Value {
+	return pushGeneric(ctx, "lpush", args, listHead, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/rpush/
+func rpush(ctx context.Context, args []Value) Value {
+	return pushGeneric(ctx, "rpush", args, listTail, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/lpushx/
+func lpushx(ctx context.Context, args []Value) Value {
+	return pushGeneric(ctx, "lpushx", args, listHead, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/rpushx/
+func rpushx(ctx context.Context, args []Value) Value {
+	return pushGeneric(ctx, "rpushx", args, listTail, true)
+}
+
+// pushes the elements one after the other at an end of the list,
+// the X variants only push to lists that exist.
+func pushGeneric(ctx context.Context, name string, args []Value, where int, onlyExisting bool) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	l, err := db.writableList(args[0].Bulk, !onlyExisting)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	for _, arg := range args[1:] {
+		l.push(where, arg.Bulk)
+	}
+	signalKeyAsReady(ctx, db, args[0].Bulk)
+
+	return Value{Typ: "integer", Num: l.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/lpop/
+func lpop(ctx context.Context, args []Value) Value {
+	return popGeneric(ctx, "lpop", args, listHead)
+}
+
+// doc: https://redis.io/docs/latest/commands/rpop/
+func rpop(ctx context.Context, args []Value) Value {
+	return popGeneric(ctx, "rpop", args, listTail)
+}
+
+// pops an element, or an array of count elements when it is given.
+func popGeneric(ctx context.Context, name string, args []Value, where int) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return Value{Typ: "error", Str: "ERR value is out of range, must be positive"}
+		}
+		count = n
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	l, err := db.writableList(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		propagateAs(ctx)
+		if len(args) == 2 {
+			return Value{Typ: "nullarray"}
+		}
+		return Value{Typ: "null"}
+	}
+
+	values := db.popList(key, l, where, count)
+	if len(args) == 2 {
+		return Value{Typ: "array", Array: values}
+	}
+	return values[0]
+}
+
+// doc: https://redis.io/docs/latest/commands/llen/
+func llen(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("llen")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	l, err := db.lookupList(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: l.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/lrange/
+func lrange(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("lrange")
+	}
+
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	end, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	result := Value{Typ: "array", Array: []Value{}}
+
+	l, err := db.lookupList(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return result
+	}
+
+	start = max(listIndex(start, l.len()), 0)
+	end = min(listIndex(end, l.len()), l.len()-1)
+
+	if start > end {
+		return result
+	}
+
+	for _, value := range l.slice(start, end) {
+		result.Array = append(result.Array, Value{Typ: "bulk", Bulk: value})
+	}
+	return result
+}
+
+// doc: https://redis.io/docs/latest/commands/lindex/
+func lindex(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("lindex")
+	}
+
+	index, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	l, err := db.lookupList(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return Value{Typ: "null"}
+	}
+
+	i := listIndex(index, l.len())
+	if i < 0 || i >= l.len() {
+		return Value{Typ: "null"}
+	}
+	return Value{Typ: "bulk", Bulk: l.index(i)}
+}
+
+// doc: https://redis.io/docs/latest/commands/lset/
+func lset(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("lset")
+	}
+
+	index, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	l, err := db.writableList(args[0].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return Value{Typ: "error", Str: "ERR no such key"}
+	}
+
+	i := listIndex(index, l.len())
+	if i < 0 || i >= l.len() {
+		return Value{Typ: "error", Str: "ERR index out of range"}
+	}
+
+	l.set(i, args[2].Bulk)
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/linsert/
+func linsert(ctx context.Context, args []Value) Value {
+	if len(args) != 4 {
+		return wrongNumberOfArgs("linsert")
+	}
+
+	var after bool
+	switch strings.ToLower(args[1].Bulk) {
+	case "before":
+		after = false
+	case "after":
+		after = true
+	default:
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	l, err := db.writableList(args[0].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	pivot := args[2].Bulk
+	for i := 0; i < l.len(); i++ {
+		if l.index(i) != pivot {
+			continue
+		}
+
+		if after {
+			i++
+		}
+		l.insert(i, args[3].Bulk)
+		return Value{Typ: "integer", Num: l.len()}
+	}
+
+	propagateAs(ctx)
+	return Value{Typ: "integer", Num: -1}
+}
+
+// doc: https://redis.io/docs/latest/commands/lrem/
+func lrem(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("lrem")
+	}
+
+	count, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	l, err := db.writableList(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	element := args[2].Bulk
+
+	// a negative count removes the elements closest to the tail,
+	// they are found first and then filtered out in a single pass.
+	remove := make(map[int]bool)
+	for n := 0; n < l.len() && (count == 0 || len(remove) < abs(count)); n++ {
+		i := n
+		if count < 0 {
+			i = l.len() - 1 - n
+		}
+		if l.index(i) == element {
+			remove[i] = true
+		}
+	}
+
+	if len(remove) == 0 {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	l.filter(func(i int, _ string) bool { return !remove[i] })
+	if l.len() == 0 {
+		db.deleteKey(key)
+	}
+
+	return Value{Typ: "integer", Num: len(remove)}
+}
+
+// doc: https://redis.io/docs/latest/commands/ltrim/
+func ltrim(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("ltrim")
+	}
+
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	end, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	l, err := db.writableList(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		return Value{Typ: "string", Str: "OK"}
+	}
+
+	start = max(listIndex(start, l.len()), 0)
+	end = min(listIndex(end, l.len()), l.len()-1)
+
+	// an empty range empties the list.
+	if start > end {
+		db.deleteKey(key)
+		return Value{Typ: "string", Str: "OK"}
+	}
+
+	l.reset(l.slice(start, end))
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/lpos/
+func lpos(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("lpos")
+	}
+
+	rank, count, maxlen := 1, 1, 0
+	hasCount := false
+
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+		if i+1 >= len(args) || (option != "rank" && option != "count" && option != "maxlen") {
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+
+		n, err := strconv.Atoi(args[i+1].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: errNotInteger.Error()}
+		}
+		i++
+
+		switch option {
+		case "rank":
+			if n == 0 {
+				return Value{Typ: "error", Str: "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match"}
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return Value{Typ: "error", Str: "ERR COUNT can't be negative"}
+			}
+			count = n
+			hasCount = true
+		case "maxlen":
+			if n < 0 {
+				return Value{Typ: "error", Str: "ERR MAXLEN can't be negative"}
+			}
+			maxlen = n
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	matches := []Value{}
+
+	l, err := db.lookupList(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l != nil {
+		element := args[1].Bulk
+		skip := abs(rank) - 1
+
+		// a negative rank searches from the tail, a count of 0 means all the matches.
+		for n := 0; n < l.len() && (maxlen == 0 || n < maxlen); n++ {
+			i := n
+			if rank < 0 {
+				i = l.len() - 1 - n
+			}
+
+			if l.index(i) != element {
+				continue
+			}
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			matches = append(matches, Value{Typ: "integer", Num: i})
+			if count != 0 && len(matches) == count {
+				break
+			}
+		}
+	}
+
+	if hasCount {
+		return Value{Typ: "array", Array: matches}
+	}
+
+	if len(matches) == 0 {
+		return Value{Typ: "null"}
+	}
+	return matches[0]
+}
+
+// doc: https://redis.io/docs/latest/commands/lmove/
+func lmove(ctx context.Context, args []Value) Value {
+	if len(args) != 4 {
+		return wrongNumberOfArgs("lmove")
+	}
+
+	from, ok1 := parseListWhere(args[2].Bulk)
+	to, ok2 := parseListWhere(args[3].Bulk)
+	if !ok1 || !ok2 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	source := args[0].Bulk
+	l, err := db.writableList(source, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if l == nil {
+		propagateAs(ctx)
+		return Value{Typ: "null"}
+	}
+
+	reply, propagate := moveElement(args[1].Bulk, from, to)(db, source, l)
+	if propagate == nil {
+		return reply
+	}
+	signalKeyAsReady(ctx, db, args[1].Bulk)
+
+	return reply
+}
+
+// parses "numkeys key [key ...] LEFT|RIGHT [COUNT count]" of LMPOP and BLMPOP.
+func parseMultiPopArgs(args []Value) (keys []string, where int, count int, err error) {
+	numkeys, err := strconv.Atoi(args[0].Bulk)
+	if err != nil || numkeys <= 0 {
+		return nil, 0, 0, errors.New("ERR numkeys should be greater than 0")
+	}
+
+	if len(args) < numkeys+2 {
+		return nil, 0, 0, errSyntax
+	}
+
+	for _, key := range args[1 : numkeys+1] {
+		keys = append(keys, key.Bulk)
+	}
+
+	where, ok := parseListWhere(args[numkeys+1].Bulk)
+	if !ok {
+		return nil, 0, 0, errSyntax
+	}
+
+	count = 1
+	options := args[numkeys+2:]
+	switch {
+	case len(options) == 0:
+	case len(options) == 2 && strings.ToLower(options[0].Bulk) == "count":
+		count, err = strconv.Atoi(options[1].Bulk)
+		if err != nil || count <= 0 {
+			return nil, 0, 0, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, 0, 0, errSyntax
+	}
+
+	return keys, where, count, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/lmpop/
+func lmpop(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("lmpop")
+	}
+
+	keys, where, count, err := parseMultiPopArgs(args)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, keys...)
+	defer unlock()
+
+	for _, key := range keys {
+		l, err := db.writableList(key, false)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if l == nil {
+			continue
+		}
+
+		reply, propagate := popElements(where, count)(db, key, l)
+
+		// the AOF gets the pop that happened, not the keys that were tried.
+		propagateAs(ctx, propagate...)
+		return reply
+	}
+
+	propagateAs(ctx)
+	return Value{Typ: "nullarray"}
+}
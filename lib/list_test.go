@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the elements of the list from head to tail.
+func listValues(l *list) []string {
+	if l.len() == 0 {
+		return []string{}
+	}
+	return l.slice(0, l.len()-1)
+}
+
+// the bulk strings of an array reply.
+func bulks(v Value) []string {
+	values := []string{}
+	for _, item := range v.Array {
+		values = append(values, item.Bulk)
+	}
+	return values
+}
+
+func TestList(t *testing.T) {
+	t.Run("It keeps the order of the elements when the buffer wraps around and grows", func(t *testing.T) {
+		l := newList()
+		expects := []string{}
+
+		for i := 0; i < 100; i++ {
+			value := strconv.Itoa(i)
+			if i%2 == 0 {
+				l.pushFront(value)
+				expects = append([]string{value}, expects...)
+			} else {
+				l.pushBack(value)
+				expects = append(expects, value)
+			}
+		}
+		assert.Equal(t, expects, listValues(l))
+
+		for i := 0; i < 90; i++ {
+			if i%3 == 0 {
+				assert.Equal(t, expects[len(expects)-1], l.popBack())
+				expects = expects[:len(expects)-1]
+			} else {
+				assert.Equal(t, expects[0], l.popFront())
+				expects = expects[1:]
+			}
+		}
+		assert.Equal(t, expects, listValues(l))
+		assert.Less(t, len(l.buf), 128)
+	})
+
+	t.Run("It inserts elements at any position", func(t *testing.T) {
+		l := newList()
+		expects := []string{}
+
+		for i := 0; i < 50; i++ {
+			value := strconv.Itoa(i)
+			at := rand.Intn(l.len() + 1)
+			l.insert(at, value)
+			expects = append(expects[:at], append([]string{value}, expects[at:]...)...)
+		}
+
+		assert.Equal(t, expects, listValues(l))
+	})
+}
+
+func TestPushAndPopCommands(t *testing.T) {
+	t.Run("It pushes and pops at both ends", func(t *testing.T) {
+		key := "list-" + strconv.Itoa(rand.Int())
+
+		result := rpush(context.Background(), command(key, "b", "c").Array)
+		assert.Equal(t, 2, result.Num)
+		result = lpush(context.Background(), command(key, "a", "z").Array)
+		assert.Equal(t, 4, result.Num)
+
+		result = lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"z", "a", "b", "c"}, bulks(result))
+
+		result = lpop(context.Background(), command(key).Array)
+		assert.Equal(t, "bulk", result.Typ)
+		assert.Equal(t, "z", result.Bulk)
+
+		result = rpop(context.Background(), command(key, "2").Array)
+		assert.Equal(t, []string{"c", "b"}, bulks(result))
+
+		result = lpop(context.Background(), command(key, "5").Array)
+		assert.Equal(t, []string{"a"}, bulks(result))
+
+		// drained lists are deleted.
+		assert.Equal(t, -2, ttl(context.Background(), command(key).Array).Num)
+		assert.Equal(t, "null", lpop(context.Background(), command(key).Array).Typ)
+		assert.Equal(t, "nullarray", lpop(context.Background(), command(key, "1").Array).Typ)
+	})
+
+	t.Run("It only pushes to existing lists with LPUSHX and RPUSHX", func(t *testing.T) {
+		key := "list-" + strconv.Itoa(rand.Int())
+
+		assert.Equal(t, 0, lpushx(context.Background(), command(key, "a").Array).Num)
+		assert.Equal(t, 0, llen(context.Background(), command(key).Array).Num)
+
+		rpush(context.Background(), command(key, "a").Array)
+		assert.Equal(t, 2, rpushx(context.Background(), command(key, "b").Array).Num)
+		assert.Equal(t, 3, lpushx(context.Background(), command(key, "c").Array).Num)
+	})
+
+	t.Run("It refuses negative counts", func(t *testing.T) {
+		result := lpop(context.Background(), command("list-negative", "-1").Array)
+		assert.Equal(t, "ERR value is out of range, must be positive", result.Str)
+	})
+}
+
+func TestListCommands(t *testing.T) {
+	t.Run("It reads ranges and indexes counting from both ends", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "b", "c", "d")
+
+		tests := []struct {
+			start, end string
+			expects    []string
+		}{
+			{"0", "1", []string{"a", "b"}},
+			{"-2", "-1", []string{"c", "d"}},
+			{"-100", "100", []string{"a", "b", "c", "d"}},
+			{"3", "1", []string{}},
+			{"5", "10", []string{}},
+		}
+		for _, tt := range tests {
+			result := lrange(context.Background(), command(key, tt.start, tt.end).Array)
+			assert.Equal(t, tt.expects, bulks(result))
+		}
+
+		assert.Equal(t, "b", lindex(context.Background(), command(key, "1").Array).Bulk)
+		assert.Equal(t, "d", lindex(context.Background(), command(key, "-1").Array).Bulk)
+		assert.Equal(t, "null", lindex(context.Background(), command(key, "4").Array).Typ)
+	})
+
+	t.Run("It sets elements by index", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "b")
+
+		assert.Equal(t, "OK", lset(context.Background(), command(key, "-1", "z").Array).Str)
+		assert.Equal(t, "ERR index out of range", lset(context.Background(), command(key, "2", "z").Array).Str)
+		assert.Equal(t, "ERR no such key", lset(context.Background(), command("list-missing", "0", "z").Array).Str)
+
+		result := lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"a", "z"}, bulks(result))
+	})
+
+	t.Run("It inserts around the pivot", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "c")
+
+		assert.Equal(t, 3, linsert(context.Background(), command(key, "BEFORE", "c", "b").Array).Num)
+		assert.Equal(t, 4, linsert(context.Background(), command(key, "AFTER", "c", "d").Array).Num)
+		assert.Equal(t, -1, linsert(context.Background(), command(key, "AFTER", "x", "y").Array).Num)
+		assert.Equal(t, 0, linsert(context.Background(), command("list-missing", "AFTER", "x", "y").Array).Num)
+
+		result := lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"a", "b", "c", "d"}, bulks(result))
+	})
+
+	t.Run("It removes elements from the head or the tail with LREM", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "x", "a", "x", "b", "x")
+
+		assert.Equal(t, 1, lrem(context.Background(), command(key, "-1", "x").Array).Num)
+		result := lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"x", "a", "x", "b"}, bulks(result))
+
+		assert.Equal(t, 1, lrem(context.Background(), command(key, "1", "x").Array).Num)
+		result = lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"a", "x", "b"}, bulks(result))
+
+		assert.Equal(t, 1, lrem(context.Background(), command(key, "0", "x").Array).Num)
+		assert.Equal(t, 2, llen(context.Background(), command(key).Array).Num)
+	})
+
+	t.Run("It trims the list to the range", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "b", "c", "d")
+
+		assert.Equal(t, "OK", ltrim(context.Background(), command(key, "1", "-2").Array).Str)
+		result := lrange(context.Background(), command(key, "0", "-1").Array)
+		assert.Equal(t, []string{"b", "c"}, bulks(result))
+
+		ltrim(context.Background(), command(key, "5", "10").Array)
+		assert.Equal(t, 0, llen(context.Background(), command(key).Array).Num)
+	})
+
+	t.Run("It finds the positions of an element with LPOS", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "b", "c", "1", "2", "3", "c", "c")
+
+		assert.Equal(t, 2, lpos(context.Background(), command(key, "c").Array).Num)
+		assert.Equal(t, 6, lpos(context.Background(), command(key, "c", "RANK", "2").Array).Num)
+		assert.Equal(t, 7, lpos(context.Background(), command(key, "c", "RANK", "-1").Array).Num)
+		assert.Equal(t, "null", lpos(context.Background(), command(key, "z").Array).Typ)
+
+		result := lpos(context.Background(), command(key, "c", "COUNT", "0").Array)
+		assert.Equal(t, []Value{{Typ: "integer", Num: 2}, {Typ: "integer", Num: 6}, {Typ: "integer", Num: 7}}, result.Array)
+
+		result = lpos(context.Background(), command(key, "c", "COUNT", "0", "MAXLEN", "3").Array)
+		assert.Equal(t, []Value{{Typ: "integer", Num: 2}}, result.Array)
+
+		result = lpos(context.Background(), command(key, "c", "RANK", "0").Array)
+		assert.Equal(t, "error", result.Typ)
+	})
+
+	t.Run("It moves elements between lists with LMOVE", func(t *testing.T) {
+		source := newTestKey(t, "RPUSH", "a", "b")
+		destination := newTestKey(t, "RPUSH", "z")
+
+		result := lmove(context.Background(), command(source, destination, "RIGHT", "LEFT").Array)
+		assert.Equal(t, "b", result.Bulk)
+		assert.Equal(t, []string{"b", "z"}, bulks(lrange(context.Background(), command(destination, "0", "-1").Array)))
+
+		// rotating a list keeps it.
+		result = lmove(context.Background(), command(destination, destination, "LEFT", "RIGHT").Array)
+		assert.Equal(t, "b", result.Bulk)
+		assert.Equal(t, []string{"z", "b"}, bulks(lrange(context.Background(), command(destination, "0", "-1").Array)))
+
+		assert.Equal(t, "null", lmove(context.Background(), command("list-missing", destination, "LEFT", "LEFT").Array).Typ)
+	})
+
+	t.Run("It pops from the first non-empty list with LMPOP", func(t *testing.T) {
+		key := newTestKey(t, "RPUSH", "a", "b", "c")
+
+		result := lmpop(context.Background(), command("2", "list-missing", key, "RIGHT", "COUNT", "2").Array)
+		assert.Equal(t, key, result.Array[0].Bulk)
+		assert.Equal(t, []string{"c", "b"}, bulks(result.Array[1]))
+
+		result = lmpop(context.Background(), command("1", "list-missing", "LEFT").Array)
+		assert.Equal(t, "nullarray", result.Typ)
+
+		result = lmpop(context.Background(), command("0", key, "LEFT").Array)
+		assert.Equal(t, "ERR numkeys should be greater than 0", result.Str)
+	})
+
+	t.Run("It persists list writes in the AOF", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t, "RPUSH", "a", "b")
+
+		// the AOF receives the pop that happened.
+		server.call(c, command("LMPOP", "2", "list-missing", key, "LEFT"))
+		assert.True(t, c.rewritten)
+		assert.Equal(t, []Value{command("lpop", key, "1")}, c.propagated)
+	})
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+type objectType uint8
+
+const (
+	typeString objectType = iota
+	typeHash
+	typeList
+	typeSet
+	typeZset
+	typeStream
+)
+
+// the names TYPE replies with, in the order of the types.
+var keyTypes = []string{"string", "hash", "list", "set", "zset", "stream"}
+
+func (t objectType) String() string {
+	return keyTypes[t]
+}
+
+// the LFU counter of redis: it grows logarithmically with the accesses,
+// by one every lfuDecayTime minutes the key is not accessed. Port of
+// https://github.com/redis/redis/blob/unstable/src/evict.c
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 1 // minutes
+)
+
+// an object of the keyspace, the value stored at a key tagged with its type.
+// The value is a stringValue, *hash, *list, *setType, *zset or *stream.
+type object struct {
+	typ     objectType
+	value   any
+	expires int64 // unix time in milliseconds the key expires at, 0 when it has none.
+
+	// the access metadata the eviction policies of redis use: the unix time
+	// in milliseconds of the last access for LRU and the logarithmic access
+	// counter for LFU. Commands reading keys update them holding the read
+	// lock only, hence the atomics.
+	accessed atomic.Int64
+	freq     atomic.Uint32
+}
+
+func newObject(typ objectType, value any) *object {
+	o := &object{typ: typ, value: value}
+	o.accessed.Store(mstime())
+	o.freq.Store(lfuInitVal)
+	return o
+}
+
+// the encoding OBJECT ENCODING replies with, the name redis gives to the
+// representation the value has.
+func (o *object) encoding() string {
+	switch v := o.value.(type) {
+	case stringValue:
+		switch {
+		case v.isInt:
+			return "int"
+		case len(v.buf) <= 44:
+			return "embstr"
+		default:
+			return "raw"
+		}
+	case *setType:
+		if v.isIntset() {
+			return "intset"
+		}
+		return "hashtable"
+	case *hash:
+		return "hashtable"
+	case *list:
+		return "quicklist"
+	case *zset:
+		return "skiplist"
+	default:
+		return "stream"
+	}
+}
+
+// a copy of the value sharing nothing with it.
+func (o *object) copyValue() any {
+	switch v := o.value.(type) {
+	case stringValue:
+		return v.clone()
+	case *hash:
+		return v.clone()
+	case *list:
+		return v.clone()
+	case *setType:
+		return v.clone()
+	case *zset:
+		return v.clone()
+	default:
+		return v.(*stream).clone()
+	}
+}
+
+// records an access to the object.
+func (o *object) touch() {
+	counter := o.decayedFreq()
+	if counter < 255 {
+		baseval := max(float64(counter)-lfuInitVal, 0)
+		if rand.Float64() < 1/(baseval*lfuLogFactor+1) {
+			counter++
+		}
+	}
+
+	o.freq.Store(counter)
+	o.accessed.Store(mstime())
+}
+
+// the LFU counter once decayed by the time passed since the last access.
+func (o *object) decayedFreq() uint32 {
+	counter := o.freq.Load()
+	periods := uint32((mstime() - o.accessed.Load()) / 60000 / lfuDecayTime)
+	if periods > counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// the seconds passed since the last access.
+func (o *object) idleTime() int {
+	return int(max(mstime()-o.accessed.Load(), 0) / 1000)
+}
+
+// doc: https://redis.io/docs/latest/commands/object/
+func objectCommand(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("object")
+	}
+
+	subcommand := strings.ToLower(args[0].Bulk)
+	switch {
+	case subcommand == "help" && len(args) == 1:
+		lines := []string{
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		}
+		reply := Value{Typ: "array", Array: []Value{}}
+		for _, line := range lines {
+			reply.Array = append(reply.Array, Value{Typ: "string", Str: line})
+		}
+		return reply
+	case (subcommand == "encoding" || subcommand == "freq" || subcommand == "idletime" || subcommand == "refcount") && len(args) == 2:
+	default:
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].Bulk)}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[1].Bulk)
+	defer unlock()
+
+	// looking the object up does not count as an access.
+	o := db.peek(args[1].Bulk)
+	if o == nil {
+		return Value{Typ: "null"}
+	}
+
+	switch subcommand {
+	case "encoding":
+		return Value{Typ: "bulk", Bulk: o.encoding()}
+	case "freq":
+		return Value{Typ: "integer", Num: int(o.decayedFreq())}
+	case "idletime":
+		return Value{Typ: "integer", Num: o.idleTime()}
+	default:
+		// values are never shared between keys.
+		return Value{Typ: "integer", Num: 1}
+	}
+}
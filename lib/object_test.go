@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjects(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It refuses to operate on keys holding another type", func(t *testing.T) {
+		str, h, l := newTestKey(t, "SET", "v"), newTestKey(t, "HSET", "f", "v"), newTestKey(t, "RPUSH", "a")
+
+		assert.Equal(t, errWrongType.Error(), get(ctx, command(h).Array).Str)
+		assert.Equal(t, errWrongType.Error(), incr(ctx, command(l).Array).Str)
+		assert.Equal(t, errWrongType.Error(), lpush(ctx, command(str, "a").Array).Str)
+		assert.Equal(t, errWrongType.Error(), hget(ctx, command(l, "f").Array).Str)
+		assert.Equal(t, errWrongType.Error(), sadd(ctx, command(h, "a").Array).Str)
+		assert.Equal(t, errWrongType.Error(), zscore(ctx, command(str, "a").Array).Str)
+		assert.Equal(t, errWrongType.Error(), xlen(ctx, command(str).Array).Str)
+
+		// nothing moves when the destination holds another type.
+		assert.Equal(t, errWrongType.Error(), lmove(ctx, command(l, str, "LEFT", "LEFT").Array).Str)
+		assert.Equal(t, 1, llen(ctx, command(l).Array).Num)
+
+		assert.Equal(t, "v", get(ctx, command(str).Array).Bulk)
+		assert.Equal(t, "v", hget(ctx, command(h, "f").Array).Bulk)
+	})
+
+	t.Run("It overwrites keys of any type with SET", func(t *testing.T) {
+		h := newTestKey(t, "HSET", "f", "v")
+		expire(ctx, command(h, "100").Array)
+
+		assert.Equal(t, errWrongType.Error(), set(ctx, command(h, "v", "GET").Array).Str)
+		assert.Equal(t, "OK", set(ctx, command(h, "v", "KEEPTTL").Array).Str)
+		assert.Equal(t, "string", typeCommand(ctx, command(h).Array).Str)
+		assert.Equal(t, 100, ttl(ctx, command(h).Array).Num)
+		assert.Equal(t, "null", mget(ctx, command(newTestKey(t, "RPUSH", "a")).Array).Array[0].Typ)
+	})
+
+	t.Run("It replies with the encoding of values", func(t *testing.T) {
+		keys := map[string]string{
+			newTestKey(t, "SET", "12"):                    "int",
+			newTestKey(t, "SET", "v"):                     "embstr",
+			newTestKey(t, "SET", strings.Repeat("v", 45)): "raw",
+			newTestKey(t, "HSET", "f", "v"):               "hashtable",
+			newTestKey(t, "RPUSH", "a"):                   "quicklist",
+			newTestKey(t, "SADD", "1", "2"):               "intset",
+			newTestKey(t, "SADD", "a"):                    "hashtable",
+			newTestKey(t, "ZADD", "1", "a"):               "skiplist",
+		}
+		for key, encoding := range keys {
+			assert.Equal(t, encoding, objectCommand(ctx, command("ENCODING", key).Array).Bulk)
+		}
+
+		assert.Equal(t, "null", objectCommand(ctx, command("ENCODING", "missing").Array).Typ)
+	})
+
+	t.Run("It tracks the accesses to keys", func(t *testing.T) {
+		key := newTestKey(t, "SET", "v")
+
+		o, _ := KvStore.object(key)
+		o.accessed.Store(mstime() - 5000)
+		assert.Equal(t, 5, objectCommand(ctx, command("IDLETIME", key).Array).Num)
+
+		// OBJECT does not count as an access, GET does.
+		assert.Equal(t, 5, objectCommand(ctx, command("IDLETIME", key).Array).Num)
+		get(ctx, command(key).Array)
+		assert.Equal(t, 0, objectCommand(ctx, command("IDLETIME", key).Array).Num)
+
+		for i := 0; i < 100; i++ {
+			get(ctx, command(key).Array)
+		}
+		assert.Greater(t, objectCommand(ctx, command("FREQ", key).Array).Num, lfuInitVal)
+
+		// the counter decays by one every minute without accesses.
+		o.freq.Store(lfuInitVal)
+		o.accessed.Store(mstime() - 3*60000)
+		assert.Equal(t, lfuInitVal-3, objectCommand(ctx, command("FREQ", key).Array).Num)
+
+		assert.Equal(t, 1, objectCommand(ctx, command("REFCOUNT", key).Array).Num)
+		assert.Equal(t, "array", objectCommand(ctx, command("HELP").Array).Typ)
+		assert.Equal(t, "ERR unknown subcommand or wrong number of arguments for 'freq'. Try OBJECT HELP.", objectCommand(ctx, command("freq").Array).Str)
+		assert.Equal(t, wrongNumberOfArgs("object"), objectCommand(ctx, command().Array))
+	})
+}
@@ -2,9 +2,12 @@ package lib
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 // Reference study: https://redis.io/docs/reference/protocol-spec/
@@ -17,32 +20,95 @@ const (
 	INTEGER = ':'
 	ARRAY   = '*'
 	NULL    = '_'
+
+	// RESP3 only datatypes.
+	// doc: https://github.com/redis/redis-specifications/blob/master/protocol/RESP3.md
+	MAP       = '%'
+	SET       = '~'
+	DOUBLE    = ','
+	BOOLEAN   = '#'
+	BIGNUMBER = '('
+	VERBATIM  = '='
+	BLOBERROR = '!'
+	ATTRIBUTE = '|'
+	PUSH      = '>'
+)
+
+// protocol versions a connection can negotiate with the HELLO command.
+const (
+	RESP2 = 2
+	RESP3 = 3
 )
 
 // will hold the request arguements and command
 // it will be used in the serialization/desrialization of reqeust
 type Value struct {
-	Typ   string  // holds the datatype of the value from the requests.
-	Num   int   // holds all integer requests.
-	Str   string  // holds all string requests.
-	Bulk  string  // holds all bulk string requests.
-	Array []Value // holds all array requests
+	Typ    string  // holds the datatype of the value from the requests.
+	Num    int     // holds all integer requests.
+	Str    string  // holds all string requests (the format of verbatim strings, the digits of big numbers).
+	Bulk   string  // holds all bulk string requests.
+	Array  []Value // holds all array requests (maps and attributes store key, value, key, value...)
+	Double float64 // holds all double requests.
+	Bool   bool    // holds all boolean requests.
+}
+
+// limits protecting the server from clients sending absurd lengths.
+const (
+	DefaultProtoMaxBulkLen      = 512 * 1024 * 1024 // proto-max-bulk-len
+	DefaultProtoMaxMultiBulkLen = 1024 * 1024       // proto-max-multibulk-len
+
+	maxInlineLen = 64 * 1024 // longest line accepted, inline commands included.
+	maxPrealloc  = 64 * 1024 // most elements or bytes allocated before they are received.
+	maxNesting   = 128       // most aggregates nested in one another.
+)
+
+// ProtocolError is returned by the parser when the client sent something
+// that is not valid RESP, the connection cannot be trusted after it.
+type ProtocolError struct {
+	Msg string // the message sent to the client.
+	Err error  // the parsing error that caused it if any.
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return "Protocol error: " + e.Msg + ": " + e.Err.Error()
+	}
+	return "Protocol error: " + e.Msg
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
 }
 
 type Resp struct {
-	reader *bufio.Reader
+	reader          *bufio.Reader
+	maxBulkLen      int64 // longest bulk string accepted.
+	maxMultiBulkLen int64 // most elements accepted in an aggregate.
+	depth           int   // the aggregates being read the value is nested in.
 }
 
+// Writer buffers the replies of a connection, nothing reaches the
+// client until Flush is called so pipelined replies go out together.
 type Writer struct {
-	writer io.Writer
+	writer *bufio.Writer
+	proto  int // the protocol version negotiated by the connection.
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{writer: w}
+	return &Writer{writer: bufio.NewWriter(w), proto: RESP2}
+}
+
+// SetProto switches the encoding used for every subsequent write.
+func (w *Writer) SetProto(proto int) {
+	w.proto = proto
+}
+
+func (w *Writer) Proto() int {
+	return w.proto
 }
 
 func (w Writer) Write(v Value) error {
-	result := v.Marshal()
+	result := v.MarshalProto(w.proto)
 
 	_, err := w.writer.Write(result)
 	if err != nil {
@@ -52,8 +118,37 @@ func (w Writer) Write(v Value) error {
 	return nil
 }
 
+// Flush sends every buffered reply to the client.
+func (w Writer) Flush() error {
+	return w.writer.Flush()
+}
+
 func NewResp(rd io.Reader) *Resp {
-	return &Resp{reader: bufio.NewReader(rd)}
+	return &Resp{
+		reader:          bufio.NewReader(rd),
+		maxBulkLen:      DefaultProtoMaxBulkLen,
+		maxMultiBulkLen: DefaultProtoMaxMultiBulkLen,
+	}
+}
+
+// SetLimits changes the longest bulk string and the most aggregate
+// elements the parser accepts before failing with a ProtocolError.
+func (r *Resp) SetLimits(maxBulkLen, maxMultiBulkLen int64) {
+	r.maxBulkLen = maxBulkLen
+	r.maxMultiBulkLen = maxMultiBulkLen
+}
+
+// Buffered returns the number of bytes already received from the
+// client but not parsed yet i.e. pipelined commands waiting their turn.
+func (r *Resp) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// Peek waits until the client sends something or the connection
+// fails, nothing is consumed.
+func (r *Resp) Peek() error {
+	_, err := r.reader.Peek(1)
+	return err
 }
 
 func (r *Resp) Read() (Value, error) {
@@ -67,43 +162,171 @@ func (r *Resp) Read() (Value, error) {
 		return r.readArray()
 	case BULK:
 		return r.readBulk()
+	case STRING:
+		return r.readSimple("string")
+	case ERROR:
+		return r.readSimple("error")
+	case INTEGER:
+		return r.readNumber()
+	case NULL:
+		return r.readNull()
+	case MAP:
+		return r.readMap("map")
+	case ATTRIBUTE:
+		return r.readMap("attribute")
+	case SET:
+		return r.readAggregate("set")
+	case PUSH:
+		return r.readAggregate("push")
+	case DOUBLE:
+		return r.readDouble()
+	case BOOLEAN:
+		return r.readBoolean()
+	case BIGNUMBER:
+		return r.readSimple("bignum")
+	case VERBATIM:
+		return r.readVerbatim()
+	case BLOBERROR:
+		return r.readBlobError()
 	default:
-		fmt.Println("Unknown type: ", string(resp_type))
-		return Value{}, nil
+		// anything that is not a RESP type is an inline command
+		// e.g. someone typing "SET key value" through telnet or netcat.
+		r.reader.UnreadByte()
+		return r.readInline()
 	}
 }
 
+// ReadCommand reads a request of a client. Like redis, clients can only
+// send a multibulk of bulk strings or an inline command, a request starting
+// with anything but '*' is read as an inline command. Port of
+// processMultibulkBuffer: https://github.com/redis/redis/blob/unstable/src/networking.c
+func (r *Resp) ReadCommand() (Value, error) {
+	first, err := r.reader.ReadByte()
+	if err != nil {
+		return Value{}, err
+	}
+
+	if first != ARRAY {
+		r.reader.UnreadByte()
+		return r.readInline()
+	}
+
+	// like redis, a length of 0 or less is an empty request, there is no
+	// command to run.
+	length, err := r.readCount("multibulk")
+	if err != nil {
+		return Value{}, err
+	}
+
+	value := Value{Typ: "array", Array: make([]Value, 0, min(max(length, 0), maxPrealloc))}
+	for i := 0; i < length; i++ {
+		typ, err := r.reader.ReadByte()
+		if err != nil {
+			return value, err
+		}
+
+		if typ != BULK {
+			return value, &ProtocolError{Msg: fmt.Sprintf("expected '$', got '%c'", typ)}
+		}
+
+		arg, err := r.readBulk()
+		if err != nil {
+			return value, err
+		}
+
+		// arguments cannot be null.
+		if arg.Typ == "null" {
+			return value, &ProtocolError{Msg: "invalid bulk length"}
+		}
+
+		value.Array = append(value.Array, arg)
+	}
+
+	return value, nil
+}
+
 // Convert respsonse into RESP type.
+// Marshal always emits RESP2, use MarshalProto for connections that
+// negotiated RESP3 with the HELLO command.
 func (v Value) Marshal() []byte {
+	return v.MarshalProto(RESP2)
+}
+
+// Convert respsonse into the RESP type of the given protocol version.
+// RESP3 only types are downgraded to their closest RESP2 type the way
+// redis does it e.g. maps become flat arrays and booleans become integers.
+func (v Value) MarshalProto(proto int) []byte {
 	switch v.Typ {
 	case "array":
-		return v.marshalArray()
+		return v.marshalAggregate(ARRAY, proto)
 	case "bulk":
 		return v.marshalBulk()
 	case "string":
 		return v.marshalString()
+	case "integer":
+		return v.marshalInteger()
 	case "null":
-		return v.marshallNull()
+		return v.marshallNull(BULK, proto)
+	case "nullarray":
+		return v.marshallNull(ARRAY, proto)
 	case "error":
 		return v.marshallError()
+	case "map":
+		return v.marshalMap(MAP, proto)
+	case "attribute":
+		return v.marshalAttribute(proto)
+	case "set":
+		return v.marshalAggregate(SET, proto)
+	case "push":
+		return v.marshalAggregate(PUSH, proto)
+	case "double":
+		return v.marshalDouble(proto)
+	case "boolean":
+		return v.marshalBoolean(proto)
+	case "bignum":
+		return v.marshalBigNumber(proto)
+	case "verbatim":
+		return v.marshalVerbatim(proto)
 	default:
 		return []byte{}
 	}
 }
 
-// Structure of RESP "error":
+// Structure of RESP "null":
 // _[Carriage Return Line Feed]
-// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#simple-errors
-func (v Value) marshallNull() []byte {
+// RESP2 has no null type so a null bulk string ($-1) or a null
+// array (*-1) is used instead depending on what the command replies.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#nulls
+func (v Value) marshallNull(prefix byte, proto int) []byte {
 	var bytes []byte
+
+	if proto < RESP3 {
+		bytes = append(bytes, prefix, '-', '1')
+		bytes = append(bytes, '\r', '\n')
+		return bytes
+	}
+
 	bytes = append(bytes, NULL)
 	bytes = append(bytes, '\r', '\n')
 	return bytes
 }
 
-// Structure of RESP "nll":
+// Structure of RESP "integer":
+// :[<+|->][value][Carriage Return Line Feed]
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#integers
+func (v Value) marshalInteger() []byte {
+	var bytes []byte
+
+	bytes = append(bytes, INTEGER)
+	bytes = strconv.AppendInt(bytes, int64(v.Num), 10)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// Structure of RESP "error":
 // -[Error Message][Carriage Return Line Feed]
-// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#nulls
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#simple-errors
 func (v Value) marshallError() []byte {
 	var bytes []byte
 
@@ -114,23 +337,64 @@ func (v Value) marshallError() []byte {
 	return bytes
 }
 
-// Structure of RESP "array":
+// Structure of RESP "array", "set" and "push":
 // *[len-of-array][Carriage Return Line Feed][firstElement]...[elementN][Carriage Return Line Feed]
+// sets (~) and pushes (>) are only part of RESP3, RESP2 receives them as arrays.
 // doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#arrays
-func (v Value) marshalArray() []byte {
+func (v Value) marshalAggregate(prefix byte, proto int) []byte {
 	var bytes []byte
 
-	bytes = append(bytes, ARRAY)
+	if proto < RESP3 {
+		prefix = ARRAY
+	}
+
+	bytes = append(bytes, prefix)
 	bytes = append(bytes, strconv.Itoa(len(v.Array))...)
 	bytes = append(bytes, '\r', '\n')
 
 	for i := 0; i < len(v.Array); i++ {
-		bytes = append(bytes, v.Array[i].Marshal()...)
+		bytes = append(bytes, v.Array[i].MarshalProto(proto)...)
 	}
 
 	return bytes
 }
 
+// Structure of RESP "map":
+// %[number-of-entries][Carriage Return Line Feed][key1][value1]...[keyN][valueN]
+// the entries are stored flat in the array (key, value, key, value...)
+// which is also how RESP2 receives them.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#maps
+func (v Value) marshalMap(prefix byte, proto int) []byte {
+	var bytes []byte
+
+	if proto < RESP3 {
+		return v.marshalAggregate(ARRAY, proto)
+	}
+
+	bytes = append(bytes, prefix)
+	bytes = append(bytes, strconv.Itoa(len(v.Array)/2)...)
+	bytes = append(bytes, '\r', '\n')
+
+	for i := 0; i < len(v.Array); i++ {
+		bytes = append(bytes, v.Array[i].MarshalProto(proto)...)
+	}
+
+	return bytes
+}
+
+// Structure of RESP "attribute":
+// |[number-of-entries][Carriage Return Line Feed][key1][value1]...[keyN][valueN]
+// attributes are auxiliary data for the reply that follows them,
+// RESP2 clients do not understand them so nothing is sent.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#attributes
+func (v Value) marshalAttribute(proto int) []byte {
+	if proto < RESP3 {
+		return []byte{}
+	}
+
+	return v.marshalMap(ATTRIBUTE, proto)
+}
+
 // Structure of RESP "string":
 // +[string][Carriage Return Line Feed]
 // doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#simple-strings
@@ -144,7 +408,6 @@ func (v Value) marshalString() []byte {
 	return result
 }
 
-
 // The structure of RESP "bulk":
 // $[len-of-the-bulk-sting][Carriage Return Line Feed][bulk-string][Carriage-Return-Line-Feed]
 // doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#bulk-strings
@@ -160,32 +423,164 @@ func (v Value) marshalBulk() []byte {
 	return bytes
 }
 
-func (r *Resp) readLine() (line []byte, n int, err error) {
+// Structure of RESP "double":
+// ,[floating-point-number][Carriage Return Line Feed]
+// RESP2 receives the number as a bulk string.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#doubles
+func (v Value) marshalDouble(proto int) []byte {
+	var bytes []byte
+
+	if proto < RESP3 {
+		return Value{Typ: "bulk", Bulk: formatDouble(v.Double)}.marshalBulk()
+	}
+
+	bytes = append(bytes, DOUBLE)
+	bytes = append(bytes, formatDouble(v.Double)...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// Structure of RESP "boolean":
+// #[t|f][Carriage Return Line Feed]
+// RESP2 receives the boolean as the integer 1 or 0.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#booleans
+func (v Value) marshalBoolean(proto int) []byte {
+	var bytes []byte
+
+	if proto < RESP3 {
+		bytes = append(bytes, INTEGER)
+		if v.Bool {
+			bytes = append(bytes, '1')
+		} else {
+			bytes = append(bytes, '0')
+		}
+		bytes = append(bytes, '\r', '\n')
+		return bytes
+	}
+
+	bytes = append(bytes, BOOLEAN)
+	if v.Bool {
+		bytes = append(bytes, 't')
+	} else {
+		bytes = append(bytes, 'f')
+	}
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// Structure of RESP "big number":
+// ([big-number][Carriage Return Line Feed]
+// RESP2 receives the number as a bulk string.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#big-numbers
+func (v Value) marshalBigNumber(proto int) []byte {
+	var bytes []byte
+
+	if proto < RESP3 {
+		return Value{Typ: "bulk", Bulk: v.Str}.marshalBulk()
+	}
+
+	bytes = append(bytes, BIGNUMBER)
+	bytes = append(bytes, v.Str...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// Structure of RESP "verbatim string":
+// =[length][Carriage Return Line Feed][format]:[data][Carriage Return Line Feed]
+// the format is always three characters long e.g. "txt" or "mkd",
+// RESP2 receives only the data as a bulk string.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#verbatim-strings
+func (v Value) marshalVerbatim(proto int) []byte {
+	var bytes []byte
+
+	if proto < RESP3 {
+		return Value{Typ: "bulk", Bulk: v.Bulk}.marshalBulk()
+	}
+
+	format := v.Str
+	if format == "" {
+		format = "txt"
+	}
+
+	bytes = append(bytes, VERBATIM)
+	bytes = append(bytes, strconv.Itoa(len(format)+1+len(v.Bulk))...)
+	bytes = append(bytes, '\r', '\n')
+	bytes = append(bytes, format...)
+	bytes = append(bytes, ':')
+	bytes = append(bytes, v.Bulk...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// formats a double the way redis replies with it: the shortest
+// representation, in exponent form only for very large or very
+// small numbers and infinities are sent as "inf" and "-inf".
+func formatDouble(f float64) string {
+	abs := math.Abs(f)
+
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	case abs == 0 || (abs >= 1e-5 && abs < 1e21):
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// reads everything up to and including the next line feed, the line must
+// fit in maxInlineLen so a client cannot make the server buffer an endless line.
+func (r *Resp) readRawLine() ([]byte, error) {
+	var line []byte
+
 	for {
-		byte, err := r.reader.ReadByte()
-		if err != nil {
-			return nil, 0, err
+		chunk, err := r.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > maxInlineLen {
+			return nil, &ProtocolError{Msg: "too big inline request"}
 		}
 
-		n += 1
-		line = append(line, byte)
+		if err == nil {
+			return line, nil
+		}
 
-		// if the line is greater than 2 and the second-to-the-last
-		// character is the 'Carriage Return' break the loop.
-		if len(line) >= 2 && line[len(line)-2] == '\r' {
-			break
+		// the line did not fit in the reader's buffer, keep going.
+		if err != bufio.ErrBufferFull {
+			return nil, err
 		}
 	}
+}
+
+func (r *Resp) readLine() (line []byte, n int, err error) {
+	line, err = r.readRawLine()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n = len(line)
+
+	if n < 2 || line[n-2] != '\r' {
+		return nil, 0, &ProtocolError{Msg: "expected CRLF at the end of the line"}
+	}
 
 	// return from the beginning of the string to the second-to-the-last character
 	// also return the number of characters on the line just read.
-	return line[:len(line)-2], n, nil
+	return line[:n-2], n, nil
 }
 
 func (r *Resp) readInteger() (num int, n int, err error) {
 	line, n, err := r.readLine()
 	if err != nil {
-		return 0, 0, nil
+		return 0, 0, err
 	}
 
 	// convert the integer to a 64-bit integer in base 10.
@@ -197,20 +592,65 @@ func (r *Resp) readInteger() (num int, n int, err error) {
 	return int(_64bitInteger), n, nil
 }
 
+// reads the number of elements of an aggregate, refusing anything
+// above the configured multibulk limit. Negative lengths are left to
+// the caller.
+func (r *Resp) readCount(what string) (int, error) {
+	length, _, err := r.readInteger()
+	if err != nil {
+		if errors.Is(err, strconv.ErrSyntax) || errors.Is(err, strconv.ErrRange) {
+			return 0, &ProtocolError{Msg: "invalid " + what + " length", Err: err}
+		}
+		return 0, err
+	}
+
+	if int64(length) > r.maxMultiBulkLen {
+		return 0, &ProtocolError{Msg: "invalid " + what + " length"}
+	}
+
+	return length, nil
+}
+
+// like readCount, refusing negative lengths other than the -1 of null arrays.
+func (r *Resp) readLength(what string) (int, error) {
+	length, err := r.readCount(what)
+	if err == nil && length < -1 {
+		return 0, &ProtocolError{Msg: "invalid " + what + " length"}
+	}
+	return length, err
+}
+
 func (r *Resp) readArray() (Value, error) {
+	return r.readAggregate("array")
+}
+
+// reads arrays and the RESP3 aggregates sharing their structure (sets and pushes).
+func (r *Resp) readAggregate(typ string) (Value, error) {
 	value := Value{}
-	value.Typ = "array"
+	value.Typ = typ
 
 	// get the len of the array by reading the next character.
 	// ["*", "2", ""]
 	//        ^
 	// the 2 specifies the number of elements in the array request.
-	arr_len, _, err := r.readInteger()
+	arr_len, err := r.readLength("multibulk")
 	if err != nil {
 		return value, err
 	}
 
-	value.Array = make([]Value, 0)
+	if err := r.enterAggregate(); err != nil {
+		return value, err
+	}
+	defer r.leaveAggregate()
+
+	// *-1 is the RESP2 null array.
+	if arr_len < 0 {
+		return Value{Typ: "nullarray"}, nil
+	}
+
+	// the length comes from the client, elements are only
+	// allocated upfront for reasonably sized arrays.
+	value.Array = make([]Value, 0, min(arr_len, maxPrealloc))
 
 	for i := 0; i < arr_len; i++ {
 		// recursion happens here [read the next stream of bytes.]
@@ -225,6 +665,55 @@ func (r *Resp) readArray() (Value, error) {
 	return value, nil
 }
 
+// reads maps and attributes, the number sent is the number of
+// key-value pairs so twice as many values follow it.
+func (r *Resp) readMap(typ string) (Value, error) {
+	value := Value{}
+	value.Typ = typ
+
+	entries, err := r.readLength("multibulk")
+	if err != nil {
+		return value, err
+	}
+
+	// maps have no null, -1 included.
+	if entries < 0 {
+		return value, &ProtocolError{Msg: "invalid multibulk length"}
+	}
+
+	if err := r.enterAggregate(); err != nil {
+		return value, err
+	}
+	defer r.leaveAggregate()
+
+	value.Array = make([]Value, 0, min(entries*2, maxPrealloc))
+
+	for i := 0; i < entries*2; i++ {
+		val, err := r.Read()
+		if err != nil {
+			return value, err
+		}
+
+		value.Array = append(value.Array, val)
+	}
+
+	return value, nil
+}
+
+// counts the aggregate being read, refusing to go deeper than maxNesting
+// so a client cannot exhaust the stack with endlessly nested aggregates.
+func (r *Resp) enterAggregate() error {
+	if r.depth == maxNesting {
+		return &ProtocolError{Msg: "too many nested aggregates"}
+	}
+	r.depth++
+	return nil
+}
+
+func (r *Resp) leaveAggregate() {
+	r.depth--
+}
+
 func (r *Resp) readBulk() (Value, error) {
 	val := Value{}
 	val.Typ = "bulk"
@@ -233,15 +722,267 @@ func (r *Resp) readBulk() (Value, error) {
 	// indicates the length of the string
 	size, _, err := r.readInteger()
 	if err != nil {
+		if errors.Is(err, strconv.ErrSyntax) || errors.Is(err, strconv.ErrRange) {
+			return val, &ProtocolError{Msg: "invalid bulk length", Err: err}
+		}
 		return val, err
 	}
 
-	bulk := make([]byte, size)
-	r.reader.Read(bulk)
-	val.Bulk = string(bulk)
+	// $-1 is the RESP2 null bulk string.
+	if size == -1 {
+		return Value{Typ: "null"}, nil
+	}
+
+	if size < 0 || int64(size) > r.maxBulkLen {
+		return val, &ProtocolError{Msg: "invalid bulk length"}
+	}
+
+	// the buffer grows as the bytes arrive instead of trusting the
+	// announced size, a client lying about it cannot exhaust the memory.
+	var bulk strings.Builder
+	bulk.Grow(min(size, maxPrealloc))
+
+	if _, err := io.CopyN(&bulk, r.reader, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return val, err
+	}
+	val.Bulk = bulk.String()
 
 	//read trailing line [CLRF]
-	r.readLine()
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(r.reader, crlf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return val, err
+	}
+
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return val, &ProtocolError{Msg: "expected CRLF after the bulk string"}
+	}
 
 	return val, nil
 }
+
+// Structure of an inline command:
+// [command] [arg1] ... [argN][Carriage Return Line Feed]
+// the arguments are separated by spaces and can be quoted the same way
+// redis-cli quotes them, the result is the array of bulk strings a client
+// would have sent for the command.
+// doc: https://redis.io/docs/latest/develop/reference/protocol-spec/#inline-commands
+func (r *Resp) readInline() (Value, error) {
+	raw, err := r.readRawLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	// the carriage return is optional for inline commands.
+	line := strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r")
+
+	args, err := splitArgs(line)
+	if err != nil {
+		return Value{}, &ProtocolError{Msg: err.Error() + " in request"}
+	}
+
+	value := Value{Typ: "array", Array: make([]Value, 0, len(args))}
+	for _, arg := range args {
+		value.Array = append(value.Array, Value{Typ: "bulk", Bulk: arg})
+	}
+
+	return value, nil
+}
+
+var errUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// splits a line into arguments separated by whitespace, arguments can be
+// wrapped in double quotes (supporting \n, \r, \t, \b, \a and \xHH escapes)
+// or in single quotes (supporting only \') to include spaces.
+// port of sdssplitargs: https://github.com/redis/redis/blob/unstable/src/sds.c
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		// skip blanks
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			current []byte
+			inDQ    bool // inside "double quotes"
+			inSQ    bool // inside 'single quotes'
+			done    bool
+		)
+
+		for !done {
+			if inDQ {
+				switch {
+				case i == len(line):
+					return nil, errUnbalancedQuotes
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				case line[i] == '"':
+					// closing quote must be followed by a space or nothing at all.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else if inSQ {
+				switch {
+				case i == len(line):
+					return nil, errUnbalancedQuotes
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current = append(current, '\'')
+				case line[i] == '\'':
+					// closing quote must be followed by a space or nothing at all.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else {
+				switch {
+				case i == len(line) || isSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDQ = true
+				case line[i] == '\'':
+					inSQ = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, string(current))
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\v' || b == '\f'
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// reads the types sent on a single line (simple strings, errors and big numbers).
+func (r *Resp) readSimple(typ string) (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{Typ: typ, Str: string(line)}, nil
+}
+
+func (r *Resp) readNumber() (Value, error) {
+	num, _, err := r.readInteger()
+	if err != nil {
+		if errors.Is(err, strconv.ErrSyntax) || errors.Is(err, strconv.ErrRange) {
+			return Value{}, &ProtocolError{Msg: "invalid integer", Err: err}
+		}
+		return Value{}, err
+	}
+
+	return Value{Typ: "integer", Num: num}, nil
+}
+
+func (r *Resp) readNull() (Value, error) {
+	_, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{Typ: "null"}, nil
+}
+
+func (r *Resp) readDouble() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	// ParseFloat understands "inf", "-inf" and "nan" too.
+	double, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return Value{}, &ProtocolError{Msg: "invalid double", Err: err}
+	}
+
+	return Value{Typ: "double", Double: double}, nil
+}
+
+func (r *Resp) readBoolean() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch string(line) {
+	case "t":
+		return Value{Typ: "boolean", Bool: true}, nil
+	case "f":
+		return Value{Typ: "boolean", Bool: false}, nil
+	default:
+		return Value{}, &ProtocolError{Msg: fmt.Sprintf("invalid boolean %q", line)}
+	}
+}
+
+func (r *Resp) readVerbatim() (Value, error) {
+	bulk, err := r.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+
+	// the first three bytes are the format followed by a colon.
+	format, data, ok := strings.Cut(bulk.Bulk, ":")
+	if !ok || len(format) != 3 {
+		return Value{}, &ProtocolError{Msg: "invalid verbatim string format"}
+	}
+
+	return Value{Typ: "verbatim", Str: format, Bulk: data}, nil
+}
+
+// blob errors are errors with the structure of a bulk string.
+func (r *Resp) readBlobError() (Value, error) {
+	bulk, err := r.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{Typ: "error", Str: bulk.Bulk}, nil
+}
@@ -1,8 +1,11 @@
 package lib
 
 import (
+	"io"
+	"math"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,3 +76,286 @@ func TestBulkString_ExpectValueTypeToBeBulk(t *testing.T) {
 		assert.Equal(t, result.Typ, "bulk")
 	})
 }
+
+func TestMarshal_WhenRESP3IsNegotiated(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   Value
+		expects string
+	}{
+		{name: "map", value: Value{Typ: "map", Array: []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "bulk", Bulk: "1"}}}, expects: "%1\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{name: "set", value: Value{Typ: "set", Array: []Value{{Typ: "bulk", Bulk: "a"}}}, expects: "~1\r\n$1\r\na\r\n"},
+		{name: "push", value: Value{Typ: "push", Array: []Value{{Typ: "bulk", Bulk: "a"}}}, expects: ">1\r\n$1\r\na\r\n"},
+		{name: "double", value: Value{Typ: "double", Double: 3.14}, expects: ",3.14\r\n"},
+		{name: "infinite double", value: Value{Typ: "double", Double: math.Inf(-1)}, expects: ",-inf\r\n"},
+		{name: "boolean", value: Value{Typ: "boolean", Bool: true}, expects: "#t\r\n"},
+		{name: "big number", value: Value{Typ: "bignum", Str: "3492890328409238509324850943850943825024385"}, expects: "(3492890328409238509324850943850943825024385\r\n"},
+		{name: "verbatim string", value: Value{Typ: "verbatim", Str: "txt", Bulk: "Some string"}, expects: "=15\r\ntxt:Some string\r\n"},
+		{name: "attribute", value: Value{Typ: "attribute", Array: []Value{{Typ: "bulk", Bulk: "ttl"}, {Typ: "bulk", Bulk: "10"}}}, expects: "|1\r\n$3\r\nttl\r\n$2\r\n10\r\n"},
+		{name: "null", value: Value{Typ: "null"}, expects: "_\r\n"},
+		{name: "null array", value: Value{Typ: "nullarray"}, expects: "_\r\n"},
+		{name: "integer", value: Value{Typ: "integer", Num: 1000}, expects: ":1000\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run("It encodes the "+test.name+" with its RESP3 type", func(t *testing.T) {
+			assert.Equal(t, test.expects, string(test.value.MarshalProto(RESP3)))
+		})
+	}
+}
+
+func TestMarshal_WhenRESP2IsNegotiated(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   Value
+		expects string
+	}{
+		{name: "map as a flat array", value: Value{Typ: "map", Array: []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "bulk", Bulk: "1"}}}, expects: "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{name: "set as an array", value: Value{Typ: "set", Array: []Value{{Typ: "bulk", Bulk: "a"}}}, expects: "*1\r\n$1\r\na\r\n"},
+		{name: "double as a bulk string", value: Value{Typ: "double", Double: 3.14}, expects: "$4\r\n3.14\r\n"},
+		{name: "boolean as an integer", value: Value{Typ: "boolean", Bool: true}, expects: ":1\r\n"},
+		{name: "big number as a bulk string", value: Value{Typ: "bignum", Str: "12345"}, expects: "$5\r\n12345\r\n"},
+		{name: "verbatim string as a bulk string", value: Value{Typ: "verbatim", Str: "txt", Bulk: "hi"}, expects: "$2\r\nhi\r\n"},
+		{name: "attribute as nothing", value: Value{Typ: "attribute", Array: []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "bulk", Bulk: "1"}}}, expects: ""},
+		{name: "null as a null bulk string", value: Value{Typ: "null"}, expects: "$-1\r\n"},
+		{name: "null array as a null array", value: Value{Typ: "nullarray"}, expects: "*-1\r\n"},
+		{name: "integer", value: Value{Typ: "integer", Num: -42}, expects: ":-42\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run("It encodes the "+test.name, func(t *testing.T) {
+			assert.Equal(t, test.expects, string(test.value.Marshal()))
+		})
+	}
+}
+
+func TestRead_WhenRESP3TypesAreSent(t *testing.T) {
+	t.Run("It reads every RESP3 type back into the value it was marshalled from", func(t *testing.T) {
+		values := []Value{
+			{Typ: "map", Array: []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "bulk", Bulk: "1"}}},
+			{Typ: "set", Array: []Value{{Typ: "bulk", Bulk: "a"}}},
+			{Typ: "push", Array: []Value{{Typ: "bulk", Bulk: "message"}}},
+			{Typ: "double", Double: -1.5},
+			{Typ: "boolean", Bool: false},
+			{Typ: "bignum", Str: "3492890328409238509324850943850943825024385"},
+			{Typ: "verbatim", Str: "mkd", Bulk: "# title"},
+			{Typ: "attribute", Array: []Value{{Typ: "bulk", Bulk: "ttl"}, {Typ: "double", Double: 1}}},
+			{Typ: "null"},
+			{Typ: "integer", Num: -7},
+		}
+
+		for _, value := range values {
+			encoded := string(value.MarshalProto(RESP3))
+			result, err := NewResp(strings.NewReader(encoded)).Read()
+
+			assert.Nil(t, err)
+			assert.Equal(t, value, result)
+		}
+	})
+
+	t.Run("It reads the RESP2 null bulk string and null array as nulls", func(t *testing.T) {
+		result, err := NewResp(strings.NewReader("$-1\r\n")).Read()
+		assert.Nil(t, err)
+		assert.Equal(t, "null", result.Typ)
+
+		result, err = NewResp(strings.NewReader("*-1\r\n")).Read()
+		assert.Nil(t, err)
+		assert.Equal(t, "nullarray", result.Typ)
+	})
+}
+
+func TestRead_WhenInlineCommandIsSent(t *testing.T) {
+	t.Run("It parses a telnet style command into an array of bulk strings", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("PING\r\n"))
+		result, err := resp.Read()
+
+		assert.Nil(t, err)
+		assert.Equal(t, Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: "PING"}}}, result)
+	})
+
+	t.Run("It splits the arguments on spaces and accepts a bare line feed", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("set   admin  king\n"))
+		result, err := resp.Read()
+
+		assert.Nil(t, err)
+		assert.Len(t, result.Array, 3)
+		assert.Equal(t, "set", result.Array[0].Bulk)
+		assert.Equal(t, "admin", result.Array[1].Bulk)
+		assert.Equal(t, "king", result.Array[2].Bulk)
+	})
+
+	t.Run("It keeps quoted arguments together and resolves escapes", func(t *testing.T) {
+		resp := NewResp(strings.NewReader(`set "hello world" 'it\'s' "a\tb\x41" ""` + "\r\n"))
+		result, err := resp.Read()
+
+		assert.Nil(t, err)
+		assert.Len(t, result.Array, 5)
+		assert.Equal(t, "hello world", result.Array[1].Bulk)
+		assert.Equal(t, "it's", result.Array[2].Bulk)
+		assert.Equal(t, "a\tbA", result.Array[3].Bulk)
+		assert.Equal(t, "", result.Array[4].Bulk)
+	})
+
+	t.Run("It returns an empty array for an empty line", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("\r\n"))
+		result, err := resp.Read()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "array", result.Typ)
+		assert.Len(t, result.Array, 0)
+	})
+
+	t.Run("It returns a protocol error for unbalanced quotes", func(t *testing.T) {
+		for _, line := range []string{`set "key value` + "\r\n", `set 'key' 'value` + "\r\n", `set "key"value` + "\r\n"} {
+			resp := NewResp(strings.NewReader(line))
+			_, err := resp.Read()
+
+			assert.ErrorContains(t, err, "unbalanced quotes")
+		}
+	})
+
+	t.Run("It reads inline and RESP commands sent on the same connection", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("PING\r\n*1\r\n$4\r\nPING\r\n"))
+
+		inline, err := resp.Read()
+		assert.Nil(t, err)
+
+		multibulk, err := resp.Read()
+		assert.Nil(t, err)
+
+		assert.Equal(t, inline, multibulk)
+	})
+}
+
+func TestReadBulk_WhenTheClientSendsItInPieces(t *testing.T) {
+	t.Run("It reads the whole bulk string even when the reads come back short", func(t *testing.T) {
+		value := strings.Repeat("minired", 10000)
+		encoded := string(Value{Typ: "bulk", Bulk: value}.Marshal())
+
+		resp := NewResp(iotest.OneByteReader(strings.NewReader(encoded)))
+		result, err := resp.Read()
+
+		assert.Nil(t, err)
+		assert.Equal(t, value, result.Bulk)
+	})
+
+	t.Run("It keeps binary data including CRLF inside the bulk string", func(t *testing.T) {
+		value := "a\r\nb\x00c"
+		encoded := string(Value{Typ: "bulk", Bulk: value}.Marshal())
+
+		result, err := NewResp(strings.NewReader(encoded)).Read()
+
+		assert.Nil(t, err)
+		assert.Equal(t, value, result.Bulk)
+	})
+
+	t.Run("It returns an unexpected EOF when the connection ends mid bulk string", func(t *testing.T) {
+		_, err := NewResp(strings.NewReader("$10\r\nabc")).Read()
+
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestRead_WhenLimitsAreExceeded(t *testing.T) {
+	t.Run("It refuses a bulk string longer than proto-max-bulk-len without allocating it", func(t *testing.T) {
+		_, err := NewResp(strings.NewReader("$999999999999\r\n")).Read()
+
+		var protoErr *ProtocolError
+		assert.ErrorAs(t, err, &protoErr)
+		assert.Equal(t, "invalid bulk length", protoErr.Msg)
+	})
+
+	t.Run("It refuses arrays with more elements than the multibulk limit", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"))
+		resp.SetLimits(DefaultProtoMaxBulkLen, 2)
+		_, err := resp.Read()
+
+		var protoErr *ProtocolError
+		assert.ErrorAs(t, err, &protoErr)
+		assert.Equal(t, "invalid multibulk length", protoErr.Msg)
+	})
+
+	t.Run("It uses the configured bulk limit", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("$5\r\nhello\r\n"))
+		resp.SetLimits(4, DefaultProtoMaxMultiBulkLen)
+		_, err := resp.Read()
+
+		assert.ErrorContains(t, err, "Protocol error: invalid bulk length")
+	})
+
+	t.Run("It refuses lines that never end", func(t *testing.T) {
+		_, err := NewResp(strings.NewReader(strings.Repeat("a", maxInlineLen+1))).Read()
+
+		assert.ErrorContains(t, err, "too big inline request")
+	})
+
+	t.Run("It refuses a bulk string not followed by CRLF", func(t *testing.T) {
+		_, err := NewResp(strings.NewReader("$3\r\nabcde\r\n")).Read()
+
+		assert.ErrorContains(t, err, "Protocol error")
+	})
+}
+
+func TestRead_WhenLengthsAreNegative(t *testing.T) {
+	t.Run("It refuses negative lengths instead of panicking", func(t *testing.T) {
+		for _, input := range []string{"%-1\r\n", "|-3\r\n", "*-2\r\n", "~-5\r\n", ">-9223372036854775808\r\n"} {
+			var err error
+			assert.NotPanics(t, func() {
+				_, err = NewResp(strings.NewReader(input)).Read()
+			}, input)
+
+			var protoErr *ProtocolError
+			assert.ErrorAs(t, err, &protoErr, input)
+			assert.Equal(t, "invalid multibulk length", protoErr.Msg, input)
+		}
+	})
+
+	t.Run("It refuses aggregates nested too deeply", func(t *testing.T) {
+		_, err := NewResp(strings.NewReader(strings.Repeat("*1\r\n", maxNesting+1) + ":1\r\n")).Read()
+		assert.ErrorContains(t, err, "too many nested aggregates")
+
+		result, err := NewResp(strings.NewReader(strings.Repeat("*1\r\n", maxNesting) + ":1\r\n")).Read()
+		assert.Nil(t, err)
+		assert.Equal(t, "array", result.Typ)
+	})
+}
+
+func TestReadCommand(t *testing.T) {
+	t.Run("It reads multibulks of bulk strings and inline commands", func(t *testing.T) {
+		resp := NewResp(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\nk\r\nPING\r\n*0\r\n*-5\r\n"))
+
+		result, err := resp.ReadCommand()
+		assert.Nil(t, err)
+		assert.Equal(t, Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: "GET"}, {Typ: "bulk", Bulk: "k"}}}, result)
+
+		result, err = resp.ReadCommand()
+		assert.Nil(t, err)
+		assert.Equal(t, "PING", result.Array[0].Bulk)
+
+		// like redis, negative lengths are empty requests too.
+		for i := 0; i < 2; i++ {
+			result, err = resp.ReadCommand()
+			assert.Nil(t, err)
+			assert.Empty(t, result.Array)
+		}
+	})
+
+	t.Run("It refuses arguments that are not bulk strings", func(t *testing.T) {
+		inputs := map[string]string{
+			"*1\r\n:1\r\n":            "expected '$', got ':'",
+			"*1\r\n%-1\r\n":           "expected '$', got '%'",
+			"*1\r\n*1\r\n$1\r\na\r\n": "expected '$', got '*'",
+			"*1\r\n$-1\r\n":           "invalid bulk length",
+		}
+		for input, msg := range inputs {
+			var err error
+			assert.NotPanics(t, func() {
+				_, err = NewResp(strings.NewReader(input)).ReadCommand()
+			}, input)
+
+			var protoErr *ProtocolError
+			assert.ErrorAs(t, err, &protoErr, input)
+			assert.Equal(t, msg, protoErr.Msg, input)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the parsed arguments of the SCAN family of commands.
+type scanOptions struct {
+	cursor   uint64
+	pattern  string // empty when every element matches.
+	count    int
+	noValues bool
+	typ      string // empty when keys of every type match.
+}
+
+// parses "cursor [MATCH pattern] [COUNT count]" for the scan command, with
+// NOVALUES too for HSCAN and TYPE for SCAN. COUNT is a hint of the number
+// of elements returned.
+func parseScanOptions(args []Value, command string) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+
+	cursor, err := strconv.ParseUint(args[0].Bulk, 10, 64)
+	if err != nil {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+
+		switch {
+		case option == "match" && i+1 < len(args):
+			opts.pattern = args[i+1].Bulk
+			if opts.pattern == "*" {
+				opts.pattern = ""
+			}
+			i++
+		case option == "count" && i+1 < len(args):
+			count, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if count < 1 {
+				return opts, errSyntax
+			}
+			opts.count = count
+			i++
+		case option == "novalues" && command == "hscan":
+			opts.noValues = true
+		case option == "type" && command == "scan" && i+1 < len(args):
+			opts.typ = strings.ToLower(args[i+1].Bulk)
+			if !slices.Contains(keyTypes, opts.typ) {
+				return opts, fmt.Errorf("ERR unknown type name '%s'", args[i+1].Bulk)
+			}
+			i++
+		default:
+			return opts, errSyntax
+		}
+	}
+
+	return opts, nil
+}
+
+func (opts scanOptions) match(key string) bool {
+	return opts.pattern == "" || stringMatch(opts.pattern, key, false)
+}
+
+// scans the dict from the cursor until about count entries were visited
+// or the whole dict was, and returns the cursor to continue from. Like
+// redis it visits at most ten times count buckets so scanning a sparse
+// table does not hold the store for long.
+func scanDict[V any](d *dict[V], cursor uint64, count int, fn func(key string, value V)) uint64 {
+	visited := 0
+	for maxBuckets := count * 10; maxBuckets > 0; maxBuckets-- {
+		cursor = d.scan(cursor, func(key string, value V) {
+			visited++
+			fn(key, value)
+		})
+
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+	return cursor
+}
+
+// the reply of the SCAN family, the cursor to continue from and the elements.
+func scanReply(cursor uint64, elements []Value) Value {
+	return Value{Typ: "array", Array: []Value{
+		{Typ: "bulk", Bulk: strconv.FormatUint(cursor, 10)},
+		{Typ: "array", Array: elements},
+	}}
+}
@@ -2,39 +2,72 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// the version reported to clients, kept in sync with the README badge.
+const Version = "0.0.1"
+
 type WriterFunc func(w io.Writer) *Writer
 
 type Server struct {
 	mu          sync.RWMutex
 	ListenAddr  string
+	config      *Config
 	ln          net.Listener
 	quitChan    chan struct{}
 	aof         *AppendOnlyFile
-	queue       []Value
-	tranMode    bool
 	spawnWriter WriterFunc
-	writer      *Writer
+
+	clients      map[int64]*Client // connected clients by id.
+	nextClientID atomic.Int64
+	clientsWG    sync.WaitGroup // running connections, waited for on shutdown.
+
+	blocking *blockingKeys // clients blocked by the blocking list commands.
+
+	// the databases clients SELECT, the first one is KvStore.
+	databases []*SimpleStore
+
+	// cancelled on shutdown, the contexts of the clients derive from it.
+	ctx          context.Context
+	cancel       context.CancelFunc
+	shuttingDown atomic.Bool
+	shutdownOnce sync.Once
 }
 
-func NewServer(addr string) Server {
-	return Server{
+func NewServer(config *Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	databases := []*SimpleStore{KvStore}
+	for id := 1; id < int(config.GetInt("databases")); id++ {
+		databases = append(databases, newSimpleStore(id))
+	}
+
+	return &Server{
 		mu:          sync.RWMutex{},
-		ListenAddr:  addr,
+		ListenAddr:  config.Addr(),
+		config:      config,
 		quitChan:    make(chan struct{}),
 		aof:         nil,
-		queue:       make([]Value, 0),
-		tranMode:    false, //the server starts in normal mode.
 		spawnWriter: NewWriter,
+		clients:     make(map[int64]*Client),
+		blocking:    newBlockingKeys(),
+		databases:   databases,
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
+// Start serves clients until the server is shut down, either by the
+// SHUTDOWN command or by calling Shutdown. It returns once every client
+// is disconnected and the AOF is safely on the disk.
 func (s *Server) Start() error {
 	//create a tcp ln on port 6379
 	ln, err := net.Listen("tcp", s.ListenAddr)
@@ -43,15 +76,63 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	s.mu.Lock()
 	s.ln = ln
+	s.mu.Unlock()
 
-	s.aof = s.createAOF("minired.aof")
+	if s.config.GetBool("appendonly") {
+		s.aof = s.createAOF(s.config.AppendOnlyPath())
+	}
 
+	go s.cron()
 	go s.acceptConn()
 
 	<-s.quitChan
 
-	defer ln.Close()
+	return s.drain()
+}
+
+// Shutdown makes Start stop accepting connections, let the running
+// commands finish and return. It can be called more than once.
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		s.shuttingDown.Store(true)
+		s.cancel()
+		close(s.quitChan)
+	})
+}
+
+// Addr returns the address the server listens on, nil before Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// closes the listener and the connections then persists the AOF.
+func (s *Server) drain() error {
+	s.ln.Close()
+
+	// idle clients are waiting for their next command, the deadline wakes
+	// them up. Busy ones notice the shutdown once their command is done.
+	s.mu.RLock()
+	for _, c := range s.clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	s.mu.RUnlock()
+
+	s.clientsWG.Wait()
+
+	if s.aof != nil {
+		if err := s.aof.Close(); err != nil {
+			fmt.Println("AOF_ERROR", err)
+			return err
+		}
+	}
 
 	return nil
 }
@@ -60,140 +141,312 @@ func (s *Server) acceptConn() {
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
+			if s.shuttingDown.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// e.g. too many open files, give the system a moment to recover.
 			fmt.Println("CONNECTION_ERROR", err)
+			time.Sleep(10 * time.Millisecond)
 			continue
 		}
-		go s.readConn(conn)
+
+		s.clientsWG.Add(1)
+		go func() {
+			defer s.clientsWG.Done()
+			s.readConn(conn)
+		}()
 	}
 }
 
 func (s *Server) readConn(conn net.Conn) {
 	defer conn.Close()
 
-	for {
-		resp := NewResp(conn)
-		value, err := resp.Read()
+	// every connection gets its own session, one parser per connection
+	// so pipelined commands are never lost between reads.
+	c := s.newClient(conn)
+	defer c.cancel()
+	defer c.writer.Flush()
+
+	if !s.addClient(c) {
+		c.writer.Write(Value{Typ: "error", Str: "ERR max number of clients reached"})
+		return
+	}
+	defer s.removeClient(c)
+
+	// commands are not run once the server is shutting down,
+	// the one running when it started is allowed to finish.
+	for !s.shuttingDown.Load() {
+		// the limits are read again for every command so CONFIG SET
+		// applies to the connections already open.
+		c.resp.SetLimits(s.config.GetInt("proto-max-bulk-len"), s.config.GetInt("proto-max-multibulk-len"))
+
+		value, err := c.resp.ReadCommand()
 		if err != nil {
-			if err == io.EOF {
+			if s.shuttingDown.Load() {
 				break
 			}
-			fmt.Println("READ_ERROR", err)
-			continue
+
+			// the client is told what went wrong before being disconnected,
+			// nothing it sends afterwards can be parsed reliably.
+			var protoErr *ProtocolError
+			if errors.As(err, &protoErr) {
+				c.writer.Write(Value{Typ: "error", Str: "ERR Protocol error: " + protoErr.Msg})
+			}
+
+			if err != io.EOF {
+				fmt.Println("READ_ERROR", err)
+			}
+			break
 		}
 
 		// if the request sent is not an array type ignore it
 		// or if the request is empty.
-		if value.Typ != "array" || len(value.Array) == 0 {
-			continue
+		if value.Typ == "array" && len(value.Array) > 0 {
+			result := s.handleCommandExecution(c, value)
+			c.writer.Write(result)
 		}
 
-		s.writer = s.spawnWriter(conn)
-		result := s.handleCommandExecution(value)
-		s.writer.Write(result)
+		// replies are only sent once every pipelined command
+		// has been executed, one write for the whole batch.
+		if c.resp.Buffered() == 0 {
+			if err := c.writer.Flush(); err != nil {
+				fmt.Println("WRITE_ERROR", err)
+				break
+			}
+		}
 	}
 }
 
-func (s *Server) handleCommandExecution(value Value) Value {
+// registers the client unless maxclients clients are already connected.
+func (s *Server) addClient(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int64(len(s.clients)) >= s.config.GetInt("maxclients") {
+		return false
+	}
+
+	s.clients[c.id] = c
+	return true
+}
+
+func (s *Server) removeClient(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c.id)
+}
+
+func (s *Server) handleCommandExecution(c *Client, value Value) Value {
 	command := strings.ToLower(value.Array[0].Bulk)
 
+	// the lists pushed to by the command, or by the whole
+	// transaction, are handed to the clients blocked on them.
+	defer s.serveBlockedClients(c)
+
 	if command == "multi" {
-		s.turnOnTranMode()
-		s.clearQueue() //clear queue at every transaction initiaition
-		return Value{Typ: "string", Str: "Ok"}
+		if c.hasFlag(ClientMulti) {
+			return Value{Typ: "error", Str: "ERR MULTI calls can not be nested"}
+		}
+		c.discardTransaction() //clear queue at every transaction initiaition
+		c.addFlag(ClientMulti)
+		return Value{Typ: "string", Str: "OK"}
 	}
 
 	if command == "exec" {
-		results := s.executeQueuedCommands()
-		s.turnOffTranMode()
-		s.clearQueue()
+		if !c.hasFlag(ClientMulti) {
+			return Value{Typ: "error", Str: "ERR EXEC without MULTI"}
+		}
+
+		if c.hasFlag(ClientDirtyExec) {
+			c.discardTransaction()
+			return Value{Typ: "error", Str: "EXECABORT Transaction discarded because of previous errors."}
+		}
+
+		results := s.executeQueuedCommands(c)
+		c.discardTransaction()
 		return results
 	}
 
 	if command == "discard" {
-		s.turnOffTranMode()
-		s.clearQueue()
-		return Value{Typ: "string", Str: "Ok"}
+		if !c.hasFlag(ClientMulti) {
+			return Value{Typ: "error", Str: "ERR DISCARD without MULTI"}
+		}
+		c.discardTransaction()
+		return Value{Typ: "string", Str: "OK"}
 	}
 
-	if s.isInTranscMode() {
-		s.enqeueCommand(value)
-		return Value{Typ: "string", Str: "Queued"}
-	}
+	if c.hasFlag(ClientMulti) {
+		// unknown commands are refused right away and doom the transaction.
+		if _, ok := CommandHandlers[command]; !ok {
+			c.addFlag(ClientDirtyExec)
+			return unknownCommand(value.Array[0].Bulk, value.Array[1:])
+		}
+
+		if noMultiCommands[command] {
+			c.addFlag(ClientDirtyExec)
+			return Value{Typ: "error", Str: "ERR Command not allowed inside a transaction"}
+		}
 
-	if command == "set" || command == "hset" {
-		s.aof.Write(value)
+		c.queue = append(c.queue, value)
+		return Value{Typ: "string", Str: "QUEUED"}
 	}
 
-	result := s.execCommand(value)
-	return result
+	return s.call(c, value)
 }
 
-func (s *Server) clearQueue() {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	s.queue = s.queue[:0] //clear the queue
+// commands that cannot be queued by MULTI.
+var noMultiCommands = map[string]bool{
+	"shutdown": true,
 }
 
-func (s *Server) executeQueuedCommands() Value {
+func (s *Server) executeQueuedCommands(c *Client) Value {
 	results := Value{Typ: "array"}
 
-	for _, value := range s.queue {
+	// the queue runs with the shards of its keys locked, the writes of
+	// other clients do not interleave with its commands and the AOF gets
+	// them together.
+	unlock := s.lockQueue(c)
+	c.holdsQueueShards = true
+	defer func() {
+		c.holdsQueueShards = false
+		unlock()
+	}()
+
+	for _, value := range c.queue {
+		result := s.call(c, value)
+		results.Array = append(results.Array, result)
+	}
 
-		result := s.execCommand(value)
+	return results
+}
 
+// locks the shards of the keys of the queued commands for writing, in
+// the database each runs in, or every shard once one of them works on
+// the whole keyspace. The returned function unlocks them.
+func (s *Server) lockQueue(c *Client) (unlock func()) {
+	db := c.db
+	var shards []*shard
+	for _, value := range c.queue {
 		command := strings.ToLower(value.Array[0].Bulk)
-		if command == "set" || command == "hset" {
-			s.aof.Write(value)
+		args := value.Array[1:]
+
+		keys, ok := commandKeys[command]
+		if !ok {
+			return lockDatabases(c.ctx, s.databases...)
 		}
 
-		results.Array = append(results.Array, result)
+		// the commands following SELECT run in the database it picks.
+		if command == "select" && len(args) == 1 {
+			if selected, err := parseDBIndex(args[0].Bulk, s.databases); err == nil {
+				db = selected.id
+			}
+		}
+		shards = append(shards, s.databases[db].shardsOf(keys(args)...)...)
 	}
+	return lockShards(c.ctx, true, shards...)
+}
 
-	return results
+// runs a command and records it in the AOF when it changed the dataset.
+func (s *Server) call(c *Client, value Value) Value {
+	c.propagated, c.rewritten = nil, false
+
+	// the shards written stay locked until the command is in the AOF.
+	c.holdShards = true
+	defer func() {
+		c.holdShards = false
+		c.releaseShards()
+	}()
+
+	result := s.execCommand(c, value)
+
+	command := strings.ToLower(value.Array[0].Bulk)
+	if !writeCommands[command] || result.Typ == "error" {
+		return result
+	}
+
+	if !c.rewritten {
+		s.propagate(c.db, value)
+		return result
+	}
+
+	for _, v := range c.propagated {
+		s.propagate(c.db, v)
+	}
+	return result
 }
 
-func (s *Server) execCommand(value Value) Value {
+func (s *Server) execCommand(c *Client, value Value) Value {
 	command := strings.ToLower(value.Array[0].Bulk)
 	args := value.Array[1:]
 
 	// get the handler for the command .
 	handler, ok := CommandHandlers[command]
 	if !ok {
-		fmt.Printf("Command not supported [%s]\n", command)
-		return Value{Typ: "string", Str: ""}
+		return unknownCommand(value.Array[0].Bulk, args)
 	}
 
-	// and feed it the arguements
-	result := handler(context.Background(), args)
+	// and feed it the arguements, the context tells the handler
+	// which client is running the command.
+	result := handler(contextWithClient(c.ctx, c), args)
 	return result
 }
 
-func (s *Server) isInTranscMode() bool {
-	return s.tranMode
-}
+// records a write command that ran in the database db in the AOF when
+// persistence is on.
+func (s *Server) propagate(db int, value Value) {
+	if s.aof == nil {
+		return
+	}
 
-func (s *Server) turnOnTranMode() bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if err := s.aof.WriteIn(db, value); err != nil {
+		fmt.Println("AOF_ERROR", err)
+	}
+}
 
-	if s.isInTranscMode() {
-		return true
+// propagateAs makes the running command be written in the AOF as the given
+// commands instead of the way it was sent, e.g. relative expire times are
+// written as unix times so replaying the AOF later gives the same dataset.
+// Without commands nothing is written.
+func propagateAs(ctx context.Context, commands ...Value) {
+	c := clientFromContext(ctx)
+	if c == nil {
+		return
 	}
-	s.tranMode = true
 
-	return true
+	c.propagated = commands
+	c.rewritten = true
 }
 
-func (s *Server) turnOffTranMode() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.tranMode = false
+// runs the background tasks of the server hz times a second until it
+// shuts down, the frequency is read again at every tick so CONFIG SET
+// applies right away.
+func (s *Server) cron() {
+	for {
+		period := time.Second / time.Duration(s.config.GetInt("hz"))
+
+		select {
+		case <-s.quitChan:
+			return
+		case <-time.After(period):
+		}
+
+		// keys and hash fields share the time of the cycle, every
+		// database gets its part.
+		budget := period * activeExpireCycleSlowTimePerc / 100 / time.Duration(len(s.databases))
+		for _, db := range s.databases {
+			db.activeExpireCycle(budget / 2)
+			db.activeExpireHashFields(budget / 2)
+		}
+	}
 }
 
-func (s *Server) enqeueCommand(args Value) {
-	s.mu.Lock()
-	s.queue = append(s.queue, args)
-	s.mu.Unlock()
+// pushes the parameters changed by CONFIG SET to the parts of
+// the server that do not read the configuration on their own.
+func (s *Server) applyConfig() {
+	if s.aof != nil {
+		s.aof.SetFsyncPolicy(s.config.Get("appendfsync"))
+	}
 }
 
 func (s *Server) createAOF(path string) *AppendOnlyFile {
@@ -202,12 +455,56 @@ func (s *Server) createAOF(path string) *AppendOnlyFile {
 		fmt.Println("Error: ", err)
 		return nil
 	}
+	aof.SetFsyncPolicy(s.config.Get("appendfsync"))
 
 	// execute every write entry in the log file to populate the
 	// store with the data before the server was shutdown.
+	for _, db := range s.databases {
+		db.loading.Store(true)
+	}
+	c := s.newFakeClient()
 	aof.Read(func(value Value) {
-		s.execCommand(value)
+		s.execCommand(c, value)
 	})
+	for _, db := range s.databases {
+		db.loading.Store(false)
+	}
 
 	return aof
 }
+
+// doc: https://redis.io/docs/latest/commands/shutdown/
+func shutdown(ctx context.Context, args []Value) Value {
+	c := clientFromContext(ctx)
+	if c == nil {
+		return Value{Typ: "error", Str: "ERR SHUTDOWN requires a connection"}
+	}
+
+	force := false
+	for _, arg := range args {
+		switch strings.ToLower(arg.Bulk) {
+		// there are no snapshots to save or skip, only the AOF.
+		case "save", "nosave":
+		// there are no replicas to wait for.
+		case "now":
+		case "force":
+			force = true
+		default:
+			return Value{Typ: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	// the shutdown is refused when the writes cannot reach the disk,
+	// unless the client is willing to lose them.
+	if c.srv.aof != nil {
+		if err := c.srv.aof.Sync(); err != nil && !force {
+			fmt.Println("AOF_ERROR", err)
+			return Value{Typ: "error", Str: "ERR Errors trying to SHUTDOWN. Check logs."}
+		}
+	}
+
+	c.srv.Shutdown()
+
+	// the connection is closed without a reply like redis does.
+	return Value{}
+}
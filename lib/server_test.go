@@ -1 +1,261 @@
 package lib
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a client connected to the server through an in-memory connection.
+func testClient(t *testing.T, server *Server) *Client {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	return server.newClient(conn)
+}
+
+// counts the writes made on the connection to check replies are batched.
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestReadConn_WhenCommandsArePipelined(t *testing.T) {
+	t.Run("It replies to every pipelined command in a single write", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		client, conn := net.Pipe()
+		counting := &countingConn{Conn: conn}
+		go server.readConn(counting)
+		defer client.Close()
+
+		commands := strings.Repeat("*1\r\n$4\r\nPING\r\n", 10) + "PING\r\n"
+		_, err := client.Write([]byte(commands))
+		assert.Nil(t, err)
+
+		reader := bufio.NewReader(client)
+		for i := 0; i < 11; i++ {
+			line, err := reader.ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, "+PONG\r\n", line)
+		}
+
+		assert.Equal(t, int32(1), counting.writes.Load())
+	})
+}
+
+func TestReadConn_WhenTheClientBreaksTheProtocol(t *testing.T) {
+	t.Run("It replies with a protocol error and closes the connection", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		client, conn := net.Pipe()
+		go server.readConn(conn)
+		defer client.Close()
+
+		go client.Write([]byte("*1\r\n$999999999999\r\n"))
+
+		reader := bufio.NewReader(client)
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "-ERR Protocol error: invalid bulk length\r\n", line)
+
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestReadConn_WhenTheLimitsAreChanged(t *testing.T) {
+	t.Run("It applies CONFIG SET to the connections already open", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		client, conn := net.Pipe()
+		go server.readConn(conn)
+		defer client.Close()
+
+		reader := bufio.NewReader(client)
+		go client.Write([]byte("PING\r\n"))
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "+PONG\r\n", line)
+
+		other := testClient(t, server)
+		assert.Equal(t, "OK", server.handleCommandExecution(other, command("CONFIG", "SET", "proto-max-multibulk-len", "2")).Str)
+
+		go client.Write([]byte("*3\r\n$4\r\nECHO\r\n$1\r\na\r\n$1\r\nb\r\n"))
+		line, err = reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "-ERR Protocol error: invalid multibulk length\r\n", line)
+	})
+}
+
+func TestExecCommand_WhenTheCommandIsUnknown(t *testing.T) {
+	t.Run("It replies with the error redis sends for unknown commands", func(t *testing.T) {
+		server := NewServer(NewConfig())
+
+		result := server.execCommand(testClient(t, server), command("FOO", "bar"))
+
+		assert.Equal(t, "error", result.Typ)
+		assert.Equal(t, "ERR unknown command 'FOO', with args beginning with: 'bar' ", result.Str)
+	})
+}
+
+func TestHandleCommandExecution_WhenClientsUseTransactions(t *testing.T) {
+	t.Run("It only queues the commands of the client that sent MULTI", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		first := testClient(t, server)
+		second := testClient(t, server)
+
+		assert.Equal(t, "OK", server.handleCommandExecution(first, command("MULTI")).Str)
+		assert.Equal(t, "QUEUED", server.handleCommandExecution(first, command("PING")).Str)
+
+		result := server.handleCommandExecution(second, command("PING"))
+		assert.Equal(t, "PONG", result.Str)
+
+		result = server.handleCommandExecution(first, command("EXEC"))
+		assert.Equal(t, "array", result.Typ)
+		assert.Len(t, result.Array, 1)
+		assert.Equal(t, "PONG", result.Array[0].Str)
+	})
+
+	t.Run("It refuses nested MULTI and EXEC or DISCARD without MULTI", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		assert.Equal(t, "ERR EXEC without MULTI", server.handleCommandExecution(c, command("EXEC")).Str)
+		assert.Equal(t, "ERR DISCARD without MULTI", server.handleCommandExecution(c, command("DISCARD")).Str)
+
+		server.handleCommandExecution(c, command("MULTI"))
+		assert.Equal(t, "ERR MULTI calls can not be nested", server.handleCommandExecution(c, command("MULTI")).Str)
+	})
+
+	t.Run("It aborts EXEC when an unknown command was queued", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("MULTI"))
+		result := server.handleCommandExecution(c, command("NOTACOMMAND"))
+		assert.Equal(t, "error", result.Typ)
+
+		result = server.handleCommandExecution(c, command("EXEC"))
+		assert.Contains(t, result.Str, "EXECABORT")
+		assert.False(t, c.hasFlag(ClientMulti))
+	})
+
+	t.Run("It leaves the transaction without running anything on DISCARD", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("MULTI"))
+		server.handleCommandExecution(c, command("PING"))
+		result := server.handleCommandExecution(c, command("DISCARD"))
+
+		assert.Equal(t, "OK", result.Str)
+		assert.Empty(t, c.queue)
+		assert.False(t, c.hasFlag(ClientMulti))
+	})
+}
+
+// starts a server on a random port storing its AOF in a temporary directory.
+func startServer(t *testing.T) (*Server, chan error) {
+	config, err := LoadConfig([]string{"--bind", "127.0.0.1", "--port", "0", "--dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(config)
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+
+	assert.Eventually(t, func() bool { return server.Addr() != nil }, time.Second, time.Millisecond)
+	t.Cleanup(server.Shutdown)
+
+	return server, done
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("It persists the AOF and returns from Start on SHUTDOWN", func(t *testing.T) {
+		server, done := startServer(t)
+
+		conn, err := net.Dial("tcp", server.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("SET shutdown-key value\r\nSHUTDOWN NOSAVE\r\n"))
+		assert.Nil(t, err)
+
+		reader := bufio.NewReader(conn)
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "+OK\r\n", line)
+
+		// no reply to SHUTDOWN, the connection is closed.
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Start did not return after SHUTDOWN")
+		}
+
+		content, err := os.ReadFile(server.config.AppendOnlyPath())
+		assert.Nil(t, err)
+		assert.Contains(t, string(content), "shutdown-key")
+	})
+
+	t.Run("It disconnects idle clients when Shutdown is called", func(t *testing.T) {
+		server, done := startServer(t)
+
+		conn, err := net.Dial("tcp", server.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		// make sure the connection is served before shutting down.
+		_, err = conn.Write([]byte("PING\r\n"))
+		assert.Nil(t, err)
+		reader := bufio.NewReader(conn)
+		_, err = reader.ReadString('\n')
+		assert.Nil(t, err)
+
+		server.Shutdown()
+
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Start did not return after Shutdown")
+		}
+
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+
+		_, err = net.Dial("tcp", server.Addr().String())
+		assert.Error(t, err)
+	})
+
+	t.Run("It refuses SHUTDOWN inside a transaction and bad options", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		result := server.handleCommandExecution(c, command("SHUTDOWN", "LATER"))
+		assert.Equal(t, "ERR syntax error", result.Str)
+
+		server.handleCommandExecution(c, command("MULTI"))
+		result = server.handleCommandExecution(c, command("SHUTDOWN"))
+		assert.Equal(t, "error", result.Typ)
+		assert.False(t, server.shuttingDown.Load())
+	})
+}
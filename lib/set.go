@@ -0,0 +1,703 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the most members an intset holds before being converted to a hash table,
+// the default of set-max-intset-entries in redis.
+const setMaxIntsetEntries = 512
+
+// setType is an unordered collection of unique strings. Small sets of integers
+// use the intset encoding of redis, a sorted array of int64 taking a fraction
+// of the memory of a hash table, members are found with a binary search.
+// The set becomes a hash table once it holds a member that is not an
+// integer or more than setMaxIntsetEntries members.
+type setType struct {
+	intset []int64
+	dict   *dict[struct{}] // nil while the set is an intset.
+}
+
+func newSetType() *setType {
+	return &setType{}
+}
+
+// the integer a member is stored as in an intset, only members
+// that read the same once formatted back e.g. not "007" or "+1".
+func intsetValue(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *setType) isIntset() bool {
+	return s.dict == nil
+}
+
+func (s *setType) len() int {
+	if s.isIntset() {
+		return len(s.intset)
+	}
+	return s.dict.len()
+}
+
+func (s *setType) contains(member string) bool {
+	if !s.isIntset() {
+		_, ok := s.dict.get(member)
+		return ok
+	}
+
+	n, ok := intsetValue(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.intset, n)
+	return found
+}
+
+// adds the member, it returns false when it was already in the set.
+func (s *setType) add(member string) bool {
+	if s.isIntset() {
+		n, ok := intsetValue(member)
+		if ok {
+			i, found := slices.BinarySearch(s.intset, n)
+			if found {
+				return false
+			}
+
+			if len(s.intset) < setMaxIntsetEntries {
+				s.intset = slices.Insert(s.intset, i, n)
+				return true
+			}
+		}
+
+		s.convert()
+	}
+
+	return s.dict.set(member, struct{}{})
+}
+
+// removes the member, it returns false when it was not in the set.
+func (s *setType) remove(member string) bool {
+	if !s.isIntset() {
+		return s.dict.delete(member)
+	}
+
+	n, ok := intsetValue(member)
+	if !ok {
+		return false
+	}
+
+	i, found := slices.BinarySearch(s.intset, n)
+	if found {
+		s.intset = slices.Delete(s.intset, i, i+1)
+	}
+	return found
+}
+
+// a random member, the set must not be empty.
+func (s *setType) random() string {
+	if s.isIntset() {
+		return strconv.FormatInt(s.intset[rand.Intn(len(s.intset))], 10)
+	}
+
+	member, _ := s.dict.random()
+	return member
+}
+
+func (s *setType) members() []string {
+	members := make([]string, 0, s.len())
+
+	if s.isIntset() {
+		for _, n := range s.intset {
+			members = append(members, strconv.FormatInt(n, 10))
+		}
+		return members
+	}
+
+	s.dict.each(func(member string, _ struct{}) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+func (s *setType) clone() *setType {
+	if s.isIntset() {
+		return &setType{intset: append([]int64(nil), s.intset...)}
+	}
+	return &setType{dict: s.dict.clone()}
+}
+
+// moves the members of the intset to a hash table.
+func (s *setType) convert() {
+	s.dict = newDict[struct{}]()
+	for _, n := range s.intset {
+		s.dict.set(strconv.FormatInt(n, 10), struct{}{})
+	}
+	s.intset = nil
+}
+
+// the reply of the commands returning members, a set for RESP3 clients.
+func setReply(members []string) Value {
+	reply := Value{Typ: "set", Array: make([]Value, 0, len(members))}
+	for _, member := range members {
+		reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: member})
+	}
+	return reply
+}
+
+// the set stored at key for commands reading it, nil when there is none.
+// The store must be locked.
+func (s *SimpleStore) lookupSet(key string) (*setType, error) {
+	return lookupValue[*setType](s, key, typeSet)
+}
+
+// the set stored at key for commands writing it, an empty one is stored
+// when create is true and there is none. The store must be locked for writing.
+func (s *SimpleStore) writableSet(key string, create bool) (*setType, error) {
+	return writableValue(s, key, typeSet, create, newSetType)
+}
+
+// doc: https://redis.io/docs/latest/commands/sadd/
+func sadd(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("sadd")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.writableSet(args[0].Bulk, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	added := 0
+	for _, member := range args[1:] {
+		if set.add(member.Bulk) {
+			added++
+		}
+	}
+
+	return Value{Typ: "integer", Num: added}
+}
+
+// doc: https://redis.io/docs/latest/commands/srem/
+func srem(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("srem")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	set, err := db.writableSet(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if set == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if set.remove(member.Bulk) {
+			removed++
+		}
+	}
+
+	// redis never keeps empty sets.
+	if set.len() == 0 {
+		db.deleteKey(key)
+	}
+
+	return Value{Typ: "integer", Num: removed}
+}
+
+// doc: https://redis.io/docs/latest/commands/smembers/
+func smembers(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("smembers")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.lookupSet(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if set == nil {
+		return setReply(nil)
+	}
+	return setReply(set.members())
+}
+
+// doc: https://redis.io/docs/latest/commands/sismember/
+func sismember(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("sismember")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.lookupSet(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if set == nil || !set.contains(args[1].Bulk) {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/smismember/
+func smismember(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("smismember")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.lookupSet(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, member := range args[1:] {
+		found := 0
+		if set != nil && set.contains(member.Bulk) {
+			found = 1
+		}
+		reply.Array = append(reply.Array, Value{Typ: "integer", Num: found})
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/scard/
+func scard(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("scard")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.lookupSet(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if set == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: set.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/spop/
+func spop(ctx context.Context, args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongNumberOfArgs("spop")
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return Value{Typ: "error", Str: "ERR value is out of range, must be positive"}
+		}
+		count = n
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	set, err := db.writableSet(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if set == nil {
+		propagateAs(ctx)
+		if len(args) == 2 {
+			return setReply(nil)
+		}
+		return Value{Typ: "null"}
+	}
+
+	popped := []string{}
+	for len(popped) < count && set.len() > 0 {
+		member := set.random()
+		set.remove(member)
+		popped = append(popped, member)
+	}
+
+	if set.len() == 0 {
+		db.deleteKey(key)
+	}
+
+	// the members are picked at random, replaying the AOF must remove the same ones.
+	if len(popped) > 0 {
+		propagateAs(ctx, command(append([]string{"srem", key}, popped...)...))
+	} else {
+		propagateAs(ctx)
+	}
+
+	if len(args) == 2 {
+		return setReply(popped)
+	}
+	return Value{Typ: "bulk", Bulk: popped[0]}
+}
+
+// doc: https://redis.io/docs/latest/commands/srandmember/
+func srandmember(ctx context.Context, args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongNumberOfArgs("srandmember")
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := parseRandomCount(ctx, args[1].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		count = n
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	set, err := db.lookupSet(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	if len(args) == 1 {
+		if set == nil {
+			return Value{Typ: "null"}
+		}
+		return Value{Typ: "bulk", Bulk: set.random()}
+	}
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	if set == nil || count == 0 {
+		return reply
+	}
+
+	var members []string
+	switch {
+	// a negative count may return the same member more than once.
+	case count < 0:
+		for i := 0; i < -count; i++ {
+			members = append(members, set.random())
+		}
+
+	case count >= set.len():
+		members = set.members()
+
+	// when most members are returned, removing the others is cheaper
+	// than picking random ones until enough are distinct.
+	case count*3 > set.len():
+		members = set.members()
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		members = members[:count]
+
+	default:
+		picked := make(map[string]bool, count)
+		for len(picked) < count {
+			member := set.random()
+			if !picked[member] {
+				picked[member] = true
+				members = append(members, member)
+			}
+		}
+	}
+
+	for _, member := range members {
+		reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: member})
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/smove/
+func smove(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("smove")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	source := args[0].Bulk
+	destination := args[1].Bulk
+	member := args[2].Bulk
+
+	set, err := db.writableSet(source, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	dst, err := db.writableSet(destination, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	if set == nil || !set.contains(member) {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	if source == destination {
+		return Value{Typ: "integer", Num: 1}
+	}
+
+	set.remove(member)
+	if set.len() == 0 {
+		db.deleteKey(source)
+	}
+
+	if dst == nil {
+		dst, _ = db.writableSet(destination, true)
+	}
+	dst.add(member)
+	return Value{Typ: "integer", Num: 1}
+}
+
+// the operations of SINTER, SUNION and SDIFF.
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// computes the intersection, union or difference of the sets stored at
+// keys, missing keys are empty sets. The store must be locked.
+func (s *SimpleStore) setAlgebra(keys []string, op int) (*setType, error) {
+	sets := make([]*setType, len(keys))
+	for i, key := range keys {
+		set, err := s.lookupSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	result := newSetType()
+
+	switch op {
+	case setInter:
+		for _, set := range sets {
+			if set == nil {
+				return result, nil
+			}
+		}
+
+		// the smallest set is walked, the others are only looked up.
+		sort.Slice(sets, func(i, j int) bool { return sets[i].len() < sets[j].len() })
+
+		for _, member := range sets[0].members() {
+			inAll := true
+			for _, other := range sets[1:] {
+				if !other.contains(member) {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result.add(member)
+			}
+		}
+
+	case setUnion:
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			for _, member := range set.members() {
+				result.add(member)
+			}
+		}
+
+	case setDiff:
+		if sets[0] == nil {
+			return result, nil
+		}
+
+		for _, member := range sets[0].members() {
+			inOther := false
+			for _, other := range sets[1:] {
+				if other != nil && other.contains(member) {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result.add(member)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func setAlgebraGeneric(ctx context.Context, name string, args []Value, op int) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, keysOf(args)...)
+	defer unlock()
+
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		keys = append(keys, arg.Bulk)
+	}
+
+	result, err := db.setAlgebra(keys, op)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	return setReply(result.members())
+}
+
+// stores the result of the operation in the destination, replacing what it held.
+func setAlgebraStoreGeneric(ctx context.Context, name string, args []Value, op int) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, keysOf(args)...)
+	defer unlock()
+
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		keys = append(keys, arg.Bulk)
+		db.expireIfNeeded(arg.Bulk)
+	}
+
+	result, err := db.setAlgebra(keys, op)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	destination := args[0].Bulk
+	db.deleteKey(destination)
+	if result.len() > 0 {
+		db.add(destination, typeSet, result)
+	}
+
+	return Value{Typ: "integer", Num: result.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/sinter/
+func sinter(ctx context.Context, args []Value) Value {
+	return setAlgebraGeneric(ctx, "sinter", args, setInter)
+}
+
+// doc: https://redis.io/docs/latest/commands/sunion/
+func sunion(ctx context.Context, args []Value) Value {
+	return setAlgebraGeneric(ctx, "sunion", args, setUnion)
+}
+
+// doc: https://redis.io/docs/latest/commands/sdiff/
+func sdiff(ctx context.Context, args []Value) Value {
+	return setAlgebraGeneric(ctx, "sdiff", args, setDiff)
+}
+
+// doc: https://redis.io/docs/latest/commands/sinterstore/
+func sinterstore(ctx context.Context, args []Value) Value {
+	return setAlgebraStoreGeneric(ctx, "sinterstore", args, setInter)
+}
+
+// doc: https://redis.io/docs/latest/commands/sunionstore/
+func sunionstore(ctx context.Context, args []Value) Value {
+	return setAlgebraStoreGeneric(ctx, "sunionstore", args, setUnion)
+}
+
+// doc: https://redis.io/docs/latest/commands/sdiffstore/
+func sdiffstore(ctx context.Context, args []Value) Value {
+	return setAlgebraStoreGeneric(ctx, "sdiffstore", args, setDiff)
+}
+
+// doc: https://redis.io/docs/latest/commands/sintercard/
+func sintercard(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("sintercard")
+	}
+
+	numkeys, err := strconv.Atoi(args[0].Bulk)
+	if err != nil || numkeys <= 0 {
+		return Value{Typ: "error", Str: "ERR numkeys should be greater than 0"}
+	}
+
+	if numkeys > len(args)-1 {
+		return Value{Typ: "error", Str: "ERR Number of keys can't be greater than number of args"}
+	}
+
+	limit, err := parseLimitOption(args[numkeys+1:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, keysOf(args[1:numkeys+1])...)
+	defer unlock()
+
+	keys := make([]string, 0, numkeys)
+	for _, arg := range args[1 : numkeys+1] {
+		keys = append(keys, arg.Bulk)
+	}
+
+	result, err := db.setAlgebra(keys, setInter)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	card := result.len()
+	if limit > 0 {
+		card = min(card, limit)
+	}
+
+	return Value{Typ: "integer", Num: card}
+}
+
+// parses the optional "LIMIT limit" of SINTERCARD, 0 means no limit.
+func parseLimitOption(args []Value) (int, error) {
+	switch {
+	case len(args) == 0:
+		return 0, nil
+	case len(args) == 2 && strings.ToLower(args[0].Bulk) == "limit":
+		limit, err := strconv.Atoi(args[1].Bulk)
+		if err != nil {
+			return 0, errNotInteger
+		}
+		if limit < 0 {
+			return 0, errors.New("ERR LIMIT can't be negative")
+		}
+		return limit, nil
+	default:
+		return 0, errSyntax
+	}
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the members of a set reply in order, sets are unordered.
+func sortedBulks(v Value) []string {
+	values := bulks(v)
+	sort.Strings(values)
+	return values
+}
+
+func TestSetType(t *testing.T) {
+	t.Run("It keeps small sets of integers as an intset", func(t *testing.T) {
+		s := newSetType()
+		assert.True(t, s.add("3"))
+		assert.True(t, s.add("-1"))
+		assert.False(t, s.add("3"))
+
+		assert.True(t, s.isIntset())
+		assert.Equal(t, []int64{-1, 3}, s.intset)
+		assert.True(t, s.contains("3"))
+		assert.False(t, s.contains("03"))
+	})
+
+	t.Run("It converts the intset to a hash table", func(t *testing.T) {
+		s := newSetType()
+		s.add("1")
+		s.add("007")
+		assert.False(t, s.isIntset())
+		assert.ElementsMatch(t, []string{"1", "007"}, s.members())
+
+		s = newSetType()
+		for i := 0; i <= setMaxIntsetEntries; i++ {
+			s.add(strconv.Itoa(i))
+		}
+		assert.False(t, s.isIntset())
+		assert.Equal(t, setMaxIntsetEntries+1, s.len())
+	})
+}
+
+func TestSetCommands(t *testing.T) {
+	t.Run("It adds, checks and removes members", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 2, sadd(context.Background(), command(key, "a", "b", "a").Array).Num)
+		assert.Equal(t, 1, sadd(context.Background(), command(key, "b", "c").Array).Num)
+		assert.Equal(t, 3, scard(context.Background(), command(key).Array).Num)
+
+		result := smembers(context.Background(), command(key).Array)
+		assert.Equal(t, "set", result.Typ)
+		assert.Equal(t, []string{"a", "b", "c"}, sortedBulks(result))
+
+		assert.Equal(t, 1, sismember(context.Background(), command(key, "a").Array).Num)
+		assert.Equal(t, 0, sismember(context.Background(), command(key, "z").Array).Num)
+
+		result = smismember(context.Background(), command(key, "a", "z").Array)
+		assert.Equal(t, []Value{{Typ: "integer", Num: 1}, {Typ: "integer", Num: 0}}, result.Array)
+
+		assert.Equal(t, 2, srem(context.Background(), command(key, "a", "b", "z").Array).Num)
+		assert.Equal(t, 1, srem(context.Background(), command(key, "c").Array).Num)
+		assert.Equal(t, -2, ttl(context.Background(), command(key).Array).Num)
+	})
+
+	t.Run("It pops random members and writes the ones it popped in the AOF", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t, "SADD", "a", "b", "c")
+
+		result := server.call(c, command("SPOP", key, "2"))
+		assert.Len(t, result.Array, 2)
+		assert.Equal(t, append([]string{"srem", key}, bulks(result)...), bulks(c.propagated[0]))
+
+		result = spop(context.Background(), command(key).Array)
+		assert.Equal(t, "bulk", result.Typ)
+		assert.Equal(t, 0, scard(context.Background(), command(key).Array).Num)
+		assert.Equal(t, "null", spop(context.Background(), command(key).Array).Typ)
+	})
+
+	t.Run("It returns random members with SRANDMEMBER", func(t *testing.T) {
+		key := newTestKey(t, "SADD", "1", "2", "3", "4", "5")
+
+		tests := []struct {
+			count   string
+			expects int
+		}{
+			{"2", 2}, {"4", 4}, {"10", 5}, {"-10", 10}, {"0", 0},
+		}
+		for _, tt := range tests {
+			result := srandmember(context.Background(), command(key, tt.count).Array)
+			assert.Len(t, result.Array, tt.expects, tt.count)
+
+			if tt.count[0] != '-' {
+				distinct := map[string]bool{}
+				for _, member := range bulks(result) {
+					distinct[member] = true
+				}
+				assert.Len(t, distinct, tt.expects)
+			}
+		}
+
+		assert.Equal(t, 5, scard(context.Background(), command(key).Array).Num)
+
+		result := srandmember(context.Background(), command(key, "-2000000000").Array)
+		assert.Equal(t, "ERR value is out of range", result.Str)
+
+		// the count is bounded by the proto-max-multibulk-len of the server.
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("CONFIG", "SET", "proto-max-multibulk-len", "10"))
+		assert.Len(t, server.handleCommandExecution(c, command("SRANDMEMBER", key, "-10")).Array, 10)
+		assert.Equal(t, "ERR value is out of range", server.handleCommandExecution(c, command("SRANDMEMBER", key, "-11")).Str)
+	})
+
+	t.Run("It moves members between sets", func(t *testing.T) {
+		source := newTestKey(t, "SADD", "a", "b")
+		destination := newTestKey(t, "SADD", "c")
+
+		assert.Equal(t, 1, smove(context.Background(), command(source, destination, "a").Array).Num)
+		assert.Equal(t, 0, smove(context.Background(), command(source, destination, "z").Array).Num)
+		assert.Equal(t, []string{"a", "c"}, sortedBulks(smembers(context.Background(), command(destination).Array)))
+		assert.Equal(t, []string{"b"}, sortedBulks(smembers(context.Background(), command(source).Array)))
+	})
+
+	t.Run("It computes intersections, unions and differences", func(t *testing.T) {
+		first := newTestKey(t, "SADD", "a", "b", "c", "d")
+		second := newTestKey(t, "SADD", "c")
+		third := newTestKey(t, "SADD", "a", "c", "e")
+
+		result := sinter(context.Background(), command(first, second, third).Array)
+		assert.Equal(t, []string{"c"}, sortedBulks(result))
+
+		result = sunion(context.Background(), command(first, second, third).Array)
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, sortedBulks(result))
+
+		result = sdiff(context.Background(), command(first, second, third).Array)
+		assert.Equal(t, []string{"b", "d"}, sortedBulks(result))
+
+		result = sinter(context.Background(), command(first, "set-missing").Array)
+		assert.Empty(t, result.Array)
+
+		assert.Equal(t, 2, sintercard(context.Background(), command("2", first, third).Array).Num)
+		assert.Equal(t, 1, sintercard(context.Background(), command("2", first, third, "LIMIT", "1").Array).Num)
+		assert.Equal(t, "ERR Number of keys can't be greater than number of args", sintercard(context.Background(), command("3", first, third).Array).Str)
+	})
+
+	t.Run("It stores the results in the destination", func(t *testing.T) {
+		first := newTestKey(t, "SADD", "1", "2", "3")
+		second := newTestKey(t, "SADD", "2", "3", "4")
+		destination := newTestKey(t, "SADD", "x")
+
+		assert.Equal(t, 4, sunionstore(context.Background(), command(destination, first, second).Array).Num)
+		assert.Equal(t, []string{"1", "2", "3", "4"}, sortedBulks(smembers(context.Background(), command(destination).Array)))
+
+		assert.Equal(t, 1, sdiffstore(context.Background(), command(destination, first, second).Array).Num)
+		assert.Equal(t, []string{"1"}, sortedBulks(smembers(context.Background(), command(destination).Array)))
+
+		assert.Equal(t, 0, sinterstore(context.Background(), command(destination, first, "set-missing").Array).Num)
+		assert.Equal(t, -2, ttl(context.Background(), command(destination).Array).Num)
+	})
+}
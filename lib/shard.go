@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"context"
+	"hash/maphash"
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+// the number of shards of every database, a power of two.
+const keyspaceShards = 64
+
+// the seed of the hash picking the shard of a key, the dicts hash keys
+// with seeds of their own so the keys of a shard still use every bucket.
+var shardSeed = maphash.MakeSeed()
+
+// a partition of the keyspace of a database, the keys hashed to it
+// along with their time to live.
+type shard struct {
+	mu    sync.RWMutex
+	order int // the shards are locked in this order, see lockShards.
+
+	// the keys of the shard, every key with the object holding its value
+	// whatever its type. It is the dict SCAN, KEYS and RANDOMKEY walk.
+	keys *dict[*object]
+
+	// the objects of the keys with a time to live, the active expire cycle
+	// samples them.
+	expires map[string]*object
+
+	// the keys of the hashes with fields that have a time to live.
+	volatileHashes map[string]bool
+}
+
+func newShard(order int) *shard {
+	return &shard{
+		order:          order,
+		keys:           newDict[*object](),
+		expires:        map[string]*object{},
+		volatileHashes: map[string]bool{},
+	}
+}
+
+// removes every key, the shard must be locked for writing.
+func (sh *shard) flush() {
+	sh.keys = newDict[*object]()
+	sh.expires = map[string]*object{}
+	sh.volatileHashes = map[string]bool{}
+}
+
+// the shard key belongs to.
+func (s *SimpleStore) shard(key string) *shard {
+	return s.shards[maphash.String(shardSeed, key)&(keyspaceShards-1)]
+}
+
+// the object stored at key whether it expired or not.
+func (s *SimpleStore) object(key string) (*object, bool) {
+	return s.shard(key).keys.get(key)
+}
+
+// a key picked at random, the shard it is picked from is drawn with a
+// probability proportional to its size so every key is as likely to come up.
+// ok is false when there are no keys. Every shard must be locked.
+func (s *SimpleStore) randomKey() (key string, ok bool) {
+	size := 0
+	for _, sh := range s.shards {
+		size += sh.keys.len()
+	}
+	if size == 0 {
+		return "", false
+	}
+
+	n := rand.Intn(size)
+	for _, sh := range s.shards {
+		if n < sh.keys.len() {
+			key, _ = sh.keys.random()
+			break
+		}
+		n -= sh.keys.len()
+	}
+	return key, true
+}
+
+// the shards of the keys.
+func (s *SimpleStore) shardsOf(keys ...string) []*shard {
+	shards := make([]*shard, len(keys))
+	for i, key := range keys {
+		shards[i] = s.shard(key)
+	}
+	return shards
+}
+
+// locks the shards of the keys for writing, the returned function unlocks them.
+func (s *SimpleStore) lock(ctx context.Context, keys ...string) (unlock func()) {
+	return lockShards(ctx, true, s.shardsOf(keys...)...)
+}
+
+// locks the shards of the keys for reading, the returned function unlocks them.
+func (s *SimpleStore) rlock(ctx context.Context, keys ...string) (unlock func()) {
+	return lockShards(ctx, false, s.shardsOf(keys...)...)
+}
+
+// locks every shard of the databases for writing, the returned function
+// unlocks them.
+func lockDatabases(ctx context.Context, dbs ...*SimpleStore) (unlock func()) {
+	var shards []*shard
+	for _, db := range dbs {
+		shards = append(shards, db.shards...)
+	}
+	return lockShards(ctx, true, shards...)
+}
+
+// locks the shards in the order of their order field, commands locking
+// several shards, of one database or more, all follow it so they never
+// wait on each other. A shard given more than once is locked once.
+// The returned function unlocks them.
+//
+// The shards a command run by call locks for writing stay locked until the
+// command is in the AOF, the returned function does nothing and call
+// unlocks them: the AOF then gets the writes to a key in the order they
+// ran. A command locks its shards once, it would wait on itself otherwise.
+// Nothing is locked while EXEC holds the shards of its queue.
+func lockShards(ctx context.Context, write bool, shards ...*shard) (unlock func()) {
+	c := clientFromContext(ctx)
+	if c != nil && c.holdsQueueShards {
+		return func() {}
+	}
+
+	sorted := slices.Clone(shards)
+	slices.SortFunc(sorted, func(a, b *shard) int { return a.order - b.order })
+	sorted = slices.Compact(sorted)
+
+	for _, sh := range sorted {
+		if write {
+			sh.mu.Lock()
+		} else {
+			sh.mu.RLock()
+		}
+	}
+
+	unlock = func() {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if write {
+				sorted[i].mu.Unlock()
+			} else {
+				sorted[i].mu.RUnlock()
+			}
+		}
+	}
+
+	if write && c != nil && c.holdShards {
+		c.heldShards = append(c.heldShards, unlock)
+		return func() {}
+	}
+	return unlock
+}
+
+// the keys given as arguments.
+func keysOf(args []Value) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+	return keys
+}
@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It locks the shards of the keys only", func(t *testing.T) {
+		db := newSimpleStore(0)
+		a, b := "a", "b"
+		for db.shard(a) == db.shard(b) {
+			b += "b"
+		}
+
+		unlock := db.lock(ctx, a)
+		assert.False(t, db.shard(a).mu.TryRLock())
+		assert.True(t, db.shard(b).mu.TryLock())
+		db.shard(b).mu.Unlock()
+		unlock()
+
+		unlock = db.rlock(ctx, a, b)
+		assert.True(t, db.shard(a).mu.TryRLock())
+		db.shard(a).mu.RUnlock()
+		assert.False(t, db.shard(b).mu.TryLock())
+		unlock()
+	})
+
+	t.Run("It locks the shards of the databases in one order", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		dbs := server.databases
+
+		// a database given twice is locked once.
+		unlock := lockDatabases(ctx, dbs[3], dbs[1], dbs[3])
+		assert.False(t, dbs[1].shards[0].mu.TryLock())
+		assert.False(t, dbs[3].shards[keyspaceShards-1].mu.TryLock())
+		unlock()
+
+		assert.True(t, dbs[1].shards[0].mu.TryLock())
+		dbs[1].shards[0].mu.Unlock()
+
+		assert.Less(t, dbs[1].shards[keyspaceShards-1].order, dbs[3].shards[0].order)
+	})
+
+	t.Run("It scans the keys of every shard", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+
+		server.handleCommandExecution(c, command("SELECT", "5"))
+		for i := 0; i < 500; i++ {
+			server.handleCommandExecution(c, command("SET", "k"+strconv.Itoa(i), "v"))
+		}
+
+		seen := map[string]bool{}
+		cursor := "0"
+		for {
+			result := server.handleCommandExecution(c, command("SCAN", cursor, "COUNT", "7"))
+			for _, key := range result.Array[1].Array {
+				seen[key.Bulk] = true
+			}
+
+			cursor = result.Array[0].Bulk
+			if cursor == "0" {
+				break
+			}
+		}
+		assert.Len(t, seen, 500)
+
+		assert.Equal(t, 500, server.handleCommandExecution(c, command("DBSIZE")).Num)
+		assert.Len(t, server.handleCommandExecution(c, command("KEYS", "k1*")).Array, 111)
+		assert.Equal(t, "bulk", server.handleCommandExecution(c, command("RANDOMKEY")).Typ)
+	})
+
+	t.Run("It keeps multi-key commands atomic across shards", func(t *testing.T) {
+		src, dst := newTestKey(t, "RPUSH", "a", "b", "c"), newTestKey(t)
+		for KvStore.shard(src) == KvStore.shard(dst) {
+			dst += "x"
+		}
+
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 1000; i++ {
+				lmove(ctx, command(src, dst, "LEFT", "RIGHT").Array)
+				lmove(ctx, command(dst, src, "LEFT", "RIGHT").Array)
+			}
+			close(done)
+		}()
+
+		for i := 0; i < 1000; i++ {
+			reply := exists(ctx, command(src).Array)
+			assert.Equal(t, 1, reply.Num)
+
+			unlock := KvStore.rlock(ctx, src, dst)
+			l1, _ := KvStore.lookupList(src)
+			l2, _ := KvStore.lookupList(dst)
+			size := 0
+			if l1 != nil {
+				size += l1.len()
+			}
+			if l2 != nil {
+				size += l2.len()
+			}
+			unlock()
+			assert.Equal(t, 3, size)
+		}
+		<-done
+	})
+
+	t.Run("It writes the commands in the AOF in the order they ran", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "shards.aof")
+		aof, err := NewAppendOnlyFile(path)
+		assert.Nil(t, err)
+
+		server := NewServer(NewConfig())
+		server.aof = aof
+		key := "aof-order-" + strconv.Itoa(rand.Int())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			c := testClient(t, server)
+			server.handleCommandExecution(c, command("SELECT", "11"))
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 300; j++ {
+					server.handleCommandExecution(c, command("RPUSH", key, strconv.Itoa(i)+":"+strconv.Itoa(j)))
+					server.handleCommandExecution(c, command("LSET", key, "0", strconv.Itoa(i)))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Nil(t, aof.Close())
+
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("SELECT", "11"))
+		live := server.handleCommandExecution(c, command("LRANGE", key, "0", "-1"))
+
+		restarted := NewServer(NewConfig())
+		assert.Nil(t, restarted.createAOF(path).Close())
+		c = testClient(t, restarted)
+		restarted.handleCommandExecution(c, command("SELECT", "11"))
+		assert.Equal(t, live, restarted.handleCommandExecution(c, command("LRANGE", key, "0", "-1")))
+	})
+
+	t.Run("It runs the commands queued by MULTI without interleaving others", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c, other := testClient(t, server), testClient(t, server)
+		key := "exec-isolation-" + strconv.Itoa(rand.Int())
+
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			for i := 0; i < 2000; i++ {
+				server.handleCommandExecution(other, command("INCR", key))
+			}
+		}()
+
+		for i := 0; i < 200; i++ {
+			server.handleCommandExecution(c, command("MULTI"))
+			server.handleCommandExecution(c, command("GET", key))
+			server.handleCommandExecution(c, command("GET", key))
+			reply := server.handleCommandExecution(c, command("EXEC"))
+			assert.Equal(t, reply.Array[0], reply.Array[1])
+		}
+		<-done
+	})
+
+	t.Run("It locks the shards of the keys queued by MULTI only", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		db := server.databases[13]
+		a, b := "queued-a", "queued-b"
+		for db.shard(a) == db.shard(b) {
+			b += "b"
+		}
+
+		exec := func(commands ...Value) chan Value {
+			server.handleCommandExecution(c, command("MULTI"))
+			for _, cmd := range commands {
+				server.handleCommandExecution(c, cmd)
+			}
+			return runBlocking(server, c, command("EXEC"))
+		}
+
+		unlock := db.lock(ctx, b)
+		reply := receive(t, exec(command("SELECT", "13"), command("SET", a, "v"), command("GET", a)))
+		assert.Equal(t, "v", reply.Array[2].Bulk)
+
+		// DBSIZE counts the keys of every shard, the one of b included.
+		result := exec(command("DBSIZE"))
+		select {
+		case <-result:
+			t.Fatal("EXEC did not wait for the shard of b")
+		case <-time.After(20 * time.Millisecond):
+		}
+		unlock()
+		assert.Equal(t, 1, receive(t, result).Array[0].Num)
+	})
+
+	t.Run("It finds the keys of the commands in their arguments", func(t *testing.T) {
+		tests := []struct {
+			command Value
+			keys    []string
+		}{
+			{command("PING"), nil},
+			{command("GET", "a"), []string{"a"}},
+			{command("MSET", "a", "1", "b", "2"), []string{"a", "b"}},
+			{command("BLPOP", "a", "b", "0"), []string{"a", "b"}},
+			{command("BITOP", "AND", "d", "a", "b"), []string{"d", "a", "b"}},
+			{command("XINFO", "STREAM", "a"), []string{"a"}},
+			{command("BLMPOP", "0", "2", "a", "b", "LEFT"), []string{"a", "b"}},
+			{command("ZUNIONSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"), []string{"d", "a", "b"}},
+			{command("SINTERCARD", "3", "a"), nil},
+			{command("XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "STREAMS", "a", "b", ">", ">"), []string{"a", "b"}},
+		}
+		for _, tt := range tests {
+			name := strings.ToLower(tt.command.Array[0].Bulk)
+			assert.Equal(t, tt.keys, commandKeys[name](tt.command.Array[1:]), name)
+		}
+
+		_, ok := commandKeys["keys"]
+		assert.False(t, ok)
+	})
+
+	t.Run("It walks the whole keyspace in MULTI", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		server.handleCommandExecution(c, command("SELECT", "13"))
+		server.handleCommandExecution(c, command("SET", "walked", "v"))
+
+		done := make(chan Value)
+		go func() {
+			server.handleCommandExecution(c, command("MULTI"))
+			server.handleCommandExecution(c, command("DBSIZE"))
+			server.handleCommandExecution(c, command("KEYS", "*"))
+			server.handleCommandExecution(c, command("SCAN", "0", "COUNT", "1000"))
+			done <- server.handleCommandExecution(c, command("EXEC"))
+		}()
+
+		select {
+		case reply := <-done:
+			assert.Equal(t, 1, reply.Array[0].Num)
+			assert.Equal(t, []string{"walked"}, bulks(reply.Array[1]))
+			assert.Equal(t, []string{"walked"}, bulks(reply.Array[2].Array[1]))
+		case <-time.After(5 * time.Second):
+			t.Fatal("EXEC waited on the shards it holds")
+		}
+	})
+}
+
+// run with -cpu 1,2,4,8 to see the throughput with the cores. The commands
+// go through the server with the AOF on like the ones of a connection:
+// clients only wait on each other when their keys share a shard, the
+// "one shard" runs show how a single lock for the whole store would do,
+// and every write still waits its turn to be appended to the AOF. The EXEC
+// runs send each INCR in a transaction of its own.
+func BenchmarkKeyspace(b *testing.B) {
+	aof, err := NewAppendOnlyFile(filepath.Join(b.TempDir(), "bench.aof"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer aof.Close()
+
+	server := NewServer(NewConfig())
+	server.aof = aof
+	db := server.databases[12]
+
+	var keys, oneShard []string
+	for i := 0; len(keys) < 1024 || len(oneShard) < 1024; i++ {
+		key := "bench:" + strconv.Itoa(i)
+		if len(keys) < 1024 {
+			keys = append(keys, key)
+		}
+		if len(oneShard) < 1024 && db.shard(key) == db.shards[0] {
+			oneShard = append(oneShard, key)
+		}
+	}
+
+	c := server.newFakeClient()
+	server.handleCommandExecution(c, command("SELECT", "12"))
+	for _, key := range append(keys, oneShard...) {
+		server.handleCommandExecution(c, command("SET", key, "v"))
+		server.handleCommandExecution(c, command("HSET", "h"+key, "f", "v"))
+	}
+
+	commands := []struct {
+		name    string
+		command func(key string) Value
+	}{
+		{"GET", func(key string) Value { return command("GET", key) }},
+		{"HGET", func(key string) Value { return command("HGET", "h"+key, "f") }},
+		{"SET", func(key string) Value { return command("SET", key, "v") }},
+		{"INCR", func(key string) Value { return command("INCR", "n"+key) }},
+		// the INCR of the transaction goes through EXEC.
+		{"EXEC", func(key string) Value { return command("INCR", "n"+key) }},
+	}
+
+	for _, cmd := range commands {
+		for _, keys := range []struct {
+			name string
+			keys []string
+		}{{"", keys}, {" one shard", oneShard}} {
+			b.Run(cmd.name+keys.name, func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					c := server.newFakeClient()
+					server.handleCommandExecution(c, command("SELECT", "12"))
+
+					for i := rand.Intn(len(keys.keys)); pb.Next(); i++ {
+						if cmd.name == "EXEC" {
+							server.handleCommandExecution(c, command("MULTI"))
+						}
+						server.handleCommandExecution(c, cmd.command(keys.keys[i%len(keys.keys)]))
+						if cmd.name == "EXEC" {
+							server.handleCommandExecution(c, command("EXEC"))
+						}
+					}
+				})
+			})
+		}
+	}
+}
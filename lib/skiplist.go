@@ -0,0 +1,295 @@
+package lib
+
+import "math/rand"
+
+// the skiplist of redis: 32 levels are enough for 2^64 elements
+// and a node has a quarter of a chance to reach the next level.
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int // number of nodes jumped over by forward, used to compute ranks.
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+// zskiplist keeps the members of a sorted set ordered by score, then by
+// member, finding a member, its rank or the n-th member is O(log n).
+// Port of the zskiplist of redis: https://github.com/redis/redis/blob/unstable/src/t_zset.c
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// reports whether the node comes before the score and member.
+func (x *zskiplistNode) before(score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+// inserts a member that is not in the skiplist yet.
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// the rank of the node each level stops at.
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// the levels above the new node jump over one more node.
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+
+	zsl.length++
+	return x
+}
+
+// unlinks the node, update holds the last node before it on every level.
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// the last node before the score and member on every level.
+func (zsl *zskiplist) findUpdate(score float64, member string) []*zskiplistNode {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	return update
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := zsl.findUpdate(score, member)
+
+	x := update[0].level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	zsl.deleteNode(x, update)
+	return true
+}
+
+// changes the score of a member, the node stays in place when
+// the order does not change.
+func (zsl *zskiplist) updateScore(score float64, member string, newScore float64) *zskiplistNode {
+	update := zsl.findUpdate(score, member)
+	x := update[0].level[0].forward
+
+	if (x.backward == nil || x.backward.score < newScore) &&
+		(x.level[0].forward == nil || x.level[0].forward.score > newScore) {
+		x.score = newScore
+		return x
+	}
+
+	zsl.deleteNode(x, update)
+	return zsl.insert(newScore, member)
+}
+
+// the 1-based rank of the member, 0 when it is not in the skiplist.
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.before(score, member) || (x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// the node at the 1-based rank, nil when out of range.
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// a range of scores or members, see zrangeSpec and zlexRangeSpec.
+type zrangeBounds interface {
+	gteMin(x *zskiplistNode) bool
+	lteMax(x *zskiplistNode) bool
+}
+
+// the first node in the range, nil when the range is empty.
+func (zsl *zskiplist) firstInRange(r zrangeBounds) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// the last node in the range, nil when the range is empty.
+func (zsl *zskiplist) lastInRange(r zrangeBounds) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == zsl.header || !r.gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+// deletes the nodes in the range along with their entry in the dict.
+func (zsl *zskiplist) deleteRange(r zrangeBounds, d *dict[float64]) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	removed := 0
+	for x = x.level[0].forward; x != nil && r.lteMax(x); removed++ {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update)
+		d.delete(x.member)
+		x = next
+	}
+	return removed
+}
+
+// deletes the nodes from the 1-based rank start to end, both included,
+// along with their entry in the dict.
+func (zsl *zskiplist) deleteRangeByRank(start, end int, d *dict[float64]) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	traversed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	traversed++
+	removed := 0
+	for x = x.level[0].forward; x != nil && traversed <= end; traversed++ {
+		next := x.level[0].forward
+		zsl.deleteNode(x, update)
+		d.delete(x.member)
+		removed++
+		x = next
+	}
+	return removed
+}
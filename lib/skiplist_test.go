@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the members of the skiplist from the lowest score, checking the
+// backward links and the spans on the way.
+func zslMembers(t *testing.T, zsl *zskiplist) []string {
+	members := []string{}
+	var prev *zskiplistNode
+
+	for x := zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if prev != nil {
+			assert.Same(t, prev, x.backward)
+		}
+		members = append(members, x.member)
+		assert.Equal(t, x, zsl.byRank(len(members)))
+		prev = x
+	}
+
+	assert.Equal(t, prev, zsl.tail)
+	assert.Equal(t, zsl.length, len(members))
+	return members
+}
+
+func TestZskiplist(t *testing.T) {
+	t.Run("It orders members by score then by member and knows their rank", func(t *testing.T) {
+		zsl := newZskiplist()
+		d := newDict[float64]()
+		expected := []zsetEntry{}
+
+		for i := 0; i < 500; i++ {
+			entry := zsetEntry{member: "m" + strconv.Itoa(i), score: float64(rand.Intn(50))}
+			zsl.insert(entry.score, entry.member)
+			d.set(entry.member, entry.score)
+			expected = append(expected, entry)
+		}
+
+		sort.Slice(expected, func(i, j int) bool {
+			a, b := expected[i], expected[j]
+			return a.score < b.score || (a.score == b.score && a.member < b.member)
+		})
+
+		members := zslMembers(t, zsl)
+		for i, entry := range expected {
+			assert.Equal(t, entry.member, members[i])
+			assert.Equal(t, i+1, zsl.rank(entry.score, entry.member))
+		}
+		assert.Equal(t, 0, zsl.rank(1, "missing"))
+		assert.Nil(t, zsl.byRank(501))
+
+		// moves members around and removes some of them.
+		for i := 0; i < 100; i++ {
+			entry := expected[i]
+			zsl.updateScore(entry.score, entry.member, entry.score+100)
+		}
+		assert.True(t, zsl.delete(expected[100].score, expected[100].member))
+		assert.False(t, zsl.delete(expected[100].score, expected[100].member))
+
+		members = zslMembers(t, zsl)
+		assert.Equal(t, 499, len(members))
+		assert.Equal(t, expected[0].member, members[399])
+	})
+
+	t.Run("It finds and deletes ranges", func(t *testing.T) {
+		zsl := newZskiplist()
+		d := newDict[float64]()
+		for i := 1; i <= 10; i++ {
+			zsl.insert(float64(i), strconv.Itoa(i))
+			d.set(strconv.Itoa(i), float64(i))
+		}
+
+		r := zrangeSpec{min: 3, max: 6, minex: true}
+		assert.Equal(t, "4", zsl.firstInRange(r).member)
+		assert.Equal(t, "6", zsl.lastInRange(r).member)
+		assert.Nil(t, zsl.firstInRange(zrangeSpec{min: 11, max: 20}))
+		assert.Nil(t, zsl.lastInRange(zrangeSpec{min: 5, max: 4}))
+
+		assert.Equal(t, 3, zsl.deleteRange(r, d))
+		assert.Equal(t, 2, zsl.deleteRangeByRank(1, 2, d))
+		assert.Equal(t, []string{"3", "7", "8", "9", "10"}, zslMembers(t, zsl))
+		assert.Equal(t, 5, d.len())
+	})
+}
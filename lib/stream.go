@@ -0,0 +1,824 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidStreamID  = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+)
+
+// the ID of a stream entry: the unix time in milliseconds the entry was
+// added at and a sequence number for the entries added the same millisecond.
+type streamID struct {
+	ms, seq uint64
+}
+
+var streamMaxID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq):
+		return -1
+	case id == other:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.compare(other) < 0
+}
+
+// the ID right after this one, false when it is the greatest ID.
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{ms: id.ms, seq: id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{ms: id.ms + 1}, true
+	default:
+		return id, false
+	}
+}
+
+// the ID right before this one, false when it is 0-0.
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{ms: id.ms, seq: id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{ms: id.ms - 1, seq: math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// parses "ms-seq", or "ms" alone in which case the sequence is missingSeq.
+func parseStreamID(arg string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+
+	if !hasSeq {
+		return streamID{ms: ms, seq: missingSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms: ms, seq: seq}, nil
+}
+
+// parses a bound of XRANGE and friends: "-" and "+" are the smallest and
+// greatest IDs, a "(" prefix excludes the ID from the range.
+func parseStreamRangeBound(arg string, start bool) (streamID, error) {
+	switch arg {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamMaxID, nil
+	}
+
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	missingSeq := uint64(0)
+	if !start {
+		missingSeq = math.MaxUint64
+	}
+
+	id, err := parseStreamID(arg, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+
+	ok := false
+	if start {
+		id, ok = id.incr()
+	} else {
+		id, ok = id.decr()
+	}
+
+	if !ok {
+		if start {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+		return id, errors.New("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string // the field value pairs one after the other.
+}
+
+// stream is an append-only log of entries ordered by ID. Entries are
+// appended with an ID greater than any before so the log stays sorted and
+// ranges are found by binary search. Consumer groups keep track of what
+// they delivered, see consumergroup.go.
+type stream struct {
+	entries      []streamEntry
+	lastID       streamID // the ID of the last entry ever added, deleted or not.
+	maxDeletedID streamID
+	entriesAdded int
+	groups       map[string]*streamGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: map[string]*streamGroup{}}
+}
+
+// a copy of the stream and its consumer groups, the fields of the
+// entries are shared since they never change.
+func (s *stream) clone() *stream {
+	c := *s
+	c.entries = append([]streamEntry(nil), s.entries...)
+	c.groups = make(map[string]*streamGroup, len(s.groups))
+	for name, g := range s.groups {
+		c.groups[name] = g.clone()
+	}
+	return &c
+}
+
+func (s *stream) len() int {
+	return len(s.entries)
+}
+
+// the index of the first entry whose ID is not less than id.
+func (s *stream) search(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].id.less(id)
+	})
+}
+
+func (s *stream) get(id streamID) (streamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		return s.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// the ID XADD gives to entries with the "*" ID, or "ms-*" when ms is
+// given, false when no greater ID is left.
+func (s *stream) nextID(ms uint64, auto bool) (streamID, bool) {
+	if auto {
+		ms = max(uint64(mstime()), s.lastID.ms)
+	}
+
+	if ms > s.lastID.ms {
+		return streamID{ms: ms}, true
+	}
+
+	if ms < s.lastID.ms {
+		return streamID{}, false
+	}
+	return s.lastID.incr()
+}
+
+func (s *stream) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+func (s *stream) delete(id streamID) bool {
+	i := s.search(id)
+	if i == len(s.entries) || s.entries[i].id != id {
+		return false
+	}
+
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// the entries from start to end, both included, at most count of
+// them unless it is negative. Reverse starts from the end.
+func (s *stream) rangeEntries(start, end streamID, count int, reverse bool) []streamEntry {
+	entries := []streamEntry{}
+	if end.less(start) {
+		return entries
+	}
+
+	from := s.search(start)
+	to := s.search(end)
+	if to < len(s.entries) && s.entries[to].id == end {
+		to++
+	}
+
+	for i := from; i < to && count != 0; i, count = i+1, count-1 {
+		if reverse {
+			entries = append(entries, s.entries[to-1-(i-from)])
+		} else {
+			entries = append(entries, s.entries[i])
+		}
+	}
+	return entries
+}
+
+// the trimming strategies of XADD and XTRIM.
+const (
+	trimNone = iota
+	trimMaxLen
+	trimMinID
+)
+
+// streamTrim is the parsed "MAXLEN|MINID [=|~] threshold [LIMIT count]".
+// Entries are trimmed exactly even with "~", which redis allows, but
+// LIMIT caps the number of entries trimmed then.
+type streamTrim struct {
+	strategy int
+	maxLen   int
+	minID    streamID
+	approx   bool
+	limit    int // 0 for no limit.
+}
+
+// removes the oldest entries according to the trimming options.
+func (s *stream) trim(t streamTrim) int {
+	n := 0
+	switch t.strategy {
+	case trimMaxLen:
+		n = max(len(s.entries)-t.maxLen, 0)
+	case trimMinID:
+		n = s.search(t.minID)
+	}
+
+	if t.limit > 0 {
+		n = min(n, t.limit)
+	}
+
+	s.entries = s.entries[n:]
+	return n
+}
+
+// parses the trimming option at args[i], if it is one, into t and returns
+// the index of the next argument.
+func parseStreamTrimOption(args []Value, i int, t *streamTrim) (int, bool, error) {
+	option := strings.ToLower(args[i].Bulk)
+
+	switch {
+	case (option == "maxlen" || option == "minid") && i+1 < len(args):
+		strategy := trimMaxLen
+		if option == "minid" {
+			strategy = trimMinID
+		}
+
+		if t.strategy != trimNone && t.strategy != strategy {
+			return i, true, errors.New("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+		}
+		t.strategy = strategy
+
+		i++
+		if (args[i].Bulk == "~" || args[i].Bulk == "=") && i+1 < len(args) {
+			t.approx = args[i].Bulk == "~"
+			i++
+		}
+
+		if strategy == trimMinID {
+			id, err := parseStreamID(args[i].Bulk, 0)
+			if err != nil {
+				return i, true, err
+			}
+			t.minID = id
+			return i + 1, true, nil
+		}
+
+		maxLen, err := strconv.Atoi(args[i].Bulk)
+		if err != nil {
+			return i, true, errNotInteger
+		}
+		if maxLen < 0 {
+			return i, true, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		t.maxLen = maxLen
+		return i + 1, true, nil
+
+	case option == "limit" && i+1 < len(args):
+		limit, err := strconv.Atoi(args[i+1].Bulk)
+		if err != nil {
+			return i, true, errNotInteger
+		}
+		if limit < 0 {
+			return i, true, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		t.limit = limit
+		return i + 2, true, nil
+	}
+
+	return i, false, nil
+}
+
+func validateStreamTrim(t streamTrim) error {
+	if t.limit > 0 && !t.approx {
+		return errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	return nil
+}
+
+// the stream stored at key for commands reading it, nil when there is none.
+// The store must be locked.
+func (s *SimpleStore) lookupStream(key string) (*stream, error) {
+	return lookupValue[*stream](s, key, typeStream)
+}
+
+// the stream stored at key for commands writing it, an empty one is
+// stored when create is true and there is none. The store must be locked for writing.
+func (s *SimpleStore) writableStream(key string, create bool) (*stream, error) {
+	return writableValue(s, key, typeStream, create, newStream)
+}
+
+// an entry as replied by XRANGE and friends, XREADGROUP and XCLAIM reply
+// with nil fields once the entry is deleted.
+func streamEntryReply(id streamID, fields []string, deleted bool) Value {
+	values := Value{Typ: "nullarray"}
+	if !deleted {
+		values = Value{Typ: "array", Array: make([]Value, 0, len(fields))}
+		for _, field := range fields {
+			values.Array = append(values.Array, Value{Typ: "bulk", Bulk: field})
+		}
+	}
+
+	return Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: id.String()}, values}}
+}
+
+func streamEntriesReply(entries []streamEntry) Value {
+	reply := Value{Typ: "array", Array: make([]Value, 0, len(entries))}
+	for _, entry := range entries {
+		reply.Array = append(reply.Array, streamEntryReply(entry.id, entry.fields, false))
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/xadd/
+func xadd(ctx context.Context, args []Value) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("xadd")
+	}
+
+	key := args[0].Bulk
+	noMkStream := false
+	var t streamTrim
+
+	i := 1
+	for i < len(args) {
+		if strings.ToLower(args[i].Bulk) == "nomkstream" {
+			noMkStream = true
+			i++
+			continue
+		}
+
+		next, ok, err := parseStreamTrimOption(args, i, &t)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if !ok {
+			break
+		}
+		i = next
+	}
+
+	if err := validateStreamTrim(t); err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// the ID then at least one field value pair.
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return wrongNumberOfArgs("xadd")
+	}
+
+	idArg := args[i].Bulk
+	auto, autoSeq := idArg == "*", false
+	var id streamID
+
+	if !auto {
+		msPart, seqPart, hasSeq := strings.Cut(idArg, "-")
+		autoSeq = hasSeq && seqPart == "*"
+
+		var err error
+		if autoSeq {
+			id, err = parseStreamID(msPart, 0)
+		} else {
+			id, err = parseStreamID(idArg, 0)
+		}
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+
+		if !autoSeq && id == (streamID{}) {
+			return Value{Typ: "error", Str: "ERR The ID specified in XADD must be greater than 0-0"}
+		}
+	}
+
+	fields := make([]string, 0, len(args)-i-1)
+	for _, field := range args[i+1:] {
+		fields = append(fields, field.Bulk)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	st, err := db.writableStream(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil && noMkStream {
+		return Value{Typ: "null"}
+	}
+
+	// the stream is only stored once the ID is known to be valid.
+	created := st == nil
+	if created {
+		st = newStream()
+	}
+
+	switch {
+	case auto || autoSeq:
+		next, ok := st.nextID(id.ms, auto)
+		if !ok {
+			if auto {
+				return Value{Typ: "error", Str: "ERR The stream has exhausted the last possible ID, unable to add more items"}
+			}
+			return Value{Typ: "error", Str: errStreamIDTooSmall.Error()}
+		}
+		id = next
+	case !st.lastID.less(id):
+		return Value{Typ: "error", Str: errStreamIDTooSmall.Error()}
+	}
+
+	st.add(id, fields)
+	st.trim(t)
+	if created {
+		db.add(key, typeStream, st)
+	}
+
+	// the AOF gets the ID the entry was given so replaying it gives the same stream.
+	rewritten := make([]Value, len(args)+1)
+	rewritten[0] = Value{Typ: "bulk", Bulk: "xadd"}
+	copy(rewritten[1:], args)
+	rewritten[i+1] = Value{Typ: "bulk", Bulk: id.String()}
+	propagateAs(ctx, Value{Typ: "array", Array: rewritten})
+
+	signalKeyAsReady(ctx, db, key)
+	return Value{Typ: "bulk", Bulk: id.String()}
+}
+
+// doc: https://redis.io/docs/latest/commands/xrange/
+func xrange(ctx context.Context, args []Value) Value {
+	return xrangeGeneric(ctx, "xrange", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/xrevrange/
+func xrevrange(ctx context.Context, args []Value) Value {
+	return xrangeGeneric(ctx, "xrevrange", args, true)
+}
+
+func xrangeGeneric(ctx context.Context, name string, args []Value, reverse bool) Value {
+	if len(args) != 3 && len(args) != 5 {
+		return wrongNumberOfArgs(name)
+	}
+
+	startArg, endArg := args[1].Bulk, args[2].Bulk
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, err := parseStreamRangeBound(startArg, true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	end, err := parseStreamRangeBound(endArg, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	count := -1
+	if len(args) == 5 {
+		if strings.ToLower(args[3].Bulk) != "count" {
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+
+		n, err := strconv.Atoi(args[4].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: errNotInteger.Error()}
+		}
+		count = max(n, 0)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	st, err := db.lookupStream(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil {
+		return streamEntriesReply(nil)
+	}
+	return streamEntriesReply(st.rangeEntries(start, end, count, reverse))
+}
+
+// doc: https://redis.io/docs/latest/commands/xlen/
+func xlen(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("xlen")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	st, err := db.lookupStream(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: st.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/xdel/
+func xdel(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("xdel")
+	}
+
+	ids, err := parseStreamIDs(args[1:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	// streams stay in the keyspace once empty, their last ID must not be lost.
+	st, err := db.writableStream(args[0].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if st.delete(id) {
+			deleted++
+		}
+	}
+	return Value{Typ: "integer", Num: deleted}
+}
+
+func parseStreamIDs(args []Value) ([]streamID, error) {
+	ids := make([]streamID, 0, len(args))
+	for _, arg := range args {
+		id, err := parseStreamID(arg.Bulk, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// doc: https://redis.io/docs/latest/commands/xtrim/
+func xtrim(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("xtrim")
+	}
+
+	var t streamTrim
+	for i := 1; i < len(args); {
+		next, ok, err := parseStreamTrimOption(args, i, &t)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		if !ok {
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+		i = next
+	}
+
+	if t.strategy == trimNone {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	if err := validateStreamTrim(t); err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	st, err := db.writableStream(args[0].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if st == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: st.trim(t)}
+}
+
+// the options shared by XREAD and XREADGROUP.
+type streamReadOptions struct {
+	count   int // 0 for no limit.
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+// parses "[COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]",
+// NOACK is only accepted by XREADGROUP.
+func parseStreamReadOptions(name string, args []Value, group bool) (streamReadOptions, error) {
+	var opts streamReadOptions
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+
+		switch {
+		case option == "count" && i+1 < len(args):
+			count, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			opts.count = max(count, 0)
+			i++
+
+		case option == "block" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return opts, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errors.New("ERR timeout is negative")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(min(ms, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+			i++
+
+		case option == "noack" && group:
+			opts.noAck = true
+
+		case option == "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return opts, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", name)
+			}
+
+			for _, key := range streams[:len(streams)/2] {
+				opts.keys = append(opts.keys, key.Bulk)
+			}
+			for _, id := range streams[len(streams)/2:] {
+				opts.ids = append(opts.ids, id.Bulk)
+			}
+			return opts, nil
+
+		case option == "group" && !group:
+			return opts, errors.New("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+
+		default:
+			return opts, errSyntax
+		}
+	}
+
+	return opts, errSyntax
+}
+
+// the reply of XREAD and XREADGROUP: the entries of every stream, a map
+// from the keys to the entries for RESP3 clients.
+func streamReadReply(ctx context.Context, keys []string, entries []Value) Value {
+	typ := "array"
+	if clientProto(ctx) >= RESP3 {
+		typ = "map"
+	}
+
+	reply := Value{Typ: typ, Array: []Value{}}
+	for i, key := range keys {
+		if typ == "map" {
+			reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: key}, entries[i])
+		} else {
+			reply.Array = append(reply.Array, Value{Typ: "array", Array: []Value{{Typ: "bulk", Bulk: key}, entries[i]}})
+		}
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/xread/
+func xread(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("xread")
+	}
+
+	opts, err := parseStreamReadOptions("xread", args, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+
+	// "$" is the last ID of the stream when the command is sent.
+	ids := make([]streamID, len(opts.keys))
+	unlock := db.rlock(ctx, opts.keys...)
+	for i, arg := range opts.ids {
+		if arg == "$" {
+			st, err := db.lookupStream(opts.keys[i])
+			if err != nil {
+				unlock()
+				return Value{Typ: "error", Str: err.Error()}
+			}
+			if st != nil {
+				ids[i] = st.lastID
+			}
+			continue
+		}
+
+		if ids[i], err = parseStreamID(arg, 0); err != nil {
+			unlock()
+			return Value{Typ: "error", Str: err.Error()}
+		}
+	}
+	unlock()
+
+	count := opts.count
+	if count == 0 {
+		count = -1
+	}
+
+	// the entries after the IDs of every stream, the client is
+	// served as soon as one of them has some.
+	read := func(string) (Value, []Value, bool) {
+		var keys []string
+		var entries []Value
+
+		for i, key := range opts.keys {
+			st, err := db.lookupStream(key)
+			if err != nil {
+				return Value{Typ: "error", Str: err.Error()}, nil, true
+			}
+			if st == nil {
+				continue
+			}
+
+			start, ok := ids[i].incr()
+			if !ok {
+				continue
+			}
+
+			if found := st.rangeEntries(start, streamMaxID, count, false); len(found) > 0 {
+				keys = append(keys, key)
+				entries = append(entries, streamEntriesReply(found))
+			}
+		}
+
+		if len(keys) == 0 {
+			return Value{}, nil, false
+		}
+		return streamReadReply(ctx, keys, entries), nil, true
+	}
+
+	if !opts.block {
+		unlock := db.rlock(ctx, opts.keys...)
+		defer unlock()
+
+		if reply, _, ok := read(""); ok {
+			return reply
+		}
+		return Value{Typ: "nullarray"}
+	}
+
+	w := &waiter{db: db, keys: opts.keys, serve: read}
+	return blockForKeys(ctx, w, opts.timeout, Value{Typ: "nullarray"})
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the IDs of the entries of an XRANGE reply.
+func streamIDs(v Value) []string {
+	ids := []string{}
+	for _, entry := range v.Array {
+		ids = append(ids, entry.Array[0].Bulk)
+	}
+	return ids
+}
+
+func TestStreamID(t *testing.T) {
+	t.Run("It parses and orders IDs", func(t *testing.T) {
+		id, err := parseStreamID("5-3", 0)
+		assert.Nil(t, err)
+		assert.Equal(t, streamID{ms: 5, seq: 3}, id)
+
+		id, _ = parseStreamID("5", 7)
+		assert.Equal(t, streamID{ms: 5, seq: 7}, id)
+
+		_, err = parseStreamID("5-x", 0)
+		assert.Equal(t, errInvalidStreamID, err)
+
+		next, ok := streamID{ms: 1, seq: streamMaxID.seq}.incr()
+		assert.True(t, ok)
+		assert.Equal(t, streamID{ms: 2}, next)
+		_, ok = streamMaxID.incr()
+		assert.False(t, ok)
+		assert.True(t, streamID{ms: 1, seq: 9}.less(streamID{ms: 2}))
+	})
+}
+
+func TestStreamCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It adds entries with generated and explicit IDs", func(t *testing.T) {
+		key := newTestKey(t)
+
+		id := xadd(ctx, command(key, "*", "f", "v").Array).Bulk
+		generated, err := parseStreamID(id, 0)
+		assert.Nil(t, err)
+		assert.InDelta(t, time.Now().UnixMilli(), int64(generated.ms), 1000)
+
+		assert.Equal(t, strconv.FormatUint(generated.ms, 10)+"-1", xadd(ctx, command(key, strconv.FormatUint(generated.ms, 10)+"-*", "f", "v").Array).Bulk)
+		assert.Equal(t, errStreamIDTooSmall.Error(), xadd(ctx, command(key, "1-1", "f", "v").Array).Str)
+		assert.Equal(t, 2, xlen(ctx, command(key).Array).Num)
+
+		other := newTestKey(t)
+		assert.Equal(t, "ERR The ID specified in XADD must be greater than 0-0", xadd(ctx, command(other, "0-0", "f", "v").Array).Str)
+		assert.Equal(t, "null", xadd(ctx, command(other, "NOMKSTREAM", "*", "f", "v").Array).Typ)
+		assert.Equal(t, -2, ttl(ctx, command(other).Array).Num)
+		assert.Equal(t, "ERR wrong number of arguments for 'xadd' command", xadd(ctx, command(other, "*", "f").Array).Str)
+	})
+
+	t.Run("It writes the generated ID in the AOF", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t)
+
+		id := server.call(c, command("XADD", key, "MAXLEN", "10", "*", "f", "v")).Bulk
+		assert.Equal(t, []string{"xadd", key, "MAXLEN", "10", id, "f", "v"}, bulks(c.propagated[0]))
+	})
+
+	t.Run("It returns ranges of entries", func(t *testing.T) {
+		key := newTestKey(t)
+		for i := 1; i <= 5; i++ {
+			xadd(ctx, command(key, strconv.Itoa(i)+"-0", "n", strconv.Itoa(i)).Array)
+		}
+
+		assert.Equal(t, []string{"1-0", "2-0", "3-0", "4-0", "5-0"}, streamIDs(xrange(ctx, command(key, "-", "+").Array)))
+		assert.Equal(t, []string{"3-0", "4-0"}, streamIDs(xrange(ctx, command(key, "(2-0", "4").Array)))
+		assert.Equal(t, []string{"5-0", "4-0"}, streamIDs(xrevrange(ctx, command(key, "+", "-", "COUNT", "2").Array)))
+		assert.Empty(t, xrange(ctx, command(key, "4", "2").Array).Array)
+
+		entry := xrange(ctx, command(key, "1", "1").Array).Array[0]
+		assert.Equal(t, []string{"n", "1"}, bulks(entry.Array[1]))
+
+		assert.Equal(t, "ERR invalid end ID for the interval", xrange(ctx, command(key, "-", "(0-0").Array).Str)
+	})
+
+	t.Run("It deletes and trims entries", func(t *testing.T) {
+		key := newTestKey(t)
+		for i := 1; i <= 6; i++ {
+			xadd(ctx, command(key, strconv.Itoa(i)+"-0", "n", strconv.Itoa(i)).Array)
+		}
+
+		assert.Equal(t, 1, xdel(ctx, command(key, "3-0", "9-0").Array).Num)
+		assert.Equal(t, 2, xtrim(ctx, command(key, "MINID", "4").Array).Num)
+		assert.Equal(t, 1, xtrim(ctx, command(key, "MAXLEN", "~", "1", "LIMIT", "1").Array).Num)
+		assert.Equal(t, []string{"5-0", "6-0"}, streamIDs(xrange(ctx, command(key, "-", "+").Array)))
+
+		xadd(ctx, command(key, "MAXLEN", "=", "1", "7-0", "n", "7").Array)
+		assert.Equal(t, []string{"7-0"}, streamIDs(xrange(ctx, command(key, "-", "+").Array)))
+
+		assert.Equal(t, "ERR syntax error, LIMIT cannot be used without the special ~ option", xtrim(ctx, command(key, "MAXLEN", "1", "LIMIT", "1").Array).Str)
+		assert.Equal(t, "ERR The MAXLEN argument must be >= 0.", xtrim(ctx, command(key, "MAXLEN", "-1").Array).Str)
+
+		// streams stay in the keyspace once empty.
+		xdel(ctx, command(key, "7-0").Array)
+		assert.Equal(t, -1, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It reads the entries added after the IDs", func(t *testing.T) {
+		a, b := newTestKey(t), newTestKey(t)
+		xadd(ctx, command(a, "1-0", "f", "v").Array)
+		xadd(ctx, command(a, "2-0", "f", "v").Array)
+		xadd(ctx, command(b, "1-0", "f", "v").Array)
+
+		result := xread(ctx, command("COUNT", "1", "STREAMS", a, b, "0", "1-0").Array)
+		assert.Len(t, result.Array, 1)
+		assert.Equal(t, a, result.Array[0].Array[0].Bulk)
+		assert.Equal(t, []string{"1-0"}, streamIDs(result.Array[0].Array[1]))
+
+		assert.Equal(t, "nullarray", xread(ctx, command("STREAMS", a, "$").Array).Typ)
+		assert.Equal(t, "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.", xread(ctx, command("STREAMS", a, b, "0").Array).Str)
+	})
+
+	t.Run("It blocks XREAD until an entry is added", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		key := newTestKey(t)
+		xadd(ctx, command(key, "1-0", "f", "v").Array)
+
+		first := runBlocking(server, testClient(t, server), command("XREAD", "BLOCK", "0", "STREAMS", key, "$"))
+		waitBlocked(t, server, key, 1)
+		second := runBlocking(server, testClient(t, server), command("XREAD", "BLOCK", "0", "STREAMS", key, "$"))
+		waitBlocked(t, server, key, 2)
+
+		server.handleCommandExecution(testClient(t, server), command("XADD", key, "2-0", "f", "v"))
+
+		// every reader gets the entry, reading does not consume it.
+		for _, result := range []chan Value{first, second} {
+			reply := receive(t, result)
+			assert.Equal(t, key, reply.Array[0].Array[0].Bulk)
+			assert.Equal(t, []string{"2-0"}, streamIDs(reply.Array[0].Array[1]))
+		}
+
+		reply := server.handleCommandExecution(testClient(t, server), command("XREAD", "BLOCK", "10", "STREAMS", key, "$"))
+		assert.Equal(t, "nullarray", reply.Typ)
+	})
+}
@@ -0,0 +1,863 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// a string value. Like redis does with its int encoding, strings holding
+// an integer are kept as an int64: it needs no allocation where the digits
+// would, and INCR neither parses nor formats the value. The other strings
+// are kept in a buffer APPEND, SETRANGE and the bit commands change in place
+// like redis changes its sds strings, rather than copying the whole value.
+type stringValue struct {
+	buf   []byte
+	num   int64
+	isInt bool
+}
+
+// the value of s, int encoded when s is the canonical form of an
+// integer: "12" is, "012" and "+12" are not since they would not read
+// back the same.
+func newStringValue(s string) stringValue {
+	if len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			return stringValue{num: n, isInt: true}
+		}
+	}
+	return stringValue{buf: []byte(s)}
+}
+
+func (v stringValue) String() string {
+	if v.isInt {
+		return strconv.FormatInt(v.num, 10)
+	}
+	return string(v.buf)
+}
+
+// a copy of the value that does not share its buffer.
+func (v stringValue) clone() stringValue {
+	v.buf = bytes.Clone(v.buf)
+	return v
+}
+
+// the value as an integer, ok is false when it is not one.
+func (v stringValue) integer() (int64, bool) {
+	if v.isInt {
+		return v.num, true
+	}
+
+	n, err := strconv.ParseInt(string(v.buf), 10, 64)
+	return n, err == nil
+}
+
+// the bytes of the value, the digits of integers. They belong to
+// the value, commands changing them store the value again.
+func (v stringValue) bytes() []byte {
+	if v.isInt {
+		return strconv.AppendInt(nil, v.num, 10)
+	}
+	return v.buf
+}
+
+// the string value stored at key for commands reading it, ok is false when
+// there is none. The store must be locked.
+func (s *SimpleStore) lookupStringValue(key string) (v stringValue, ok bool, err error) {
+	o := s.lookup(key)
+	if o == nil {
+		return stringValue{}, false, nil
+	}
+	if o.typ != typeString {
+		return stringValue{}, false, errWrongType
+	}
+	return o.value.(stringValue), true, nil
+}
+
+// the string stored at key for commands reading it. The store must be locked.
+func (s *SimpleStore) lookupString(key string) (string, bool, error) {
+	v, ok, err := s.lookupStringValue(key)
+	return v.String(), ok, err
+}
+
+// stores the string at key in place of any value it held, its time to
+// live is kept. The store must be locked for writing.
+func (s *SimpleStore) setString(key string, v stringValue) {
+	sh := s.shard(key)
+	o, ok := sh.keys.get(key)
+	if !ok {
+		sh.keys.set(key, newObject(typeString, v))
+		return
+	}
+
+	if o.typ == typeHash {
+		delete(sh.volatileHashes, key)
+	}
+	o.typ, o.value = typeString, v
+}
+
+// the bytes of the string stored at key for commands reading them without
+// a copy, they must not be changed. The store must be locked.
+func (s *SimpleStore) lookupBytes(key string) ([]byte, bool, error) {
+	v, ok, err := s.lookupStringValue(key)
+	return v.bytes(), ok, err
+}
+
+// the proto-max-bulk-len of the server of the client, redis refuses to
+// build strings longer than the longest one a client could send.
+func maxStringLength(ctx context.Context) int64 {
+	if c := clientFromContext(ctx); c != nil {
+		return c.srv.config.GetInt("proto-max-bulk-len")
+	}
+	return DefaultProtoMaxBulkLen
+}
+
+func checkStringLength(ctx context.Context, size int64) error {
+	if size > maxStringLength(ctx) {
+		return errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	return nil
+}
+
+// grows the buffer to size bytes when it is shorter, the new bytes are zero.
+func growString(buf []byte, size int) []byte {
+	if len(buf) >= size {
+		return buf
+	}
+	return append(buf, make([]byte, size-len(buf))...)
+}
+
+// flags of the options of SET and GETEX.
+const (
+	setNX      = 1 << iota // only set the key if it does not exist.
+	setXX                  // only set the key if it exists.
+	setGet                 // reply with the value the key held.
+	setKeepTTL             // keep the time to live of the key.
+	setExpire              // one of EX, PX, EXAT or PXAT was given.
+	setPersist             // remove the time to live, GETEX only.
+)
+
+// doc: https://redis.io/docs/latest/commands/set/
+func set(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("set")
+	}
+
+	key := args[0].Bulk
+	value := args[1].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	flags, expireAt, err := parseStringOptions("set", args[2:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// a time to live that passed is not kept by KEEPTTL.
+	db.expireIfNeeded(key)
+
+	// SET replaces values of any type, unless it has to reply with them.
+	old, exists, err := db.lookupString(key)
+	if err != nil && flags&setGet != 0 {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	reply := Value{Typ: "string", Str: "OK"}
+	if flags&setGet != 0 {
+		reply = bulkOrNull(old, exists)
+	}
+
+	if flags&setNX != 0 && db.keyExists(key) || flags&setXX != 0 && !db.keyExists(key) {
+		propagateAs(ctx)
+		if flags&setGet != 0 {
+			return reply
+		}
+		return Value{Typ: "null"}
+	}
+
+	db.setString(key, newStringValue(value))
+
+	switch {
+	case flags&setExpire != 0:
+		db.setExpire(key, expireAt)
+		// restarting later must not make the key live longer.
+		propagateAs(ctx, command("set", key, value, "pxat", strconv.FormatInt(expireAt, 10)))
+	case flags&setKeepTTL == 0:
+		db.removeExpire(key)
+	}
+
+	return reply
+}
+
+// parses the options of SET and GETEX, and of HSETEX and HGETEX which
+// name NX and XX FNX and FXX, the expire time is returned as the unix time
+// in milliseconds the key expires at. Port of
+// parseExtendedStringArgumentsOrReply: https://github.com/redis/redis/blob/unstable/src/t_string.c
+func parseStringOptions(name string, args []Value) (flags int, expireAt int64, err error) {
+	isSet := name == "set" || name == "hsetex"
+	nx, xx := "nx", "xx"
+	if name == "hsetex" {
+		nx, xx = "fnx", "fxx"
+	}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+		hasArg := i+1 < len(args)
+		unit, relative, isExpire := setExpireOption(option)
+
+		switch {
+		case option == nx && isSet && flags&setXX == 0:
+			flags |= setNX
+		case option == xx && isSet && flags&setNX == 0:
+			flags |= setXX
+		case option == "get" && name == "set":
+			flags |= setGet
+		case option == "keepttl" && isSet && flags&(setExpire|setKeepTTL) == 0:
+			flags |= setKeepTTL
+		case option == "persist" && !isSet && flags&(setExpire|setPersist) == 0:
+			flags |= setPersist
+		// only one of the expire options can be given.
+		case isExpire && hasArg && flags&(setExpire|setKeepTTL|setPersist) == 0:
+			when, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return 0, 0, errNotInteger
+			}
+
+			if when <= 0 {
+				return 0, 0, errors.New(invalidExpireTime(name).Str)
+			}
+
+			expireAt, err = absoluteExpireTime(when, unit, relative)
+			if err != nil {
+				return 0, 0, errors.New(invalidExpireTime(name).Str)
+			}
+
+			flags |= setExpire
+			i++
+		default:
+			return 0, 0, errSyntax
+		}
+	}
+
+	return flags, expireAt, nil
+}
+
+// the unit and kind of the expire time given with the options EX, PX, EXAT and PXAT.
+func setExpireOption(option string) (unit int64, relative bool, ok bool) {
+	switch option {
+	case "ex":
+		return 1000, true, true
+	case "px":
+		return 1, true, true
+	case "exat":
+		return 1000, false, true
+	case "pxat":
+		return 1, false, true
+	default:
+		return 0, false, false
+	}
+}
+
+func get(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("get")
+	}
+
+	key := args[0].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, key)
+	defer unlock()
+
+	value, ok, err := db.lookupString(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if !ok {
+		return Value{Typ: "null"}
+	}
+
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// doc: https://redis.io/docs/latest/commands/setnx/
+func setnx(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("setnx")
+	}
+
+	key := args[0].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+	db.expireIfNeeded(key)
+
+	if db.keyExists(key) {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	db.setString(key, newStringValue(args[1].Bulk))
+	return Value{Typ: "integer", Num: 1}
+}
+
+// doc: https://redis.io/docs/latest/commands/getset/
+func getset(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("getset")
+	}
+
+	key := args[0].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+	db.expireIfNeeded(key)
+
+	old, exists, err := db.lookupString(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db.setString(key, newStringValue(args[1].Bulk))
+	db.removeExpire(key)
+
+	return bulkOrNull(old, exists)
+}
+
+// doc: https://redis.io/docs/latest/commands/getdel/
+func getdel(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("getdel")
+	}
+
+	key := args[0].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+	db.expireIfNeeded(key)
+
+	value, ok, err := db.lookupString(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if !ok {
+		propagateAs(ctx)
+		return Value{Typ: "null"}
+	}
+
+	db.deleteKey(key)
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// doc: https://redis.io/docs/latest/commands/getex/
+func getex(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("getex")
+	}
+
+	key := args[0].Bulk
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	flags, expireAt, err := parseStringOptions("getex", args[1:])
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db.expireIfNeeded(key)
+
+	value, ok, err := db.lookupString(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if !ok {
+		propagateAs(ctx)
+		return Value{Typ: "null"}
+	}
+
+	switch {
+	case flags&setExpire != 0:
+		if expireAt <= db.now() {
+			db.deleteKey(key)
+		} else {
+			db.setExpire(key, expireAt)
+		}
+		propagateAs(ctx, command("pexpireat", key, strconv.FormatInt(expireAt, 10)))
+	case flags&setPersist != 0:
+		db.removeExpire(key)
+		propagateAs(ctx, command("persist", key))
+	default:
+		// a plain GET, nothing to write.
+		propagateAs(ctx)
+	}
+
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// doc: https://redis.io/docs/latest/commands/incr/
+func incr(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("incr")
+	}
+	return incrDecr(ctx, args[0].Bulk, 1)
+}
+
+// doc: https://redis.io/docs/latest/commands/decr/
+func decr(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("decr")
+	}
+	return incrDecr(ctx, args[0].Bulk, -1)
+}
+
+// doc: https://redis.io/docs/latest/commands/incrby/
+func incrby(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("incrby")
+	}
+
+	incr, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+	return incrDecr(ctx, args[0].Bulk, incr)
+}
+
+// doc: https://redis.io/docs/latest/commands/decrby/
+func decrby(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("decrby")
+	}
+
+	decr, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	// the opposite of the smallest integer does not fit in one.
+	if decr == math.MinInt64 {
+		return Value{Typ: "error", Str: "ERR decrement would overflow"}
+	}
+	return incrDecr(ctx, args[0].Bulk, -decr)
+}
+
+// adds incr to the integer stored at key, a missing key counts as 0.
+// The time to live of the key is kept.
+func incrDecr(ctx context.Context, key string, incr int64) Value {
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	db.expireIfNeeded(key)
+
+	v, ok, err := db.lookupStringValue(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	current := int64(0)
+	if ok {
+		if current, ok = v.integer(); !ok {
+			return Value{Typ: "error", Str: errNotInteger.Error()}
+		}
+	}
+
+	if (incr < 0 && current < math.MinInt64-incr) || (incr > 0 && current > math.MaxInt64-incr) {
+		return Value{Typ: "error", Str: "ERR increment or decrement would overflow"}
+	}
+
+	current += incr
+	db.setString(key, stringValue{num: current, isInt: true})
+	return Value{Typ: "integer", Num: int(current)}
+}
+
+// doc: https://redis.io/docs/latest/commands/incrbyfloat/
+func incrbyfloat(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("incrbyfloat")
+	}
+
+	incr, err := parseFloat(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	db.expireIfNeeded(key)
+
+	value, ok, err := db.lookupString(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	current := 0.0
+	if ok {
+		if current, err = parseFloat(value); err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+	}
+
+	current += incr
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return Value{Typ: "error", Str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	if !ok {
+		value = "0"
+	}
+	value = addLongDoubles(value, args[1].Bulk)
+	db.setString(key, newStringValue(value))
+
+	// the AOF gets the result so float rounding cannot differ when replaying it.
+	propagateAs(ctx, command("set", key, value, "KEEPTTL"))
+	return Value{Typ: "bulk", Bulk: value}
+}
+
+// doc: https://redis.io/docs/latest/commands/append/
+func appendCommand(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("append")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	db.expireIfNeeded(key)
+
+	old, exists, err := db.lookupStringValue(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if !exists {
+		db.setString(key, newStringValue(args[1].Bulk))
+		return Value{Typ: "integer", Num: len(args[1].Bulk)}
+	}
+
+	value := old.bytes()
+	if err := checkStringLength(ctx, int64(len(value)+len(args[1].Bulk))); err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	value = append(value, args[1].Bulk...)
+	db.setString(key, stringValue{buf: value})
+	return Value{Typ: "integer", Num: len(value)}
+}
+
+// doc: https://redis.io/docs/latest/commands/strlen/
+func strlen(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("strlen")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	value, _, err := db.lookupString(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	return Value{Typ: "integer", Num: len(value)}
+}
+
+// doc: https://redis.io/docs/latest/commands/getrange/
+func getrange(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("getrange")
+	}
+
+	start, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	end, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	value, _, err := db.lookupString(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	length := int64(len(value))
+
+	// negative indexes count from the end, port of getrangeCommand:
+	// https://github.com/redis/redis/blob/unstable/src/t_string.c
+	if start < 0 && end < 0 && start > end {
+		return Value{Typ: "bulk", Bulk: ""}
+	}
+	if start < 0 {
+		start = max(start+length, 0)
+	}
+	if end < 0 {
+		end = max(end+length, 0)
+	}
+	end = min(end, length-1)
+
+	if length == 0 || start > end {
+		return Value{Typ: "bulk", Bulk: ""}
+	}
+	return Value{Typ: "bulk", Bulk: value[start : end+1]}
+}
+
+// doc: https://redis.io/docs/latest/commands/setrange/
+func setrange(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("setrange")
+	}
+
+	offset, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+	if offset < 0 {
+		return Value{Typ: "error", Str: "ERR offset is out of range"}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key, patch := args[0].Bulk, args[2].Bulk
+	db.expireIfNeeded(key)
+
+	value, _, err := db.lookupBytes(key)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	// nothing to write, a missing key is not created.
+	if len(patch) == 0 {
+		propagateAs(ctx)
+		return Value{Typ: "integer", Num: len(value)}
+	}
+
+	if err := checkStringLength(ctx, offset+int64(len(patch))); err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	value = growString(value, int(offset)+len(patch))
+	copy(value[offset:], patch)
+
+	db.setString(key, stringValue{buf: value})
+	return Value{Typ: "integer", Num: len(value)}
+}
+
+// doc: https://redis.io/docs/latest/commands/mget/
+func mget(ctx context.Context, args []Value) Value {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("mget")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, keysOf(args)...)
+	defer unlock()
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, key := range args {
+		// the keys holding another type are replied with null.
+		value, ok, _ := db.lookupString(key.Bulk)
+		reply.Array = append(reply.Array, bulkOrNull(value, ok))
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/mset/
+func mset(ctx context.Context, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongNumberOfArgs("mset")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, msetKeys(args)...)
+	defer unlock()
+
+	db.msetPairs(args)
+	return Value{Typ: "string", Str: "OK"}
+}
+
+// doc: https://redis.io/docs/latest/commands/msetnx/
+func msetnx(ctx context.Context, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongNumberOfArgs("msetnx")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, msetKeys(args)...)
+	defer unlock()
+
+	// none of the keys is set when one of them exists.
+	for i := 0; i < len(args); i += 2 {
+		if db.keyExists(args[i].Bulk) {
+			propagateAs(ctx)
+			return Value{Typ: "integer", Num: 0}
+		}
+	}
+
+	db.msetPairs(args)
+	return Value{Typ: "integer", Num: 1}
+}
+
+// sets the keys to their values like SET does, the store must be locked for writing.
+func (s *SimpleStore) msetPairs(args []Value) {
+	for i := 0; i < len(args); i += 2 {
+		key := args[i].Bulk
+		s.setString(key, newStringValue(args[i+1].Bulk))
+		s.removeExpire(key)
+	}
+}
+
+// the keys of the key value pairs of MSET and MSETNX.
+func msetKeys(args []Value) []string {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i].Bulk)
+	}
+	return keys
+}
+
+// doc: https://redis.io/docs/latest/commands/lcs/
+func lcs(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("lcs")
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+
+		switch {
+		case option == "len":
+			getLen = true
+		case option == "idx":
+			getIdx = true
+		case option == "withmatchlen":
+			withMatchLen = true
+		case option == "minmatchlen" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return Value{Typ: "error", Str: errNotInteger.Error()}
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+	}
+
+	if getLen && getIdx {
+		return Value{Typ: "error", Str: "ERR If you want both the length and indexes, please just use IDX."}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	a, _, err := db.lookupString(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	b, _, err := db.lookupString(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	if uint64(len(a)+1)*uint64(len(b)+1) >= math.MaxUint32/4 {
+		return Value{Typ: "error", Str: "ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len"}
+	}
+
+	// lengths[i*(len(b)+1)+j] is the length of the LCS of the first i
+	// bytes of a and the first j bytes of b. Port of lcsCommand:
+	// https://github.com/redis/redis/blob/unstable/src/t_string.c
+	width := len(b) + 1
+	lengths := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lengths[i*width+j] = lengths[(i-1)*width+j-1] + 1
+			} else {
+				lengths[i*width+j] = max(lengths[(i-1)*width+j], lengths[i*width+j-1])
+			}
+		}
+	}
+
+	length := int(lengths[len(a)*width+len(b)])
+	if getLen {
+		return Value{Typ: "integer", Num: length}
+	}
+
+	// walk the table back from the end, collecting the LCS and the
+	// ranges where a and b match, from the last to the first.
+	result := make([]byte, length)
+	matches := []Value{}
+	noRange := len(a)
+	aStart, aEnd, bStart, bEnd := noRange, 0, 0, 0
+
+	for i, j, k := len(a), len(b), length; i > 0 && j > 0; {
+		emitRange := false
+
+		if a[i-1] == b[j-1] {
+			result[k-1] = a[i-1]
+			if aStart == noRange {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else {
+				aStart--
+				bStart--
+			}
+
+			emitRange = aStart == 0 || bStart == 0
+			i, j, k = i-1, j-1, k-1
+		} else {
+			if lengths[(i-1)*width+j] > lengths[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			emitRange = aStart != noRange
+		}
+
+		if emitRange {
+			if matchLen := aEnd - aStart + 1; matchLen >= minMatchLen {
+				match := Value{Typ: "array", Array: []Value{
+					{Typ: "array", Array: []Value{{Typ: "integer", Num: aStart}, {Typ: "integer", Num: aEnd}}},
+					{Typ: "array", Array: []Value{{Typ: "integer", Num: bStart}, {Typ: "integer", Num: bEnd}}},
+				}}
+				if withMatchLen {
+					match.Array = append(match.Array, Value{Typ: "integer", Num: matchLen})
+				}
+				matches = append(matches, match)
+			}
+			aStart = noRange
+		}
+	}
+
+	if getIdx {
+		return Value{Typ: "map", Array: []Value{
+			{Typ: "bulk", Bulk: "matches"}, {Typ: "array", Array: matches},
+			{Typ: "bulk", Bulk: "len"}, {Typ: "integer", Num: length},
+		}}
+	}
+	return Value{Typ: "bulk", Bulk: string(result)}
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringValue(t *testing.T) {
+	t.Run("It keeps integers int encoded", func(t *testing.T) {
+		assert.Equal(t, stringValue{num: 12, isInt: true}, newStringValue("12"))
+		assert.Equal(t, stringValue{num: -9223372036854775808, isInt: true}, newStringValue("-9223372036854775808"))
+		assert.False(t, newStringValue("012").isInt)
+		assert.False(t, newStringValue("+12").isInt)
+		assert.False(t, newStringValue("1.5").isInt)
+		assert.False(t, newStringValue("9223372036854775808").isInt)
+
+		assert.Equal(t, "-42", newStringValue("-42").String())
+		n, ok := newStringValue("007").integer()
+		assert.True(t, ok)
+		assert.Equal(t, int64(7), n)
+	})
+}
+
+func TestStringCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It increments and decrements integers", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 1, incr(ctx, command(key).Array).Num)
+		assert.Equal(t, 11, incrby(ctx, command(key, "10").Array).Num)
+		assert.Equal(t, 10, decr(ctx, command(key).Array).Num)
+		assert.Equal(t, -5, decrby(ctx, command(key, "15").Array).Num)
+		assert.Equal(t, "-5", get(ctx, command(key).Array).Bulk)
+		assert.True(t, storedValue[stringValue](key).isInt)
+
+		set(ctx, command(key, "9223372036854775806").Array)
+		assert.Equal(t, 9223372036854775807, incr(ctx, command(key).Array).Num)
+		assert.Equal(t, "ERR increment or decrement would overflow", incr(ctx, command(key).Array).Str)
+		assert.Equal(t, "ERR decrement would overflow", decrby(ctx, command(key, "-9223372036854775808").Array).Str)
+		assert.Equal(t, errNotInteger.Error(), incrby(ctx, command(key, "x").Array).Str)
+
+		set(ctx, command(key, "ten").Array)
+		assert.Equal(t, errNotInteger.Error(), incr(ctx, command(key).Array).Str)
+	})
+
+	t.Run("It keeps the time to live when incrementing", func(t *testing.T) {
+		key := newTestKey(t)
+		set(ctx, command(key, "1", "EX", "100").Array)
+
+		incr(ctx, command(key).Array)
+		assert.Equal(t, 100, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It increments floats", func(t *testing.T) {
+		key := newTestKey(t, "SET", "10.50")
+
+		assert.Equal(t, "10.6", incrbyfloat(ctx, command(key, "0.1").Array).Bulk)
+		assert.Equal(t, "5010.6", incrbyfloat(ctx, command(key, "5e3").Array).Bulk)
+		assert.Equal(t, "ERR value is not a valid float", incrbyfloat(ctx, command(key, "x").Array).Str)
+		assert.Equal(t, "ERR increment would produce NaN or Infinity", incrbyfloat(ctx, command(key, "inf").Array).Str)
+
+		set(ctx, command(key, "ten").Array)
+		assert.Equal(t, "ERR value is not a valid float", incrbyfloat(ctx, command(key, "1").Array).Str)
+	})
+
+	t.Run("It rounds the floats it increments like redis", func(t *testing.T) {
+		key := newTestKey(t, "SET", "1.1")
+
+		assert.Equal(t, "3.3", incrbyfloat(ctx, command(key, "2.2").Array).Bulk)
+		assert.Equal(t, "0", incrbyfloat(ctx, command(key, "-3.3").Array).Bulk)
+		assert.Equal(t, "100000000000000000000", incrbyfloat(ctx, command(key, "1e20").Array).Bulk)
+
+		key = newTestKey(t, "SET", "0")
+		assert.Equal(t, "-0.000001", incrbyfloat(ctx, command(key, "-1e-6").Array).Bulk)
+	})
+
+	t.Run("It writes the result of INCRBYFLOAT in the AOF", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		key := newTestKey(t, "SET", "1.5")
+
+		server.call(c, command("INCRBYFLOAT", key, "1"))
+		assert.Equal(t, []string{"set", key, "2.5", "KEEPTTL"}, bulks(c.propagated[0]))
+	})
+
+	t.Run("It appends and reads ranges", func(t *testing.T) {
+		key := newTestKey(t)
+
+		assert.Equal(t, 5, appendCommand(ctx, command(key, "Hello").Array).Num)
+		assert.Equal(t, 11, appendCommand(ctx, command(key, " World").Array).Num)
+		assert.Equal(t, 11, strlen(ctx, command(key).Array).Num)
+		assert.Equal(t, 0, strlen(ctx, command("string-missing").Array).Num)
+
+		assert.Equal(t, "Hello", getrange(ctx, command(key, "0", "4").Array).Bulk)
+		assert.Equal(t, "World", getrange(ctx, command(key, "-5", "-1").Array).Bulk)
+		assert.Equal(t, "Hello World", getrange(ctx, command(key, "0", "100").Array).Bulk)
+		assert.Equal(t, "", getrange(ctx, command(key, "-1", "-5").Array).Bulk)
+		assert.Equal(t, "", getrange(ctx, command(key, "5", "3").Array).Bulk)
+
+		assert.Equal(t, 11, setrange(ctx, command(key, "6", "Redis").Array).Num)
+		assert.Equal(t, "Hello Redis", get(ctx, command(key).Array).Bulk)
+
+		padded := newTestKey(t)
+		assert.Equal(t, 0, setrange(ctx, command(padded, "3", "").Array).Num)
+		assert.Equal(t, "null", get(ctx, command(padded).Array).Typ)
+		assert.Equal(t, 5, setrange(ctx, command(padded, "3", "ab").Array).Num)
+		assert.Equal(t, "\x00\x00\x00ab", get(ctx, command(padded).Array).Bulk)
+
+		assert.Equal(t, "ERR offset is out of range", setrange(ctx, command(key, "-1", "x").Array).Str)
+		assert.Equal(t, "ERR string exceeds maximum allowed size (proto-max-bulk-len)", setrange(ctx, command(key, "536870912", "x").Array).Str)
+	})
+
+	t.Run("It sets and gets many keys", func(t *testing.T) {
+		a, b, c := newTestKey(t), newTestKey(t), newTestKey(t)
+		set(ctx, command(a, "old", "EX", "100").Array)
+
+		assert.Equal(t, "OK", mset(ctx, command(a, "1", b, "2").Array).Str)
+		assert.Equal(t, -1, ttl(ctx, command(a).Array).Num)
+		assert.Equal(t, []string{"1", "2", ""}, bulks(mget(ctx, command(a, b, c).Array)))
+		assert.Equal(t, "null", mget(ctx, command(c).Array).Array[0].Typ)
+
+		assert.Equal(t, 0, msetnx(ctx, command(c, "3", a, "4").Array).Num)
+		assert.Equal(t, "null", get(ctx, command(c).Array).Typ)
+		assert.Equal(t, 1, msetnx(ctx, command(c, "3").Array).Num)
+		assert.Equal(t, "3", get(ctx, command(c).Array).Bulk)
+
+		assert.Equal(t, "ERR wrong number of arguments for 'mset' command", mset(ctx, command(a, "1", b).Array).Str)
+	})
+
+	t.Run("It finds the longest common subsequence", func(t *testing.T) {
+		a, b := newTestKey(t, "SET", "ohmytext"), newTestKey(t, "SET", "mynewtext")
+
+		assert.Equal(t, "mytext", lcs(ctx, command(a, b).Array).Bulk)
+		assert.Equal(t, 6, lcs(ctx, command(a, b, "LEN").Array).Num)
+
+		result := lcs(ctx, command(a, b, "IDX").Array)
+		assert.Equal(t, "map", result.Typ)
+		assert.Equal(t, "matches", result.Array[0].Bulk)
+		assert.Equal(t, []Value{
+			{Typ: "array", Array: []Value{
+				{Typ: "array", Array: []Value{{Typ: "integer", Num: 4}, {Typ: "integer", Num: 7}}},
+				{Typ: "array", Array: []Value{{Typ: "integer", Num: 5}, {Typ: "integer", Num: 8}}},
+			}},
+			{Typ: "array", Array: []Value{
+				{Typ: "array", Array: []Value{{Typ: "integer", Num: 2}, {Typ: "integer", Num: 3}}},
+				{Typ: "array", Array: []Value{{Typ: "integer", Num: 0}, {Typ: "integer", Num: 1}}},
+			}},
+		}, result.Array[1].Array)
+		assert.Equal(t, 6, result.Array[3].Num)
+
+		result = lcs(ctx, command(a, b, "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN").Array)
+		assert.Len(t, result.Array[1].Array, 1)
+		assert.Equal(t, 4, result.Array[1].Array[0].Array[2].Num)
+
+		assert.Equal(t, "", lcs(ctx, command(a, "string-missing").Array).Bulk)
+		assert.Equal(t, "ERR If you want both the length and indexes, please just use IDX.", lcs(ctx, command(a, b, "LEN", "IDX").Array).Str)
+	})
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// stringMatch reports whether str matches the glob-style pattern the way
+// redis matches patterns for KEYS, SCAN and CONFIG GET:
+//
+//	h?llo matches hello, hallo and hxllo
+//	h*llo matches hllo and heeeello
+//	h[ae]llo matches hello and hallo, but not hillo
+//	h[^e]llo matches hallo, hbllo, ... but not hello
+//	h[a-b]llo matches hallo and hbllo
+//
+// special characters can be escaped with a backslash.
+// port of stringmatchlen: https://github.com/redis/redis/blob/unstable/src/util.c
+func stringMatch(pattern, str string, nocase bool) bool {
+	if nocase {
+		pattern = strings.ToLower(pattern)
+		str = strings.ToLower(str)
+	}
+
+	skipLongerMatches := false
+	return globMatch(pattern, str, &skipLongerMatches)
+}
+
+// once a star fails to match the rest of the string, longer matches of any
+// outer star cannot succeed either, skipLongerMatches cuts the search short
+// so patterns like "*a*a*a*a*b" do not take exponential time.
+func globMatch(pattern, str string, skipLongerMatches *bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// collapse consecutive stars, a trailing one matches everything.
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for ; len(str) > 0; str = str[1:] {
+				if globMatch(pattern[1:], str, skipLongerMatches) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+			}
+			*skipLongerMatches = true
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for {
+				if len(pattern) == 0 {
+					// unterminated class, redis treats the end of the pattern as "]".
+					break
+				}
+
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+
+				pattern = pattern[1:]
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+
+			// the loop below moves past the closing bracket.
+			if len(pattern) == 0 {
+				return len(str) == 0
+			}
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(str) == 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formats a float without exponent and with as few digits as needed to
+// parse it back.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// the precision of the long doubles of redis, the 64 bits mantissa of
+// the x87 extended precision.
+const longDoublePrec = 64
+
+// the sum INCRBYFLOAT and HINCRBYFLOAT store, the floats they are given
+// as strings added the way redis does with long doubles and rounded to 17
+// significant digits like %.17Lg, written without exponent nor trailing
+// zeros. 1.1 plus 2.2 gives 3.3 where float64 gives 3.3000000000000003.
+// Both must be valid floats whose sum is finite.
+func addLongDoubles(a, b string) string {
+	x, _, errX := big.ParseFloat(a, 0, longDoublePrec, big.ToNearestEven)
+	y, _, errY := big.ParseFloat(b, 0, longDoublePrec, big.ToNearestEven)
+	if errX != nil || errY != nil {
+		fx, _ := strconv.ParseFloat(a, 64)
+		fy, _ := strconv.ParseFloat(b, 64)
+		return formatFloat(fx + fy)
+	}
+	sum := new(big.Float).SetPrec(longDoublePrec).Add(x, y)
+
+	// -d.dddddddddddddddde±dd, the 17 digits are placed around the point.
+	mantissa, exponent, _ := strings.Cut(sum.Text('e', 16), "e")
+	sign := ""
+	if mantissa[0] == '-' {
+		sign, mantissa = "-", mantissa[1:]
+	}
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(exponent)
+
+	var integer, fraction string
+	switch {
+	case exp < 0:
+		integer, fraction = "0", strings.Repeat("0", -exp-1)+digits
+	case exp+1 < len(digits):
+		integer, fraction = digits[:exp+1], digits[exp+1:]
+	default:
+		integer = digits + strings.Repeat("0", exp+1-len(digits))
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if fraction != "" {
+		integer += "." + fraction
+	}
+	if integer == "0" {
+		return "0"
+	}
+	return sign + integer
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		matches bool
+	}{
+		{pattern: "*", str: "anything", matches: true},
+		{pattern: "h?llo", str: "hello", matches: true},
+		{pattern: "h?llo", str: "hllo", matches: false},
+		{pattern: "h*llo", str: "heeeello", matches: true},
+		{pattern: "h[ae]llo", str: "hallo", matches: true},
+		{pattern: "h[ae]llo", str: "hillo", matches: false},
+		{pattern: "h[^e]llo", str: "hallo", matches: true},
+		{pattern: "h[^e]llo", str: "hello", matches: false},
+		{pattern: "h[a-b]llo", str: "hbllo", matches: true},
+		{pattern: "h[a-b]llo", str: "hcllo", matches: false},
+		{pattern: `h\*llo`, str: "h*llo", matches: true},
+		{pattern: `h\*llo`, str: "hello", matches: false},
+		{pattern: "user:*:name", str: "user:42:name", matches: true},
+		{pattern: "user:*:name", str: "user:42:email", matches: false},
+	}
+
+	for _, test := range tests {
+		t.Run("It matches "+test.str+" against "+test.pattern, func(t *testing.T) {
+			assert.Equal(t, test.matches, stringMatch(test.pattern, test.str, false))
+		})
+	}
+
+	t.Run("It ignores the case when asked to", func(t *testing.T) {
+		assert.True(t, stringMatch("APPEND*", "appendonly", true))
+		assert.False(t, stringMatch("APPEND*", "appendonly", false))
+	})
+
+	t.Run("It gives up quickly on patterns with many stars", func(t *testing.T) {
+		pattern := strings.Repeat("*a", 30) + "b"
+		assert.False(t, stringMatch(pattern, strings.Repeat("a", 100), false))
+	})
+}
@@ -0,0 +1,1174 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the options of ZADD, some of them are used by ZINCRBY too.
+const (
+	zaddIncr = 1 << iota // add the score to the current one.
+	zaddNX               // only add new members.
+	zaddXX               // only update existing members.
+	zaddGT               // only update members when the score increases.
+	zaddLT               // only update members when the score decreases.
+	zaddCH               // reply with the members changed rather than the ones added.
+)
+
+// what zset.add did with a member.
+const (
+	zaddOutNaN     = 1 << iota // the score is not a number, nothing was done.
+	zaddOutAdded               // the member is new.
+	zaddOutUpdated             // the score of the member changed.
+	zaddOutNop                 // the options prevented the change.
+)
+
+var (
+	errNotFloat       = errors.New("ERR value is not a valid float")
+	errRangeNotFloat  = errors.New("ERR min or max is not a float")
+	errRangeNotString = errors.New("ERR min or max not valid string range item")
+)
+
+// zset is a sorted set: the dict finds the score of a member in O(1) and
+// the skiplist keeps the members ordered for ranks and ranges in O(log n).
+type zset struct {
+	dict *dict[float64]
+	zsl  *zskiplist
+}
+
+// a member of a sorted set with its score.
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func newZset() *zset {
+	return &zset{dict: newDict[float64](), zsl: newZskiplist()}
+}
+
+func (z *zset) clone() *zset {
+	c := newZset()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.add(x.score, x.member, 0)
+	}
+	return c
+}
+
+func (z *zset) len() int {
+	return z.dict.len()
+}
+
+func (z *zset) score(member string) (float64, bool) {
+	return z.dict.get(member)
+}
+
+// adds the member or updates its score according to the zadd flags,
+// it returns what was done and the score of the member. Port of zsetAdd:
+// https://github.com/redis/redis/blob/unstable/src/t_zset.c
+func (z *zset) add(score float64, member string, flags int) (int, float64) {
+	current, exists := z.dict.get(member)
+
+	if !exists {
+		if flags&zaddXX != 0 {
+			return zaddOutNop, 0
+		}
+
+		z.zsl.insert(score, member)
+		z.dict.set(member, score)
+		return zaddOutAdded, score
+	}
+
+	if flags&zaddNX != 0 {
+		return zaddOutNop, current
+	}
+
+	if flags&zaddIncr != 0 {
+		score += current
+		if math.IsNaN(score) {
+			return zaddOutNaN, 0
+		}
+	}
+
+	if (flags&zaddLT != 0 && score >= current) || (flags&zaddGT != 0 && score <= current) {
+		return zaddOutNop, current
+	}
+
+	if score == current {
+		return 0, score
+	}
+
+	z.zsl.updateScore(current, member, score)
+	z.dict.set(member, score)
+	return zaddOutUpdated, score
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict.get(member)
+	if !ok {
+		return false
+	}
+
+	z.dict.delete(member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// the 0-based rank of the member, counted from the highest score when reverse.
+func (z *zset) rank(member string, reverse bool) (int, float64, bool) {
+	score, ok := z.dict.get(member)
+	if !ok {
+		return 0, 0, false
+	}
+
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.len() - rank, score, true
+	}
+	return rank - 1, score, true
+}
+
+// the members from the 0-based rank start to end, both included and in range.
+func (z *zset) rangeByRank(start, end int, reverse bool) []zsetEntry {
+	var x *zskiplistNode
+	if reverse {
+		x = z.zsl.byRank(z.len() - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+
+	entries := make([]zsetEntry, 0, end-start+1)
+	for n := start; n <= end && x != nil; n++ {
+		entries = append(entries, zsetEntry{member: x.member, score: x.score})
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// the members in the range once offset of them are skipped, at most limit
+// of them unless it is negative. Reverse starts from the highest score.
+func (z *zset) rangeIn(r zrangeBounds, reverse bool, offset, limit int) []zsetEntry {
+	entries := []zsetEntry{}
+	if offset < 0 {
+		return entries
+	}
+
+	var x *zskiplistNode
+	if reverse {
+		x = z.zsl.lastInRange(r)
+	} else {
+		x = z.zsl.firstInRange(r)
+	}
+
+	for ; x != nil && limit != 0; limit-- {
+		if (reverse && !r.gteMin(x)) || (!reverse && !r.lteMax(x)) {
+			break
+		}
+
+		if offset > 0 {
+			offset--
+			limit++
+		} else {
+			entries = append(entries, zsetEntry{member: x.member, score: x.score})
+		}
+
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// pops the member with the lowest score, or the highest one.
+func (z *zset) pop(max bool) zsetEntry {
+	x := z.zsl.header.level[0].forward
+	if max {
+		x = z.zsl.tail
+	}
+
+	entry := zsetEntry{member: x.member, score: x.score}
+	z.remove(x.member)
+	return entry
+}
+
+// a range of scores, the bounds are excluded when prefixed by "(".
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r zrangeSpec) gteMin(x *zskiplistNode) bool {
+	if r.minex {
+		return x.score > r.min
+	}
+	return x.score >= r.min
+}
+
+func (r zrangeSpec) lteMax(x *zskiplistNode) bool {
+	if r.maxex {
+		return x.score < r.max
+	}
+	return x.score <= r.max
+}
+
+func parseRangeBound(arg string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	value, err := parseFloat(arg)
+	if err != nil {
+		return 0, false, errRangeNotFloat
+	}
+	return value, exclusive, nil
+}
+
+func parseRange(min, max string) (zrangeSpec, error) {
+	var r zrangeSpec
+	var err error
+
+	if r.min, r.minex, err = parseRangeBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, err = parseRangeBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// a bound of a lexicographical range: "[member" includes the member,
+// "(member" excludes it, "-" and "+" are the lowest and highest strings.
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for "-", 1 for "+".
+}
+
+type zlexRangeSpec struct {
+	min, max lexBound
+}
+
+func (r zlexRangeSpec) gteMin(x *zskiplistNode) bool {
+	switch r.min.inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+
+	if r.min.exclusive {
+		return x.member > r.min.value
+	}
+	return x.member >= r.min.value
+}
+
+func (r zlexRangeSpec) lteMax(x *zskiplistNode) bool {
+	switch r.max.inf {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+
+	if r.max.exclusive {
+		return x.member < r.max.value
+	}
+	return x.member <= r.max.value
+}
+
+func parseLexBound(arg string) (lexBound, error) {
+	switch {
+	case arg == "+":
+		return lexBound{inf: 1}, nil
+	case arg == "-":
+		return lexBound{inf: -1}, nil
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, nil
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, nil
+	default:
+		return lexBound{}, errRangeNotString
+	}
+}
+
+func parseLexRange(min, max string) (zlexRangeSpec, error) {
+	var r zlexRangeSpec
+	var err error
+
+	if r.min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// parses a float, "inf", "+inf" and "-inf" included but not NaN.
+func parseFloat(arg string) (float64, error) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// the sorted set stored at key for commands reading it, nil when there is none.
+// The store must be locked.
+func (s *SimpleStore) lookupZset(key string) (*zset, error) {
+	return lookupValue[*zset](s, key, typeZset)
+}
+
+// the sorted set stored at key for commands writing it, an empty one is
+// stored when create is true and there is none. The store must be locked for writing.
+func (s *SimpleStore) writableZset(key string, create bool) (*zset, error) {
+	return writableValue(s, key, typeZset, create, newZset)
+}
+
+// redis never keeps empty sorted sets. The store must be locked for writing.
+func (s *SimpleStore) deleteZsetIfEmpty(key string, z *zset) {
+	if z.len() == 0 {
+		s.deleteKey(key)
+	}
+}
+
+// the reply of the commands returning members with their scores: RESP3
+// clients receive a pair per member, RESP2 ones a flat array.
+func zsetReply(ctx context.Context, entries []zsetEntry, withScores bool) Value {
+	reply := Value{Typ: "array", Array: make([]Value, 0, len(entries))}
+	pairs := clientProto(ctx) >= RESP3
+
+	for _, entry := range entries {
+		member := Value{Typ: "bulk", Bulk: entry.member}
+		if !withScores {
+			reply.Array = append(reply.Array, member)
+			continue
+		}
+
+		score := Value{Typ: "double", Double: entry.score}
+		if pairs {
+			reply.Array = append(reply.Array, Value{Typ: "array", Array: []Value{member, score}})
+		} else {
+			reply.Array = append(reply.Array, member, score)
+		}
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/zadd/
+func zadd(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("zadd")
+	}
+
+	flags := 0
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i].Bulk) {
+		case "nx":
+			flags |= zaddNX
+		case "xx":
+			flags |= zaddXX
+		case "gt":
+			flags |= zaddGT
+		case "lt":
+			flags |= zaddLT
+		case "ch":
+			flags |= zaddCH
+		case "incr":
+			flags |= zaddIncr
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return Value{Typ: "error", Str: "ERR XX and NX options at the same time are not compatible"}
+	}
+
+	if (flags&zaddGT != 0 && flags&zaddNX != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) || (flags&zaddGT != 0 && flags&zaddLT != 0) {
+		return Value{Typ: "error", Str: "ERR GT, LT, and/or NX options at the same time are not compatible"}
+	}
+
+	if flags&zaddIncr != 0 && len(pairs) > 2 {
+		return Value{Typ: "error", Str: "ERR INCR option supports a single increment-element pair"}
+	}
+
+	// nothing is added unless every score is valid.
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseFloat(pairs[j].Bulk)
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		scores = append(scores, score)
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	z, err := db.writableZset(key, flags&zaddXX == 0)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		if flags&zaddIncr != 0 {
+			return Value{Typ: "null"}
+		}
+		return Value{Typ: "integer", Num: 0}
+	}
+	defer db.deleteZsetIfEmpty(key, z)
+
+	added, updated, processed := 0, 0, 0
+	var score float64
+
+	for j, s := range scores {
+		out, newScore := z.add(s, pairs[j*2+1].Bulk, flags)
+		if out&zaddOutNaN != 0 {
+			return Value{Typ: "error", Str: "ERR resulting score is not a number (NaN)"}
+		}
+
+		if out&zaddOutAdded != 0 {
+			added++
+		}
+		if out&zaddOutUpdated != 0 {
+			updated++
+		}
+		if out&zaddOutNop == 0 {
+			processed++
+		}
+		score = newScore
+	}
+
+	if flags&zaddIncr != 0 {
+		if processed == 0 {
+			return Value{Typ: "null"}
+		}
+		return Value{Typ: "double", Double: score}
+	}
+
+	if flags&zaddCH != 0 {
+		return Value{Typ: "integer", Num: added + updated}
+	}
+	return Value{Typ: "integer", Num: added}
+}
+
+// doc: https://redis.io/docs/latest/commands/zincrby/
+func zincrby(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zincrby")
+	}
+
+	return zadd(ctx, []Value{args[0], {Typ: "bulk", Bulk: "incr"}, args[1], args[2]})
+}
+
+// doc: https://redis.io/docs/latest/commands/zrem/
+func zrem(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("zrem")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	z, err := db.writableZset(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if z.remove(member.Bulk) {
+			removed++
+		}
+	}
+
+	db.deleteZsetIfEmpty(key, z)
+	return Value{Typ: "integer", Num: removed}
+}
+
+// doc: https://redis.io/docs/latest/commands/zscore/
+func zscore(ctx context.Context, args []Value) Value {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("zscore")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z != nil {
+		if score, ok := z.score(args[1].Bulk); ok {
+			return Value{Typ: "double", Double: score}
+		}
+	}
+	return Value{Typ: "null"}
+}
+
+// doc: https://redis.io/docs/latest/commands/zmscore/
+func zmscore(ctx context.Context, args []Value) Value {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("zmscore")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	reply := Value{Typ: "array", Array: []Value{}}
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if z != nil {
+			score, ok = z.score(member.Bulk)
+		}
+
+		if ok {
+			reply.Array = append(reply.Array, Value{Typ: "double", Double: score})
+		} else {
+			reply.Array = append(reply.Array, Value{Typ: "null"})
+		}
+	}
+	return reply
+}
+
+// doc: https://redis.io/docs/latest/commands/zcard/
+func zcard(ctx context.Context, args []Value) Value {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("zcard")
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	return Value{Typ: "integer", Num: z.len()}
+}
+
+// doc: https://redis.io/docs/latest/commands/zcount/
+func zcount(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zcount")
+	}
+
+	r, err := parseRange(args[1].Bulk, args[2].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	// the ranks of both ends of the range give the count without walking it.
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+	last := z.zsl.lastInRange(r)
+
+	count := z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+	return Value{Typ: "integer", Num: count}
+}
+
+// doc: https://redis.io/docs/latest/commands/zrank/
+func zrank(ctx context.Context, args []Value) Value {
+	return zrankGeneric(ctx, "zrank", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/zrevrank/
+func zrevrank(ctx context.Context, args []Value) Value {
+	return zrankGeneric(ctx, "zrevrank", args, true)
+}
+
+func zrankGeneric(ctx context.Context, name string, args []Value, reverse bool) Value {
+	if len(args) < 2 || len(args) > 3 {
+		return wrongNumberOfArgs(name)
+	}
+
+	withScore := len(args) == 3
+	if withScore && strings.ToLower(args[2].Bulk) != "withscore" {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	rank, score, ok := 0, 0.0, false
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z != nil {
+		rank, score, ok = z.rank(args[1].Bulk, reverse)
+	}
+
+	switch {
+	case !ok && withScore:
+		return Value{Typ: "nullarray"}
+	case !ok:
+		return Value{Typ: "null"}
+	case withScore:
+		return Value{Typ: "array", Array: []Value{{Typ: "integer", Num: rank}, {Typ: "double", Double: score}}}
+	default:
+		return Value{Typ: "integer", Num: rank}
+	}
+}
+
+// how ZRANGE selects members.
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// a parsed ZRANGE or ZRANGESTORE query.
+type zrangeQuery struct {
+	by         int
+	reverse    bool
+	offset     int
+	limit      int // negative for no limit.
+	withScores bool
+
+	start, end int          // the ranks when by rank.
+	r          zrangeBounds // the range when by score or lex.
+}
+
+// parses "start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]".
+// Port of zrangeGenericCommand: https://github.com/redis/redis/blob/unstable/src/t_zset.c
+func parseZrangeQuery(args []Value, allowWithScores bool) (zrangeQuery, error) {
+	q := zrangeQuery{limit: -1}
+	hasLimit := false
+
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i].Bulk)
+
+		switch {
+		case option == "withscores" && allowWithScores:
+			q.withScores = true
+		case option == "limit" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1].Bulk)
+			limit, err2 := strconv.Atoi(args[i+2].Bulk)
+			if err1 != nil || err2 != nil {
+				return q, errNotInteger
+			}
+			q.offset, q.limit = offset, limit
+			hasLimit = true
+			i += 2
+		case option == "byscore" && q.by == zrangeByRank:
+			q.by = zrangeByScore
+		case option == "bylex" && q.by == zrangeByRank:
+			q.by = zrangeByLex
+		case option == "rev":
+			q.reverse = true
+		default:
+			return q, errSyntax
+		}
+	}
+
+	if hasLimit && q.by == zrangeByRank {
+		return q, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	if q.withScores && q.by == zrangeByLex {
+		return q, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// the range is given from the highest to the lowest when reversed.
+	min, max := args[0].Bulk, args[1].Bulk
+	if q.reverse && q.by != zrangeByRank {
+		min, max = max, min
+	}
+
+	var err error
+	switch q.by {
+	case zrangeByRank:
+		start, err1 := strconv.Atoi(min)
+		end, err2 := strconv.Atoi(max)
+		if err1 != nil || err2 != nil {
+			return q, errNotInteger
+		}
+		q.start, q.end = start, end
+	case zrangeByScore:
+		q.r, err = parseRange(min, max)
+	case zrangeByLex:
+		q.r, err = parseLexRange(min, max)
+	}
+
+	return q, err
+}
+
+// the members of the sorted set selected by the query.
+func (z *zset) query(q zrangeQuery) []zsetEntry {
+	if q.by != zrangeByRank {
+		return z.rangeIn(q.r, q.reverse, q.offset, q.limit)
+	}
+
+	start := max(listIndex(q.start, z.len()), 0)
+	end := min(listIndex(q.end, z.len()), z.len()-1)
+	if start > end {
+		return []zsetEntry{}
+	}
+	return z.rangeByRank(start, end, q.reverse)
+}
+
+// doc: https://redis.io/docs/latest/commands/zrange/
+func zrange(ctx context.Context, args []Value) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("zrange")
+	}
+
+	q, err := parseZrangeQuery(args[1:], true)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.rlock(ctx, args[0].Bulk)
+	defer unlock()
+
+	z, err := db.lookupZset(args[0].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return zsetReply(ctx, nil, false)
+	}
+	return zsetReply(ctx, z.query(q), q.withScores)
+}
+
+// doc: https://redis.io/docs/latest/commands/zrangestore/
+func zrangestore(ctx context.Context, args []Value) Value {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("zrangestore")
+	}
+
+	q, err := parseZrangeQuery(args[2:], false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk, args[1].Bulk)
+	defer unlock()
+
+	var entries []zsetEntry
+	z, err := db.writableZset(args[1].Bulk, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z != nil {
+		entries = z.query(q)
+	}
+
+	return Value{Typ: "integer", Num: db.storeZset(args[0].Bulk, entries)}
+}
+
+// replaces what the destination held by a sorted set of the entries,
+// nothing is stored when there are none. The store must be locked for writing.
+func (s *SimpleStore) storeZset(destination string, entries []zsetEntry) int {
+	s.deleteKey(destination)
+	if len(entries) == 0 {
+		return 0
+	}
+
+	z := newZset()
+	for _, entry := range entries {
+		z.add(entry.score, entry.member, 0)
+	}
+	s.add(destination, typeZset, z)
+	return z.len()
+}
+
+// doc: https://redis.io/docs/latest/commands/zpopmin/
+func zpopmin(ctx context.Context, args []Value) Value {
+	return zpopGeneric(ctx, "zpopmin", args, false)
+}
+
+// doc: https://redis.io/docs/latest/commands/zpopmax/
+func zpopmax(ctx context.Context, args []Value) Value {
+	return zpopGeneric(ctx, "zpopmax", args, true)
+}
+
+func zpopGeneric(ctx context.Context, name string, args []Value, max bool) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongNumberOfArgs(name)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return Value{Typ: "error", Str: "ERR value is out of range, must be positive"}
+		}
+		count = n
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, args[0].Bulk)
+	defer unlock()
+
+	key := args[0].Bulk
+	entries := []zsetEntry{}
+
+	z, err := db.writableZset(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z != nil {
+		for len(entries) < count && z.len() > 0 {
+			entries = append(entries, z.pop(max))
+		}
+		db.deleteZsetIfEmpty(key, z)
+	}
+
+	// a single member is a flat pair whatever the protocol.
+	if len(args) == 1 {
+		reply := Value{Typ: "array", Array: []Value{}}
+		for _, entry := range entries {
+			reply.Array = append(reply.Array, Value{Typ: "bulk", Bulk: entry.member}, Value{Typ: "double", Double: entry.score})
+		}
+		return reply
+	}
+	return zsetReply(ctx, entries, true)
+}
+
+// doc: https://redis.io/docs/latest/commands/zremrangebyrank/
+func zremrangebyrank(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zremrangebyrank")
+	}
+
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	end, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	return zremrangeGeneric(ctx, args[0].Bulk, func(z *zset) int {
+		start = max(listIndex(start, z.len()), 0)
+		end = min(listIndex(end, z.len()), z.len()-1)
+		if start > end {
+			return 0
+		}
+		return z.zsl.deleteRangeByRank(start+1, end+1, z.dict)
+	})
+}
+
+// doc: https://redis.io/docs/latest/commands/zremrangebyscore/
+func zremrangebyscore(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zremrangebyscore")
+	}
+
+	r, err := parseRange(args[1].Bulk, args[2].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	return zremrangeGeneric(ctx, args[0].Bulk, func(z *zset) int {
+		return z.zsl.deleteRange(r, z.dict)
+	})
+}
+
+// doc: https://redis.io/docs/latest/commands/zremrangebylex/
+func zremrangebylex(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zremrangebylex")
+	}
+
+	r, err := parseLexRange(args[1].Bulk, args[2].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+
+	return zremrangeGeneric(ctx, args[0].Bulk, func(z *zset) int {
+		return z.zsl.deleteRange(r, z.dict)
+	})
+}
+
+func zremrangeGeneric(ctx context.Context, key string, remove func(z *zset) int) Value {
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, key)
+	defer unlock()
+
+	z, err := db.writableZset(key, false)
+	if err != nil {
+		return Value{Typ: "error", Str: err.Error()}
+	}
+	if z == nil {
+		return Value{Typ: "integer", Num: 0}
+	}
+
+	removed := remove(z)
+	db.deleteZsetIfEmpty(key, z)
+	return Value{Typ: "integer", Num: removed}
+}
+
+// the operations of ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE.
+const (
+	zsetUnion = iota
+	zsetInter
+	zsetDiff
+)
+
+// how the scores of a member found in several inputs are combined.
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// an input of ZUNIONSTORE and friends: sets are sorted sets whose scores are 1.
+type zsetInput struct {
+	members map[string]float64
+	weight  float64
+}
+
+func aggregateScores(target, value float64, aggregate int) float64 {
+	switch aggregate {
+	case aggregateMin:
+		return math.Min(target, value)
+	case aggregateMax:
+		return math.Max(target, value)
+	default:
+		// inf + -inf is NaN, redis makes it 0.
+		if sum := target + value; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+}
+
+// doc: https://redis.io/docs/latest/commands/zunionstore/
+func zunionstore(ctx context.Context, args []Value) Value {
+	return zsetStoreGeneric(ctx, "zunionstore", args, zsetUnion)
+}
+
+// doc: https://redis.io/docs/latest/commands/zinterstore/
+func zinterstore(ctx context.Context, args []Value) Value {
+	return zsetStoreGeneric(ctx, "zinterstore", args, zsetInter)
+}
+
+// doc: https://redis.io/docs/latest/commands/zdiffstore/
+func zdiffstore(ctx context.Context, args []Value) Value {
+	return zsetStoreGeneric(ctx, "zdiffstore", args, zsetDiff)
+}
+
+// parses "destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]"
+// and stores the union, intersection or difference of the inputs.
+func zsetStoreGeneric(ctx context.Context, name string, args []Value, op int) Value {
+	if len(args) < 3 {
+		return wrongNumberOfArgs(name)
+	}
+
+	numkeys, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Value{Typ: "error", Str: errNotInteger.Error()}
+	}
+
+	if numkeys < 1 {
+		return Value{Typ: "error", Str: fmt.Sprintf("ERR at least 1 input key is needed for '%s' command", name)}
+	}
+
+	if numkeys > len(args)-2 {
+		return Value{Typ: "error", Str: errSyntax.Error()}
+	}
+
+	keys := args[2 : numkeys+2]
+	weights := make([]float64, numkeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := aggregateSum
+
+	options := args[numkeys+2:]
+	for i := 0; i < len(options); i++ {
+		option := strings.ToLower(options[i].Bulk)
+		remaining := len(options) - i - 1
+
+		switch {
+		case option == "weights" && op != zsetDiff && remaining >= numkeys:
+			for j := 0; j < numkeys; j++ {
+				weight, err := parseFloat(options[i+1+j].Bulk)
+				if err != nil {
+					return Value{Typ: "error", Str: "ERR weight value is not a float"}
+				}
+				weights[j] = weight
+			}
+			i += numkeys
+		case option == "aggregate" && op != zsetDiff && remaining >= 1:
+			switch strings.ToLower(options[i+1].Bulk) {
+			case "sum":
+				aggregate = aggregateSum
+			case "min":
+				aggregate = aggregateMin
+			case "max":
+				aggregate = aggregateMax
+			default:
+				return Value{Typ: "error", Str: errSyntax.Error()}
+			}
+			i++
+		default:
+			return Value{Typ: "error", Str: errSyntax.Error()}
+		}
+	}
+
+	db := clientDB(ctx)
+	unlock := db.lock(ctx, append(keysOf(keys), args[0].Bulk)...)
+	defer unlock()
+
+	inputs := make([]zsetInput, numkeys)
+	for i, key := range keys {
+		input, err := db.zsetInput(key.Bulk, weights[i])
+		if err != nil {
+			return Value{Typ: "error", Str: err.Error()}
+		}
+		inputs[i] = input
+	}
+
+	result := combineZsetInputs(inputs, op, aggregate)
+
+	entries := make([]zsetEntry, 0, len(result))
+	for member, score := range result {
+		entries = append(entries, zsetEntry{member: member, score: score})
+	}
+
+	return Value{Typ: "integer", Num: db.storeZset(args[0].Bulk, entries)}
+}
+
+// the members of the sorted set or set stored at key with their weighted
+// scores, the members of sets score 1. The store must be locked.
+func (s *SimpleStore) zsetInput(key string, weight float64) (zsetInput, error) {
+	input := zsetInput{members: map[string]float64{}, weight: weight}
+
+	o := s.lookup(key)
+	switch {
+	case o == nil:
+	case o.typ == typeZset:
+		o.value.(*zset).dict.each(func(member string, score float64) bool {
+			input.members[member] = score
+			return true
+		})
+	case o.typ == typeSet:
+		for _, member := range o.value.(*setType).members() {
+			input.members[member] = 1
+		}
+	default:
+		return input, errWrongType
+	}
+
+	return input, nil
+}
+
+func weightedScore(score, weight float64) float64 {
+	// 0 * inf is NaN, redis makes it 0.
+	if weighted := score * weight; !math.IsNaN(weighted) {
+		return weighted
+	}
+	return 0
+}
+
+func combineZsetInputs(inputs []zsetInput, op int, aggregate int) map[string]float64 {
+	result := map[string]float64{}
+
+	switch op {
+	case zsetUnion:
+		for _, input := range inputs {
+			for member, score := range input.members {
+				score = weightedScore(score, input.weight)
+				if current, ok := result[member]; ok {
+					score = aggregateScores(current, score, aggregate)
+				}
+				result[member] = score
+			}
+		}
+
+	case zsetInter:
+		// the smallest input is walked, the others are only looked up.
+		sort.SliceStable(inputs, func(i, j int) bool { return len(inputs[i].members) < len(inputs[j].members) })
+
+		for member, score := range inputs[0].members {
+			score = weightedScore(score, inputs[0].weight)
+			inAll := true
+
+			for _, other := range inputs[1:] {
+				otherScore, ok := other.members[member]
+				if !ok {
+					inAll = false
+					break
+				}
+				score = aggregateScores(score, weightedScore(otherScore, other.weight), aggregate)
+			}
+
+			if inAll {
+				result[member] = score
+			}
+		}
+
+	case zsetDiff:
+		for member, score := range inputs[0].members {
+			inOther := false
+			for _, other := range inputs[1:] {
+				if _, ok := other.members[member]; ok {
+					inOther = true
+					break
+				}
+			}
+
+			if !inOther {
+				result[member] = score
+			}
+		}
+	}
+
+	return result
+}
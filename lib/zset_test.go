@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the members and scores of a flat reply as RESP2 clients receive them.
+func zsetBulks(v Value) []string {
+	values := []string{}
+	for _, item := range v.Array {
+		if item.Typ == "double" {
+			values = append(values, formatDouble(item.Double))
+		} else {
+			values = append(values, item.Bulk)
+		}
+	}
+	return values
+}
+
+func TestZsetCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It adds members according to the ZADD options", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b")
+
+		assert.Equal(t, 1, zadd(ctx, command(key, "NX", "5", "a", "3", "c").Array).Num)
+		assert.Equal(t, 0, zadd(ctx, command(key, "XX", "5", "d").Array).Num)
+		assert.Equal(t, 1, zadd(ctx, command(key, "GT", "CH", "0", "a", "4", "b").Array).Num)
+		assert.Equal(t, 0, zadd(ctx, command(key, "LT", "CH", "9", "c").Array).Num)
+
+		assert.Equal(t, 1.0, zscore(ctx, command(key, "a").Array).Double)
+		assert.Equal(t, 4.0, zscore(ctx, command(key, "b").Array).Double)
+		assert.Equal(t, "null", zscore(ctx, command(key, "d").Array).Typ)
+
+		result := zadd(ctx, command(key, "INCR", "2.5", "a").Array)
+		assert.Equal(t, Value{Typ: "double", Double: 3.5}, result)
+		assert.Equal(t, "null", zadd(ctx, command(key, "NX", "INCR", "1", "a").Array).Typ)
+		assert.Equal(t, 5.5, zincrby(ctx, command(key, "2", "a").Array).Double)
+
+		result = zmscore(ctx, command(key, "a", "d").Array)
+		assert.Equal(t, []Value{{Typ: "double", Double: 5.5}, {Typ: "null"}}, result.Array)
+		assert.Equal(t, 3, zcard(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It refuses invalid ZADD arguments", func(t *testing.T) {
+		key := "zset-" + strconv.Itoa(rand.Int())
+
+		assert.Equal(t, "ERR XX and NX options at the same time are not compatible", zadd(ctx, command(key, "NX", "XX", "1", "a").Array).Str)
+		assert.Equal(t, "ERR GT, LT, and/or NX options at the same time are not compatible", zadd(ctx, command(key, "GT", "LT", "1", "a").Array).Str)
+		assert.Equal(t, "ERR INCR option supports a single increment-element pair", zadd(ctx, command(key, "INCR", "1", "a", "2", "b").Array).Str)
+		assert.Equal(t, "ERR value is not a valid float", zadd(ctx, command(key, "1", "a", "nan", "b").Array).Str)
+		assert.Equal(t, "ERR syntax error", zadd(ctx, command(key, "1", "a", "2").Array).Str)
+		assert.Equal(t, 0, zcard(ctx, command(key).Array).Num)
+
+		zadd(ctx, command(key, "+inf", "a").Array)
+		assert.Equal(t, "ERR resulting score is not a number (NaN)", zincrby(ctx, command(key, "-inf", "a").Array).Str)
+	})
+
+	t.Run("It removes members and deletes the empty sorted set", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b")
+
+		assert.Equal(t, 1, zrem(ctx, command(key, "a", "z").Array).Num)
+		assert.Equal(t, 1, zrem(ctx, command(key, "b").Array).Num)
+		assert.Equal(t, -2, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It counts members and ranks them", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b", "2", "c", "3", "d")
+
+		assert.Equal(t, 3, zcount(ctx, command(key, "(1", "+inf").Array).Num)
+		assert.Equal(t, 0, zcount(ctx, command(key, "4", "5").Array).Num)
+		assert.Equal(t, "ERR min or max is not a float", zcount(ctx, command(key, "x", "1").Array).Str)
+
+		assert.Equal(t, 2, zrank(ctx, command(key, "c").Array).Num)
+		assert.Equal(t, 0, zrevrank(ctx, command(key, "d").Array).Num)
+		assert.Equal(t, "null", zrank(ctx, command(key, "z").Array).Typ)
+
+		result := zrevrank(ctx, command(key, "a", "WITHSCORE").Array)
+		assert.Equal(t, []Value{{Typ: "integer", Num: 3}, {Typ: "double", Double: 1}}, result.Array)
+		assert.Equal(t, "nullarray", zrank(ctx, command(key, "z", "WITHSCORE").Array).Typ)
+	})
+
+	t.Run("It returns ranges by rank, score and member", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+
+		assert.Equal(t, []string{"b", "c", "d"}, bulks(zrange(ctx, command(key, "1", "-2").Array)))
+		assert.Equal(t, []string{"e", "d"}, bulks(zrange(ctx, command(key, "0", "1", "REV").Array)))
+		assert.Equal(t, []string{"a", "1", "b", "2"}, zsetBulks(zrange(ctx, command(key, "0", "1", "WITHSCORES").Array)))
+		assert.Empty(t, zrange(ctx, command(key, "3", "1").Array).Array)
+
+		assert.Equal(t, []string{"c", "d"}, bulks(zrange(ctx, command(key, "(2", "4", "BYSCORE").Array)))
+		assert.Equal(t, []string{"d", "c"}, bulks(zrange(ctx, command(key, "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2").Array)))
+		assert.Equal(t, []string{"b", "c", "d", "e"}, bulks(zrange(ctx, command(key, "2", "+inf", "BYSCORE", "LIMIT", "0", "-1").Array)))
+
+		lex := newTestKey(t, "ZADD", "0", "a", "0", "b", "0", "c", "0", "d")
+		assert.Equal(t, []string{"b", "c"}, bulks(zrange(ctx, command(lex, "(a", "[c", "BYLEX").Array)))
+		assert.Equal(t, []string{"d", "c", "b", "a"}, bulks(zrange(ctx, command(lex, "+", "-", "BYLEX", "REV").Array)))
+
+		assert.Equal(t, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX", zrange(ctx, command(key, "0", "1", "LIMIT", "0", "1").Array).Str)
+		assert.Equal(t, "ERR syntax error, WITHSCORES not supported in combination with BYLEX", zrange(ctx, command(key, "-", "+", "BYLEX", "WITHSCORES").Array).Str)
+		assert.Equal(t, "ERR min or max not valid string range item", zrange(ctx, command(key, "a", "+", "BYLEX").Array).Str)
+	})
+
+	t.Run("It replies with score pairs to RESP3 clients", func(t *testing.T) {
+		server := NewServer(NewConfig())
+		c := testClient(t, server)
+		c.writer.SetProto(RESP3)
+		ctx := contextWithClient(context.Background(), c)
+
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b")
+		result := zrange(ctx, command(key, "0", "-1", "WITHSCORES").Array)
+		assert.Equal(t, []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "double", Double: 1}}, result.Array[0].Array)
+
+		result = zpopmin(ctx, command(key).Array)
+		assert.Equal(t, []Value{{Typ: "bulk", Bulk: "a"}, {Typ: "double", Double: 1}}, result.Array)
+	})
+
+	t.Run("It stores ranges and pops members", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b", "3", "c", "4", "d")
+		destination := "zset-" + strconv.Itoa(rand.Int())
+
+		assert.Equal(t, 2, zrangestore(ctx, command(destination, key, "3", "2", "BYSCORE", "REV").Array).Num)
+		assert.Equal(t, []string{"b", "c"}, bulks(zrange(ctx, command(destination, "0", "-1").Array)))
+
+		assert.Equal(t, []string{"d", "4", "c", "3"}, zsetBulks(zpopmax(ctx, command(key, "2").Array)))
+		assert.Equal(t, []string{"a", "1"}, zsetBulks(zpopmin(ctx, command(key).Array)))
+		assert.Equal(t, "ERR value is out of range, must be positive", zpopmin(ctx, command(key, "-1").Array).Str)
+		assert.Equal(t, []string{"b", "2"}, zsetBulks(zpopmin(ctx, command(key, "5").Array)))
+		assert.Equal(t, -2, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It removes ranges", func(t *testing.T) {
+		key := newTestKey(t, "ZADD", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e", "5", "f")
+
+		assert.Equal(t, 2, zremrangebyrank(ctx, command(key, "0", "1").Array).Num)
+		assert.Equal(t, 1, zremrangebyscore(ctx, command(key, "(3", "4").Array).Num)
+		assert.Equal(t, 2, zremrangebylex(ctx, command(key, "[e", "+").Array).Num)
+		assert.Equal(t, []string{"c"}, bulks(zrange(ctx, command(key, "0", "-1").Array)))
+
+		assert.Equal(t, 1, zremrangebyrank(ctx, command(key, "0", "-1").Array).Num)
+		assert.Equal(t, -2, ttl(ctx, command(key).Array).Num)
+	})
+
+	t.Run("It stores unions, intersections and differences with weights", func(t *testing.T) {
+		a := newTestKey(t, "ZADD", "1", "x", "2", "y")
+		b := newTestKey(t, "ZADD", "10", "y", "20", "z")
+		s := newTestKey(t, "SADD", "x", "z")
+		destination := "zset-" + strconv.Itoa(rand.Int())
+
+		assert.Equal(t, 3, zunionstore(ctx, command(destination, "3", a, b, s, "WEIGHTS", "2", "1", "1").Array).Num)
+		assert.Equal(t, []string{"x", "3", "y", "14", "z", "21"}, zsetBulks(zrange(ctx, command(destination, "0", "-1", "WITHSCORES").Array)))
+
+		assert.Equal(t, 1, zinterstore(ctx, command(destination, "2", a, b, "AGGREGATE", "MAX").Array).Num)
+		assert.Equal(t, []string{"y", "10"}, zsetBulks(zrange(ctx, command(destination, "0", "-1", "WITHSCORES").Array)))
+
+		assert.Equal(t, 1, zdiffstore(ctx, command(destination, "2", a, s).Array).Num)
+		assert.Equal(t, []string{"y", "2"}, zsetBulks(zrange(ctx, command(destination, "0", "-1", "WITHSCORES").Array)))
+
+		assert.Equal(t, 0, zinterstore(ctx, command(destination, "2", a, "zset-missing").Array).Num)
+		assert.Equal(t, -2, ttl(ctx, command(destination).Array).Num)
+
+		assert.Equal(t, "ERR at least 1 input key is needed for 'zunionstore' command", zunionstore(ctx, command(destination, "0", a).Array).Str)
+		assert.Equal(t, "ERR syntax error", zdiffstore(ctx, command(destination, "1", a, "WEIGHTS", "1").Array).Str)
+		assert.Equal(t, "ERR weight value is not a float", zunionstore(ctx, command(destination, "1", a, "WEIGHTS", "x").Array).Str)
+	})
+}
@@ -1,11 +1,38 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	server "minired/lib"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	server := server.NewServer(":6379")
-	log.Fatal(server.Start())
+	if len(os.Args) == 2 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
+		fmt.Println("minired", server.Version)
+		return
+	}
+
+	// minired [/path/to/minired.conf] [--directive value ...]
+	config, err := server.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+
+	server := server.NewServer(config)
+
+	// orchestrators stop containers with SIGTERM, terminals with SIGINT.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, shutting down", sig)
+		server.Shutdown()
+	}()
+
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
 }